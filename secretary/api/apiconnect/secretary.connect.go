@@ -6,8 +6,11 @@ package apiconnect
 
 import (
 	connect "connectrpc.com/connect"
-	_ "github.com/codeharik/secretary/api"
+	context "context"
+	errors "errors"
+	api "github.com/codeharik/secretary/api"
 	http "net/http"
+	strings "strings"
 )
 
 // This is a compile-time assertion to ensure that this generated file and the connect package are
@@ -22,8 +25,56 @@ const (
 	SecretaryName = "secretary.Secretary"
 )
 
+// These constants are the fully-qualified names of the RPCs defined in this package. They're
+// exposed at runtime as Spec.Procedure and as the final two segments of the HTTP route.
+//
+// Note that these are different from the fully-qualified method names used by
+// google.golang.org/protobuf/reflect/protoreflect. To convert from these constants to
+// reflection-formatted method names, remove the leading slash and convert the remaining slash to a
+// period.
+const (
+	// SecretaryCreateCollectionProcedure is the fully-qualified name of the Secretary's
+	// CreateCollection RPC.
+	SecretaryCreateCollectionProcedure = "/secretary.Secretary/CreateCollection"
+	// SecretaryListCollectionsProcedure is the fully-qualified name of the Secretary's ListCollections
+	// RPC.
+	SecretaryListCollectionsProcedure = "/secretary.Secretary/ListCollections"
+	// SecretaryGetProcedure is the fully-qualified name of the Secretary's Get RPC.
+	SecretaryGetProcedure = "/secretary.Secretary/Get"
+	// SecretarySetProcedure is the fully-qualified name of the Secretary's Set RPC.
+	SecretarySetProcedure = "/secretary.Secretary/Set"
+	// SecretaryBatchSetProcedure is the fully-qualified name of the Secretary's BatchSet RPC.
+	SecretaryBatchSetProcedure = "/secretary.Secretary/BatchSet"
+	// SecretaryApplyBatchProcedure is the fully-qualified name of the Secretary's ApplyBatch RPC.
+	SecretaryApplyBatchProcedure = "/secretary.Secretary/ApplyBatch"
+	// SecretaryDeleteProcedure is the fully-qualified name of the Secretary's Delete RPC.
+	SecretaryDeleteProcedure = "/secretary.Secretary/Delete"
+	// SecretaryScanProcedure is the fully-qualified name of the Secretary's Scan RPC.
+	SecretaryScanProcedure = "/secretary.Secretary/Scan"
+	// SecretaryStatsProcedure is the fully-qualified name of the Secretary's Stats RPC.
+	SecretaryStatsProcedure = "/secretary.Secretary/Stats"
+)
+
 // SecretaryClient is a client for the secretary.Secretary service.
 type SecretaryClient interface {
+	// CreateCollection creates an empty collection, AlreadyExists if the name is taken
+	CreateCollection(context.Context, *connect.Request[api.CreateCollectionRequest]) (*connect.Response[api.CreateCollectionResponse], error)
+	// ListCollections returns the settings of every open collection
+	ListCollections(context.Context, *connect.Request[api.ListCollectionsRequest]) (*connect.Response[api.ListCollectionsResponse], error)
+	// Get returns the record of a key
+	Get(context.Context, *connect.Request[api.GetRequest]) (*connect.Response[api.GetResponse], error)
+	// Set inserts a record or replaces its value
+	Set(context.Context, *connect.Request[api.SetRequest]) (*connect.Response[api.SetResponse], error)
+	// BatchSet sets every record in one transaction
+	BatchSet(context.Context, *connect.Request[api.BatchSetRequest]) (*connect.Response[api.BatchSetResponse], error)
+	// ApplyBatch applies ordered puts and deletes with one WAL sync, an op that cannot apply is skipped
+	ApplyBatch(context.Context, *connect.Request[api.ApplyBatchRequest]) (*connect.Response[api.ApplyBatchResponse], error)
+	// Delete removes the record of a key
+	Delete(context.Context, *connect.Request[api.DeleteRequest]) (*connect.Response[api.DeleteResponse], error)
+	// Scan streams the records of a key range in pages
+	Scan(context.Context, *connect.Request[api.ScanRequest]) (*connect.ServerStreamForClient[api.ScanResponse], error)
+	// Stats reports the size and state of a collection
+	Stats(context.Context, *connect.Request[api.StatsRequest]) (*connect.Response[api.StatsResponse], error)
 }
 
 // NewSecretaryClient constructs a client for the secretary.Secretary service. By default, it uses
@@ -34,15 +85,144 @@ type SecretaryClient interface {
 // The URL supplied here should be the base URL for the Connect or gRPC server (for example,
 // http://api.acme.com or https://acme.com/grpc).
 func NewSecretaryClient(httpClient connect.HTTPClient, baseURL string, opts ...connect.ClientOption) SecretaryClient {
-	return &secretaryClient{}
+	baseURL = strings.TrimRight(baseURL, "/")
+	secretaryMethods := api.File_secretary_proto.Services().ByName("Secretary").Methods()
+	return &secretaryClient{
+		createCollection: connect.NewClient[api.CreateCollectionRequest, api.CreateCollectionResponse](
+			httpClient,
+			baseURL+SecretaryCreateCollectionProcedure,
+			connect.WithSchema(secretaryMethods.ByName("CreateCollection")),
+			connect.WithClientOptions(opts...),
+		),
+		listCollections: connect.NewClient[api.ListCollectionsRequest, api.ListCollectionsResponse](
+			httpClient,
+			baseURL+SecretaryListCollectionsProcedure,
+			connect.WithSchema(secretaryMethods.ByName("ListCollections")),
+			connect.WithClientOptions(opts...),
+		),
+		get: connect.NewClient[api.GetRequest, api.GetResponse](
+			httpClient,
+			baseURL+SecretaryGetProcedure,
+			connect.WithSchema(secretaryMethods.ByName("Get")),
+			connect.WithClientOptions(opts...),
+		),
+		set: connect.NewClient[api.SetRequest, api.SetResponse](
+			httpClient,
+			baseURL+SecretarySetProcedure,
+			connect.WithSchema(secretaryMethods.ByName("Set")),
+			connect.WithClientOptions(opts...),
+		),
+		batchSet: connect.NewClient[api.BatchSetRequest, api.BatchSetResponse](
+			httpClient,
+			baseURL+SecretaryBatchSetProcedure,
+			connect.WithSchema(secretaryMethods.ByName("BatchSet")),
+			connect.WithClientOptions(opts...),
+		),
+		applyBatch: connect.NewClient[api.ApplyBatchRequest, api.ApplyBatchResponse](
+			httpClient,
+			baseURL+SecretaryApplyBatchProcedure,
+			connect.WithSchema(secretaryMethods.ByName("ApplyBatch")),
+			connect.WithClientOptions(opts...),
+		),
+		delete: connect.NewClient[api.DeleteRequest, api.DeleteResponse](
+			httpClient,
+			baseURL+SecretaryDeleteProcedure,
+			connect.WithSchema(secretaryMethods.ByName("Delete")),
+			connect.WithClientOptions(opts...),
+		),
+		scan: connect.NewClient[api.ScanRequest, api.ScanResponse](
+			httpClient,
+			baseURL+SecretaryScanProcedure,
+			connect.WithSchema(secretaryMethods.ByName("Scan")),
+			connect.WithClientOptions(opts...),
+		),
+		stats: connect.NewClient[api.StatsRequest, api.StatsResponse](
+			httpClient,
+			baseURL+SecretaryStatsProcedure,
+			connect.WithSchema(secretaryMethods.ByName("Stats")),
+			connect.WithClientOptions(opts...),
+		),
+	}
 }
 
 // secretaryClient implements SecretaryClient.
 type secretaryClient struct {
+	createCollection *connect.Client[api.CreateCollectionRequest, api.CreateCollectionResponse]
+	listCollections  *connect.Client[api.ListCollectionsRequest, api.ListCollectionsResponse]
+	get              *connect.Client[api.GetRequest, api.GetResponse]
+	set              *connect.Client[api.SetRequest, api.SetResponse]
+	batchSet         *connect.Client[api.BatchSetRequest, api.BatchSetResponse]
+	applyBatch       *connect.Client[api.ApplyBatchRequest, api.ApplyBatchResponse]
+	delete           *connect.Client[api.DeleteRequest, api.DeleteResponse]
+	scan             *connect.Client[api.ScanRequest, api.ScanResponse]
+	stats            *connect.Client[api.StatsRequest, api.StatsResponse]
+}
+
+// CreateCollection calls secretary.Secretary.CreateCollection.
+func (c *secretaryClient) CreateCollection(ctx context.Context, req *connect.Request[api.CreateCollectionRequest]) (*connect.Response[api.CreateCollectionResponse], error) {
+	return c.createCollection.CallUnary(ctx, req)
+}
+
+// ListCollections calls secretary.Secretary.ListCollections.
+func (c *secretaryClient) ListCollections(ctx context.Context, req *connect.Request[api.ListCollectionsRequest]) (*connect.Response[api.ListCollectionsResponse], error) {
+	return c.listCollections.CallUnary(ctx, req)
+}
+
+// Get calls secretary.Secretary.Get.
+func (c *secretaryClient) Get(ctx context.Context, req *connect.Request[api.GetRequest]) (*connect.Response[api.GetResponse], error) {
+	return c.get.CallUnary(ctx, req)
+}
+
+// Set calls secretary.Secretary.Set.
+func (c *secretaryClient) Set(ctx context.Context, req *connect.Request[api.SetRequest]) (*connect.Response[api.SetResponse], error) {
+	return c.set.CallUnary(ctx, req)
+}
+
+// BatchSet calls secretary.Secretary.BatchSet.
+func (c *secretaryClient) BatchSet(ctx context.Context, req *connect.Request[api.BatchSetRequest]) (*connect.Response[api.BatchSetResponse], error) {
+	return c.batchSet.CallUnary(ctx, req)
+}
+
+// ApplyBatch calls secretary.Secretary.ApplyBatch.
+func (c *secretaryClient) ApplyBatch(ctx context.Context, req *connect.Request[api.ApplyBatchRequest]) (*connect.Response[api.ApplyBatchResponse], error) {
+	return c.applyBatch.CallUnary(ctx, req)
+}
+
+// Delete calls secretary.Secretary.Delete.
+func (c *secretaryClient) Delete(ctx context.Context, req *connect.Request[api.DeleteRequest]) (*connect.Response[api.DeleteResponse], error) {
+	return c.delete.CallUnary(ctx, req)
+}
+
+// Scan calls secretary.Secretary.Scan.
+func (c *secretaryClient) Scan(ctx context.Context, req *connect.Request[api.ScanRequest]) (*connect.ServerStreamForClient[api.ScanResponse], error) {
+	return c.scan.CallServerStream(ctx, req)
+}
+
+// Stats calls secretary.Secretary.Stats.
+func (c *secretaryClient) Stats(ctx context.Context, req *connect.Request[api.StatsRequest]) (*connect.Response[api.StatsResponse], error) {
+	return c.stats.CallUnary(ctx, req)
 }
 
 // SecretaryHandler is an implementation of the secretary.Secretary service.
 type SecretaryHandler interface {
+	// CreateCollection creates an empty collection, AlreadyExists if the name is taken
+	CreateCollection(context.Context, *connect.Request[api.CreateCollectionRequest]) (*connect.Response[api.CreateCollectionResponse], error)
+	// ListCollections returns the settings of every open collection
+	ListCollections(context.Context, *connect.Request[api.ListCollectionsRequest]) (*connect.Response[api.ListCollectionsResponse], error)
+	// Get returns the record of a key
+	Get(context.Context, *connect.Request[api.GetRequest]) (*connect.Response[api.GetResponse], error)
+	// Set inserts a record or replaces its value
+	Set(context.Context, *connect.Request[api.SetRequest]) (*connect.Response[api.SetResponse], error)
+	// BatchSet sets every record in one transaction
+	BatchSet(context.Context, *connect.Request[api.BatchSetRequest]) (*connect.Response[api.BatchSetResponse], error)
+	// ApplyBatch applies ordered puts and deletes with one WAL sync, an op that cannot apply is skipped
+	ApplyBatch(context.Context, *connect.Request[api.ApplyBatchRequest]) (*connect.Response[api.ApplyBatchResponse], error)
+	// Delete removes the record of a key
+	Delete(context.Context, *connect.Request[api.DeleteRequest]) (*connect.Response[api.DeleteResponse], error)
+	// Scan streams the records of a key range in pages
+	Scan(context.Context, *connect.Request[api.ScanRequest], *connect.ServerStream[api.ScanResponse]) error
+	// Stats reports the size and state of a collection
+	Stats(context.Context, *connect.Request[api.StatsRequest]) (*connect.Response[api.StatsResponse], error)
 }
 
 // NewSecretaryHandler builds an HTTP handler from the service implementation. It returns the path
@@ -51,8 +231,81 @@ type SecretaryHandler interface {
 // By default, handlers support the Connect, gRPC, and gRPC-Web protocols with the binary Protobuf
 // and JSON codecs. They also support gzip compression.
 func NewSecretaryHandler(svc SecretaryHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	secretaryMethods := api.File_secretary_proto.Services().ByName("Secretary").Methods()
+	secretaryCreateCollectionHandler := connect.NewUnaryHandler(
+		SecretaryCreateCollectionProcedure,
+		svc.CreateCollection,
+		connect.WithSchema(secretaryMethods.ByName("CreateCollection")),
+		connect.WithHandlerOptions(opts...),
+	)
+	secretaryListCollectionsHandler := connect.NewUnaryHandler(
+		SecretaryListCollectionsProcedure,
+		svc.ListCollections,
+		connect.WithSchema(secretaryMethods.ByName("ListCollections")),
+		connect.WithHandlerOptions(opts...),
+	)
+	secretaryGetHandler := connect.NewUnaryHandler(
+		SecretaryGetProcedure,
+		svc.Get,
+		connect.WithSchema(secretaryMethods.ByName("Get")),
+		connect.WithHandlerOptions(opts...),
+	)
+	secretarySetHandler := connect.NewUnaryHandler(
+		SecretarySetProcedure,
+		svc.Set,
+		connect.WithSchema(secretaryMethods.ByName("Set")),
+		connect.WithHandlerOptions(opts...),
+	)
+	secretaryBatchSetHandler := connect.NewUnaryHandler(
+		SecretaryBatchSetProcedure,
+		svc.BatchSet,
+		connect.WithSchema(secretaryMethods.ByName("BatchSet")),
+		connect.WithHandlerOptions(opts...),
+	)
+	secretaryApplyBatchHandler := connect.NewUnaryHandler(
+		SecretaryApplyBatchProcedure,
+		svc.ApplyBatch,
+		connect.WithSchema(secretaryMethods.ByName("ApplyBatch")),
+		connect.WithHandlerOptions(opts...),
+	)
+	secretaryDeleteHandler := connect.NewUnaryHandler(
+		SecretaryDeleteProcedure,
+		svc.Delete,
+		connect.WithSchema(secretaryMethods.ByName("Delete")),
+		connect.WithHandlerOptions(opts...),
+	)
+	secretaryScanHandler := connect.NewServerStreamHandler(
+		SecretaryScanProcedure,
+		svc.Scan,
+		connect.WithSchema(secretaryMethods.ByName("Scan")),
+		connect.WithHandlerOptions(opts...),
+	)
+	secretaryStatsHandler := connect.NewUnaryHandler(
+		SecretaryStatsProcedure,
+		svc.Stats,
+		connect.WithSchema(secretaryMethods.ByName("Stats")),
+		connect.WithHandlerOptions(opts...),
+	)
 	return "/secretary.Secretary/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
+		case SecretaryCreateCollectionProcedure:
+			secretaryCreateCollectionHandler.ServeHTTP(w, r)
+		case SecretaryListCollectionsProcedure:
+			secretaryListCollectionsHandler.ServeHTTP(w, r)
+		case SecretaryGetProcedure:
+			secretaryGetHandler.ServeHTTP(w, r)
+		case SecretarySetProcedure:
+			secretarySetHandler.ServeHTTP(w, r)
+		case SecretaryBatchSetProcedure:
+			secretaryBatchSetHandler.ServeHTTP(w, r)
+		case SecretaryApplyBatchProcedure:
+			secretaryApplyBatchHandler.ServeHTTP(w, r)
+		case SecretaryDeleteProcedure:
+			secretaryDeleteHandler.ServeHTTP(w, r)
+		case SecretaryScanProcedure:
+			secretaryScanHandler.ServeHTTP(w, r)
+		case SecretaryStatsProcedure:
+			secretaryStatsHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -61,3 +314,39 @@ func NewSecretaryHandler(svc SecretaryHandler, opts ...connect.HandlerOption) (s
 
 // UnimplementedSecretaryHandler returns CodeUnimplemented from all methods.
 type UnimplementedSecretaryHandler struct{}
+
+func (UnimplementedSecretaryHandler) CreateCollection(context.Context, *connect.Request[api.CreateCollectionRequest]) (*connect.Response[api.CreateCollectionResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("secretary.Secretary.CreateCollection is not implemented"))
+}
+
+func (UnimplementedSecretaryHandler) ListCollections(context.Context, *connect.Request[api.ListCollectionsRequest]) (*connect.Response[api.ListCollectionsResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("secretary.Secretary.ListCollections is not implemented"))
+}
+
+func (UnimplementedSecretaryHandler) Get(context.Context, *connect.Request[api.GetRequest]) (*connect.Response[api.GetResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("secretary.Secretary.Get is not implemented"))
+}
+
+func (UnimplementedSecretaryHandler) Set(context.Context, *connect.Request[api.SetRequest]) (*connect.Response[api.SetResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("secretary.Secretary.Set is not implemented"))
+}
+
+func (UnimplementedSecretaryHandler) BatchSet(context.Context, *connect.Request[api.BatchSetRequest]) (*connect.Response[api.BatchSetResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("secretary.Secretary.BatchSet is not implemented"))
+}
+
+func (UnimplementedSecretaryHandler) ApplyBatch(context.Context, *connect.Request[api.ApplyBatchRequest]) (*connect.Response[api.ApplyBatchResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("secretary.Secretary.ApplyBatch is not implemented"))
+}
+
+func (UnimplementedSecretaryHandler) Delete(context.Context, *connect.Request[api.DeleteRequest]) (*connect.Response[api.DeleteResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("secretary.Secretary.Delete is not implemented"))
+}
+
+func (UnimplementedSecretaryHandler) Scan(context.Context, *connect.Request[api.ScanRequest], *connect.ServerStream[api.ScanResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("secretary.Secretary.Scan is not implemented"))
+}
+
+func (UnimplementedSecretaryHandler) Stats(context.Context, *connect.Request[api.StatsRequest]) (*connect.Response[api.StatsResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("secretary.Secretary.Stats is not implemented"))
+}
@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.5
+// 	protoc-gen-go v1.36.6
 // 	protoc        (unknown)
 // source: secretary.proto
 
@@ -11,6 +11,7 @@ import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
+	sync "sync"
 	unsafe "unsafe"
 )
 
@@ -21,32 +22,1478 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type Record struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Record) Reset() {
+	*x = Record{}
+	mi := &file_secretary_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Record) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Record) ProtoMessage() {}
+
+func (x *Record) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Record.ProtoReflect.Descriptor instead.
+func (*Record) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Record) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *Record) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type Collection struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	CollectionName      string                 `protobuf:"bytes,1,opt,name=collection_name,json=collectionName,proto3" json:"collection_name,omitempty"`
+	Order               uint32                 `protobuf:"varint,2,opt,name=order,proto3" json:"order,omitempty"`
+	NumLevel            uint32                 `protobuf:"varint,3,opt,name=num_level,json=numLevel,proto3" json:"num_level,omitempty"`
+	BaseSize            uint32                 `protobuf:"varint,4,opt,name=base_size,json=baseSize,proto3" json:"base_size,omitempty"`
+	Increment           uint32                 `protobuf:"varint,5,opt,name=increment,proto3" json:"increment,omitempty"`
+	CompactionBatchSize uint32                 `protobuf:"varint,6,opt,name=compaction_batch_size,json=compactionBatchSize,proto3" json:"compaction_batch_size,omitempty"`
+	MaxKeySize          uint32                 `protobuf:"varint,7,opt,name=max_key_size,json=maxKeySize,proto3" json:"max_key_size,omitempty"` // 0 for the fixed 16 byte keys of older collections
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *Collection) Reset() {
+	*x = Collection{}
+	mi := &file_secretary_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Collection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Collection) ProtoMessage() {}
+
+func (x *Collection) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Collection.ProtoReflect.Descriptor instead.
+func (*Collection) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Collection) GetCollectionName() string {
+	if x != nil {
+		return x.CollectionName
+	}
+	return ""
+}
+
+func (x *Collection) GetOrder() uint32 {
+	if x != nil {
+		return x.Order
+	}
+	return 0
+}
+
+func (x *Collection) GetNumLevel() uint32 {
+	if x != nil {
+		return x.NumLevel
+	}
+	return 0
+}
+
+func (x *Collection) GetBaseSize() uint32 {
+	if x != nil {
+		return x.BaseSize
+	}
+	return 0
+}
+
+func (x *Collection) GetIncrement() uint32 {
+	if x != nil {
+		return x.Increment
+	}
+	return 0
+}
+
+func (x *Collection) GetCompactionBatchSize() uint32 {
+	if x != nil {
+		return x.CompactionBatchSize
+	}
+	return 0
+}
+
+func (x *Collection) GetMaxKeySize() uint32 {
+	if x != nil {
+		return x.MaxKeySize
+	}
+	return 0
+}
+
+type CreateCollectionRequest struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	CollectionName      string                 `protobuf:"bytes,1,opt,name=collection_name,json=collectionName,proto3" json:"collection_name,omitempty"`
+	Order               uint32                 `protobuf:"varint,2,opt,name=order,proto3" json:"order,omitempty"`
+	NumLevel            uint32                 `protobuf:"varint,3,opt,name=num_level,json=numLevel,proto3" json:"num_level,omitempty"`
+	BaseSize            uint32                 `protobuf:"varint,4,opt,name=base_size,json=baseSize,proto3" json:"base_size,omitempty"` // Size of the smallest record slot
+	Increment           uint32                 `protobuf:"varint,5,opt,name=increment,proto3" json:"increment,omitempty"`               // Growth of the record slots per level in percent
+	CompactionBatchSize uint32                 `protobuf:"varint,6,opt,name=compaction_batch_size,json=compactionBatchSize,proto3" json:"compaction_batch_size,omitempty"`
+	MaxKeySize          uint32                 `protobuf:"varint,7,opt,name=max_key_size,json=maxKeySize,proto3" json:"max_key_size,omitempty"` // 0 for the default
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *CreateCollectionRequest) Reset() {
+	*x = CreateCollectionRequest{}
+	mi := &file_secretary_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateCollectionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateCollectionRequest) ProtoMessage() {}
+
+func (x *CreateCollectionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateCollectionRequest.ProtoReflect.Descriptor instead.
+func (*CreateCollectionRequest) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CreateCollectionRequest) GetCollectionName() string {
+	if x != nil {
+		return x.CollectionName
+	}
+	return ""
+}
+
+func (x *CreateCollectionRequest) GetOrder() uint32 {
+	if x != nil {
+		return x.Order
+	}
+	return 0
+}
+
+func (x *CreateCollectionRequest) GetNumLevel() uint32 {
+	if x != nil {
+		return x.NumLevel
+	}
+	return 0
+}
+
+func (x *CreateCollectionRequest) GetBaseSize() uint32 {
+	if x != nil {
+		return x.BaseSize
+	}
+	return 0
+}
+
+func (x *CreateCollectionRequest) GetIncrement() uint32 {
+	if x != nil {
+		return x.Increment
+	}
+	return 0
+}
+
+func (x *CreateCollectionRequest) GetCompactionBatchSize() uint32 {
+	if x != nil {
+		return x.CompactionBatchSize
+	}
+	return 0
+}
+
+func (x *CreateCollectionRequest) GetMaxKeySize() uint32 {
+	if x != nil {
+		return x.MaxKeySize
+	}
+	return 0
+}
+
+type CreateCollectionResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Collection    *Collection            `protobuf:"bytes,1,opt,name=collection,proto3" json:"collection,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateCollectionResponse) Reset() {
+	*x = CreateCollectionResponse{}
+	mi := &file_secretary_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateCollectionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateCollectionResponse) ProtoMessage() {}
+
+func (x *CreateCollectionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateCollectionResponse.ProtoReflect.Descriptor instead.
+func (*CreateCollectionResponse) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CreateCollectionResponse) GetCollection() *Collection {
+	if x != nil {
+		return x.Collection
+	}
+	return nil
+}
+
+type ListCollectionsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListCollectionsRequest) Reset() {
+	*x = ListCollectionsRequest{}
+	mi := &file_secretary_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListCollectionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListCollectionsRequest) ProtoMessage() {}
+
+func (x *ListCollectionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListCollectionsRequest.ProtoReflect.Descriptor instead.
+func (*ListCollectionsRequest) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{4}
+}
+
+type ListCollectionsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Collections   []*Collection          `protobuf:"bytes,1,rep,name=collections,proto3" json:"collections,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListCollectionsResponse) Reset() {
+	*x = ListCollectionsResponse{}
+	mi := &file_secretary_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListCollectionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListCollectionsResponse) ProtoMessage() {}
+
+func (x *ListCollectionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListCollectionsResponse.ProtoReflect.Descriptor instead.
+func (*ListCollectionsResponse) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListCollectionsResponse) GetCollections() []*Collection {
+	if x != nil {
+		return x.Collections
+	}
+	return nil
+}
+
+type GetRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	CollectionName string                 `protobuf:"bytes,1,opt,name=collection_name,json=collectionName,proto3" json:"collection_name,omitempty"`
+	Key            []byte                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Snapshot       uint64                 `protobuf:"varint,3,opt,name=snapshot,proto3" json:"snapshot,omitempty"` // Open snapshot to read, 0 for the live collection
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *GetRequest) Reset() {
+	*x = GetRequest{}
+	mi := &file_secretary_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRequest) ProtoMessage() {}
+
+func (x *GetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRequest.ProtoReflect.Descriptor instead.
+func (*GetRequest) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetRequest) GetCollectionName() string {
+	if x != nil {
+		return x.CollectionName
+	}
+	return ""
+}
+
+func (x *GetRequest) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *GetRequest) GetSnapshot() uint64 {
+	if x != nil {
+		return x.Snapshot
+	}
+	return 0
+}
+
+type GetResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Record        *Record                `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetResponse) Reset() {
+	*x = GetResponse{}
+	mi := &file_secretary_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetResponse) ProtoMessage() {}
+
+func (x *GetResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetResponse.ProtoReflect.Descriptor instead.
+func (*GetResponse) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetResponse) GetRecord() *Record {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+type SetRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	CollectionName string                 `protobuf:"bytes,1,opt,name=collection_name,json=collectionName,proto3" json:"collection_name,omitempty"`
+	Key            []byte                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Value          []byte                 `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *SetRequest) Reset() {
+	*x = SetRequest{}
+	mi := &file_secretary_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetRequest) ProtoMessage() {}
+
+func (x *SetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetRequest.ProtoReflect.Descriptor instead.
+func (*SetRequest) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SetRequest) GetCollectionName() string {
+	if x != nil {
+		return x.CollectionName
+	}
+	return ""
+}
+
+func (x *SetRequest) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *SetRequest) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type SetResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Created       bool                   `protobuf:"varint,1,opt,name=created,proto3" json:"created,omitempty"` // False if the key existed and its value was replaced
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetResponse) Reset() {
+	*x = SetResponse{}
+	mi := &file_secretary_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetResponse) ProtoMessage() {}
+
+func (x *SetResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetResponse.ProtoReflect.Descriptor instead.
+func (*SetResponse) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *SetResponse) GetCreated() bool {
+	if x != nil {
+		return x.Created
+	}
+	return false
+}
+
+type BatchSetRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	CollectionName string                 `protobuf:"bytes,1,opt,name=collection_name,json=collectionName,proto3" json:"collection_name,omitempty"`
+	Records        []*Record              `protobuf:"bytes,2,rep,name=records,proto3" json:"records,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *BatchSetRequest) Reset() {
+	*x = BatchSetRequest{}
+	mi := &file_secretary_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchSetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchSetRequest) ProtoMessage() {}
+
+func (x *BatchSetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchSetRequest.ProtoReflect.Descriptor instead.
+func (*BatchSetRequest) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *BatchSetRequest) GetCollectionName() string {
+	if x != nil {
+		return x.CollectionName
+	}
+	return ""
+}
+
+func (x *BatchSetRequest) GetRecords() []*Record {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+type BatchSetResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Created       uint32                 `protobuf:"varint,1,opt,name=created,proto3" json:"created,omitempty"`
+	Updated       uint32                 `protobuf:"varint,2,opt,name=updated,proto3" json:"updated,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchSetResponse) Reset() {
+	*x = BatchSetResponse{}
+	mi := &file_secretary_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchSetResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchSetResponse) ProtoMessage() {}
+
+func (x *BatchSetResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchSetResponse.ProtoReflect.Descriptor instead.
+func (*BatchSetResponse) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *BatchSetResponse) GetCreated() uint32 {
+	if x != nil {
+		return x.Created
+	}
+	return 0
+}
+
+func (x *BatchSetResponse) GetUpdated() uint32 {
+	if x != nil {
+		return x.Updated
+	}
+	return 0
+}
+
+type BatchOp struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Delete        bool                   `protobuf:"varint,3,opt,name=delete,proto3" json:"delete,omitempty"` // Deletes key, value is ignored
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchOp) Reset() {
+	*x = BatchOp{}
+	mi := &file_secretary_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchOp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchOp) ProtoMessage() {}
+
+func (x *BatchOp) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchOp.ProtoReflect.Descriptor instead.
+func (*BatchOp) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *BatchOp) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *BatchOp) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *BatchOp) GetDelete() bool {
+	if x != nil {
+		return x.Delete
+	}
+	return false
+}
+
+type ApplyBatchRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	CollectionName string                 `protobuf:"bytes,1,opt,name=collection_name,json=collectionName,proto3" json:"collection_name,omitempty"`
+	Ops            []*BatchOp             `protobuf:"bytes,2,rep,name=ops,proto3" json:"ops,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ApplyBatchRequest) Reset() {
+	*x = ApplyBatchRequest{}
+	mi := &file_secretary_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ApplyBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ApplyBatchRequest) ProtoMessage() {}
+
+func (x *ApplyBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ApplyBatchRequest.ProtoReflect.Descriptor instead.
+func (*ApplyBatchRequest) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ApplyBatchRequest) GetCollectionName() string {
+	if x != nil {
+		return x.CollectionName
+	}
+	return ""
+}
+
+func (x *ApplyBatchRequest) GetOps() []*BatchOp {
+	if x != nil {
+		return x.Ops
+	}
+	return nil
+}
+
+type BatchOpResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Created       bool                   `protobuf:"varint,1,opt,name=created,proto3" json:"created,omitempty"` // A put inserted a new key
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`      // Why the op was skipped, empty if it was applied
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchOpResult) Reset() {
+	*x = BatchOpResult{}
+	mi := &file_secretary_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchOpResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchOpResult) ProtoMessage() {}
+
+func (x *BatchOpResult) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchOpResult.ProtoReflect.Descriptor instead.
+func (*BatchOpResult) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *BatchOpResult) GetCreated() bool {
+	if x != nil {
+		return x.Created
+	}
+	return false
+}
+
+func (x *BatchOpResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type ApplyBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*BatchOpResult       `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"` // One per op, in order
+	Applied       uint32                 `protobuf:"varint,2,opt,name=applied,proto3" json:"applied,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ApplyBatchResponse) Reset() {
+	*x = ApplyBatchResponse{}
+	mi := &file_secretary_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ApplyBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ApplyBatchResponse) ProtoMessage() {}
+
+func (x *ApplyBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ApplyBatchResponse.ProtoReflect.Descriptor instead.
+func (*ApplyBatchResponse) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ApplyBatchResponse) GetResults() []*BatchOpResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *ApplyBatchResponse) GetApplied() uint32 {
+	if x != nil {
+		return x.Applied
+	}
+	return 0
+}
+
+type DeleteRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	CollectionName string                 `protobuf:"bytes,1,opt,name=collection_name,json=collectionName,proto3" json:"collection_name,omitempty"`
+	Key            []byte                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *DeleteRequest) Reset() {
+	*x = DeleteRequest{}
+	mi := &file_secretary_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRequest) ProtoMessage() {}
+
+func (x *DeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *DeleteRequest) GetCollectionName() string {
+	if x != nil {
+		return x.CollectionName
+	}
+	return ""
+}
+
+func (x *DeleteRequest) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+type DeleteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteResponse) Reset() {
+	*x = DeleteResponse{}
+	mi := &file_secretary_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteResponse) ProtoMessage() {}
+
+func (x *DeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteResponse.ProtoReflect.Descriptor instead.
+func (*DeleteResponse) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{17}
+}
+
+type ScanRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	CollectionName string                 `protobuf:"bytes,1,opt,name=collection_name,json=collectionName,proto3" json:"collection_name,omitempty"`
+	StartKey       []byte                 `protobuf:"bytes,2,opt,name=start_key,json=startKey,proto3" json:"start_key,omitempty"`          // First key, empty for the start of the collection
+	EndKey         []byte                 `protobuf:"bytes,3,opt,name=end_key,json=endKey,proto3" json:"end_key,omitempty"`                // Last key, empty for the end of the collection
+	Limit          uint32                 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`                               // Most records streamed, 0 for no limit
+	Reverse        bool                   `protobuf:"varint,5,opt,name=reverse,proto3" json:"reverse,omitempty"`                           // From end_key down to start_key
+	PageSize       uint32                 `protobuf:"varint,6,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`         // Records per response, 0 for the default
+	ResumeToken    []byte                 `protobuf:"bytes,7,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"` // resume_token of the last response received, continues after it
+	Snapshot       uint64                 `protobuf:"varint,8,opt,name=snapshot,proto3" json:"snapshot,omitempty"`                         // Open snapshot to read, 0 for the live collection
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ScanRequest) Reset() {
+	*x = ScanRequest{}
+	mi := &file_secretary_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanRequest) ProtoMessage() {}
+
+func (x *ScanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanRequest.ProtoReflect.Descriptor instead.
+func (*ScanRequest) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *ScanRequest) GetCollectionName() string {
+	if x != nil {
+		return x.CollectionName
+	}
+	return ""
+}
+
+func (x *ScanRequest) GetStartKey() []byte {
+	if x != nil {
+		return x.StartKey
+	}
+	return nil
+}
+
+func (x *ScanRequest) GetEndKey() []byte {
+	if x != nil {
+		return x.EndKey
+	}
+	return nil
+}
+
+func (x *ScanRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ScanRequest) GetReverse() bool {
+	if x != nil {
+		return x.Reverse
+	}
+	return false
+}
+
+func (x *ScanRequest) GetPageSize() uint32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ScanRequest) GetResumeToken() []byte {
+	if x != nil {
+		return x.ResumeToken
+	}
+	return nil
+}
+
+func (x *ScanRequest) GetSnapshot() uint64 {
+	if x != nil {
+		return x.Snapshot
+	}
+	return 0
+}
+
+type ScanResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       []*Record              `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	ResumeToken   []byte                 `protobuf:"bytes,2,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"` // Continues the same scan after this page
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanResponse) Reset() {
+	*x = ScanResponse{}
+	mi := &file_secretary_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanResponse) ProtoMessage() {}
+
+func (x *ScanResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanResponse.ProtoReflect.Descriptor instead.
+func (*ScanResponse) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *ScanResponse) GetRecords() []*Record {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *ScanResponse) GetResumeToken() []byte {
+	if x != nil {
+		return x.ResumeToken
+	}
+	return nil
+}
+
+type StatsRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	CollectionName string                 `protobuf:"bytes,1,opt,name=collection_name,json=collectionName,proto3" json:"collection_name,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *StatsRequest) Reset() {
+	*x = StatsRequest{}
+	mi := &file_secretary_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsRequest) ProtoMessage() {}
+
+func (x *StatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
+func (*StatsRequest) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *StatsRequest) GetCollectionName() string {
+	if x != nil {
+		return x.CollectionName
+	}
+	return ""
+}
+
+type StatsResponse struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Collection        *Collection            `protobuf:"bytes,1,opt,name=collection,proto3" json:"collection,omitempty"`
+	Height            uint32                 `protobuf:"varint,2,opt,name=height,proto3" json:"height,omitempty"` // Levels of nodes from the root to the leaves
+	NumNodes          uint64                 `protobuf:"varint,3,opt,name=num_nodes,json=numNodes,proto3" json:"num_nodes,omitempty"`
+	LoadedNodes       uint64                 `protobuf:"varint,4,opt,name=loaded_nodes,json=loadedNodes,proto3" json:"loaded_nodes,omitempty"` // Nodes in memory
+	KeySeq            uint64                 `protobuf:"varint,5,opt,name=key_seq,json=keySeq,proto3" json:"key_seq,omitempty"`
+	ReclaimableBytes  uint64                 `protobuf:"varint,6,opt,name=reclaimable_bytes,json=reclaimableBytes,proto3" json:"reclaimable_bytes,omitempty"` // Free node pages and record slots a compaction gives back
+	WalBytes          uint64                 `protobuf:"varint,7,opt,name=wal_bytes,json=walBytes,proto3" json:"wal_bytes,omitempty"`                         // Size of the write-ahead log since the last checkpoint
+	CheckpointLsn     uint64                 `protobuf:"varint,8,opt,name=checkpoint_lsn,json=checkpointLsn,proto3" json:"checkpoint_lsn,omitempty"`
+	OpenSnapshots     uint32                 `protobuf:"varint,9,opt,name=open_snapshots,json=openSnapshots,proto3" json:"open_snapshots,omitempty"`
+	CompactionRunning bool                   `protobuf:"varint,10,opt,name=compaction_running,json=compactionRunning,proto3" json:"compaction_running,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *StatsResponse) Reset() {
+	*x = StatsResponse{}
+	mi := &file_secretary_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsResponse) ProtoMessage() {}
+
+func (x *StatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secretary_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsResponse.ProtoReflect.Descriptor instead.
+func (*StatsResponse) Descriptor() ([]byte, []int) {
+	return file_secretary_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *StatsResponse) GetCollection() *Collection {
+	if x != nil {
+		return x.Collection
+	}
+	return nil
+}
+
+func (x *StatsResponse) GetHeight() uint32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetNumNodes() uint64 {
+	if x != nil {
+		return x.NumNodes
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetLoadedNodes() uint64 {
+	if x != nil {
+		return x.LoadedNodes
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetKeySeq() uint64 {
+	if x != nil {
+		return x.KeySeq
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetReclaimableBytes() uint64 {
+	if x != nil {
+		return x.ReclaimableBytes
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetWalBytes() uint64 {
+	if x != nil {
+		return x.WalBytes
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetCheckpointLsn() uint64 {
+	if x != nil {
+		return x.CheckpointLsn
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetOpenSnapshots() uint32 {
+	if x != nil {
+		return x.OpenSnapshots
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetCompactionRunning() bool {
+	if x != nil {
+		return x.CompactionRunning
+	}
+	return false
+}
+
 var File_secretary_proto protoreflect.FileDescriptor
 
-var file_secretary_proto_rawDesc = string([]byte{
-	0x0a, 0x0f, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x61, 0x72, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x12, 0x09, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x61, 0x72, 0x79, 0x1a, 0x1b, 0x62, 0x75,
-	0x66, 0x2f, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x65, 0x2f, 0x76, 0x61, 0x6c, 0x69, 0x64,
-	0x61, 0x74, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x32, 0x0b, 0x0a, 0x09, 0x53, 0x65, 0x63,
-	0x72, 0x65, 0x74, 0x61, 0x72, 0x79, 0x42, 0x87, 0x01, 0x0a, 0x0d, 0x63, 0x6f, 0x6d, 0x2e, 0x73,
-	0x65, 0x63, 0x72, 0x65, 0x74, 0x61, 0x72, 0x79, 0x42, 0x0e, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74,
-	0x61, 0x72, 0x79, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x50, 0x01, 0x5a, 0x22, 0x67, 0x69, 0x74, 0x68,
-	0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x63, 0x6f, 0x64, 0x65, 0x68, 0x61, 0x72, 0x69, 0x6b,
-	0x2f, 0x73, 0x65, 0x63, 0x72, 0x65, 0x74, 0x61, 0x72, 0x79, 0x2f, 0x61, 0x70, 0x69, 0xa2, 0x02,
-	0x03, 0x53, 0x58, 0x58, 0xaa, 0x02, 0x09, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x61, 0x72, 0x79,
-	0xca, 0x02, 0x09, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x61, 0x72, 0x79, 0xe2, 0x02, 0x15, 0x53,
-	0x65, 0x63, 0x72, 0x65, 0x74, 0x61, 0x72, 0x79, 0x5c, 0x47, 0x50, 0x42, 0x4d, 0x65, 0x74, 0x61,
-	0x64, 0x61, 0x74, 0x61, 0xea, 0x02, 0x09, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x61, 0x72, 0x79,
-	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
-})
-
-var file_secretary_proto_goTypes = []any{}
+const file_secretary_proto_rawDesc = "" +
+	"\n" +
+	"\x0fsecretary.proto\x12\tsecretary\x1a\x1bbuf/validate/validate.proto\"9\n" +
+	"\x06Record\x12\x19\n" +
+	"\x03key\x18\x01 \x01(\fB\a\xbaH\x04z\x02\x10\x01R\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\"\xf9\x01\n" +
+	"\n" +
+	"Collection\x12'\n" +
+	"\x0fcollection_name\x18\x01 \x01(\tR\x0ecollectionName\x12\x14\n" +
+	"\x05order\x18\x02 \x01(\rR\x05order\x12\x1b\n" +
+	"\tnum_level\x18\x03 \x01(\rR\bnumLevel\x12\x1b\n" +
+	"\tbase_size\x18\x04 \x01(\rR\bbaseSize\x12\x1c\n" +
+	"\tincrement\x18\x05 \x01(\rR\tincrement\x122\n" +
+	"\x15compaction_batch_size\x18\x06 \x01(\rR\x13compactionBatchSize\x12 \n" +
+	"\fmax_key_size\x18\a \x01(\rR\n" +
+	"maxKeySize\"\xd1\x02\n" +
+	"\x17CreateCollectionRequest\x122\n" +
+	"\x0fcollection_name\x18\x01 \x01(\tB\t\xbaH\x06r\x04\x10\x05\x18\x1eR\x0ecollectionName\x12 \n" +
+	"\x05order\x18\x02 \x01(\rB\n" +
+	"\xbaH\a*\x05\x18\xc8\x01(\x03R\x05order\x12'\n" +
+	"\tnum_level\x18\x03 \x01(\rB\n" +
+	"\xbaH\a*\x05\x18\xff\x01(\x01R\bnumLevel\x12$\n" +
+	"\tbase_size\x18\x04 \x01(\rB\a\xbaH\x04*\x02(\x01R\bbaseSize\x12(\n" +
+	"\tincrement\x18\x05 \x01(\rB\n" +
+	"\xbaH\a*\x05\x18\xc8\x01(nR\tincrement\x12;\n" +
+	"\x15compaction_batch_size\x18\x06 \x01(\rB\a\xbaH\x04*\x02(\x01R\x13compactionBatchSize\x12*\n" +
+	"\fmax_key_size\x18\a \x01(\rB\b\xbaH\x05*\x03\x18\x80\bR\n" +
+	"maxKeySize\"Q\n" +
+	"\x18CreateCollectionResponse\x125\n" +
+	"\n" +
+	"collection\x18\x01 \x01(\v2\x15.secretary.CollectionR\n" +
+	"collection\"\x18\n" +
+	"\x16ListCollectionsRequest\"R\n" +
+	"\x17ListCollectionsResponse\x127\n" +
+	"\vcollections\x18\x01 \x03(\v2\x15.secretary.CollectionR\vcollections\"u\n" +
+	"\n" +
+	"GetRequest\x120\n" +
+	"\x0fcollection_name\x18\x01 \x01(\tB\a\xbaH\x04r\x02\x10\x01R\x0ecollectionName\x12\x19\n" +
+	"\x03key\x18\x02 \x01(\fB\a\xbaH\x04z\x02\x10\x01R\x03key\x12\x1a\n" +
+	"\bsnapshot\x18\x03 \x01(\x04R\bsnapshot\"8\n" +
+	"\vGetResponse\x12)\n" +
+	"\x06record\x18\x01 \x01(\v2\x11.secretary.RecordR\x06record\"o\n" +
+	"\n" +
+	"SetRequest\x120\n" +
+	"\x0fcollection_name\x18\x01 \x01(\tB\a\xbaH\x04r\x02\x10\x01R\x0ecollectionName\x12\x19\n" +
+	"\x03key\x18\x02 \x01(\fB\a\xbaH\x04z\x02\x10\x01R\x03key\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\fR\x05value\"'\n" +
+	"\vSetResponse\x12\x18\n" +
+	"\acreated\x18\x01 \x01(\bR\acreated\"z\n" +
+	"\x0fBatchSetRequest\x120\n" +
+	"\x0fcollection_name\x18\x01 \x01(\tB\a\xbaH\x04r\x02\x10\x01R\x0ecollectionName\x125\n" +
+	"\arecords\x18\x02 \x03(\v2\x11.secretary.RecordB\b\xbaH\x05\x92\x01\x02\b\x01R\arecords\"F\n" +
+	"\x10BatchSetResponse\x12\x18\n" +
+	"\acreated\x18\x01 \x01(\rR\acreated\x12\x18\n" +
+	"\aupdated\x18\x02 \x01(\rR\aupdated\"R\n" +
+	"\aBatchOp\x12\x19\n" +
+	"\x03key\x18\x01 \x01(\fB\a\xbaH\x04z\x02\x10\x01R\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\x12\x16\n" +
+	"\x06delete\x18\x03 \x01(\bR\x06delete\"u\n" +
+	"\x11ApplyBatchRequest\x120\n" +
+	"\x0fcollection_name\x18\x01 \x01(\tB\a\xbaH\x04r\x02\x10\x01R\x0ecollectionName\x12.\n" +
+	"\x03ops\x18\x02 \x03(\v2\x12.secretary.BatchOpB\b\xbaH\x05\x92\x01\x02\b\x01R\x03ops\"?\n" +
+	"\rBatchOpResult\x12\x18\n" +
+	"\acreated\x18\x01 \x01(\bR\acreated\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"b\n" +
+	"\x12ApplyBatchResponse\x122\n" +
+	"\aresults\x18\x01 \x03(\v2\x18.secretary.BatchOpResultR\aresults\x12\x18\n" +
+	"\aapplied\x18\x02 \x01(\rR\aapplied\"\\\n" +
+	"\rDeleteRequest\x120\n" +
+	"\x0fcollection_name\x18\x01 \x01(\tB\a\xbaH\x04r\x02\x10\x01R\x0ecollectionName\x12\x19\n" +
+	"\x03key\x18\x02 \x01(\fB\a\xbaH\x04z\x02\x10\x01R\x03key\"\x10\n" +
+	"\x0eDeleteResponse\"\x81\x02\n" +
+	"\vScanRequest\x120\n" +
+	"\x0fcollection_name\x18\x01 \x01(\tB\a\xbaH\x04r\x02\x10\x01R\x0ecollectionName\x12\x1b\n" +
+	"\tstart_key\x18\x02 \x01(\fR\bstartKey\x12\x17\n" +
+	"\aend_key\x18\x03 \x01(\fR\x06endKey\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\rR\x05limit\x12\x18\n" +
+	"\areverse\x18\x05 \x01(\bR\areverse\x12\x1b\n" +
+	"\tpage_size\x18\x06 \x01(\rR\bpageSize\x12!\n" +
+	"\fresume_token\x18\a \x01(\fR\vresumeToken\x12\x1a\n" +
+	"\bsnapshot\x18\b \x01(\x04R\bsnapshot\"^\n" +
+	"\fScanResponse\x12+\n" +
+	"\arecords\x18\x01 \x03(\v2\x11.secretary.RecordR\arecords\x12!\n" +
+	"\fresume_token\x18\x02 \x01(\fR\vresumeToken\"@\n" +
+	"\fStatsRequest\x120\n" +
+	"\x0fcollection_name\x18\x01 \x01(\tB\a\xbaH\x04r\x02\x10\x01R\x0ecollectionName\"\xfe\x02\n" +
+	"\rStatsResponse\x125\n" +
+	"\n" +
+	"collection\x18\x01 \x01(\v2\x15.secretary.CollectionR\n" +
+	"collection\x12\x16\n" +
+	"\x06height\x18\x02 \x01(\rR\x06height\x12\x1b\n" +
+	"\tnum_nodes\x18\x03 \x01(\x04R\bnumNodes\x12!\n" +
+	"\floaded_nodes\x18\x04 \x01(\x04R\vloadedNodes\x12\x17\n" +
+	"\akey_seq\x18\x05 \x01(\x04R\x06keySeq\x12+\n" +
+	"\x11reclaimable_bytes\x18\x06 \x01(\x04R\x10reclaimableBytes\x12\x1b\n" +
+	"\twal_bytes\x18\a \x01(\x04R\bwalBytes\x12%\n" +
+	"\x0echeckpoint_lsn\x18\b \x01(\x04R\rcheckpointLsn\x12%\n" +
+	"\x0eopen_snapshots\x18\t \x01(\rR\ropenSnapshots\x12-\n" +
+	"\x12compaction_running\x18\n" +
+	" \x01(\bR\x11compactionRunning2\xf4\x04\n" +
+	"\tSecretary\x12[\n" +
+	"\x10CreateCollection\x12\".secretary.CreateCollectionRequest\x1a#.secretary.CreateCollectionResponse\x12X\n" +
+	"\x0fListCollections\x12!.secretary.ListCollectionsRequest\x1a\".secretary.ListCollectionsResponse\x124\n" +
+	"\x03Get\x12\x15.secretary.GetRequest\x1a\x16.secretary.GetResponse\x124\n" +
+	"\x03Set\x12\x15.secretary.SetRequest\x1a\x16.secretary.SetResponse\x12C\n" +
+	"\bBatchSet\x12\x1a.secretary.BatchSetRequest\x1a\x1b.secretary.BatchSetResponse\x12I\n" +
+	"\n" +
+	"ApplyBatch\x12\x1c.secretary.ApplyBatchRequest\x1a\x1d.secretary.ApplyBatchResponse\x12=\n" +
+	"\x06Delete\x12\x18.secretary.DeleteRequest\x1a\x19.secretary.DeleteResponse\x129\n" +
+	"\x04Scan\x12\x16.secretary.ScanRequest\x1a\x17.secretary.ScanResponse0\x01\x12:\n" +
+	"\x05Stats\x12\x17.secretary.StatsRequest\x1a\x18.secretary.StatsResponseB\x87\x01\n" +
+	"\rcom.secretaryB\x0eSecretaryProtoP\x01Z\"github.com/codeharik/secretary/api\xa2\x02\x03SXX\xaa\x02\tSecretary\xca\x02\tSecretary\xe2\x02\x15Secretary\\GPBMetadata\xea\x02\tSecretaryb\x06proto3"
+
+var (
+	file_secretary_proto_rawDescOnce sync.Once
+	file_secretary_proto_rawDescData []byte
+)
+
+func file_secretary_proto_rawDescGZIP() []byte {
+	file_secretary_proto_rawDescOnce.Do(func() {
+		file_secretary_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_secretary_proto_rawDesc), len(file_secretary_proto_rawDesc)))
+	})
+	return file_secretary_proto_rawDescData
+}
+
+var file_secretary_proto_msgTypes = make([]protoimpl.MessageInfo, 22)
+var file_secretary_proto_goTypes = []any{
+	(*Record)(nil),                   // 0: secretary.Record
+	(*Collection)(nil),               // 1: secretary.Collection
+	(*CreateCollectionRequest)(nil),  // 2: secretary.CreateCollectionRequest
+	(*CreateCollectionResponse)(nil), // 3: secretary.CreateCollectionResponse
+	(*ListCollectionsRequest)(nil),   // 4: secretary.ListCollectionsRequest
+	(*ListCollectionsResponse)(nil),  // 5: secretary.ListCollectionsResponse
+	(*GetRequest)(nil),               // 6: secretary.GetRequest
+	(*GetResponse)(nil),              // 7: secretary.GetResponse
+	(*SetRequest)(nil),               // 8: secretary.SetRequest
+	(*SetResponse)(nil),              // 9: secretary.SetResponse
+	(*BatchSetRequest)(nil),          // 10: secretary.BatchSetRequest
+	(*BatchSetResponse)(nil),         // 11: secretary.BatchSetResponse
+	(*BatchOp)(nil),                  // 12: secretary.BatchOp
+	(*ApplyBatchRequest)(nil),        // 13: secretary.ApplyBatchRequest
+	(*BatchOpResult)(nil),            // 14: secretary.BatchOpResult
+	(*ApplyBatchResponse)(nil),       // 15: secretary.ApplyBatchResponse
+	(*DeleteRequest)(nil),            // 16: secretary.DeleteRequest
+	(*DeleteResponse)(nil),           // 17: secretary.DeleteResponse
+	(*ScanRequest)(nil),              // 18: secretary.ScanRequest
+	(*ScanResponse)(nil),             // 19: secretary.ScanResponse
+	(*StatsRequest)(nil),             // 20: secretary.StatsRequest
+	(*StatsResponse)(nil),            // 21: secretary.StatsResponse
+}
 var file_secretary_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1,  // 0: secretary.CreateCollectionResponse.collection:type_name -> secretary.Collection
+	1,  // 1: secretary.ListCollectionsResponse.collections:type_name -> secretary.Collection
+	0,  // 2: secretary.GetResponse.record:type_name -> secretary.Record
+	0,  // 3: secretary.BatchSetRequest.records:type_name -> secretary.Record
+	12, // 4: secretary.ApplyBatchRequest.ops:type_name -> secretary.BatchOp
+	14, // 5: secretary.ApplyBatchResponse.results:type_name -> secretary.BatchOpResult
+	0,  // 6: secretary.ScanResponse.records:type_name -> secretary.Record
+	1,  // 7: secretary.StatsResponse.collection:type_name -> secretary.Collection
+	2,  // 8: secretary.Secretary.CreateCollection:input_type -> secretary.CreateCollectionRequest
+	4,  // 9: secretary.Secretary.ListCollections:input_type -> secretary.ListCollectionsRequest
+	6,  // 10: secretary.Secretary.Get:input_type -> secretary.GetRequest
+	8,  // 11: secretary.Secretary.Set:input_type -> secretary.SetRequest
+	10, // 12: secretary.Secretary.BatchSet:input_type -> secretary.BatchSetRequest
+	13, // 13: secretary.Secretary.ApplyBatch:input_type -> secretary.ApplyBatchRequest
+	16, // 14: secretary.Secretary.Delete:input_type -> secretary.DeleteRequest
+	18, // 15: secretary.Secretary.Scan:input_type -> secretary.ScanRequest
+	20, // 16: secretary.Secretary.Stats:input_type -> secretary.StatsRequest
+	3,  // 17: secretary.Secretary.CreateCollection:output_type -> secretary.CreateCollectionResponse
+	5,  // 18: secretary.Secretary.ListCollections:output_type -> secretary.ListCollectionsResponse
+	7,  // 19: secretary.Secretary.Get:output_type -> secretary.GetResponse
+	9,  // 20: secretary.Secretary.Set:output_type -> secretary.SetResponse
+	11, // 21: secretary.Secretary.BatchSet:output_type -> secretary.BatchSetResponse
+	15, // 22: secretary.Secretary.ApplyBatch:output_type -> secretary.ApplyBatchResponse
+	17, // 23: secretary.Secretary.Delete:output_type -> secretary.DeleteResponse
+	19, // 24: secretary.Secretary.Scan:output_type -> secretary.ScanResponse
+	21, // 25: secretary.Secretary.Stats:output_type -> secretary.StatsResponse
+	17, // [17:26] is the sub-list for method output_type
+	8,  // [8:17] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
 }
 
 func init() { file_secretary_proto_init() }
@@ -60,12 +1507,13 @@ func file_secretary_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_secretary_proto_rawDesc), len(file_secretary_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   0,
+			NumMessages:   22,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_secretary_proto_goTypes,
 		DependencyIndexes: file_secretary_proto_depIdxs,
+		MessageInfos:      file_secretary_proto_msgTypes,
 	}.Build()
 	File_secretary_proto = out.File
 	file_secretary_proto_goTypes = nil
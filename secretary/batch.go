@@ -0,0 +1,116 @@
+package secretary
+
+import (
+	"slices"
+
+	"github.com/codeharik/secretary/utils/binstruct"
+)
+
+/*
+Batches
+
+ApplyBatch applies many puts and deletes with one acquisition of the tree
+latch. The ops are logged as a single WAL_BATCH entry and the WAL is synced
+once, so a batch is replayed completely or not at all.
+
+Ops apply in order, a later op sees the earlier ones : a put then a delete
+of the same key leaves no record. An op that cannot apply, an invalid key,
+a record too large or a delete of a missing key, is skipped and its error
+is returned in its OpResult, the other ops still apply.
+*/
+
+// ApplyBatch applies ops in order and returns one result per op, an error if the batch could not be logged or applied
+func (tree *BTree) ApplyBatch(ops []Op) ([]OpResult, error) {
+	results := make([]OpResult, len(ops))
+	// Puts are encoded in a copy, the ops of the caller are left as given
+	ops = slices.Clone(ops)
+	for i, op := range ops {
+		switch op.Type {
+		case OP_PUT:
+			if err := tree.checkKey(op.Key); err != nil {
+				results[i].Err = err
+			} else if ops[i].Value, err = tree.encodeValue(op.Value); err != nil {
+				results[i].Err = err
+			} else if err := tree.checkRecordSize(op.Key, ops[i].Value); err != nil {
+				results[i].Err = err
+			}
+		case OP_DELETE:
+		default:
+			results[i].Err = ErrorBatchOpType(op.Type)
+		}
+	}
+
+	indexes, unlock := tree.lockIndexes()
+	defer unlock()
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	defer tree.evictNodes()
+
+	// Keys the batch already put or deleted
+	exists := map[string]bool{}
+	logged := make([]TxnOp, 0, len(ops))
+	for i, op := range ops {
+		if results[i].Err != nil {
+			continue
+		}
+
+		found, ok := exists[string(op.Key)]
+		if !ok && tree.root != nil {
+			var err error
+			if _, _, found, err = tree.getLeafNode(op.Key); err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case op.Type == OP_DELETE && !found:
+			results[i].Err = ErrorKeyNotFound
+			continue
+		case op.Type == OP_DELETE:
+			logged = append(logged, TxnOp{Op: WAL_DELETE, Key: op.Key})
+		case found:
+			logged = append(logged, TxnOp{Op: WAL_UPDATE, Key: op.Key, Value: op.Value})
+		default:
+			logged = append(logged, TxnOp{Op: WAL_SET, Key: op.Key, Value: op.Value})
+			results[i].Created = true
+		}
+		exists[string(op.Key)] = op.Type == OP_PUT
+	}
+	if len(logged) == 0 {
+		return results, nil
+	}
+
+	finish := func(bool) {}
+	if !indexes.empty() {
+		changes, err := tree.indexChanges(logged, tree.documentLocked)
+		if err != nil {
+			return nil, err
+		}
+		if finish, err = beginIndexWrite(indexes, changes, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if tree.wal != nil {
+		value, err := binstruct.Serialize(logged)
+		if err != nil {
+			return nil, err
+		}
+		if err := tree.logMutation(WAL_BATCH, nil, value); err != nil {
+			return nil, err
+		}
+		if err := tree.wal.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tree.applyLogged(func() error { return tree.applyTxnOps(logged) }); err != nil {
+		return nil, err
+	}
+	finish(true)
+
+	tree.maybeCheckpoint()
+
+	return results, nil
+}
@@ -0,0 +1,83 @@
+package secretary
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestBatchApply(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	ops := []Op{}
+	expected := map[string]string{}
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		ops = append(ops, Op{Type: OP_PUT, Key: []byte(key), Value: []byte("value" + key)})
+		expected[key] = "value" + key
+	}
+	results, err := tree.ApplyBatch(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if !result.Created || result.Err != nil {
+			t.Fatal("Expected a created key at", i, result.Err)
+		}
+	}
+
+	entries, err := tree.wal.ReadEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Op != WAL_BATCH {
+		t.Fatal("Expected one WAL entry for the batch", len(entries))
+	}
+
+	// Later ops see the earlier ones
+	results, err = tree.ApplyBatch([]Op{
+		{Type: OP_PUT, Key: []byte("key00"), Value: []byte("updated")},
+		{Type: OP_PUT, Key: []byte("new"), Value: []byte("new")},
+		{Type: OP_DELETE, Key: []byte("new")},
+		{Type: OP_DELETE, Key: []byte("new")},
+		{Type: OP_DELETE, Key: []byte("key01")},
+		{Type: OP_PUT, Key: []byte("key01"), Value: []byte("again")},
+		{Type: OP_PUT, Key: nil, Value: []byte("value")},
+		{Type: OP_PUT, Key: bytes.Repeat([]byte("k"), MAX_KEY_SIZE+1), Value: []byte("value")},
+		{Type: 42, Key: []byte("key02")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected["key00"] = "updated"
+	expected["key01"] = "again"
+
+	created := []bool{false, true, false, false, false, true, false, false, false}
+	failed := []error{nil, nil, nil, ErrorKeyNotFound, nil, nil, ErrorInvalidKey, ErrorInvalidKey, ErrorBatchOpType(42)}
+	for i, result := range results {
+		if result.Created != created[i] || fmt.Sprint(result.Err) != fmt.Sprint(failed[i]) {
+			t.Fatal("Result mismatch at", i, result.Created, result.Err)
+		}
+	}
+	verifyTreeRecords(t, tree, expected)
+
+	// Nothing to apply logs nothing
+	if results, err := tree.ApplyBatch([]Op{{Type: OP_DELETE, Key: []byte("missing")}}); err != nil || results[0].Err != ErrorKeyNotFound {
+		t.Fatal("Expected a missing key", err)
+	}
+	if entries, _ := tree.wal.ReadEntries(); len(entries) != 2 {
+		t.Fatal("Expected no entry for an empty batch", len(entries))
+	}
+
+	// Reopen without closing the first instance, as after a kill -9
+	reopened := dummySecretary(t)
+	reloaded, err := reopened.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTreeRecords(t, reloaded, expected)
+
+	reopened.PagerShutdown()
+	s.PagerShutdown()
+}
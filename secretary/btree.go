@@ -2,6 +2,7 @@ package secretary
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 
@@ -10,7 +11,13 @@ import (
 	"github.com/codeharik/secretary/utils/file"
 )
 
-func calcNodeSize(order int) int {
+// calcNodeSize is the page size of a node with order keys, maxKeySize 0 for fixed KEY_SIZE keys
+func calcNodeSize(order int, maxKeySize int) int {
+	if maxKeySize > 0 {
+		// No common prefix, every key at full length
+		return NODE_PAGE_HEADER + maxKeySize + order*(KEY_OFFSET_SIZE+NODE_PAGE_KEY_LENGTH+maxKeySize)
+	}
+
 	node := Node{
 		Version:     1,
 		NodeID:      1,
@@ -28,6 +35,8 @@ func calcNodeSize(order int) int {
 	return len(bin)
 }
 
+// NewBTree creates an empty collection, existing pages, schema and write-ahead log are discarded.
+// Keys are up to maxKeySize bytes, DEFAULT_MAX_KEY_SIZE if 0.
 func (s *Secretary) NewBTree(
 	collectionName string,
 	order uint8,
@@ -35,28 +44,92 @@ func (s *Secretary) NewBTree(
 	baseSize uint32,
 	increment uint8,
 	compactionBatchSize uint32,
+	maxKeySize uint16,
 ) (*BTree, error) {
-	if order < MIN_ORDER || order > MAX_ORDER {
-		return nil, ErrorInvalidOrder
+	if maxKeySize == 0 {
+		maxKeySize = DEFAULT_MAX_KEY_SIZE
 	}
 
-	if increment < 110 || increment > 200 {
-		return nil, ErrorInvalidIncrement
+	tree, err := newBTree(collectionName, order, numLevel, baseSize, increment, compactionBatchSize, maxKeySize)
+	if err != nil {
+		return nil, err
 	}
 
-	nodeSize := calcNodeSize(int(order))
+	if err := tree.reset(); err != nil {
+		return nil, err
+	}
+
+	s.AddTree(tree)
+
+	return tree, nil
+}
 
+// reset discards the pages, write-ahead log, schema, vectors and indexes of a new tree
+func (tree *BTree) reset() error {
+	if MODE_WASM {
+		return nil
+	}
+
+	errs := []error{tree.nodePager.Truncate(), tree.wal.Reset(), tree.journal.Reset(), saveSchema(tree.CollectionName, nil), removeVectorIndex(tree.CollectionName), removeIndexes(tree.CollectionName)}
+	for _, pager := range tree.recordPagers {
+		errs = append(errs, pager.Truncate())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	// The previous header would point into the discarded pages
+	return tree.SaveHeader()
+}
+
+func newBTree(
+	collectionName string,
+	order uint8,
+	numLevel uint8,
+	baseSize uint32,
+	increment uint8,
+	compactionBatchSize uint32,
+	maxKeySize uint16,
+) (*BTree, error) {
 	safeCollectionName := utils.SafeCollectionString(collectionName)
 	if len(safeCollectionName) < 5 || len(safeCollectionName) > MAX_COLLECTION_NAME_LENGTH {
 		return nil, ErrorInvalidCollectionName
 	}
 
-	if err := file.EnsureDir(fmt.Sprintf("%s/%s", SECRETARY, safeCollectionName)); err != nil {
+	return openBTree(safeCollectionName, order, numLevel, baseSize, increment, compactionBatchSize, maxKeySize)
+}
+
+// openBTree opens the files of a tree in SECRETARY/<collectionName>, the name is not checked so
+// trees of a collection, like its indexes, can live in its directory
+func openBTree(
+	collectionName string,
+	order uint8,
+	numLevel uint8,
+	baseSize uint32,
+	increment uint8,
+	compactionBatchSize uint32,
+	maxKeySize uint16,
+) (*BTree, error) {
+	if order < MIN_ORDER || order > MAX_ORDER {
+		return nil, ErrorInvalidOrder
+	}
+
+	if increment < 110 || increment > 200 {
+		return nil, ErrorInvalidIncrement
+	}
+
+	if maxKeySize > MAX_KEY_SIZE {
+		return nil, ErrorInvalidMaxKeySize
+	}
+
+	nodeSize := calcNodeSize(int(order), int(maxKeySize))
+
+	if err := file.EnsureDir(fmt.Sprintf("%s/%s", SECRETARY, collectionName)); err != nil {
 		return nil, err
 	}
 
 	tree := &BTree{
-		CollectionName: safeCollectionName,
+		CollectionName: collectionName,
 
 		root: &Node{},
 
@@ -65,12 +138,16 @@ func (s *Secretary) NewBTree(
 		BaseSize:  baseSize,
 		Increment: increment,
 
-		nodeSize: uint32(nodeSize),
+		MaxKeySize: maxKeySize,
+
+		nodeSize:       uint32(nodeSize),
+		maxLoadedNodes: MAX_LOADED_NODES,
 
 		minNumKeys: uint32(int(order)-1) / 2,
 
 		CompactionBatchSize: compactionBatchSize,
 	}
+	tree.resetNodes()
 
 	if !MODE_WASM {
 		nodePager, err := tree.NewNodePager("index", 0)
@@ -88,9 +165,19 @@ func (s *Secretary) NewBTree(
 			recordPagers[i] = pager
 		}
 		tree.recordPagers = recordPagers
-	}
 
-	s.AddTree(tree)
+		wal, err := tree.NewWAL()
+		if err != nil {
+			return nil, err
+		}
+		tree.wal = wal
+
+		journal, err := tree.NewJournal()
+		if err != nil {
+			return nil, err
+		}
+		tree.journal = journal
+	}
 
 	return tree, nil
 }
@@ -100,9 +187,35 @@ func (tree *BTree) close() error {
 		return ErrorModeWASM
 	}
 
+	tree.StopCompaction()
+
+	// indexMu is taken before tree.mu, as writes do
+	indexErr := tree.closeIndexes()
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	// An unfinished compaction pass is dropped, the next one starts over
+	tree.abortCompaction()
+	// The slots kept for open snapshots are freed by the last checkpoint
+	tree.releaseSnapshots()
+
+	// Persist pending changes, untouched trees keep their header as is
+	if tree.nodePager != nil && (len(tree.dirtyNodes) > 0 || (tree.wal != nil && tree.wal.size > 0)) {
+		if err := tree.checkpoint(); err != nil {
+			return errors.Join(err, tree.closeFiles(), indexErr)
+		}
+	}
+
+	return errors.Join(tree.closeFiles(), indexErr)
+}
+
+func (tree *BTree) closeFiles() error {
 	errs := []error{}
 
+	// The caches run goroutines until they are closed, index trees open pagers as well
 	if tree.nodePager != nil {
+		tree.nodePager.cache.Close()
 		if err := tree.nodePager.file.Close(); err != nil {
 			errs = append(errs, err)
 		}
@@ -110,12 +223,25 @@ func (tree *BTree) close() error {
 
 	for _, pager := range tree.recordPagers {
 		if pager != nil {
+			pager.cache.Close()
 			if err := pager.file.Close(); err != nil {
 				errs = append(errs, err)
 			}
 		}
 	}
 
+	if tree.wal != nil {
+		if err := tree.wal.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if tree.journal != nil {
+		if err := tree.journal.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	return errors.Join(errs...)
 }
 
@@ -124,18 +250,97 @@ func (tree *BTree) SaveHeader() error {
 		return ErrorModeWASM
 	}
 
-	headerBytes, err := binstruct.Serialize(tree)
+	headerBytes, err := tree.headerBytes()
 	if err != nil {
 		return err
 	}
 
+	return tree.nodePager.WriteAt(headerBytes, 0)
+}
+
+// headerBytes serializes the header, binstruct fields first and then the extension
+func (tree *BTree) headerBytes() ([]byte, error) {
+	return tree.encodeHeader(tree.RootIndex, tree.FreeListIndex)
+}
+
+// encodeHeader serializes the header of an index.bin whose root and free list are at the given pages
+func (tree *BTree) encodeHeader(rootIndex uint64, freeListIndex uint64) ([]byte, error) {
+	headerBytes, err := binstruct.Serialize(tree)
+	if err != nil {
+		return nil, err
+	}
+
 	headerBytes = append([]byte(SECRETARY), headerBytes...)
 
-	if len(headerBytes) < SECRETARY_HEADER_LENGTH {
-		headerBytes = append(headerBytes, utils.MakeByteArray(SECRETARY_HEADER_LENGTH-len(headerBytes), '-')...)
+	if len(headerBytes) > SECRETARY_HEADER_EXTENSION {
+		return nil, ErrorHeaderTooLarge(len(headerBytes))
 	}
+	headerBytes = append(headerBytes, utils.MakeByteArray(SECRETARY_HEADER_EXTENSION-len(headerBytes), '-')...)
 
-	return tree.nodePager.WriteAt(headerBytes, 0)
+	extension := make([]byte, SECRETARY_HEADER_LENGTH-SECRETARY_HEADER_EXTENSION)
+	extension[0] = SECRETARY_HEADER_VERSION
+	binary.BigEndian.PutUint64(extension[1:9], rootIndex)
+	binary.BigEndian.PutUint64(extension[9:17], tree.CheckpointLSN)
+	binary.BigEndian.PutUint64(extension[17:25], freeListIndex)
+	binary.BigEndian.PutUint16(extension[25:27], tree.MaxKeySize)
+
+	return append(headerBytes, extension...), nil
+}
+
+// parseHeader reads a header written by headerBytes or by a release without the extension
+func parseHeader(headerData []byte) (*BTree, error) {
+	if len(headerData) < SECRETARY_HEADER_LENGTH || !bytes.HasPrefix(headerData, []byte(SECRETARY)) {
+		return nil, ErrorInvalidHeader
+	}
+
+	// Trailing '-' padding is ignored by Deserialize, trimming it would also cut a field ending in '-'
+	var header BTree
+	err := binstruct.Deserialize(headerData[len(SECRETARY):SECRETARY_HEADER_EXTENSION], &header)
+	if err != nil {
+		return nil, err
+	}
+
+	extension := headerData[SECRETARY_HEADER_EXTENSION:]
+	switch extension[0] {
+	case SECRETARY_HEADER_VERSION:
+		header.MaxKeySize = binary.BigEndian.Uint16(extension[25:27])
+		fallthrough
+	case 2:
+		// Version 2 has fixed KEY_SIZE keys
+		header.FreeListIndex = binary.BigEndian.Uint64(extension[17:25])
+		fallthrough
+	case 1:
+		// Version 1 has no free list, its freed pages were never tracked
+		header.RootIndex = binary.BigEndian.Uint64(extension[1:9])
+		header.CheckpointLSN = binary.BigEndian.Uint64(extension[9:17])
+	case '-':
+		// Written before the extension existed, no node was ever checkpointed
+	default:
+		return nil, ErrorHeaderVersion(extension[0])
+	}
+
+	return &header, nil
+}
+
+// readHeader reads the counters saved by the last checkpoint
+func (tree *BTree) readHeader() error {
+	headerData, err := tree.nodePager.ReadAt(0, SECRETARY_HEADER_LENGTH)
+	if err != nil {
+		return err
+	}
+	header, err := parseHeader(headerData)
+	if err != nil {
+		return err
+	}
+
+	tree.KeySeq = header.KeySeq
+	tree.NodeSeq = header.NodeSeq
+	tree.NumNodeSeq = header.NumNodeSeq
+	tree.RootIndex = header.RootIndex
+	tree.CheckpointLSN = header.CheckpointLSN
+	tree.FreeListIndex = header.FreeListIndex
+
+	return tree.readFreeList()
 }
 
 func (tree *BTree) ReadNodeAtIndex(index uint64) (*Node, error) {
@@ -144,7 +349,10 @@ func (tree *BTree) ReadNodeAtIndex(index uint64) (*Node, error) {
 	}
 
 	page, err := tree.nodePager.ReadPage(int64(index))
-	return page.Data, err
+	if err != nil {
+		return nil, err
+	}
+	return page.Data, nil
 }
 
 func (tree *BTree) readRoot() error {
@@ -152,55 +360,84 @@ func (tree *BTree) readRoot() error {
 		return ErrorModeWASM
 	}
 
-	node, err := tree.ReadNodeAtIndex(0)
-	if err != nil {
-		return err
-	}
-	tree.root = node
-	return nil
+	tree.resetNodes()
+	return tree.loadRoot()
 }
 
+// WriteNodeAtIndex stores node with its links converted to page indexes
 func (tree *BTree) WriteNodeAtIndex(node *Node, index uint64) error {
 	if MODE_WASM {
 		return ErrorModeWASM
 	}
 
+	if err := tree.linkIndexes(node, index); err != nil {
+		return err
+	}
+
+	data, err := tree.nodeBytes(node)
+	if err != nil {
+		return err
+	}
+	return tree.nodePager.WritePageBytes(data, int64(index))
+}
+
+// linkIndexes converts the links of node to the page indexes that are serialized
+func (tree *BTree) linkIndexes(node *Node, index uint64) error {
 	node.Index = index
+	node.ParentIndex = 0
+	node.NextIndex = 0
+	node.PrevIndex = 0
 	if node.parent != nil {
 		node.ParentIndex = node.parent.Index
 	}
-	// if node.next != nil {
-	// 	node.NextIndex = node.next.Index
-	// }
-	// if node.prev != nil {
-	// 	node.PrevIndex = node.prev.Index
-	// }
+	if node.next != nil {
+		node.NextIndex = node.next.Index
+	}
+	if node.prev != nil {
+		node.PrevIndex = node.prev.Index
+	}
 
-	return tree.nodePager.WritePage(node, int64(index))
+	if node.children != nil {
+		node.IsLeaf = 0
+		node.KeyLocation = make([]uint64, len(node.children))
+		for i, child := range node.children {
+			node.KeyLocation[i] = child.Index
+		}
+	} else if node.records != nil {
+		node.IsLeaf = 1
+		node.KeyLocation = make([]uint64, len(node.records))
+		for i, record := range node.records {
+			if record.location == nil {
+				return ErrorInvalidDataLocation
+			}
+			node.KeyLocation[i] = record.location.ToDataLocation()
+		}
+	} else {
+		node.IsLeaf = 1
+		node.KeyLocation = []uint64{}
+	}
+
+	return nil
 }
 
+// WriteNode stores node, a node without page gets the next free index
 func (tree *BTree) WriteNode(node *Node) error {
 	if MODE_WASM {
 		return ErrorModeWASM
 	}
 
 	if node.Index == 0 {
-		lastFileIndex, err := tree.nodePager.NumPages()
-		if err != nil {
-			return err
-		}
-		if uint64(lastFileIndex) != tree.NumNodeSeq {
-			return fmt.Errorf("NumNodes dont match %d != %d", lastFileIndex, tree.NumNodeSeq)
-		}
-		tree.WriteNodeAtIndex(node, uint64(lastFileIndex))
-	} else {
-		tree.WriteNodeAtIndex(node, uint64(node.Index))
+		tree.addNode(node)
 	}
-	return nil
+	return tree.WriteNodeAtIndex(node, node.Index)
 }
 
 func (tree *BTree) writeRoot() error {
-	return tree.WriteNodeAtIndex(tree.root, 0)
+	if err := tree.WriteNode(tree.root); err != nil {
+		return err
+	}
+	tree.RootIndex = tree.root.Index
+	return nil
 }
 
 // func (s *Secretary) NewBTreeReadHeader(collectionName string) (*BTree, error) {
@@ -235,6 +472,37 @@ func (s *Secretary) NewBTreeReadHeader(collectionName string) (*BTree, error) {
 		return nil, ErrorModeWASM
 	}
 
+	tree, err := readBTree(collectionName, s.txnLog, newBTree)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tree.openIndexes(); err != nil {
+		tree.closeFiles()
+		return nil, err
+	}
+
+	s.AddTree(tree)
+
+	return tree, nil
+}
+
+// readBTree opens the tree saved in SECRETARY/<collectionName> with open and recovers it
+func readBTree(
+	collectionName string,
+	txnLog *TxnLog,
+	open func(string, uint8, uint8, uint32, uint8, uint32, uint16) (*BTree, error),
+) (*BTree, error) {
+	// A finished compaction replaces the files before the journal is applied to them
+	if err := recoverCompaction(collectionName); err != nil {
+		return nil, err
+	}
+
+	// A checkpoint interrupted while writing index.bin is finished first
+	if err := recoverJournal(collectionName); err != nil {
+		return nil, err
+	}
+
 	temptree := BTree{CollectionName: collectionName}
 	nodePager, err := temptree.NewNodePager("index", 0)
 	if err != nil {
@@ -242,44 +510,103 @@ func (s *Secretary) NewBTreeReadHeader(collectionName string) (*BTree, error) {
 	}
 
 	headerData, err := nodePager.ReadAt(0, SECRETARY_HEADER_LENGTH)
+	nodePager.Close()
 	if err != nil {
 		return nil, err
 	}
-
-	data := bytes.Trim(headerData, "-")[len(SECRETARY):]
-	var deserializedTree BTree
-	err = binstruct.Deserialize(data, &deserializedTree)
+	deserializedTree, err := parseHeader(headerData)
 	if err != nil {
 		return nil, err
 	}
 
-	tree, err := s.NewBTree(
+	tree, err := open(
 		collectionName,
 		deserializedTree.Order,
 		deserializedTree.NumLevel,
 		deserializedTree.BaseSize,
 		deserializedTree.Increment,
 		deserializedTree.CompactionBatchSize,
+		deserializedTree.MaxKeySize,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	tree.KeySeq = deserializedTree.KeySeq
 	tree.NodeSeq = deserializedTree.NodeSeq
 	tree.NumNodeSeq = deserializedTree.NumNodeSeq
+	tree.RootIndex = deserializedTree.RootIndex
+	tree.CheckpointLSN = deserializedTree.CheckpointLSN
+	tree.FreeListIndex = deserializedTree.FreeListIndex
 
-	// if err := tree.readRoot(); err != nil {
-	// 	fmt.Println("--> ", collectionName, err.Error())
-	// 	return nil, err
-	// }
+	schema, err := loadSchema(collectionName)
+	if err != nil {
+		tree.closeFiles()
+		return nil, err
+	}
+	tree.schema.Store(schema)
+
+	numPages, err := tree.nodePager.NumPages()
+	if err != nil {
+		tree.closeFiles()
+		return nil, err
+	}
+	tree.nextNodeIndex = uint64(max(numPages, 1))
+
+	if err := tree.readFreeList(); err != nil {
+		tree.closeFiles()
+		return nil, err
+	}
+
+	if err := tree.readRoot(); err != nil {
+		tree.closeFiles()
+		return nil, err
+	}
+
+	// Recover acknowledged mutations, transactions only if they committed
+	tree.txnLog = txnLog
+	if err := tree.replayWAL(); err != nil {
+		tree.closeFiles()
+		return nil, err
+	}
 
 	return tree, nil
 }
 
-func (tree *BTree) Height() int {
+// Stats returns the size and state of the tree
+func (tree *BTree) Stats() (TreeStats, error) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	defer tree.evictNodes()
+
+	height, err := tree.Height()
+	if err != nil {
+		return TreeStats{}, err
+	}
+
+	stats := TreeStats{
+		Height:           height,
+		NumNodes:         tree.NumNodeSeq,
+		LoadedNodes:      tree.numLoaded,
+		KeySeq:           tree.KeySeq,
+		ReclaimableBytes: tree.ReclaimableBytes,
+		CheckpointLSN:    tree.CheckpointLSN,
+		OpenSnapshots:    len(tree.snapshots),
+		Compaction:       tree.compactionStatus,
+	}
+	if tree.wal != nil {
+		stats.WALBytes = tree.wal.Size()
+	}
+	return stats, nil
+}
+
+func (tree *BTree) Height() (int, error) {
 	height := 0
 
 	for node := tree.root; node != nil; node = node.children[0] {
+		if err := tree.load(node); err != nil {
+			return 0, err
+		}
 		height++
 
 		// Stop if we've reached a leaf node (no children)
@@ -288,27 +615,33 @@ func (tree *BTree) Height() int {
 		}
 	}
 
-	return height
+	return height, nil
 }
 
-func (tree *BTree) Level(node *Node) int {
+func (tree *BTree) Level(node *Node) (int, error) {
 	if node == nil || tree.root == nil {
-		return -1 // Return -1 for invalid nodes
+		return -1, nil // Return -1 for invalid nodes
 	}
 
 	level := 0
 	for node != nil && node != tree.root {
 		level++
+		if err := tree.load(node); err != nil {
+			return -1, err
+		}
 		node = node.parent
 	}
 
-	return level
+	return level, nil
 }
 
-func (tree *BTree) GetFirstNodePerHeight() []*Node {
+func (tree *BTree) GetFirstNodePerHeight() ([]*Node, error) {
 	var firstNodePerHeight []*Node
 
 	for node := tree.root; node != nil; node = node.children[0] {
+		if err := tree.load(node); err != nil {
+			return nil, err
+		}
 		firstNodePerHeight = append(firstNodePerHeight, node)
 
 		// Stop if we've reached a leaf node (no children)
@@ -317,16 +650,19 @@ func (tree *BTree) GetFirstNodePerHeight() []*Node {
 		}
 	}
 
-	return firstNodePerHeight
+	return firstNodePerHeight, nil
 }
 
-func (tree *BTree) BFSCompactBatchTraversal() []*Node {
+func (tree *BTree) BFSCompactBatchTraversal() ([]*Node, error) {
 	var compactBatch []*Node
 
-	firstNodePerHeight := tree.GetFirstNodePerHeight()
+	firstNodePerHeight, err := tree.GetFirstNodePerHeight()
+	if err != nil {
+		return nil, err
+	}
 
 	if tree.root == nil {
-		return compactBatch
+		return compactBatch, nil
 	}
 	if tree.nextCompactionNode == nil {
 		tree.nextCompactionNode = tree.root
@@ -340,13 +676,19 @@ func (tree *BTree) BFSCompactBatchTraversal() []*Node {
 			break
 		}
 
+		if err := tree.load(tree.nextCompactionNode); err != nil {
+			return nil, err
+		}
 		compactBatch = append(compactBatch, tree.nextCompactionNode)
 
 		if tree.nextCompactionNode.next != nil {
 			tree.nextCompactionNode = tree.nextCompactionNode.next
 		} else {
 			// Ensure nextCompactionNode is not nil before calling Level()
-			level := tree.Level(tree.nextCompactionNode)
+			level, err := tree.Level(tree.nextCompactionNode)
+			if err != nil {
+				return nil, err
+			}
 
 			// utils.Log("level", level, "lenfirst", len(firstNodePerHeight), level > 0 && (level+1) < len(firstNodePerHeight))
 
@@ -354,17 +696,64 @@ func (tree *BTree) BFSCompactBatchTraversal() []*Node {
 				firstNode := firstNodePerHeight[level+1]
 				tree.nextCompactionNode = firstNode
 			} else {
+				// Last node of the last level, the next batch starts over at the root
+				tree.nextCompactionNode = nil
 				break
 			}
 		}
 	}
 
-	return compactBatch
+	return compactBatch, nil
+}
+
+func (tree *BTree) Erase() error {
+	indexes, unlock := tree.lockIndexes()
+	defer unlock()
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	if err := tree.keepAllVersions(); err != nil {
+		return err
+	}
+	if err := tree.logMutation(WAL_ERASE, nil, nil); err != nil {
+		return err
+	}
+
+	if err := tree.erase(); err != nil {
+		return err
+	}
+	tree.maybeCheckpoint()
+
+	// After the collection, a crash in between leaves stale entries
+	for _, index := range indexes.fields {
+		if err := index.tree.Erase(); err != nil {
+			return err
+		}
+	}
+	if indexes.text != nil {
+		if err := indexes.text.tree.Erase(); err != nil {
+			return err
+		}
+	}
+	if indexes.vectors != nil {
+		return indexes.vectors.reset()
+	}
+
+	return nil
 }
 
-func (tree *BTree) Erase() {
+func (tree *BTree) erase() error {
+	if err := tree.freeAllPages(); err != nil {
+		return err
+	}
+
+	tree.resetNodes()
+	tree.nextCompactionNode = nil
 	tree.root = nil
 	tree.NodeSeq = 0
 	tree.NumNodeSeq = 0
 	tree.KeySeq = 0
+
+	return nil
 }
@@ -44,6 +44,7 @@ func TestBtreeInvalid(t *testing.T) {
 		1024,
 		125,
 		1000,
+		0,
 	)
 	_, invalidIncrementErr := s.NewBTree(
 		"Tes",
@@ -52,6 +53,7 @@ func TestBtreeInvalid(t *testing.T) {
 		1024,
 		225,
 		1000,
+		0,
 	)
 	_, invalidOrderErr := s.NewBTree(
 		"Tes",
@@ -60,6 +62,7 @@ func TestBtreeInvalid(t *testing.T) {
 		1024,
 		225,
 		1000,
+		0,
 	)
 	if invalidNameErr == nil || invalidIncrementErr == nil || invalidOrderErr == nil {
 		t.Fatal(invalidNameErr, invalidIncrementErr, invalidOrderErr)
@@ -96,6 +99,47 @@ func TestBtreeSaveReadHeader(t *testing.T) {
 	s.PagerShutdown()
 }
 
+func TestBtreeReadLegacyHeader(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 10)
+
+	// Header as written before the extension, binstruct fields padded with '-'
+	headerBytes, err := binstruct.Serialize(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerBytes = append([]byte(SECRETARY), headerBytes...)
+	headerBytes = append(headerBytes, utils.MakeByteArray(SECRETARY_HEADER_LENGTH-len(headerBytes), '-')...)
+	if err := tree.nodePager.WriteAt(headerBytes, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	legacyTree, err := s.NewBTreeReadHeader(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eq, err := binstruct.Compare(tree, legacyTree)
+	if !eq || err != nil || legacyTree.RootIndex != 0 || legacyTree.CheckpointLSN != 0 {
+		t.Fatalf("\nShould be Equal\n%+v\n%+v", tree, legacyTree)
+	}
+
+	key := []byte(utils.GenerateSeqString(new(uint64), KEY_SIZE, KEY_INCREMENT))
+	if _, err := legacyTree.SetKV(key, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := legacyTree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	headerData, err := legacyTree.nodePager.ReadAt(0, SECRETARY_HEADER_LENGTH)
+	if err != nil || headerData[SECRETARY_HEADER_EXTENSION] != SECRETARY_HEADER_VERSION {
+		t.Fatal("Expected header extension after checkpoint", err)
+	}
+
+	s.PagerShutdown()
+}
+
 func TestBTreeHeight(t *testing.T) {
 	s := dummySecretary(t)
 	tree := dummyTree(t, s, 4)
@@ -111,8 +155,8 @@ func TestBTreeHeight(t *testing.T) {
 		}
 	}
 
-	if tree.Height() != 2 {
-		t.Fatalf("Expected height %d", tree.Height())
+	if height, err := tree.Height(); err != nil || height != 2 {
+		t.Fatalf("Expected height %d, got %d %v", 2, height, err)
 	}
 
 	key := []byte(utils.GenerateSeqRandomString(&keySeq, 16, 5, 4))
@@ -121,8 +165,8 @@ func TestBTreeHeight(t *testing.T) {
 		t.Fatalf("Set failed: %s", err)
 	}
 
-	if tree.Height() != 3 {
-		t.Fatalf("Expected height %d", tree.Height())
+	if height, err := tree.Height(); err != nil || height != 3 {
+		t.Fatalf("Expected height %d, got %d %v", 3, height, err)
 	}
 
 	s.PagerShutdown()
@@ -0,0 +1,430 @@
+package secretary
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"iter"
+	"slices"
+	"sort"
+	"sync/atomic"
+)
+
+/*
+Bulk Load
+
+BulkLoad merges a stream of records sorted by key into a tree that may
+already hold data. The stream is cut into chunks, every chunk is logged as
+one WAL_BULK_LOAD entry in the dump format, merged and checkpointed under
+one acquisition of the tree latch. A crash keeps the chunks that reached
+the WAL.
+
+Merging walks the leaves in key order instead of descending once per key :
+the records that belong to a leaf, those before the first key of the next
+leaf, are merged into it in one step. A leaf that overflows is cut into
+leaves of FillFactor * (Order - 1) keys, appending past the last key
+therefore packs whole leaves. Internal nodes split as usual.
+
+Loaded keys replace the values of existing keys. The records can come from
+CSV (key,value rows), NDJSON (the lines of GET /range) or a binary dump
+written by Dump.
+*/
+
+const (
+	BULK_LOAD_FILL_FACTOR = 0.9   // Share of a leaf filled by loaded keys
+	BULK_LOAD_CHUNK_SIZE  = 10000 // Records merged and checkpointed together
+)
+
+// BulkLoadOptions configure BulkLoad, zero values take the defaults
+type BulkLoadOptions struct {
+	FillFactor float64 // Between 0.5 and 1
+	ChunkSize  int
+}
+
+// BulkLoadResult counts the records a bulk load merged
+type BulkLoadResult struct {
+	Loaded  int `json:"loaded"`
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+}
+
+// BulkLoad merges records sorted by strictly increasing keys into the tree.
+// On error the chunks before the failing record stay loaded, result counts them.
+func (tree *BTree) BulkLoad(records iter.Seq2[*Record, error], options BulkLoadOptions) (BulkLoadResult, error) {
+	result := BulkLoadResult{}
+
+	fillFactor := options.FillFactor
+	if fillFactor == 0 {
+		fillFactor = BULK_LOAD_FILL_FACTOR
+	}
+	if fillFactor < 0.5 || fillFactor > 1 {
+		return result, ErrorInvalidFillFactor
+	}
+	chunkSize := options.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = BULK_LOAD_CHUNK_SIZE
+	}
+	perLeaf := max(1, int(fillFactor*float64(tree.Order-1)))
+
+	chunk := make([]*Record, 0, chunkSize)
+	flush := func() error {
+		created, err := tree.loadChunk(chunk, perLeaf)
+		if err != nil {
+			return err
+		}
+		result.Loaded += len(chunk)
+		result.Created += created
+		result.Updated += len(chunk) - created
+		chunk = chunk[:0]
+		return nil
+	}
+
+	var last []byte
+	for record, err := range records {
+		if err != nil {
+			return result, err
+		}
+		if last != nil && bytes.Compare(last, record.Key) >= 0 {
+			return result, ErrorRecordsNotSorted
+		}
+		if err := tree.checkKey(record.Key); err != nil {
+			return result, err
+		}
+		value, err := tree.encodeValue(record.Value)
+		if err != nil {
+			return result, err
+		}
+		if err := tree.checkRecordSize(record.Key, value); err != nil {
+			return result, err
+		}
+		if tree.encodesValues() {
+			record = &Record{Key: record.Key, Value: value}
+		}
+		last = record.Key
+
+		chunk = append(chunk, record)
+		if len(chunk) == chunkSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if len(chunk) > 0 {
+		return result, flush()
+	}
+	return result, nil
+}
+
+// loadChunk logs, merges and checkpoints sorted records, it returns how many keys were new
+func (tree *BTree) loadChunk(records []*Record, perLeaf int) (created int, err error) {
+	indexes, unlock := tree.lockIndexes()
+	defer unlock()
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	defer tree.evictNodes()
+
+	finish := func(bool) {}
+	if !indexes.empty() {
+		ops := make([]TxnOp, len(records))
+		for i, record := range records {
+			ops[i] = TxnOp{Op: WAL_SET, Key: record.Key, Value: record.Value}
+		}
+		changes, err := tree.indexChanges(ops, tree.documentLocked)
+		if err != nil {
+			return 0, err
+		}
+		if finish, err = beginIndexWrite(indexes, changes, true); err != nil {
+			return 0, err
+		}
+	}
+
+	if tree.wal != nil {
+		value := []byte{}
+		for _, record := range records {
+			value = appendDumpRecord(value, record)
+		}
+		perLeafBytes := binary.BigEndian.AppendUint64(nil, uint64(perLeaf))
+		if err := tree.logMutation(WAL_BULK_LOAD, perLeafBytes, value); err != nil {
+			return 0, err
+		}
+		if err := tree.wal.Sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	err = tree.applyLogged(func() error {
+		created, err = tree.mergeSorted(records, perLeaf)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	finish(true)
+
+	// Dirty nodes are never evicted, the checkpoint lets the next chunks reuse the memory
+	if tree.nodePager != nil {
+		if err := tree.checkpoint(); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// mergeSorted merges sorted records leaf by leaf, tree.mu is held exclusively
+func (tree *BTree) mergeSorted(records []*Record, perLeaf int) (created int, err error) {
+	if tree.root == nil {
+		tree.root = tree.createLeafNode()
+	}
+
+	for i := 0; i < len(records); {
+		leaf, _, _, err := tree.getLeafNode(records[i].Key)
+		if err != nil {
+			return created, err
+		}
+
+		// The leaf holds the keys before the first key of the next leaf
+		end := len(records)
+		if leaf.next != nil {
+			if err := tree.load(leaf.next); err != nil {
+				return created, err
+			}
+			upper := leaf.next.Keys[0]
+			end = i + sort.Search(len(records)-i, func(j int) bool {
+				return bytes.Compare(records[i+j].Key, upper) >= 0
+			})
+		}
+
+		created += tree.mergeIntoLeaf(leaf, records[i:end], perLeaf)
+		i = end
+	}
+
+	return created, nil
+}
+
+// mergeIntoLeaf merges sorted records into leaf and cuts it into packed leaves if it overflows
+func (tree *BTree) mergeIntoLeaf(leaf *Node, records []*Record, perLeaf int) (created int) {
+	tree.markDirty(leaf)
+
+	keys := make([][]byte, 0, len(leaf.Keys)+len(records))
+	merged := make([]*Record, 0, len(leaf.Keys)+len(records))
+	i := 0
+	for _, record := range records {
+		for i < len(leaf.Keys) && bytes.Compare(leaf.Keys[i], record.Key) < 0 {
+			keys = append(keys, leaf.Keys[i])
+			merged = append(merged, leaf.records[i])
+			i++
+		}
+
+		if i < len(leaf.Keys) && bytes.Equal(leaf.Keys[i], record.Key) {
+			tree.keepVersion(leaf.Keys[i], leaf.records[i])
+			i++
+		} else {
+			tree.keepVersion(record.Key, nil)
+			atomic.AddUint64(&tree.KeySeq, KEY_INCREMENT)
+			created++
+		}
+		keys = append(keys, record.Key)
+		merged = append(merged, &Record{Key: record.Key, Value: record.Value})
+	}
+	keys = append(keys, leaf.Keys[i:]...)
+	merged = append(merged, leaf.records[i:]...)
+
+	sizes := tree.packSizes(len(keys), perLeaf)
+	leaf.Keys = slices.Clip(keys[:sizes[0]])
+	leaf.records = slices.Clip(merged[:sizes[0]])
+
+	left, start := leaf, sizes[0]
+	for _, size := range sizes[1:] {
+		right := tree.createLeafNode()
+		right.Keys = append(right.Keys, keys[start:start+size]...)
+		right.records = append(right.records, merged[start:start+size]...)
+
+		tree.markDirty(left.next)
+		right.next = left.next
+		if left.next != nil {
+			left.next.prev = right
+		}
+		left.next = right
+		right.prev = left
+
+		tree.promoteKey(left, right.Keys[0], right)
+		left, start = right, start+size
+	}
+
+	return created
+}
+
+// packSizes divides numKeys into leaves of perLeaf keys and a last one with the rest,
+// every leaf keeps between minNumKeys and Order - 1 keys
+func (tree *BTree) packSizes(numKeys int, perLeaf int) []int {
+	maxKeys := int(tree.Order) - 1
+	minKeys := int(tree.minNumKeys)
+	if numKeys <= maxKeys {
+		return []int{numKeys}
+	}
+
+	sizes := make([]int, 0, numKeys/perLeaf+1)
+	for left := numKeys; left > 0; left -= perLeaf {
+		sizes = append(sizes, min(left, perLeaf))
+	}
+
+	// A short last leaf takes keys from the one before
+	last := len(sizes) - 1
+	if sizes[last] < minKeys {
+		total := sizes[last-1] + sizes[last]
+		if total <= maxKeys {
+			sizes[last-1] = total
+			sizes = sizes[:last]
+		} else {
+			sizes[last-1], sizes[last] = total-minKeys, minKeys
+		}
+	}
+	return sizes
+}
+
+// applyWALBulkLoad replays a WAL_BULK_LOAD entry with the same packing
+func (tree *BTree) applyWALBulkLoad(entry *WALEntry) error {
+	if len(entry.Key) != 8 {
+		return ErrorInvalidDataLocation
+	}
+
+	records := []*Record{}
+	for record, err := range DumpRecords(bytes.NewReader(entry.Value)) {
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+
+	_, err := tree.mergeSorted(records, int(binary.BigEndian.Uint64(entry.Key)))
+	return err
+}
+
+//------------------------------------------------------------------
+// Sources
+//------------------------------------------------------------------
+
+// LoadRecords reads records in format "csv", "ndjson" or "dump"
+func LoadRecords(format string, r io.Reader) (iter.Seq2[*Record, error], error) {
+	switch format {
+	case "csv":
+		return CSVRecords(r), nil
+	case "ndjson":
+		return NDJSONRecords(r), nil
+	case "dump":
+		return DumpRecords(r), nil
+	}
+	return nil, ErrorLoadFormat(format)
+}
+
+// CSVRecords reads key,value rows
+func CSVRecords(r io.Reader) iter.Seq2[*Record, error] {
+	return func(yield func(*Record, error) bool) {
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = 2
+		reader.ReuseRecord = true
+
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(&Record{Key: []byte(row[0]), Value: []byte(row[1])}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// NDJSONRecords reads {"key":...,"value":...} lines, as written by GET /range
+func NDJSONRecords(r io.Reader) iter.Seq2[*Record, error] {
+	return func(yield func(*Record, error) bool) {
+		decoder := json.NewDecoder(r)
+		for decoder.More() {
+			var line struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			}
+			if err := decoder.Decode(&line); err != nil {
+				yield(nil, ErrorInvalidJson)
+				return
+			}
+			if !yield(&Record{Key: []byte(line.Key), Value: []byte(line.Value)}, nil) {
+				return
+			}
+		}
+	}
+}
+
+/*
+**Dump Record**
++-----------------+-----+-------------------+-------+
+| Key length      | Key | Value length      | Value |
+| (uvarint)       |     | (uvarint)         |       |
++-----------------+-----+-------------------+-------+
+*/
+
+// DumpRecords reads records written by Dump
+func DumpRecords(r io.Reader) iter.Seq2[*Record, error] {
+	return func(yield func(*Record, error) bool) {
+		reader := bufio.NewReader(r)
+		readBytes := func() ([]byte, error) {
+			size, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return nil, err
+			}
+			data := make([]byte, size)
+			_, err = io.ReadFull(reader, data)
+			return data, err
+		}
+
+		for {
+			key, err := readBytes()
+			if err == io.EOF {
+				return
+			}
+			var value []byte
+			if err == nil {
+				value, err = readBytes()
+			}
+			if err != nil {
+				yield(nil, errors.Join(ErrorInvalidDump, err))
+				return
+			}
+			if !yield(&Record{Key: key, Value: value}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Dump writes every record of the tree in key order, BulkLoad reads it back with DumpRecords
+func (tree *BTree) Dump(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	for record, err := range tree.Iterate(ScanOptions{}) {
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(appendDumpRecord(nil, record)); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func appendDumpRecord(data []byte, record *Record) []byte {
+	data = binary.AppendUvarint(data, uint64(len(record.Key)))
+	data = append(data, record.Key...)
+	data = binary.AppendUvarint(data, uint64(len(record.Value)))
+	return append(data, record.Value...)
+}
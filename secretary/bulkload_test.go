@@ -0,0 +1,142 @@
+package secretary
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func sortedRecords(keys []string) func(yield func(*Record, error) bool) {
+	return func(yield func(*Record, error) bool) {
+		for _, key := range keys {
+			if !yield(&Record{Key: []byte(key), Value: []byte("loaded" + key)}, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestBulkLoadMerge(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 10)
+
+	expected := map[string]string{}
+	for i := 0; i < 200; i += 4 {
+		key := fmt.Sprintf("key%04d", i)
+		if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = "value" + key
+	}
+
+	// Between, over and after the keys of the tree
+	keys := []string{}
+	for i := 0; i < 1000; i += 2 {
+		keys = append(keys, fmt.Sprintf("key%04d", i))
+	}
+	result, err := tree.BulkLoad(sortedRecords(keys), BulkLoadOptions{FillFactor: 1, ChunkSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Loaded != 500 || result.Updated != 50 || result.Created != 450 {
+		t.Fatal("Result mismatch", result)
+	}
+	for _, key := range keys {
+		expected[key] = "loaded" + key
+	}
+	verifyTreeRecords(t, tree, expected)
+
+	// Keys past the end fill whole leaves
+	leaf, _, _, err := tree.getLeafNode([]byte("key0800"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaf.Keys) != int(tree.Order)-1 {
+		t.Fatal("Expected a packed leaf", len(leaf.Keys))
+	}
+
+	if _, err := tree.BulkLoad(sortedRecords([]string{"b", "a"}), BulkLoadOptions{}); err != ErrorRecordsNotSorted {
+		t.Fatal("Expected unsorted records", err)
+	}
+	if _, err := tree.BulkLoad(sortedRecords(keys), BulkLoadOptions{FillFactor: 0.2}); err != ErrorInvalidFillFactor {
+		t.Fatal("Expected an invalid fill factor", err)
+	}
+
+	// The last chunk is in the WAL only, reopen as after a kill -9
+	result, err = tree.BulkLoad(sortedRecords([]string{"key1000", "key1001"}), BulkLoadOptions{})
+	if err != nil || result.Created != 2 {
+		t.Fatal("Expected two new keys", result, err)
+	}
+	if err := tree.logMutation(WAL_DELETE, []byte("key1001"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.delete([]byte("key1001")); err != nil {
+		t.Fatal(err)
+	}
+	expected["key1000"] = "loadedkey1000"
+
+	reopened := dummySecretary(t)
+	reloaded, err := reopened.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTreeRecords(t, reloaded, expected)
+
+	reopened.PagerShutdown()
+	s.PagerShutdown()
+}
+
+func TestBulkLoadSources(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	records, err := LoadRecords("csv", strings.NewReader("a,1\nb,\"2,3\"\nc,4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.BulkLoad(records, BulkLoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err = LoadRecords("ndjson", strings.NewReader(`{"key":"d","value":"5"}`+"\n"+`{"key":"e","value":"6"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.BulkLoad(records, BulkLoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	verifyTreeRecords(t, tree, map[string]string{"a": "1", "b": "2,3", "c": "4", "d": "5", "e": "6"})
+
+	var dump bytes.Buffer
+	if err := tree.Dump(&dump); err != nil {
+		t.Fatal(err)
+	}
+	copied := dummyTree(t, s, 4)
+	result, err := copied.BulkLoad(DumpRecords(bytes.NewReader(dump.Bytes())), BulkLoadOptions{})
+	if err != nil || result.Created != 5 {
+		t.Fatal("Dump mismatch", result, err)
+	}
+	verifyTreeRecords(t, copied, map[string]string{"a": "1", "b": "2,3", "c": "4", "d": "5", "e": "6"})
+
+	keys := []string{}
+	for record, err := range DumpRecords(bytes.NewReader(dump.Bytes()[:dump.Len()-1])) {
+		if err != nil {
+			if !strings.Contains(err.Error(), ErrorInvalidDump.Error()) {
+				t.Fatal("Expected a truncated dump", err)
+			}
+			break
+		}
+		keys = append(keys, string(record.Key))
+	}
+	if !slices.Equal(keys, []string{"a", "b", "c", "d"}) {
+		t.Fatal("Expected the records before the truncated one", keys)
+	}
+
+	if _, err := LoadRecords("xml", nil); err == nil {
+		t.Fatal("Expected an unknown format")
+	}
+
+	s.PagerShutdown()
+}
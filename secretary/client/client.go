@@ -0,0 +1,217 @@
+/*
+Package client is the typed Go client of the secretary RPC service.
+
+	c := client.New("http://localhost:8080")
+	created, err := c.Set(ctx, "users", []byte("alice"), []byte("{}"))
+
+Errors are *connect.Error, IsNotFound tells a missing collection or key apart.
+Scan returns an iterator and resumes a lost stream after the last record it
+received.
+*/
+package client
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/codeharik/secretary/api"
+	"github.com/codeharik/secretary/api/apiconnect"
+	"google.golang.org/protobuf/proto"
+)
+
+const SCAN_RESUME_ATTEMPTS = 3 // Times Scan reopens a stream that broke
+
+var errScanStopped = errors.New("Scan stopped by the caller")
+
+// Client calls a secretary server
+type Client struct {
+	rpc apiconnect.SecretaryClient
+}
+
+// Option configures New
+type Option func(*config)
+
+type config struct {
+	httpClient connect.HTTPClient
+	options    []connect.ClientOption
+}
+
+// WithHTTPClient sends the requests with httpClient instead of http.DefaultClient
+func WithHTTPClient(httpClient connect.HTTPClient) Option {
+	return func(c *config) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithGRPC speaks gRPC instead of the Connect protocol, the http client must support HTTP/2
+func WithGRPC() Option {
+	return func(c *config) {
+		c.options = append(c.options, connect.WithGRPC())
+	}
+}
+
+// WithClientOptions passes options to the Connect client
+func WithClientOptions(options ...connect.ClientOption) Option {
+	return func(c *config) {
+		c.options = append(c.options, options...)
+	}
+}
+
+// New returns a client of the server at baseURL, like http://localhost:8080
+func New(baseURL string, options ...Option) *Client {
+	c := &config{httpClient: http.DefaultClient}
+	for _, option := range options {
+		option(c)
+	}
+	return &Client{rpc: apiconnect.NewSecretaryClient(c.httpClient, baseURL, c.options...)}
+}
+
+// IsNotFound reports if err is a missing collection, key or snapshot
+func IsNotFound(err error) bool {
+	return connect.CodeOf(err) == connect.CodeNotFound
+}
+
+// CollectionOptions configure a new collection, zero values are rejected except MaxKeySize
+type CollectionOptions struct {
+	Order               uint32
+	NumLevel            uint32
+	BaseSize            uint32
+	Increment           uint32
+	CompactionBatchSize uint32
+	MaxKeySize          uint32 // 0 for the server default
+}
+
+func (c *Client) CreateCollection(ctx context.Context, name string, options CollectionOptions) (*api.Collection, error) {
+	res, err := c.rpc.CreateCollection(ctx, connect.NewRequest(&api.CreateCollectionRequest{
+		CollectionName:      name,
+		Order:               options.Order,
+		NumLevel:            options.NumLevel,
+		BaseSize:            options.BaseSize,
+		Increment:           options.Increment,
+		CompactionBatchSize: options.CompactionBatchSize,
+		MaxKeySize:          options.MaxKeySize,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return res.Msg.Collection, nil
+}
+
+// ListCollections returns the collections sorted by name
+func (c *Client) ListCollections(ctx context.Context) ([]*api.Collection, error) {
+	res, err := c.rpc.ListCollections(ctx, connect.NewRequest(&api.ListCollectionsRequest{}))
+	if err != nil {
+		return nil, err
+	}
+	return res.Msg.Collections, nil
+}
+
+// Get returns the value of key
+func (c *Client) Get(ctx context.Context, collection string, key []byte) ([]byte, error) {
+	return c.GetAt(ctx, collection, key, 0)
+}
+
+// GetAt returns the value of key as seen by an open snapshot, 0 for the latest value
+func (c *Client) GetAt(ctx context.Context, collection string, key []byte, snapshot uint64) ([]byte, error) {
+	res, err := c.rpc.Get(ctx, connect.NewRequest(&api.GetRequest{CollectionName: collection, Key: key, Snapshot: snapshot}))
+	if err != nil {
+		return nil, err
+	}
+	return res.Msg.Record.GetValue(), nil
+}
+
+// Set inserts or replaces the value of key, created is false if key existed
+func (c *Client) Set(ctx context.Context, collection string, key []byte, value []byte) (created bool, err error) {
+	res, err := c.rpc.Set(ctx, connect.NewRequest(&api.SetRequest{CollectionName: collection, Key: key, Value: value}))
+	if err != nil {
+		return false, err
+	}
+	return res.Msg.Created, nil
+}
+
+// BatchSet sets records in one transaction, all or none of them are written
+func (c *Client) BatchSet(ctx context.Context, collection string, records []*api.Record) (created uint32, updated uint32, err error) {
+	res, err := c.rpc.BatchSet(ctx, connect.NewRequest(&api.BatchSetRequest{CollectionName: collection, Records: records}))
+	if err != nil {
+		return 0, 0, err
+	}
+	return res.Msg.Created, res.Msg.Updated, nil
+}
+
+// ApplyBatch applies ops in order with one WAL sync, an op that cannot apply is skipped and its result holds why
+func (c *Client) ApplyBatch(ctx context.Context, collection string, ops []*api.BatchOp) ([]*api.BatchOpResult, error) {
+	res, err := c.rpc.ApplyBatch(ctx, connect.NewRequest(&api.ApplyBatchRequest{CollectionName: collection, Ops: ops}))
+	if err != nil {
+		return nil, err
+	}
+	return res.Msg.Results, nil
+}
+
+func (c *Client) Delete(ctx context.Context, collection string, key []byte) error {
+	_, err := c.rpc.Delete(ctx, connect.NewRequest(&api.DeleteRequest{CollectionName: collection, Key: key}))
+	return err
+}
+
+func (c *Client) Stats(ctx context.Context, collection string) (*api.StatsResponse, error) {
+	res, err := c.rpc.Stats(ctx, connect.NewRequest(&api.StatsRequest{CollectionName: collection}))
+	if err != nil {
+		return nil, err
+	}
+	return res.Msg, nil
+}
+
+// Scan returns the records of request one by one. A stream that breaks with
+// CodeUnavailable is reopened after the last record received.
+func (c *Client) Scan(ctx context.Context, request *api.ScanRequest) iter.Seq2[*api.Record, error] {
+	return func(yield func(*api.Record, error) bool) {
+		msg := proto.Clone(request).(*api.ScanRequest)
+
+		attempts := 0
+		for {
+			received, err := c.scanStream(ctx, msg, yield)
+			if received > 0 {
+				attempts = 0
+			}
+			if err == nil || errors.Is(err, errScanStopped) {
+				return
+			}
+
+			attempts++
+			if connect.CodeOf(err) != connect.CodeUnavailable || attempts > SCAN_RESUME_ATTEMPTS {
+				yield(nil, err)
+				return
+			}
+		}
+	}
+}
+
+// scanStream yields the records of one stream, moving the resume token and limit of msg along
+func (c *Client) scanStream(ctx context.Context, msg *api.ScanRequest, yield func(*api.Record, error) bool) (received int, err error) {
+	stream, err := c.rpc.Scan(ctx, connect.NewRequest(msg))
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	for stream.Receive() {
+		page := stream.Msg()
+		for _, record := range page.Records {
+			received++
+			if !yield(record, nil) {
+				return received, errScanStopped
+			}
+		}
+
+		msg.ResumeToken = page.ResumeToken
+		if msg.Limit > 0 {
+			msg.Limit -= uint32(len(page.Records))
+			if msg.Limit == 0 {
+				return received, nil
+			}
+		}
+	}
+	return received, stream.Err()
+}
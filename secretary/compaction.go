@@ -0,0 +1,600 @@
+package secretary
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/codeharik/secretary/utils"
+	"github.com/codeharik/secretary/utils/file"
+)
+
+/*
+Compaction
+
+Freed pages are reused, but the files never shrink and the nodes of a level
+end up scattered over index.bin. A compaction pass walks the tree with
+BFSCompactBatchTraversal, each batch runs under tree.mu :
+
+  - every node of the batch gets the next page of the new index.bin, its
+    children get theirs right after, so the pages follow the BFS order
+  - the records of a leaf are copied to the end of the new record files
+  - the node is written with its links translated to the new pages
+
+A node changed after it was written is written again, nodes and records
+removed after they were copied free their new page. Dirty nodes are left
+for the swap.
+
+Once the batch cursor wraps, swapCompaction checkpoints the tree, writes
+the nodes that are still missing, the free list and the header, and marks
+the new files complete with compact/ready. From then on they replace the
+old files, a crash before they are renamed is finished by recoverCompaction.
+*/
+
+const (
+	COMPACTION_DIR      = "compact"
+	COMPACTION_READY    = "ready"
+	COMPACTION_INTERVAL = 100 * time.Millisecond // Pause between two batches of the background compactor
+)
+
+func compactionDir(collectionName string) string {
+	return fmt.Sprintf("%s/%s/%s", SECRETARY, collectionName, COMPACTION_DIR)
+}
+
+//------------------------------------------------------------------
+// Background Compactor
+//------------------------------------------------------------------
+
+// StartCompaction compacts the tree in the background, one batch every interval.
+// A new pass only starts once some bytes are reclaimable.
+func (tree *BTree) StartCompaction(interval time.Duration) error {
+	if MODE_WASM {
+		return ErrorModeWASM
+	}
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	if tree.CompactionBatchSize == 0 {
+		return ErrorCompactionBatchSize
+	}
+	if tree.compactionStop != nil {
+		return nil
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	tree.compactionStop, tree.compactionDone = stop, done
+	tree.compactionStatus.Running = true
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if _, err := tree.compactInBackground(); err != nil {
+					utils.Log("Compaction", tree.CollectionName, err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// StopCompaction stops the background compactor, the running pass continues on the next start
+func (tree *BTree) StopCompaction() {
+	tree.mu.Lock()
+	stop, done := tree.compactionStop, tree.compactionDone
+	tree.compactionStop, tree.compactionDone = nil, nil
+	tree.compactionStatus.Running = false
+	tree.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (tree *BTree) CompactionStatus() CompactionStatus {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	return tree.compactionStatus
+}
+
+func (tree *BTree) compactInBackground() (bool, error) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	defer tree.evictNodes()
+
+	if tree.compaction == nil && tree.ReclaimableBytes == 0 {
+		return false, nil
+	}
+	return tree.compactStep()
+}
+
+// CompactStep compacts the next batch of nodes and returns true once the files were swapped
+func (tree *BTree) CompactStep() (bool, error) {
+	if MODE_WASM {
+		return false, ErrorModeWASM
+	}
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	defer tree.evictNodes()
+
+	return tree.compactStep()
+}
+
+func (tree *BTree) compactStep() (swapped bool, err error) {
+	if tree.root == nil {
+		return false, nil
+	}
+	if tree.CompactionBatchSize == 0 {
+		return false, ErrorCompactionBatchSize
+	}
+
+	defer func() {
+		if err != nil {
+			// The old files are untouched, the next pass starts over
+			tree.compactionStatus.LastError = err.Error()
+			tree.abortCompaction()
+		}
+	}()
+
+	if tree.compaction == nil {
+		compaction, err := tree.newCompaction()
+		if err != nil {
+			return false, err
+		}
+		tree.compaction = compaction
+		tree.nextCompactionNode = nil
+	}
+
+	batch, err := tree.BFSCompactBatchTraversal()
+	if err != nil {
+		return false, err
+	}
+	for _, node := range batch {
+		if err := tree.compactNode(node); err != nil {
+			return false, err
+		}
+	}
+
+	if tree.nextCompactionNode != nil {
+		return false, nil
+	}
+	return true, tree.swapCompaction()
+}
+
+//------------------------------------------------------------------
+// Compaction Pass
+//------------------------------------------------------------------
+
+func (tree *BTree) newCompaction() (*Compaction, error) {
+	// A swap that could not rename its files is finished before the directory is reused
+	if err := recoverCompaction(tree.CollectionName); err != nil {
+		return nil, err
+	}
+	if err := file.EnsureDir(compactionDir(tree.CollectionName)); err != nil {
+		return nil, err
+	}
+
+	// Pagers of the new files, named like the ones they replace
+	shadow := &BTree{
+		CollectionName: tree.CollectionName + "/" + COMPACTION_DIR,
+		BaseSize:       tree.BaseSize,
+		Increment:      tree.Increment,
+		nodeSize:       tree.nodeSize,
+	}
+
+	compaction := &Compaction{
+		nodeIndexes: map[*Node]uint64{},
+		written:     map[*Node]bool{},
+		records:     map[uint64]uint64{},
+		nextIndex:   1,
+	}
+
+	nodePager, err := shadow.NewNodePager("index", 0)
+	if err != nil {
+		return nil, err
+	}
+	compaction.nodePager = nodePager
+
+	compaction.recordPagers = make([]*RecordPager, len(tree.recordPagers))
+	for i := range compaction.recordPagers {
+		pager, err := shadow.NewRecordPager("record", uint8(i))
+		if err != nil {
+			compaction.close()
+			return nil, err
+		}
+		compaction.recordPagers[i] = pager
+	}
+
+	tree.compactionStatus.NodesCopied = 0
+	tree.compactionStatus.RecordsCopied = 0
+
+	return compaction, nil
+}
+
+// abortCompaction drops the running pass and its files
+func (tree *BTree) abortCompaction() {
+	if tree.compaction == nil {
+		return
+	}
+
+	tree.compaction.close()
+	tree.compaction = nil
+
+	if err := os.RemoveAll(compactionDir(tree.CollectionName)); err != nil {
+		utils.Log("Compaction", tree.CollectionName, err)
+	}
+}
+
+func (compaction *Compaction) close() {
+	if compaction.nodePager != nil {
+		compaction.nodePager.Close()
+	}
+	for _, pager := range compaction.recordPagers {
+		if pager != nil {
+			pager.Close()
+		}
+	}
+}
+
+func (compaction *Compaction) sync() error {
+	if err := compaction.nodePager.file.Sync(); err != nil {
+		return err
+	}
+	for _, pager := range compaction.recordPagers {
+		if err := pager.file.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageIndex returns the page of node in the new index.bin, the next unused one the first time
+func (compaction *Compaction) pageIndex(node *Node) uint64 {
+	if node == nil {
+		return 0
+	}
+
+	index, ok := compaction.nodeIndexes[node]
+	if !ok {
+		index = compaction.nextIndex
+		compaction.nextIndex++
+		compaction.nodeIndexes[node] = index
+	}
+	return index
+}
+
+// invalidate drops the written copy of a node that is about to change
+func (compaction *Compaction) invalidate(node *Node) {
+	if compaction == nil {
+		return
+	}
+	delete(compaction.written, node)
+}
+
+// release frees the new page of a node that was removed from the tree
+func (compaction *Compaction) release(node *Node) {
+	if compaction == nil {
+		return
+	}
+
+	if index, ok := compaction.nodeIndexes[node]; ok {
+		compaction.nodePager.FreePage(int64(index))
+		delete(compaction.nodeIndexes, node)
+		delete(compaction.written, node)
+	}
+}
+
+// releaseRecord frees the new slot of a removed record, its old slot may hold another record later
+func (compaction *Compaction) releaseRecord(location *RecordLocation) {
+	if compaction == nil {
+		return
+	}
+
+	dataLocation := location.ToDataLocation()
+	if copied, ok := compaction.records[dataLocation]; ok {
+		newLocation := ToRecordLocation(copied)
+		pager := compaction.recordPagers[newLocation.batchLevel]
+		pager.FreePage(int64(newLocation.offset) / pager.itemSize)
+		delete(compaction.records, dataLocation)
+	}
+}
+
+// copyRecord copies a stored record to the end of the new record file of its level
+func (tree *BTree) copyRecord(location *RecordLocation) (uint64, error) {
+	compaction := tree.compaction
+
+	dataLocation := location.ToDataLocation()
+	if copied, ok := compaction.records[dataLocation]; ok {
+		return copied, nil
+	}
+	if int(location.batchLevel) >= len(tree.recordPagers) {
+		return 0, ErrorInvalidDataLocation
+	}
+
+	pager := tree.recordPagers[location.batchLevel]
+	data, err := pager.ReadAt(int64(location.offset), int32(pager.itemSize))
+	if err != nil {
+		return 0, err
+	}
+
+	newPager := compaction.recordPagers[location.batchLevel]
+	index, err := newPager.NumPages()
+	if err != nil {
+		return 0, err
+	}
+	if err := newPager.WriteAt(data, index*newPager.itemSize); err != nil {
+		return 0, err
+	}
+
+	copied := RecordLocation{batchLevel: location.batchLevel, offset: uint64(index * newPager.itemSize)}
+	compaction.records[dataLocation] = copied.ToDataLocation()
+	tree.compactionStatus.RecordsCopied++
+
+	return copied.ToDataLocation(), nil
+}
+
+// compactNode writes node to the new index.bin, a dirty node is written by the swap after its checkpoint
+func (tree *BTree) compactNode(node *Node) error {
+	compaction := tree.compaction
+
+	if compaction.written[node] {
+		return nil
+	}
+	if _, dirty := tree.dirtyNodes[node]; dirty {
+		return nil
+	}
+	if err := tree.load(node); err != nil {
+		return err
+	}
+
+	page := &Node{
+		Version:     node.Version,
+		NodeID:      node.NodeID,
+		Index:       compaction.pageIndex(node),
+		ParentIndex: compaction.pageIndex(node.parent),
+		IsLeaf:      1,
+		Keys:        node.Keys,
+		KeyLocation: []uint64{},
+	}
+
+	if node.children != nil {
+		page.IsLeaf = 0
+		for _, child := range node.children {
+			page.KeyLocation = append(page.KeyLocation, compaction.pageIndex(child))
+		}
+	} else {
+		for _, record := range node.records {
+			if record.location == nil {
+				return ErrorInvalidDataLocation
+			}
+			copied, err := tree.copyRecord(record.location)
+			if err != nil {
+				return err
+			}
+			page.KeyLocation = append(page.KeyLocation, copied)
+		}
+	}
+
+	// Siblings after the children, the next sibling is mostly a child of the same parent
+	page.NextIndex = compaction.pageIndex(node.next)
+	page.PrevIndex = compaction.pageIndex(node.prev)
+
+	data, err := tree.nodeBytes(page)
+	if err != nil {
+		return err
+	}
+	offset := int64(page.Index)*compaction.nodePager.itemSize + compaction.nodePager.headerSize
+	if err := compaction.nodePager.WriteAt(data, offset); err != nil {
+		return err
+	}
+
+	compaction.written[node] = true
+	tree.compactionStatus.NodesCopied++
+
+	return nil
+}
+
+//------------------------------------------------------------------
+// Swap
+//------------------------------------------------------------------
+
+// swapCompaction completes the new files and replaces the old ones with them
+func (tree *BTree) swapCompaction() error {
+	compaction := tree.compaction
+
+	// Every record gets its location and no node stays dirty
+	if err := tree.checkpoint(); err != nil {
+		return err
+	}
+	// Records kept for snapshots are not copied
+	if err := tree.detachVersions(); err != nil {
+		return err
+	}
+
+	// Nodes changed after they were written, nodes reached but not written and their subtrees
+	queue := []*Node{tree.root}
+	for node := range compaction.nodeIndexes {
+		if !compaction.written[node] {
+			queue = append(queue, node)
+		}
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		if compaction.written[node] {
+			continue
+		}
+		if err := tree.compactNode(node); err != nil {
+			return err
+		}
+		queue = append(queue, node.children...)
+	}
+
+	// The new files start as a checkpoint, the pages freed during the pass are reusable
+	rootIndex := compaction.pageIndex(tree.root)
+	compaction.nodePager.CommitFreePages()
+	for _, pager := range compaction.recordPagers {
+		pager.CommitFreePages()
+	}
+
+	pages, chain, err := encodeFreeList(compaction.nodePager, compaction.recordPagers, nil, func() uint64 {
+		index := compaction.nextIndex
+		compaction.nextIndex++
+		return index
+	})
+	if err != nil {
+		return err
+	}
+	header, err := tree.encodeHeader(rootIndex, chain[0])
+	if err != nil {
+		return err
+	}
+	pages = append(pages, JournalPage{Offset: 0, Data: header})
+
+	for _, page := range pages {
+		if err := compaction.nodePager.WriteAt(page.Data, int64(page.Offset)); err != nil {
+			return err
+		}
+	}
+	if err := compaction.sync(); err != nil {
+		return err
+	}
+
+	oldSize, err := filesSize(tree.nodePager, tree.recordPagers)
+	if err != nil {
+		return err
+	}
+	newSize, err := filesSize(compaction.nodePager, compaction.recordPagers)
+	if err != nil {
+		return err
+	}
+
+	// From here the new files replace the old ones, even after a crash
+	ready, err := os.Create(fmt.Sprintf("%s/%s", compactionDir(tree.CollectionName), COMPACTION_READY))
+	if err != nil {
+		return err
+	}
+	if err := ready.Sync(); err != nil {
+		ready.Close()
+		return err
+	}
+	ready.Close()
+
+	tree.replaceFiles(rootIndex, chain)
+
+	tree.compactionStatus.Passes++
+	tree.compactionStatus.ReclaimedBytes = uint64(max(oldSize-newSize, 0))
+	tree.compactionStatus.LastError = ""
+
+	// The tree already uses the new files, a failed rename is finished on the next start
+	return recoverCompaction(tree.CollectionName)
+}
+
+// replaceFiles moves the tree to the pagers of the finished compaction pass
+func (tree *BTree) replaceFiles(rootIndex uint64, chain []uint64) {
+	compaction := tree.compaction
+
+	tree.nodePager.Close()
+	for _, pager := range tree.recordPagers {
+		pager.Close()
+	}
+	tree.nodePager = compaction.nodePager
+	tree.recordPagers = compaction.recordPagers
+
+	// Stubs that were not reached belong to removed nodes
+	nodes := make(map[uint64]*Node, len(compaction.nodeIndexes))
+	for node, index := range compaction.nodeIndexes {
+		node.Index = index
+		nodes[index] = node
+	}
+
+	tree.numLoaded = 0
+	for _, node := range nodes {
+		if node.stub {
+			continue
+		}
+		tree.numLoaded++
+
+		for _, record := range node.records {
+			location := ToRecordLocation(compaction.records[record.location.ToDataLocation()])
+			record.location = &location
+		}
+		// Every link already points to a node with its new page
+		tree.linkIndexes(node, node.Index)
+	}
+	tree.nodes = nodes
+
+	tree.RootIndex = rootIndex
+	tree.FreeListIndex = chain[0]
+	tree.freeListPages = chain
+	tree.nextNodeIndex = compaction.nextIndex
+	tree.nextCompactionNode = nil
+	tree.compaction = nil
+
+	tree.countReclaimableBytes()
+}
+
+func filesSize(nodePager *NodePager, recordPagers []*RecordPager) (int64, error) {
+	stat, err := nodePager.file.Stat()
+	if err != nil {
+		return 0, ErrorFileStat(err)
+	}
+	size := stat.Size()
+
+	for _, pager := range recordPagers {
+		stat, err := pager.file.Stat()
+		if err != nil {
+			return 0, ErrorFileStat(err)
+		}
+		size += stat.Size()
+	}
+	return size, nil
+}
+
+// recoverCompaction renames the files of a finished pass over the old ones, an unfinished pass is removed
+func recoverCompaction(collectionName string) error {
+	if MODE_WASM {
+		return ErrorModeWASM
+	}
+
+	dir := compactionDir(collectionName)
+
+	if _, err := os.Stat(fmt.Sprintf("%s/%s", dir, COMPACTION_READY)); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		return os.RemoveAll(dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == COMPACTION_READY {
+			continue
+		}
+		if err := os.Rename(
+			fmt.Sprintf("%s/%s", dir, entry.Name()),
+			fmt.Sprintf("%s/%s/%s", SECRETARY, collectionName, entry.Name()),
+		); err != nil {
+			return err
+		}
+	}
+
+	// The marker goes last, a crash while renaming renames the rest on the next start
+	return os.RemoveAll(dir)
+}
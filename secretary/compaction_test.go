@@ -0,0 +1,211 @@
+package secretary
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/codeharik/secretary/utils"
+)
+
+func TestCompactionSwapFiles(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	expected := map[string]string{}
+
+	var keySeq uint64 = 0
+	var keys []string
+	for i := 0; i < 300; i++ {
+		key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+		keys = append(keys, key)
+		if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = "value" + key
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys = utils.Shuffle(keys)
+	for _, key := range keys[:200] {
+		if err := tree.Delete([]byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		delete(expected, key)
+	}
+	keys = keys[200:]
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	sizeBefore, err := filesSize(tree.nodePager, tree.recordPagers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes between the batches are carried over to the new files
+	swapped := false
+	for step := 0; !swapped; step++ {
+		if step > 100 {
+			t.Fatal("Expected the batch cursor to wrap")
+		}
+
+		swapped, err = tree.CompactStep()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch step % 3 {
+		case 0:
+			key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+			if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+				t.Fatal(err)
+			}
+			expected[key] = "value" + key
+		case 1:
+			if err := tree.Update([]byte(keys[step]), []byte(fmt.Sprint("updated", step))); err != nil {
+				t.Fatal(err)
+			}
+			expected[keys[step]] = fmt.Sprint("updated", step)
+		case 2:
+			if err := tree.Delete([]byte(keys[step])); err != nil {
+				t.Fatal(err)
+			}
+			delete(expected, keys[step])
+		}
+	}
+	verifyTreeRecords(t, tree, expected)
+
+	status := tree.CompactionStatus()
+	if status.Passes != 1 || status.NodesCopied == 0 || status.RecordsCopied == 0 || status.ReclaimedBytes == 0 {
+		t.Fatalf("Unexpected compaction status %+v", status)
+	}
+	if _, err := os.Stat(compactionDir(tree.CollectionName)); !os.IsNotExist(err) {
+		t.Fatal("Expected the compaction directory to be removed", err)
+	}
+
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	sizeAfter, err := filesSize(tree.nodePager, tree.recordPagers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sizeAfter >= sizeBefore {
+		t.Fatalf("Expected the files to shrink from %d bytes, got %d", sizeBefore, sizeAfter)
+	}
+
+	newSecretary := dummySecretary(t)
+	reloaded, err := newSecretary.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTreeRecords(t, reloaded, expected)
+
+	newSecretary.PagerShutdown()
+	s.PagerShutdown()
+}
+
+func TestCompactionUnfinishedPass(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	expected := map[string]string{}
+
+	var keySeq uint64 = 0
+	for i := 0; i < 100; i++ {
+		key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+		if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = "value" + key
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	if swapped, err := tree.CompactStep(); err != nil || swapped {
+		t.Fatal("Expected an unfinished pass", err)
+	}
+	if _, err := os.Stat(compactionDir(tree.CollectionName)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash, the files are closed without a checkpoint
+	if err := tree.closeFiles(); err != nil {
+		t.Fatal(err)
+	}
+	tree.compaction.close()
+
+	reopened := dummySecretary(t)
+	reloaded, err := reopened.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(compactionDir(tree.CollectionName)); !os.IsNotExist(err) {
+		t.Fatal("Expected the unfinished pass to be removed", err)
+	}
+	verifyTreeRecords(t, reloaded, expected)
+
+	reopened.PagerShutdown()
+	delete(s.trees, tree.CollectionName)
+	s.PagerShutdown()
+}
+
+func TestCompactionBackground(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 5)
+
+	expected := map[string]string{}
+
+	var keySeq uint64 = 0
+	var keys []string
+	for i := 0; i < 200; i++ {
+		key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+		keys = append(keys, key)
+		if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = "value" + key
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.StartCompaction(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, key := range keys {
+		if i%2 == 0 {
+			if err := tree.Delete([]byte(key)); err != nil {
+				t.Fatal(err)
+			}
+			delete(expected, key)
+		}
+		if i%20 == 0 {
+			if err := tree.Checkpoint(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for tree.CompactionStatus().Passes == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a compaction pass, status %+v", tree.CompactionStatus())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	tree.StopCompaction()
+	if tree.CompactionStatus().Running {
+		t.Fatal("Expected the compactor to stop")
+	}
+	verifyTreeRecords(t, tree, expected)
+
+	s.PagerShutdown()
+}
@@ -0,0 +1,325 @@
+package secretary
+
+import (
+	"bytes"
+	"iter"
+)
+
+/*
+Cursors
+
+A Cursor walks the records of a tree in key order, forwards and backwards,
+without reading the whole range first. It reads one leaf at a time, as
+RangeScan does, and holds no latch between two moves. Every leaf is read
+as of the move that reached it, records changed after that are seen once
+the cursor reaches their leaf again.
+
+Moving past the buffered leaf follows the next or prev link if the leaf is
+unchanged, otherwise the cursor descends again from its current key.
+*/
+
+// Cursor is a position between the records of a tree
+type Cursor struct {
+	tree *BTree
+
+	leaf    *Node     // Leaf the records were read from
+	version uint64    // Version of leaf when it was read
+	records []*Record // Records of leaf
+	index   int       // Current record, -1 if the cursor is not positioned
+
+	err    error
+	closed bool
+}
+
+// Cursor opens a cursor that is not positioned yet, move it with Seek, SeekReverse, First or Last
+func (tree *BTree) Cursor() *Cursor {
+	return &Cursor{tree: tree, index: -1}
+}
+
+// Seek moves to the first record with a key at or after key
+func (cursor *Cursor) Seek(key []byte) bool {
+	return cursor.position(func() (*Node, error) {
+		return cursor.tree.readLeaf(key)
+	}, key, true, true)
+}
+
+// SeekReverse moves to the last record with a key at or before key
+func (cursor *Cursor) SeekReverse(key []byte) bool {
+	return cursor.position(func() (*Node, error) {
+		return cursor.tree.readLeaf(key)
+	}, key, false, true)
+}
+
+// First moves to the record with the smallest key
+func (cursor *Cursor) First() bool {
+	return cursor.position(func() (*Node, error) {
+		return cursor.tree.readEdgeLeaf(false)
+	}, nil, true, true)
+}
+
+// Last moves to the record with the largest key
+func (cursor *Cursor) Last() bool {
+	return cursor.position(func() (*Node, error) {
+		return cursor.tree.readEdgeLeaf(true)
+	}, nil, false, true)
+}
+
+// Next moves to the following record, false at the end
+func (cursor *Cursor) Next() bool {
+	return cursor.step(true)
+}
+
+// Prev moves to the preceding record, false at the start
+func (cursor *Cursor) Prev() bool {
+	return cursor.step(false)
+}
+
+// Valid reports if the cursor is on a record
+func (cursor *Cursor) Valid() bool {
+	return !cursor.closed && cursor.index >= 0
+}
+
+// Key returns the key of the current record, nil if the cursor is not on a record
+func (cursor *Cursor) Key() []byte {
+	if !cursor.Valid() {
+		return nil
+	}
+	return cursor.records[cursor.index].Key
+}
+
+// Value returns the value of the current record, nil if the cursor is not on a record
+func (cursor *Cursor) Value() []byte {
+	if !cursor.Valid() {
+		return nil
+	}
+	return cursor.records[cursor.index].Value
+}
+
+// Record returns the current record, nil if the cursor is not on a record
+func (cursor *Cursor) Record() *Record {
+	if !cursor.Valid() {
+		return nil
+	}
+	return cursor.records[cursor.index]
+}
+
+// Err returns the error that stopped the last move
+func (cursor *Cursor) Err() error {
+	return cursor.err
+}
+
+// Close drops the buffered leaf, the cursor cannot move anymore
+func (cursor *Cursor) Close() error {
+	cursor.closed = true
+	cursor.leaf = nil
+	cursor.records = nil
+	cursor.index = -1
+	return nil
+}
+
+// step moves within the buffered leaf, or continues from the current key in the leaf after or before it
+func (cursor *Cursor) step(forward bool) bool {
+	if !cursor.Valid() {
+		return false
+	}
+
+	next := cursor.index + 1
+	if !forward {
+		next = cursor.index - 1
+	}
+	if next >= 0 && next < len(cursor.records) {
+		cursor.index = next
+		return true
+	}
+
+	key := cursor.Key()
+	leaf, version := cursor.leaf, cursor.version
+	tree := cursor.tree
+
+	return cursor.position(func() (*Node, error) {
+		if err := tree.load(leaf); err != nil {
+			return nil, err
+		}
+		leaf.mu.RLock()
+		if leaf.Version != version {
+			leaf.mu.RUnlock()
+			return tree.readLeaf(key)
+		}
+		return leaf, nil
+	}, key, forward, false)
+}
+
+// position moves to the first key after (forward) or the last key before key, starting from the leaf find
+// returns read latched. nil key matches every key, inclusive also matches key itself.
+func (cursor *Cursor) position(find func() (*Node, error), key []byte, forward bool, inclusive bool) bool {
+	if cursor.closed {
+		return false
+	}
+	tree := cursor.tree
+	defer tree.settle()
+
+	cursor.index = -1
+	cursor.err = nil
+
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	leaf, err := find()
+	for leaf != nil && err == nil {
+		if index := positionInLeaf(leaf, key, forward, inclusive); index >= 0 {
+			err = cursor.buffer(leaf, index)
+			leaf.mu.RUnlock()
+			break
+		}
+		leaf, err = tree.readNeighbourLeaf(leaf, forward)
+	}
+
+	if err != nil {
+		cursor.err = err
+		cursor.index = -1
+		return false
+	}
+	return cursor.index >= 0
+}
+
+// positionInLeaf returns the index of the first key after or the last key before key in leaf, -1 if there is none
+func positionInLeaf(leaf *Node, key []byte, forward bool, inclusive bool) int {
+	if key == nil {
+		if len(leaf.Keys) == 0 {
+			return -1
+		}
+		if forward {
+			return 0
+		}
+		return len(leaf.Keys) - 1
+	}
+
+	index, found := leaf.getKey(key)
+	switch {
+	case forward && found && !inclusive:
+		index++
+	case !forward && !(found && inclusive):
+		index--
+	}
+
+	if index < 0 || index >= len(leaf.Keys) {
+		return -1
+	}
+	return index
+}
+
+// buffer reads the records of the read latched leaf and moves to index
+func (cursor *Cursor) buffer(leaf *Node, index int) error {
+	records := make([]*Record, len(leaf.records))
+	for i, record := range leaf.records {
+		stored, err := cursor.tree.readValue(record)
+		if err != nil {
+			return err
+		}
+		records[i] = stored
+	}
+
+	cursor.leaf = leaf
+	cursor.version = leaf.Version
+	cursor.records = records
+	cursor.index = index
+	return nil
+}
+
+//------------------------------------------------------------------
+// Iterate
+//------------------------------------------------------------------
+
+// ScanOptions select the records Iterate returns
+type ScanOptions struct {
+	StartKey []byte // First key, nil for the start of the tree
+	EndKey   []byte // Last key, nil for the end of the tree
+	Prefix   []byte // Only keys with this prefix, within StartKey and EndKey
+	Reverse  bool   // From EndKey down to StartKey
+	Offset   int    // Records skipped before the first one returned
+	Limit    int    // Most records returned, 0 for no limit
+}
+
+// Iterate returns the records selected by options in key order, or reversed
+func (tree *BTree) Iterate(options ScanOptions) iter.Seq2[*Record, error] {
+	return func(yield func(*Record, error) bool) {
+		cursor := tree.Cursor()
+		defer cursor.Close()
+
+		lower := options.StartKey
+		if options.Prefix != nil && bytes.Compare(options.Prefix, lower) > 0 {
+			lower = options.Prefix
+		}
+
+		var ok bool
+		if !options.Reverse {
+			if lower == nil {
+				ok = cursor.First()
+			} else {
+				ok = cursor.Seek(lower)
+			}
+		} else {
+			ok = cursor.seekUpper(options.EndKey, options.Prefix)
+		}
+
+		skipped, returned := 0, 0
+		for ; ok; ok = cursor.step(!options.Reverse) {
+			key := cursor.Key()
+			if options.Prefix != nil && !bytes.HasPrefix(key, options.Prefix) {
+				break
+			}
+			if !options.Reverse && options.EndKey != nil && bytes.Compare(key, options.EndKey) > 0 {
+				break
+			}
+			if options.Reverse && lower != nil && bytes.Compare(key, lower) < 0 {
+				break
+			}
+
+			if skipped < options.Offset {
+				skipped++
+				continue
+			}
+			if !yield(cursor.Record(), nil) {
+				return
+			}
+			returned++
+			if options.Limit > 0 && returned >= options.Limit {
+				return
+			}
+		}
+
+		if err := cursor.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// seekUpper moves to the last record at or before endKey that may have prefix
+func (cursor *Cursor) seekUpper(endKey []byte, prefix []byte) bool {
+	// Every key with the prefix orders before its successor
+	successor := prefixSuccessor(prefix)
+	if successor != nil && (endKey == nil || bytes.Compare(successor, endKey) <= 0) {
+		ok := cursor.SeekReverse(successor)
+		if ok && bytes.Equal(cursor.Key(), successor) {
+			ok = cursor.Prev()
+		}
+		return ok
+	}
+
+	if endKey == nil {
+		return cursor.Last()
+	}
+	return cursor.SeekReverse(endKey)
+}
+
+// prefixSuccessor returns the smallest key after every key with prefix, nil if there is none
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xFF {
+			successor := bytes.Clone(prefix[:i+1])
+			successor[i]++
+			return successor
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package secretary
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func cursorTree(t *testing.T, s *Secretary, numKeys int) (*BTree, []string) {
+	tree := dummyTree(t, s, 4)
+
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%03d", i*2)
+	}
+	for i, key := range keys {
+		if _, err := tree.SetKV([]byte(key), []byte(fmt.Sprint("value", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tree, keys
+}
+
+func iterateKeys(t *testing.T, tree *BTree, options ScanOptions) []string {
+	keys := []string{}
+	for record, err := range tree.Iterate(options) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, string(record.Key))
+	}
+	return keys
+}
+
+func TestCursorWalk(t *testing.T) {
+	s := dummySecretary(t)
+	tree, keys := cursorTree(t, s, 100)
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	cursor := tree.Cursor()
+	if cursor.Valid() || cursor.Next() || cursor.Key() != nil {
+		t.Fatal("Expected an unpositioned cursor")
+	}
+
+	forward := []string{}
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		forward = append(forward, string(cursor.Key()))
+		if string(cursor.Value()) != fmt.Sprint("value", len(forward)-1) {
+			t.Fatal("Value mismatch at", string(cursor.Key()))
+		}
+	}
+	if !slices.Equal(forward, keys) || cursor.Err() != nil {
+		t.Fatal("Forward walk mismatch", len(forward), cursor.Err())
+	}
+
+	backward := []string{}
+	for ok := cursor.Last(); ok; ok = cursor.Prev() {
+		backward = append(backward, string(cursor.Key()))
+	}
+	slices.Reverse(backward)
+	if !slices.Equal(backward, keys) {
+		t.Fatal("Backward walk mismatch", len(backward))
+	}
+
+	// Keys between two stored keys
+	if !cursor.Seek([]byte("key051")) || string(cursor.Key()) != "key052" {
+		t.Fatal("Seek mismatch", string(cursor.Key()))
+	}
+	if !cursor.Seek([]byte("key052")) || string(cursor.Key()) != "key052" {
+		t.Fatal("Seek mismatch", string(cursor.Key()))
+	}
+	if !cursor.Prev() || string(cursor.Key()) != "key050" {
+		t.Fatal("Prev mismatch", string(cursor.Key()))
+	}
+	if !cursor.SeekReverse([]byte("key051")) || string(cursor.Key()) != "key050" {
+		t.Fatal("SeekReverse mismatch", string(cursor.Key()))
+	}
+	if cursor.Seek([]byte("key999")) || cursor.SeekReverse([]byte("a")) {
+		t.Fatal("Expected no record outside the keys")
+	}
+
+	cursor.Close()
+	if cursor.First() || cursor.Valid() {
+		t.Fatal("Expected a closed cursor")
+	}
+
+	s.PagerShutdown()
+}
+
+func TestCursorChangedLeaf(t *testing.T) {
+	s := dummySecretary(t)
+	tree, keys := cursorTree(t, s, 60)
+
+	cursor := tree.Cursor()
+	defer cursor.Close()
+
+	// Splits and merges between two moves send the cursor down again
+	walked := []string{}
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		key := string(cursor.Key())
+		walked = append(walked, key)
+
+		i := slices.Index(keys, key)
+		if i < 0 {
+			continue
+		}
+		if _, err := tree.SetKV([]byte(key+"x"), []byte("inserted")); err != nil {
+			t.Fatal(err)
+		}
+		// Changes of the buffered leaf are seen once the cursor reads it again, these are leaves ahead
+		if i+8 < len(keys) && i%3 == 0 {
+			if err := tree.Delete([]byte(keys[i+8])); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for i := 1; i < len(walked); i++ {
+		if walked[i-1] >= walked[i] {
+			t.Fatal("Cursor went back at", walked[i])
+		}
+	}
+	for i, key := range keys {
+		deleted := i >= 8 && i%3 == 2
+		if !deleted && !slices.Contains(walked, key) {
+			t.Fatal("Cursor skipped", key)
+		}
+		if deleted && slices.Contains(walked, key) {
+			t.Fatal("Cursor read a deleted key", key)
+		}
+	}
+
+	backward := []string{}
+	for ok := cursor.Last(); ok; ok = cursor.Prev() {
+		key := string(cursor.Key())
+		backward = append(backward, key)
+		if bytes.HasSuffix(cursor.Key(), []byte("x")) {
+			if err := tree.Delete(cursor.Key()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := 1; i < len(backward); i++ {
+		if backward[i-1] <= backward[i] {
+			t.Fatal("Cursor went forward at", backward[i])
+		}
+	}
+	if errs := tree.TreeVerify(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	s.PagerShutdown()
+}
+
+func TestCursorIterate(t *testing.T) {
+	s := dummySecretary(t)
+	tree, keys := cursorTree(t, s, 100)
+
+	if got := iterateKeys(t, tree, ScanOptions{}); !slices.Equal(got, keys) {
+		t.Fatal("Iterate mismatch", len(got))
+	}
+
+	got := iterateKeys(t, tree, ScanOptions{StartKey: []byte("key010"), EndKey: []byte("key021"), Offset: 1})
+	if !slices.Equal(got, []string{"key012", "key014", "key016", "key018", "key020"}) {
+		t.Fatal("Range mismatch", got)
+	}
+
+	got = iterateKeys(t, tree, ScanOptions{StartKey: []byte("key010"), EndKey: []byte("key021"), Reverse: true, Limit: 3})
+	if !slices.Equal(got, []string{"key020", "key018", "key016"}) {
+		t.Fatal("Reverse range mismatch", got)
+	}
+
+	got = iterateKeys(t, tree, ScanOptions{Prefix: []byte("key1")})
+	if len(got) != 50 || got[0] != "key100" || got[49] != "key198" {
+		t.Fatal("Prefix mismatch", got)
+	}
+
+	got = iterateKeys(t, tree, ScanOptions{Prefix: []byte("key1"), Reverse: true, Offset: 2, Limit: 2})
+	if !slices.Equal(got, []string{"key194", "key192"}) {
+		t.Fatal("Reverse prefix mismatch", got)
+	}
+
+	got = iterateKeys(t, tree, ScanOptions{Prefix: []byte("key0"), EndKey: []byte("key005"), Reverse: true})
+	if !slices.Equal(got, []string{"key004", "key002", "key000"}) {
+		t.Fatal("Reverse prefix with end mismatch", got)
+	}
+
+	if got := iterateKeys(t, tree, ScanOptions{Prefix: []byte("zzz")}); len(got) != 0 {
+		t.Fatal("Expected no keys with the prefix", got)
+	}
+
+	// Stopping early releases the cursor
+	for record, err := range tree.Iterate(ScanOptions{Reverse: true}) {
+		if err != nil || string(record.Key) != keys[len(keys)-1] {
+			t.Fatal("Expected the last key first", err)
+		}
+		break
+	}
+
+	s.PagerShutdown()
+}
@@ -11,6 +11,10 @@ func ToRecordLocation(datalocation uint64) RecordLocation {
 	}
 }
 
+func (location RecordLocation) ToDataLocation() uint64 {
+	return (uint64(location.batchLevel) << 56) | (location.offset & RECORD_BATCH_OFFSET_AND)
+}
+
 func (nodes *Node) ToBytes() ([]byte, error) {
 	return binstruct.Serialize(nodes)
 }
@@ -22,7 +26,12 @@ func (nodes *Node) NewPage(index int64) *Page[*Node] {
 	}
 }
 
+// FromBytes reads both node page layouts, see keys.go
 func (nodes *Node) FromBytes(data []byte) error {
+	if len(data) > 0 && data[0] == NODE_PAGE_PREFIX_KEYS {
+		return nodes.fromPrefixKeysBytes(data)
+	}
+
 	err := binstruct.Deserialize(data, nodes)
 	if err != nil {
 		return err
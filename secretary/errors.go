@@ -13,12 +13,15 @@ var (
 	ErrorNodeIsEitherLeaforInternal = errors.New("Node Is Either Leaf or Internal, Node can either have children or record")
 
 	ErrorTreeNotFound = errors.New("Tree not found")
+	ErrorTreeExists   = errors.New("Tree already exists")
 
 	// Keys
 	ErrorKeyNotFound         = errors.New("Key not found")
 	ErrorKeyNotInNode        = errors.New("Key not in node")
 	ErrorDuplicateKey        = errors.New("Duplicate key")
 	ErrorInvalidKey          = errors.New("Invalid key size")
+	ErrorInvalidMaxKeySize   = fmt.Errorf("Max key size must be at most %d", MAX_KEY_SIZE)
+	ErrorInvalidKeyEncoding  = errors.New("Invalid composite key encoding")
 	ErrorKeysNotOrdered      = errors.New("Keys not ordered")
 	ErrorKeysGTEOrder        = errors.New("len(n.Keys) >= int(tree.Order)")
 	ErrorKeysLTOrder         = errors.New("len(n.Keys) < minKeys")
@@ -28,15 +31,128 @@ var (
 	ErrorLeafLenRecords    = errors.New("len(n.records) != len(n.Keys)")
 	ErrorRecordKeyMismatch = errors.New("record.key != key")
 	ErrorRecordsNotSorted  = errors.New("Records not sorted")
+	ErrorRecordTooLarge    = func(size int) error {
+		return fmt.Errorf("Record of %d bytes exceeds the largest record level", size)
+	}
 
 	ErrorInvalidOrder          = fmt.Errorf("Order must be between %d and %d", MIN_ORDER, MAX_ORDER)
 	ErrorInvalidIncrement      = errors.New("Increment must be between 110 and 200")
 	ErrorInvalidCollectionName = errors.New("Collection name is not valid, should be a-z 0-9 and with >4 & <30 characters")
 
-	ErrorInvalidJson = errors.New("Invalid Json")
+	ErrorInvalidJson    = errors.New("Invalid Json")
+	ErrorInvalidHeader  = errors.New("Invalid header, missing SECRETARY prefix")
+	ErrorHeaderTooLarge = func(size int) error {
+		return fmt.Errorf("Header fields of %d bytes overlap the header extension at %d", size, SECRETARY_HEADER_EXTENSION)
+	}
+	ErrorHeaderVersion = func(version uint8) error {
+		return fmt.Errorf("Unknown header version %d", version)
+	}
+	ErrorInvalidFreeList     = errors.New("Invalid free list chain in index.bin")
+	ErrorCompactionBatchSize = errors.New("Compaction batch size must be at least 1")
 
 	ErrorModeWASM = errors.New("Function disabled : WASM_MODE")
 
+	// RPC
+	ErrorInvalidField = func(field string, rule string) error {
+		return fmt.Errorf("Invalid %s, %s", field, rule)
+	}
+
+	// SQL
+	ErrorSQLMissingKey = errors.New("INSERT needs a key column")
+	ErrorSQLSetKey     = errors.New("UPDATE cannot SET the key column")
+	ErrorSQLKeyType    = errors.New("Key must be a string or a number")
+	ErrorSQLCount      = errors.New("LIMIT and OFFSET must be whole numbers >= 0")
+	ErrorSQLParams     = func(expected int, got int) error {
+		return fmt.Errorf("Statement needs %d parameters, got %d", expected, got)
+	}
+	ErrorSQLParamType = func(param any) error {
+		return fmt.Errorf("Unsupported parameter type %T", param)
+	}
+
+	// WAL
+	ErrorWALUnknownOp = func(entry *WALEntry) error {
+		return fmt.Errorf("WAL entry %d has unknown op %d", entry.LSN, entry.Op)
+	}
+	ErrorWALReplay = func(entry *WALEntry, err error) error {
+		return fmt.Errorf("WAL replay failed at entry %d (op %d): %v", entry.LSN, entry.Op, err)
+	}
+
+	// Transactions
+	ErrorTxnDone      = errors.New("Transaction already committed or rolled back")
+	ErrorTxnConflict  = errors.New("Transaction conflict, a key changed since it was read")
+	ErrorTxnUnknownOp = func(op WALOp) error {
+		return fmt.Errorf("Transaction has unknown op %d", op)
+	}
+
+	// Batches
+	ErrorBatchOpType = func(opType OpType) error {
+		return fmt.Errorf("Batch op has unknown type %d", opType)
+	}
+	ErrorInvalidFillFactor = errors.New("Fill factor must be between 0.5 and 1")
+	ErrorInvalidDump       = errors.New("Invalid dump record")
+	ErrorLoadFormat        = func(format string) error {
+		return fmt.Errorf("Unknown load format %q, expected csv, ndjson or dump", format)
+	}
+
+	// Schemas
+	ErrorSchemaEmpty    = errors.New("Schema has no fields")
+	ErrorSchemaNotEmpty = errors.New("Schema can only be set on an empty collection")
+	ErrorInvalidSchema  = func(err error) error {
+		return fmt.Errorf("Invalid schema: %v", err)
+	}
+	ErrorSchemaDocument = func(err error) error {
+		return fmt.Errorf("Document does not match the schema: %v", err)
+	}
+
+	// Indexes
+	ErrorIndexNotFound     = errors.New("Index not found")
+	ErrorIndexExists       = errors.New("Index already exists")
+	ErrorInvalidIndexField = errors.New("Index field must be 1 to 64 letters, digits, '.' or '_' and not key")
+	ErrorIndexKeySize      = errors.New("Keys of the collection are too long to be indexed")
+	ErrorIndexScanOptions  = errors.New("Index scans are forward only and take no snapshot")
+	ErrorIndexValueType    = func(value any) error {
+		return fmt.Errorf("Index values are strings, numbers or booleans, got %T", value)
+	}
+
+	// Full-text index
+	ErrorTextIndexNotFound = errors.New("Text index not found")
+	ErrorTextIndexExists   = errors.New("Text index already exists")
+	ErrorTextIndexFields   = errors.New("Text index takes 1 to 16 distinct fields")
+	ErrorTextIndexCorrupt  = errors.New("Text index entry is corrupt")
+	ErrorTextIndexNotFuzzy = errors.New("Text index has no fuzzy mode")
+	ErrorTextIndexFuzzy    = func(alphabet string) error {
+		return fmt.Errorf("Unknown fuzzy alphabet %q, expected sec16 or sec32", alphabet)
+	}
+	ErrorSearchQuery = func(reason string) error {
+		return fmt.Errorf("Invalid search query: %s", reason)
+	}
+
+	// Vectors
+	ErrorVectorsNotFound      = errors.New("Collection has no vectors")
+	ErrorVectorsNotEmpty      = errors.New("Vectors can only be set on an empty collection")
+	ErrorVectorsSchema        = errors.New("A collection has either a schema or vectors")
+	ErrorVectorIndexCorrupt   = errors.New("Vector index page is corrupt")
+	ErrorInvalidVectorOptions = func(reason string) error {
+		return fmt.Errorf("Invalid vector options: %s", reason)
+	}
+	ErrorVectorDocument = func(err error) error {
+		return fmt.Errorf("Document is not a vector document: %v", err)
+	}
+	ErrorKNNQuery = func(reason string) error {
+		return fmt.Errorf("Invalid k-NN query: %s", reason)
+	}
+
+	// Snapshots
+	ErrorSnapshotNotFound = errors.New("Snapshot not found")
+	ErrorSnapshotReleased = errors.New("Snapshot already released")
+	ErrorSnapshotReverse  = errors.New("Snapshot scans are forward only")
+
+	// Nodes
+	ErrorInvalidNodePage = errors.New("Invalid node page")
+	ErrorLoadNode        = func(index uint64, err error) error {
+		return fmt.Errorf("Error loading node %d: %v", index, err)
+	}
+
 	// File I/O
 	ErrorFileNotAligned = func(fileInfo os.FileInfo) error {
 		return fmt.Errorf("Error : File %s not aligned", fileInfo.Name())
@@ -1,13 +1,45 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/codeharik/secretary"
 	"github.com/codeharik/secretary/utils"
 )
 
+// Usage :
+//
+//	main            serve the collections
+//	main sql        SQL REPL on the local collections
+//	main sql <url>  SQL REPL on the server at url, through POST /sql
+//	main index <collection> [field ...]
+//	                index the fields that are not indexed yet and rebuild
+//	                every index of the collection from its documents
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "sql" {
+		if err := sqlRepl(os.Args[2:]); err != nil {
+			utils.Log(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "index" {
+		if err := buildIndexes(os.Args[2:]); err != nil {
+			utils.Log(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	s, err := secretary.New(nil)
 	if err != nil {
 		utils.Log(err)
@@ -18,3 +50,84 @@ func main() {
 
 	s.Serve()
 }
+
+func sqlRepl(args []string) error {
+	if len(args) > 0 {
+		return secretary.SQLRepl(os.Stdin, os.Stdout, remoteSQL(args[0]))
+	}
+
+	s, err := secretary.New(nil)
+	if err != nil {
+		return err
+	}
+	defer s.PagerShutdown()
+
+	return secretary.SQLRepl(os.Stdin, os.Stdout, func(query string) (*secretary.SQLResult, error) {
+		return s.ExecSQL(query)
+	})
+}
+
+// buildIndexes creates the missing indexes of a local collection and rebuilds them all
+func buildIndexes(args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: index <collection> [field ...]")
+	}
+
+	s, err := secretary.New(nil)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, s.PagerShutdown()) }()
+
+	tree, err := s.Tree(args[0])
+	if err != nil {
+		return err
+	}
+
+	// New indexes are built when they are created
+	if err := tree.BuildIndexes(); err != nil {
+		return err
+	}
+	for _, field := range args[1:] {
+		if slices.Contains(tree.Indexes(), field) {
+			continue
+		}
+		if err := tree.CreateIndex(field); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(args[0], "indexes", tree.Indexes())
+	return nil
+}
+
+// remoteSQL runs statements on the server at baseURL
+func remoteSQL(baseURL string) func(string) (*secretary.SQLResult, error) {
+	url := strings.TrimSuffix(baseURL, "/") + "/sql"
+
+	return func(query string) (*secretary.SQLResult, error) {
+		body, err := json.Marshal(secretary.SQLRequest{Query: query})
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			message, _ := io.ReadAll(resp.Body)
+			return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+		}
+
+		var response struct {
+			Data secretary.SQLResult `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return nil, err
+		}
+		return &response.Data, nil
+	}
+}
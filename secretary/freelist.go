@@ -0,0 +1,241 @@
+package secretary
+
+import (
+	"encoding/binary"
+
+	"github.com/codeharik/secretary/utils/binstruct"
+)
+
+/*
+Free List
+
+Deleted or replaced records free their slot in the record file of their
+level, nodes removed by a merge free their page in index.bin. Erase and
+SortedRecordSet free every page of the previous tree.
+
+The last checkpoint on disk still points to a page freed after it, a crash
+would replay the WAL on top of it. Freed pages are pending until Checkpoint
+has made the tree without them durable, only then NextPage and
+allocateNodeIndex hand them out before growing the files.
+
+Checkpoint serializes the FreeList into a chain of index.bin pages that is
+journaled with the nodes, FreeListIndex in the header points to its first
+page. The chain keeps its pages and only grows, they are never on the
+free list themselves.
+*/
+
+const FREE_LIST_PAGE_HEADER = 12 // NextIndex (uint64) and Length (uint32)
+
+// freeRecord queues the slot of a record that was removed from its leaf
+func (tree *BTree) freeRecord(record *Record) {
+	if record.location == nil || int(record.location.batchLevel) >= len(tree.recordPagers) {
+		return
+	}
+
+	tree.nodesMu.Lock()
+	defer tree.nodesMu.Unlock()
+
+	tree.compaction.releaseRecord(record.location)
+
+	pager := tree.recordPagers[record.location.batchLevel]
+	pager.FreePage(int64(record.location.offset) / pager.itemSize)
+	tree.ReclaimableBytes += uint64(pager.itemSize)
+}
+
+// freeNodePage queues the page of a node that was removed from the tree
+func (tree *BTree) freeNodePage(index uint64) {
+	if tree.nodePager == nil || index == 0 {
+		return
+	}
+
+	tree.nodePager.FreePage(int64(index))
+	tree.ReclaimableBytes += uint64(tree.nodePager.itemSize)
+}
+
+// freeAllPages queues every page of the tree on disk, it is replaced as a whole
+func (tree *BTree) freeAllPages() error {
+	if tree.nodePager == nil {
+		return nil
+	}
+
+	isFree := map[int64]bool{}
+	for _, index := range tree.nodePager.FreePages() {
+		isFree[index] = true
+	}
+	for _, index := range tree.freeListPages {
+		isFree[int64(index)] = true
+	}
+	for index := int64(1); index < int64(tree.nextNodeIndex); index++ {
+		if !isFree[index] {
+			tree.nodePager.FreePage(index)
+		}
+	}
+
+	for _, pager := range tree.recordPagers {
+		numPages, err := pager.NumPages()
+		if err != nil {
+			return err
+		}
+
+		isFree := map[int64]bool{}
+		for _, index := range pager.FreePages() {
+			isFree[index] = true
+		}
+		for index := range numPages {
+			if !isFree[index] {
+				pager.FreePage(index)
+			}
+		}
+	}
+
+	tree.countReclaimableBytes()
+	return nil
+}
+
+// commitFreePages makes the pages freed before a durable checkpoint reusable
+func (tree *BTree) commitFreePages() {
+	tree.nodePager.CommitFreePages()
+	for _, pager := range tree.recordPagers {
+		pager.CommitFreePages()
+	}
+}
+
+func (tree *BTree) countReclaimableBytes() {
+	tree.ReclaimableBytes = 0
+	if tree.nodePager == nil {
+		return
+	}
+
+	tree.ReclaimableBytes = tree.nodePager.FreeBytes()
+	for _, pager := range tree.recordPagers {
+		tree.ReclaimableBytes += pager.FreeBytes()
+	}
+}
+
+// freeListJournalPages serializes the free pages into the page images of the free list chain
+func (tree *BTree) freeListJournalPages() ([]JournalPage, error) {
+	pages, chain, err := encodeFreeList(tree.nodePager, tree.recordPagers, tree.freeListPages, tree.appendNodeIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	tree.freeListPages = chain
+	tree.FreeListIndex = chain[0]
+
+	return pages, nil
+}
+
+// encodeFreeList returns the chain pages holding the free pages of the pagers, chain grows with appendIndex
+func encodeFreeList(
+	nodePager *NodePager,
+	recordPagers []*RecordPager,
+	chain []uint64,
+	appendIndex func() uint64,
+) ([]JournalPage, []uint64, error) {
+	freeList := FreeList{Nodes: []uint64{}, Records: []uint64{}}
+	for _, index := range nodePager.FreePages() {
+		freeList.Nodes = append(freeList.Nodes, uint64(index))
+	}
+	for level, pager := range recordPagers {
+		for _, index := range pager.FreePages() {
+			location := RecordLocation{batchLevel: uint8(level), offset: uint64(index * pager.itemSize)}
+			freeList.Records = append(freeList.Records, location.ToDataLocation())
+		}
+	}
+
+	payload, err := binstruct.Serialize(freeList)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chunkSize := int(nodePager.itemSize) - FREE_LIST_PAGE_HEADER
+	numPages := (len(payload) + chunkSize - 1) / chunkSize
+	for len(chain) < numPages {
+		// Taken past the end, the free pages are already serialized
+		chain = append(chain, appendIndex())
+	}
+
+	pages := make([]JournalPage, len(chain))
+	for i, index := range chain {
+		chunk := payload[min(i*chunkSize, len(payload)):min((i+1)*chunkSize, len(payload))]
+
+		var next uint64
+		if i+1 < len(chain) {
+			next = chain[i+1]
+		}
+
+		data := make([]byte, FREE_LIST_PAGE_HEADER, FREE_LIST_PAGE_HEADER+len(chunk))
+		binary.BigEndian.PutUint64(data[0:8], next)
+		binary.BigEndian.PutUint32(data[8:12], uint32(len(chunk)))
+
+		pages[i] = JournalPage{
+			Offset: uint64(int64(index)*nodePager.itemSize + nodePager.headerSize),
+			Data:   append(data, chunk...),
+		}
+	}
+
+	return pages, chain, nil
+}
+
+// readFreeList reads the free list chain saved by the last checkpoint
+func (tree *BTree) readFreeList() error {
+	tree.freeListPages = nil
+
+	numPages, err := tree.nodePager.NumPages()
+	if err != nil {
+		return err
+	}
+
+	payload := []byte{}
+	for index := tree.FreeListIndex; index != 0; {
+		if index >= uint64(numPages) || len(tree.freeListPages) >= int(numPages) {
+			return ErrorInvalidFreeList
+		}
+
+		data, err := tree.nodePager.ReadAt(
+			int64(index)*tree.nodePager.itemSize+tree.nodePager.headerSize,
+			int32(tree.nodePager.itemSize),
+		)
+		if err != nil {
+			return err
+		}
+
+		length := int(binary.BigEndian.Uint32(data[8:12]))
+		if length > len(data)-FREE_LIST_PAGE_HEADER {
+			return ErrorInvalidFreeList
+		}
+		payload = append(payload, data[FREE_LIST_PAGE_HEADER:FREE_LIST_PAGE_HEADER+length]...)
+
+		tree.freeListPages = append(tree.freeListPages, index)
+		index = binary.BigEndian.Uint64(data[0:8])
+	}
+
+	freeList := FreeList{}
+	if len(payload) > 0 {
+		if err := binstruct.Deserialize(payload, &freeList); err != nil {
+			return err
+		}
+	}
+
+	nodePages := make([]int64, len(freeList.Nodes))
+	for i, index := range freeList.Nodes {
+		nodePages[i] = int64(index)
+	}
+	tree.nodePager.SetFreePages(nodePages)
+
+	recordPages := make([][]int64, len(tree.recordPagers))
+	for _, dataLocation := range freeList.Records {
+		location := ToRecordLocation(dataLocation)
+		if int(location.batchLevel) >= len(tree.recordPagers) {
+			return ErrorInvalidFreeList
+		}
+		pager := tree.recordPagers[location.batchLevel]
+		recordPages[location.batchLevel] = append(recordPages[location.batchLevel], int64(location.offset)/pager.itemSize)
+	}
+	for level, pager := range tree.recordPagers {
+		pager.SetFreePages(recordPages[level])
+	}
+
+	tree.countReclaimableBytes()
+	return nil
+}
@@ -0,0 +1,141 @@
+package secretary
+
+import (
+	"testing"
+
+	"github.com/codeharik/secretary/utils"
+)
+
+func numRecordPages(t *testing.T, tree *BTree) int64 {
+	var total int64
+	for _, pager := range tree.recordPagers {
+		numPages, err := pager.NumPages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += numPages
+	}
+	return total
+}
+
+func TestFreeListReuseSlots(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	expected := map[string]string{}
+
+	var keySeq uint64 = 0
+	var keys []string
+	for i := 0; i < 100; i++ {
+		key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+		keys = append(keys, key)
+		if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = "value" + key
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	recordPages := numRecordPages(t, tree)
+
+	for _, key := range utils.Shuffle(keys)[:60] {
+		if err := tree.Delete([]byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		delete(expected, key)
+	}
+	if tree.ReclaimableBytes == 0 {
+		t.Fatal("Expected reclaimable bytes after delete")
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	reclaimable := tree.ReclaimableBytes
+
+	for i := 0; i < 60; i++ {
+		key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+		if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = "value" + key
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	if numPages := numRecordPages(t, tree); numPages != recordPages {
+		t.Fatalf("Expected the freed slots to be reused, %d record pages grew to %d", recordPages, numPages)
+	}
+	if tree.ReclaimableBytes >= reclaimable {
+		t.Fatalf("Expected reclaimable bytes to shrink from %d, got %d", reclaimable, tree.ReclaimableBytes)
+	}
+	verifyTreeRecords(t, tree, expected)
+
+	newSecretary := dummySecretary(t)
+	reloaded, err := newSecretary.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.ReclaimableBytes != tree.ReclaimableBytes {
+		t.Fatalf("Expected %d reclaimable bytes after reopen, got %d", tree.ReclaimableBytes, reloaded.ReclaimableBytes)
+	}
+	verifyTreeRecords(t, reloaded, expected)
+
+	newSecretary.PagerShutdown()
+	s.PagerShutdown()
+}
+
+func TestFreeListPendingUntilCheckpoint(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	var keySeq uint64 = 0
+	var keys []string
+	for i := 0; i < 10; i++ {
+		key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+		keys = append(keys, key)
+		if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	recordPages := numRecordPages(t, tree)
+
+	// The checkpoint on disk still points to the replaced slots
+	for _, key := range keys[:5] {
+		if err := tree.Update([]byte(key), []byte("other"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if numPages := numRecordPages(t, tree); numPages != recordPages+5 {
+		t.Fatalf("Expected %d record pages, got %d", recordPages+5, numPages)
+	}
+
+	// Durable now, the next updates reuse them
+	for _, key := range keys[5:] {
+		if err := tree.Update([]byte(key), []byte("other"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if numPages := numRecordPages(t, tree); numPages != recordPages+5 {
+		t.Fatalf("Expected %d record pages, got %d", recordPages+5, numPages)
+	}
+
+	for _, key := range keys {
+		record, err := tree.Get([]byte(key))
+		if err != nil || string(record.Value) != "other"+key {
+			t.Fatal("Record not updated", key, err)
+		}
+	}
+
+	s.PagerShutdown()
+}
@@ -0,0 +1,1300 @@
+package secretary
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/codeharik/secretary/utils"
+	"github.com/codeharik/secretary/utils/encode"
+	"github.com/codeharik/secretary/utils/file"
+	"github.com/codeharik/secretary/utils/ngram"
+)
+
+/*
+Full-text index
+
+A collection can have one full-text index over string fields of its JSON
+documents. Its terms are the words of the fields, the unigrams of
+ngram.GenerateNGrams lower cased and cut to TEXT_TERM_SIZE bytes. The index
+is a tree of its own in SECRETARY/<collection>/text, its TextIndexOptions
+are saved in text.json.
+
+Every indexed version of a document gets a new document ID, IDs only grow.
+The keys of the tree start with a tag :
+
+	s                          -> next ID, documents, words
+	d Uint64(id)               -> words, hash of the terms, primary key
+	k Bytes(key) Uint64(id)    -> empty
+	p String(term) Uint64(id)  -> postings block
+	f String(form) String(term) -> empty, in fuzzy mode
+
+A postings block holds the documents of a term from the ID of its key, up to
+TEXT_BLOCK_SIZE bytes. Every posting is the uvarint delta of its ID to the
+previous one, the term frequency and the first TEXT_POSITIONS positions of
+the term as uvarint deltas. New IDs are always the largest, a new posting
+is appended to the last block of its term.
+
+Writes maintain the text index with the field indexes, see index.go : the
+postings of a new document are added under a new ID before the write, those
+of the replaced document are removed once it applied. Search only returns
+the documents whose stored terms still hash to their d entry, IDs left by a
+failed write are skipped. BuildTextIndex rebuilds the tree.
+
+Search takes words, "quoted phrases", prefix* words and fuzzy~ words, every
+part of the query has to match. A prefix or a fuzzy word matches its first
+TEXT_PREFIX_TERMS terms. Documents are ranked by BM25 over the parts of the
+query, a phrase counts as one term whose frequency is its number of
+occurrences.
+
+In fuzzy mode the index also keeps the phonetic form of every term, its
+letters mapped to the SEC16 or SEC32 alphabet of utils/encode where b and p,
+c, k and q, ... are one letter, and the forms with one letter deleted. Two
+terms whose forms are at most one edit apart share an f entry. The terms
+found for a word are ranked by their Damerau distance to it, at most
+FUZZY_MAX_DISTANCE, then by their number of documents : Suggest returns
+them as "did you mean" candidates and fuzzy~ words search them.
+
+GET /search/<collection>?q=<query>&limit=<n> returns the best documents,
+GET /suggest/<collection>?q=<words>&limit=<n> the terms close to every word.
+*/
+
+const (
+	TEXT_INDEX_DIR    = "text"
+	TEXT_INDEX_FILE   = "text.json"
+	MAX_TEXT_FIELDS   = 16
+	TEXT_TERM_SIZE    = 64   // Bytes of a word that are indexed
+	TEXT_BLOCK_SIZE   = 1024 // Largest postings block a posting is appended to
+	TEXT_POSITIONS    = 128  // Positions of a term kept per document, for phrases
+	TEXT_PREFIX_TERMS = 64   // Terms a prefix matches
+	TEXT_SEARCH_LIMIT = 10   // Results of /search and /suggest without a limit
+
+	// Phonetic alphabets of the fuzzy mode
+	TEXT_FUZZY_SEC16   = "sec16"
+	TEXT_FUZZY_SEC32   = "sec32"
+	FUZZY_MAX_DISTANCE = 2 // Damerau distance of the terms found for a word
+
+	// Record levels of the text tree, 64 to 2048 bytes
+	TEXT_NUM_LEVEL = 6
+	TEXT_BASE_SIZE = 64
+	TEXT_INCREMENT = 200
+
+	BM25_K1 = 1.2
+	BM25_B  = 0.75
+)
+
+// Key tags of the text tree
+const (
+	TEXT_KEY_STATS       byte = 's'
+	TEXT_KEY_DOCUMENT    byte = 'd'
+	TEXT_KEY_DOCUMENT_ID byte = 'k'
+	TEXT_KEY_POSTINGS    byte = 'p'
+	TEXT_KEY_FUZZY       byte = 'f'
+)
+
+// TextIndexOptions configure the text index of a collection
+type TextIndexOptions struct {
+	Fields []string `json:"fields"`          // String fields whose words are indexed
+	Fuzzy  string   `json:"fuzzy,omitempty"` // Phonetic alphabet of the fuzzy mode, sec16 or sec32, none if empty
+}
+
+// textIndex is the full-text index of a collection
+type textIndex struct {
+	options  TextIndexOptions
+	phonetic func(string) string // Phonetic form of a term, nil without fuzzy mode
+	tree     *BTree
+}
+
+// textStats are the totals BM25 needs and the next document ID
+type textStats struct {
+	next      uint64
+	documents uint64
+	words     uint64
+}
+
+// textDocument is the terms of a document and their positions
+type textDocument struct {
+	terms map[string][]uint32
+	words int
+	hash  uint64
+}
+
+// posting is a document of a postings block
+type posting struct {
+	id        uint64
+	frequency uint32
+	positions []uint32
+}
+
+// textClause is a part of a search query, a word, a phrase, a prefix or a fuzzy word
+type textClause struct {
+	terms  []string
+	prefix bool
+	fuzzy  bool
+}
+
+// SearchResult is a document found by Search and its BM25 score
+type SearchResult struct {
+	Record *Record
+	Score  float64
+}
+
+// Suggestion is an indexed term close to a word
+type Suggestion struct {
+	Term      string `json:"term"`
+	Distance  int    `json:"distance"`  // Damerau distance to the word
+	Documents int    `json:"documents"` // Documents with the term
+}
+
+// WordSuggestions are the suggestions for a word of a Suggest text, best first
+type WordSuggestions struct {
+	Word        string       `json:"word"`
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+func textIndexDir(collectionName string) string {
+	return fmt.Sprintf("%s/%s/%s", SECRETARY, collectionName, TEXT_INDEX_DIR)
+}
+
+func textIndexFile(collectionName string) string {
+	return fmt.Sprintf("%s/%s/%s", SECRETARY, collectionName, TEXT_INDEX_FILE)
+}
+
+// checkTextIndexOptions validates the options of a text index and returns its phonetic function
+func checkTextIndexOptions(options TextIndexOptions) (func(string) string, error) {
+	if len(options.Fields) == 0 || len(options.Fields) > MAX_TEXT_FIELDS {
+		return nil, ErrorTextIndexFields
+	}
+	for i, field := range options.Fields {
+		if err := checkIndexField(field); err != nil {
+			return nil, err
+		}
+		if slices.Contains(options.Fields[:i], field) {
+			return nil, ErrorTextIndexFields
+		}
+	}
+
+	switch options.Fuzzy {
+	case "":
+		return nil, nil
+	case TEXT_FUZZY_SEC16:
+		return encode.StringToSec16, nil
+	case TEXT_FUZZY_SEC32:
+		return encode.StringToSec32, nil
+	default:
+		return nil, ErrorTextIndexFuzzy(options.Fuzzy)
+	}
+}
+
+// textTerms returns the indexed terms of text
+func textTerms(text string) []string {
+	terms := ngram.GenerateNGrams(text, 1)
+	for i, term := range terms {
+		if len(term) > TEXT_TERM_SIZE {
+			terms[i] = strings.ToValidUTF8(term[:TEXT_TERM_SIZE], "")
+		}
+	}
+	return terms
+}
+
+// TextIndex returns the options of the text index, nil without one
+func (tree *BTree) TextIndex() *TextIndexOptions {
+	tree.indexMu.RLock()
+	defer tree.indexMu.RUnlock()
+
+	if tree.textIndex == nil {
+		return nil
+	}
+	options := tree.textIndex.options
+	options.Fields = slices.Clone(options.Fields)
+	return &options
+}
+
+// CreateTextIndex indexes the words of the fields of options and builds the index from the stored documents
+func (tree *BTree) CreateTextIndex(options TextIndexOptions) error {
+	phonetic, err := checkTextIndexOptions(options)
+	if err != nil {
+		return err
+	}
+	options.Fields = slices.Clone(options.Fields)
+
+	tree.indexMu.Lock()
+	defer tree.indexMu.Unlock()
+
+	if tree.textIndex != nil {
+		return ErrorTextIndexExists
+	}
+
+	index, err := tree.newTextIndex(options, phonetic)
+	if err != nil {
+		return err
+	}
+	if err := index.build(tree); err != nil {
+		return errors.Join(err, index.remove())
+	}
+	if err := saveTextIndex(tree.CollectionName, &index.options); err != nil {
+		return errors.Join(err, index.remove())
+	}
+	tree.textIndex = index
+
+	return nil
+}
+
+// DropTextIndex removes the text index
+func (tree *BTree) DropTextIndex() error {
+	tree.indexMu.Lock()
+	defer tree.indexMu.Unlock()
+
+	index := tree.textIndex
+	if index == nil {
+		return ErrorTextIndexNotFound
+	}
+
+	if err := saveTextIndex(tree.CollectionName, nil); err != nil {
+		return err
+	}
+	tree.textIndex = nil
+
+	return index.remove()
+}
+
+// BuildTextIndex rebuilds the text index from the stored documents
+func (tree *BTree) BuildTextIndex() error {
+	indexes, unlock := tree.lockIndexes()
+	defer unlock()
+
+	if indexes.text == nil {
+		return ErrorTextIndexNotFound
+	}
+	return indexes.text.build(tree)
+}
+
+//------------------------------------------------------------------
+// Search
+//------------------------------------------------------------------
+
+// Search returns the documents matching every part of query, best BM25 score first, at most limit unless it is 0
+func (tree *BTree) Search(query string, limit int) ([]SearchResult, error) {
+	clauses, err := parseTextQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Writes keep the text index and the collection in step under indexMu
+	tree.indexMu.RLock()
+	defer tree.indexMu.RUnlock()
+
+	index := tree.textIndex
+	if index == nil {
+		return nil, ErrorTextIndexNotFound
+	}
+
+	stats, err := index.stats()
+	if err != nil {
+		return nil, err
+	}
+
+	// Documents matching every clause and their frequency in each
+	var candidates map[uint64][]uint32
+	frequencies := make([]int, len(clauses))
+	for i, clause := range clauses {
+		matches, err := index.match(clause)
+		if err != nil {
+			return nil, err
+		}
+		frequencies[i] = len(matches)
+
+		next := map[uint64][]uint32{}
+		for id, frequency := range matches {
+			if i == 0 {
+				next[id] = []uint32{frequency}
+			} else if previous, ok := candidates[id]; ok {
+				next[id] = append(previous, frequency)
+			}
+		}
+		candidates = next
+	}
+	if len(candidates) == 0 || stats.documents == 0 {
+		return []SearchResult{}, nil
+	}
+
+	type scoredDocument struct {
+		key   []byte
+		hash  uint64
+		score float64
+	}
+	scored := make([]scoredDocument, 0, len(candidates))
+	averageWords := float64(stats.words) / float64(stats.documents)
+	for id, documentFrequencies := range candidates {
+		record, err := index.tree.Get(textDocumentKey(id))
+		if errors.Is(err, ErrorKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		words, hash, key, err := decodeTextDocument(record.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		score := 0.0
+		for i, frequency := range documentFrequencies {
+			score += bm25(float64(frequency), float64(words), averageWords, float64(stats.documents), float64(frequencies[i]))
+		}
+		scored = append(scored, scoredDocument{key: key, hash: hash, score: score})
+	}
+	slices.SortFunc(scored, func(a, b scoredDocument) int {
+		if a.score != b.score {
+			if a.score > b.score {
+				return -1
+			}
+			return 1
+		}
+		return bytes.Compare(a.key, b.key)
+	})
+
+	// IDs of replaced versions are skipped, the document no longer hashes to them
+	results := []SearchResult{}
+	seen := map[string]bool{}
+	for _, document := range scored {
+		if seen[string(document.key)] {
+			continue
+		}
+		record, err := tree.Get(document.key)
+		if errors.Is(err, ErrorKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if index.document(record.Value).hash != document.hash {
+			continue
+		}
+		seen[string(document.key)] = true
+
+		results = append(results, SearchResult{Record: record, Score: document.score})
+		if limit > 0 && len(results) == limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// bm25 scores a term of frequency in a document of words, found in documents of total
+func bm25(frequency float64, words float64, averageWords float64, total float64, documents float64) float64 {
+	idf := math.Log(1 + (total-documents+0.5)/(documents+0.5))
+	return idf * frequency * (BM25_K1 + 1) / (frequency + BM25_K1*(1-BM25_B+BM25_B*words/averageWords))
+}
+
+// parseTextQuery splits a query into words, "quoted phrases", prefix* words and fuzzy~ words
+func parseTextQuery(query string) ([]textClause, error) {
+	clauses := []textClause{}
+	for rest := query; ; {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			break
+		}
+
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return nil, ErrorSearchQuery("unterminated phrase")
+			}
+			if terms := textTerms(rest[1 : 1+end]); len(terms) > 0 {
+				clauses = append(clauses, textClause{terms: terms})
+			}
+			rest = rest[end+2:]
+			continue
+		}
+
+		end := strings.IndexFunc(rest, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		rest = rest[end:]
+
+		terms := textTerms(word)
+		for i, term := range terms {
+			last := i == len(terms)-1
+			clauses = append(clauses, textClause{
+				terms:  []string{term},
+				prefix: last && strings.HasSuffix(word, "*"),
+				fuzzy:  last && strings.HasSuffix(word, "~"),
+			})
+		}
+	}
+
+	if len(clauses) == 0 {
+		return nil, ErrorSearchQuery("no words")
+	}
+	return clauses, nil
+}
+
+// match returns the frequency of clause in the documents it matches, by ID
+func (index *textIndex) match(clause textClause) (map[uint64]uint32, error) {
+	matches := map[uint64]uint32{}
+
+	if clause.fuzzy {
+		suggestions, err := index.suggest(clause.terms[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, suggestion := range suggestions[:min(len(suggestions), TEXT_PREFIX_TERMS)] {
+			postings, err := index.postings(suggestion.Term)
+			if err != nil {
+				return nil, err
+			}
+			for id, posting := range postings {
+				matches[id] += posting.frequency
+			}
+		}
+		return matches, nil
+	}
+
+	if clause.prefix {
+		// The escaped term without its terminator is a prefix of the longer terms
+		prefix := textTermPrefix(clause.terms[0])
+		prefix = prefix[:len(prefix)-2]
+
+		terms := 0
+		lastTerm := ""
+		for record, err := range index.tree.Iterate(ScanOptions{Prefix: prefix}) {
+			if err != nil {
+				return nil, err
+			}
+			term, first, err := decodePostingsKey(record.Key)
+			if err != nil {
+				return nil, err
+			}
+			if term != lastTerm {
+				if terms == TEXT_PREFIX_TERMS {
+					break
+				}
+				terms++
+				lastTerm = term
+			}
+
+			postings, err := decodePostings(first, record.Value)
+			if err != nil {
+				return nil, err
+			}
+			for _, posting := range postings {
+				matches[posting.id] += posting.frequency
+			}
+		}
+		return matches, nil
+	}
+
+	// Positions of every term of a phrase, by ID
+	termPostings := make([]map[uint64]posting, len(clause.terms))
+	for i, term := range clause.terms {
+		postings, err := index.postings(term)
+		if err != nil {
+			return nil, err
+		}
+		termPostings[i] = postings
+	}
+
+	for id, first := range termPostings[0] {
+		if len(clause.terms) == 1 {
+			matches[id] = first.frequency
+			continue
+		}
+
+		occurrences := uint32(0)
+		for _, position := range first.positions {
+			found := true
+			for i := 1; i < len(clause.terms) && found; i++ {
+				_, found = slices.BinarySearch(termPostings[i][id].positions, position+uint32(i))
+			}
+			if found {
+				occurrences++
+			}
+		}
+		if occurrences > 0 {
+			matches[id] = occurrences
+		}
+	}
+	return matches, nil
+}
+
+// postings returns the postings of term by ID
+func (index *textIndex) postings(term string) (map[uint64]posting, error) {
+	postings := map[uint64]posting{}
+	for record, err := range index.tree.Iterate(ScanOptions{Prefix: textTermPrefix(term)}) {
+		if err != nil {
+			return nil, err
+		}
+		_, first, err := decodePostingsKey(record.Key)
+		if err != nil {
+			return nil, err
+		}
+		block, err := decodePostings(first, record.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, posting := range block {
+			postings[posting.id] = posting
+		}
+	}
+	return postings, nil
+}
+
+//------------------------------------------------------------------
+// Fuzzy
+//------------------------------------------------------------------
+
+// Suggest returns the indexed terms close to every word of text, at most limit per word unless it is 0
+func (tree *BTree) Suggest(text string, limit int) ([]WordSuggestions, error) {
+	words := textTerms(text)
+	if len(words) == 0 {
+		return nil, ErrorSearchQuery("no words")
+	}
+
+	tree.indexMu.RLock()
+	defer tree.indexMu.RUnlock()
+
+	index := tree.textIndex
+	if index == nil {
+		return nil, ErrorTextIndexNotFound
+	}
+
+	results := make([]WordSuggestions, 0, len(words))
+	for _, word := range words {
+		suggestions, err := index.suggest(word)
+		if err != nil {
+			return nil, err
+		}
+		if limit > 0 && len(suggestions) > limit {
+			suggestions = suggestions[:limit]
+		}
+		results = append(results, WordSuggestions{Word: word, Suggestions: suggestions})
+	}
+	return results, nil
+}
+
+// suggest returns the terms of the index at most FUZZY_MAX_DISTANCE from word,
+// closest first, then those of the most documents
+func (index *textIndex) suggest(word string) ([]Suggestion, error) {
+	if index.phonetic == nil {
+		return nil, ErrorTextIndexNotFuzzy
+	}
+
+	// Terms sharing a phonetic form with the word
+	terms := map[string]bool{}
+	for _, form := range index.fuzzyForms(word) {
+		prefix := textFuzzyPrefix(form)
+		for record, err := range index.tree.Iterate(ScanOptions{Prefix: prefix}) {
+			if err != nil {
+				return nil, err
+			}
+			parts, err := DecodeKey(record.Key[len(prefix):])
+			if err != nil || len(parts) != 1 {
+				return nil, ErrorTextIndexCorrupt
+			}
+			term, ok := parts[0].(string)
+			if !ok {
+				return nil, ErrorTextIndexCorrupt
+			}
+			terms[term] = true
+		}
+	}
+
+	suggestions := []Suggestion{}
+	for term := range terms {
+		distance := utils.EditDistance(word, term, true)
+		if distance > FUZZY_MAX_DISTANCE {
+			continue
+		}
+		postings, err := index.postings(term)
+		if err != nil {
+			return nil, err
+		}
+		if len(postings) == 0 {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{Term: term, Distance: distance, Documents: len(postings)})
+	}
+	slices.SortFunc(suggestions, func(a, b Suggestion) int {
+		if a.Distance != b.Distance {
+			return a.Distance - b.Distance
+		}
+		if a.Documents != b.Documents {
+			return b.Documents - a.Documents
+		}
+		return strings.Compare(a.Term, b.Term)
+	})
+	return suggestions, nil
+}
+
+// fuzzyForms returns the phonetic form of term and the forms with one letter deleted
+func (index *textIndex) fuzzyForms(term string) []string {
+	// Phonetic forms are ASCII, a letter is a byte
+	form := index.phonetic(term)
+	forms := []string{form}
+	for i := range len(form) {
+		deleted := form[:i] + form[i+1:]
+		if !slices.Contains(forms, deleted) {
+			forms = append(forms, deleted)
+		}
+	}
+	return forms
+}
+
+// addFuzzyTerm adds the f entries of a new term
+func (index *textIndex) addFuzzyTerm(term string) error {
+	for _, form := range index.fuzzyForms(term) {
+		_, err := index.tree.SetKV(textFuzzyKey(form, term), []byte{})
+		if err != nil && !errors.Is(err, ErrorDuplicateKey) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFuzzyTerm removes the f entries of a term without postings
+func (index *textIndex) removeFuzzyTerm(term string) error {
+	for _, form := range index.fuzzyForms(term) {
+		err := index.tree.Delete(textFuzzyKey(form, term))
+		if err != nil && !errors.Is(err, ErrorKeyNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------
+// Documents
+//------------------------------------------------------------------
+
+// document returns the terms of the indexed fields of a document, with no words for nil
+func (index *textIndex) document(document []byte) textDocument {
+	text := textDocument{terms: map[string][]uint32{}}
+	if document == nil {
+		return text
+	}
+
+	hash := fnv.New64a()
+	row := decodeSQLRow(&Record{Value: document})
+	position := uint32(0)
+	for _, field := range index.options.Fields {
+		hash.Write([]byte{0})
+		value, ok := row[field].(string)
+		if !ok {
+			continue
+		}
+		for _, term := range textTerms(value) {
+			hash.Write([]byte(term))
+			hash.Write([]byte{1})
+			text.terms[term] = append(text.terms[term], position)
+			text.words++
+			position++
+		}
+		// A phrase does not span two fields
+		position++
+	}
+	text.hash = hash.Sum64()
+
+	return text
+}
+
+func textDocumentKey(id uint64) []byte {
+	return append([]byte{TEXT_KEY_DOCUMENT}, NewKeyEncoder().Uint64(id).Key()...)
+}
+
+func textDocumentIDPrefix(key []byte) []byte {
+	return append([]byte{TEXT_KEY_DOCUMENT_ID}, NewKeyEncoder().Bytes(key).Key()...)
+}
+
+func textTermPrefix(term string) []byte {
+	return append([]byte{TEXT_KEY_POSTINGS}, NewKeyEncoder().String(term).Key()...)
+}
+
+func textFuzzyPrefix(form string) []byte {
+	return append([]byte{TEXT_KEY_FUZZY}, NewKeyEncoder().String(form).Key()...)
+}
+
+func textFuzzyKey(form string, term string) []byte {
+	return append(textFuzzyPrefix(form), NewKeyEncoder().String(term).Key()...)
+}
+
+func textPostingsKey(term string, first uint64) []byte {
+	return append(textTermPrefix(term), NewKeyEncoder().Uint64(first).Key()...)
+}
+
+// decodePostingsKey returns the term and the first ID of a postings block
+func decodePostingsKey(key []byte) (string, uint64, error) {
+	if len(key) == 0 || key[0] != TEXT_KEY_POSTINGS {
+		return "", 0, ErrorTextIndexCorrupt
+	}
+	parts, err := DecodeKey(key[1:])
+	if err != nil || len(parts) != 2 {
+		return "", 0, ErrorTextIndexCorrupt
+	}
+	term, ok := parts[0].(string)
+	first, ok2 := parts[1].(uint64)
+	if !ok || !ok2 {
+		return "", 0, ErrorTextIndexCorrupt
+	}
+	return term, first, nil
+}
+
+func encodeTextDocument(words int, hash uint64, key []byte) []byte {
+	value := binary.AppendUvarint(nil, uint64(words))
+	value = binary.BigEndian.AppendUint64(value, hash)
+	return append(value, key...)
+}
+
+// decodeTextDocument returns the words, the hash and the primary key of a d entry
+func decodeTextDocument(value []byte) (uint64, uint64, []byte, error) {
+	words, n := binary.Uvarint(value)
+	if n <= 0 || len(value) < n+8 {
+		return 0, 0, nil, ErrorTextIndexCorrupt
+	}
+	return words, binary.BigEndian.Uint64(value[n : n+8]), value[n+8:], nil
+}
+
+//------------------------------------------------------------------
+// Postings
+//------------------------------------------------------------------
+
+// newPosting returns the posting of a term at positions
+func newPosting(id uint64, positions []uint32) posting {
+	return posting{id: id, frequency: uint32(len(positions)), positions: positions[:min(len(positions), TEXT_POSITIONS)]}
+}
+
+// appendPosting encodes p after a posting of ID previous
+func appendPosting(block []byte, previous uint64, p posting) []byte {
+	block = binary.AppendUvarint(block, p.id-previous)
+	block = binary.AppendUvarint(block, uint64(p.frequency))
+	block = binary.AppendUvarint(block, uint64(len(p.positions)))
+	last := uint32(0)
+	for _, position := range p.positions {
+		block = binary.AppendUvarint(block, uint64(position-last))
+		last = position
+	}
+	return block
+}
+
+func encodePostings(postings []posting) []byte {
+	block := []byte{}
+	previous := postings[0].id
+	for _, p := range postings {
+		block = appendPosting(block, previous, p)
+		previous = p.id
+	}
+	return block
+}
+
+// decodePostings reads the postings of a block whose first ID is first
+func decodePostings(first uint64, block []byte) ([]posting, error) {
+	next := func() (uint64, error) {
+		value, n := binary.Uvarint(block)
+		if n <= 0 {
+			return 0, ErrorTextIndexCorrupt
+		}
+		block = block[n:]
+		return value, nil
+	}
+
+	postings := []posting{}
+	id := first
+	for len(block) > 0 {
+		delta, err := next()
+		if err != nil {
+			return nil, err
+		}
+		frequency, err := next()
+		if err != nil {
+			return nil, err
+		}
+		count, err := next()
+		if err != nil || count > frequency || count > TEXT_POSITIONS {
+			return nil, ErrorTextIndexCorrupt
+		}
+
+		id += delta
+		p := posting{id: id, frequency: uint32(frequency), positions: make([]uint32, count)}
+		position := uint32(0)
+		for i := range p.positions {
+			delta, err := next()
+			if err != nil {
+				return nil, err
+			}
+			position += uint32(delta)
+			p.positions[i] = position
+		}
+		postings = append(postings, p)
+	}
+	return postings, nil
+}
+
+// lastBlock returns the last postings block of term at or before the ID end, nil if there is none
+func (index *textIndex) lastBlock(term string, end uint64) (*Record, error) {
+	options := ScanOptions{Prefix: textTermPrefix(term), EndKey: textPostingsKey(term, end), Reverse: true, Limit: 1}
+	for record, err := range index.tree.Iterate(options) {
+		return record, err
+	}
+	return nil, nil
+}
+
+// addPosting appends p to the postings of term, p has the largest ID of the index
+func (index *textIndex) addPosting(term string, p posting) error {
+	record, err := index.lastBlock(term, math.MaxUint64)
+	if err != nil {
+		return err
+	}
+	if record != nil {
+		_, first, err := decodePostingsKey(record.Key)
+		if err != nil {
+			return err
+		}
+		postings, err := decodePostings(first, record.Value)
+		if err != nil {
+			return err
+		}
+		block := appendPosting(bytes.Clone(record.Value), postings[len(postings)-1].id, p)
+		if len(block) <= TEXT_BLOCK_SIZE {
+			return index.tree.Update(record.Key, block)
+		}
+	} else if index.phonetic != nil {
+		if err := index.addFuzzyTerm(term); err != nil {
+			return err
+		}
+	}
+
+	_, err = index.tree.SetKV(textPostingsKey(term, p.id), appendPosting(nil, p.id, p))
+	return err
+}
+
+// removePosting removes the posting of id from the postings of term
+func (index *textIndex) removePosting(term string, id uint64) error {
+	record, err := index.lastBlock(term, id)
+	if err != nil || record == nil {
+		return err
+	}
+	_, first, err := decodePostingsKey(record.Key)
+	if err != nil {
+		return err
+	}
+	postings, err := decodePostings(first, record.Value)
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(postings, func(p posting) bool { return p.id == id })
+	switch {
+	case i < 0:
+		return nil
+	case len(postings) == 1:
+		if err := index.tree.Delete(record.Key); err != nil || index.phonetic == nil {
+			return err
+		}
+		// The f entries go with the last block of the term
+		if record, err := index.lastBlock(term, math.MaxUint64); err != nil || record != nil {
+			return err
+		}
+		return index.removeFuzzyTerm(term)
+	case i == 0:
+		// The block is keyed by its first ID
+		postings = postings[1:]
+		if err := index.tree.Delete(record.Key); err != nil {
+			return err
+		}
+		_, err := index.tree.SetKV(textPostingsKey(term, postings[0].id), encodePostings(postings))
+		return err
+	default:
+		postings = slices.Delete(postings, i, i+1)
+		return index.tree.Update(record.Key, encodePostings(postings))
+	}
+}
+
+//------------------------------------------------------------------
+// Maintenance
+//------------------------------------------------------------------
+
+// stats reads the totals of the index
+func (index *textIndex) stats() (textStats, error) {
+	record, err := index.tree.Get([]byte{TEXT_KEY_STATS})
+	if errors.Is(err, ErrorKeyNotFound) {
+		return textStats{next: 1}, nil
+	}
+	if err != nil {
+		return textStats{}, err
+	}
+
+	values := [3]uint64{}
+	value := record.Value
+	for i := range values {
+		v, n := binary.Uvarint(value)
+		if n <= 0 {
+			return textStats{}, ErrorTextIndexCorrupt
+		}
+		values[i] = v
+		value = value[n:]
+	}
+	return textStats{next: values[0], documents: values[1], words: values[2]}, nil
+}
+
+func (stats textStats) bytes() []byte {
+	value := binary.AppendUvarint(nil, stats.next)
+	value = binary.AppendUvarint(value, stats.documents)
+	return binary.AppendUvarint(value, stats.words)
+}
+
+func (index *textIndex) saveStats(stats textStats) error {
+	err := index.tree.Update([]byte{TEXT_KEY_STATS}, stats.bytes())
+	if errors.Is(err, ErrorKeyNotFound) {
+		_, err = index.tree.SetKV([]byte{TEXT_KEY_STATS}, stats.bytes())
+	}
+	return err
+}
+
+// addDocument indexes the terms of the document of key under id
+func (index *textIndex) addDocument(id uint64, key []byte, document textDocument, stats *textStats) error {
+	terms := make([]string, 0, len(document.terms))
+	for term := range document.terms {
+		terms = append(terms, term)
+	}
+	slices.Sort(terms)
+
+	for _, term := range terms {
+		if err := index.addPosting(term, newPosting(id, document.terms[term])); err != nil {
+			return err
+		}
+	}
+	if _, err := index.tree.SetKV(textDocumentKey(id), encodeTextDocument(document.words, document.hash, key)); err != nil {
+		return err
+	}
+	if _, err := index.tree.SetKV(append(textDocumentIDPrefix(key), NewKeyEncoder().Uint64(id).Key()...), []byte{}); err != nil {
+		return err
+	}
+
+	stats.documents++
+	stats.words += uint64(document.words)
+	return nil
+}
+
+// removeDocument removes the ID of a version of the document of key.
+// Its postings are removed if document is the version it indexed, otherwise searches skip them.
+func (index *textIndex) removeDocument(id uint64, key []byte, document textDocument, stats *textStats) error {
+	record, err := index.tree.Get(textDocumentKey(id))
+	if err != nil && !errors.Is(err, ErrorKeyNotFound) {
+		return err
+	}
+	if record != nil {
+		words, hash, _, err := decodeTextDocument(record.Value)
+		if err != nil {
+			return err
+		}
+		if hash == document.hash {
+			for term := range document.terms {
+				if err := index.removePosting(term, id); err != nil {
+					return err
+				}
+			}
+		}
+		if err := index.tree.Delete(textDocumentKey(id)); err != nil {
+			return err
+		}
+		stats.documents -= min(stats.documents, 1)
+		stats.words -= min(stats.words, words)
+	}
+
+	err = index.tree.Delete(append(textDocumentIDPrefix(key), NewKeyEncoder().Uint64(id).Key()...))
+	if errors.Is(err, ErrorKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+// documentIDs returns the IDs of the indexed versions of the document of key
+func (index *textIndex) documentIDs(key []byte) ([]uint64, error) {
+	prefix := textDocumentIDPrefix(key)
+	ids := []uint64{}
+	for record, err := range index.tree.Iterate(ScanOptions{Prefix: prefix}) {
+		if err != nil {
+			return nil, err
+		}
+		parts, err := DecodeKey(record.Key[len(prefix):])
+		if err != nil || len(parts) != 1 {
+			return nil, ErrorTextIndexCorrupt
+		}
+		id, ok := parts[0].(uint64)
+		if !ok {
+			return nil, ErrorTextIndexCorrupt
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// begin indexes the new documents of changes under new IDs and returns the function that ends the write,
+// like beginIndexWrite
+func (index *textIndex) begin(changes []indexChange, sync bool) (func(applied bool), error) {
+	stats, err := index.stats()
+	if err != nil {
+		return nil, err
+	}
+
+	type textVersion struct {
+		id       uint64
+		key      []byte
+		document textDocument
+	}
+	added := []textVersion{}
+	replaced := []textVersion{}
+
+	finish := func(applied bool) {
+		err := func() error {
+			if !applied {
+				for _, version := range added {
+					if err := index.removeDocument(version.id, version.key, version.document, &stats); err != nil {
+						return err
+					}
+				}
+				return index.saveStats(stats)
+			}
+
+			// Every other version of a replaced document goes, those of failed writes too
+			for _, version := range replaced {
+				ids, err := index.documentIDs(version.key)
+				if err != nil {
+					return err
+				}
+				for _, id := range ids {
+					if slices.ContainsFunc(added, func(v textVersion) bool { return v.id == id }) {
+						continue
+					}
+					if err := index.removeDocument(id, version.key, version.document, &stats); err != nil {
+						return err
+					}
+				}
+			}
+			return index.saveStats(stats)
+		}()
+		if err != nil {
+			// Searches skip the versions that are left
+			utils.Log("Text index", index.tree.CollectionName, err)
+		}
+	}
+
+	for _, change := range changes {
+		old, new := index.document(change.old), index.document(change.new)
+		if old.words == new.words && old.hash == new.hash {
+			continue
+		}
+		replaced = append(replaced, textVersion{key: change.key, document: old})
+		if new.words == 0 {
+			continue
+		}
+
+		id := stats.next
+		stats.next++
+		if err := index.addDocument(id, change.key, new, &stats); err != nil {
+			finish(false)
+			return nil, err
+		}
+		added = append(added, textVersion{id: id, key: change.key, document: new})
+	}
+	if len(replaced) == 0 {
+		return func(bool) {}, nil
+	}
+
+	if err := index.saveStats(stats); err != nil {
+		finish(false)
+		return nil, err
+	}
+	if sync && index.tree.wal != nil {
+		if err := index.tree.wal.Sync(); err != nil {
+			finish(false)
+			return nil, err
+		}
+	}
+
+	return finish, nil
+}
+
+// build replaces the index by the documents stored in tree
+func (index *textIndex) build(tree *BTree) error {
+	documents := []*Record{}
+	for record, err := range tree.Iterate(ScanOptions{}) {
+		if err != nil {
+			return err
+		}
+		documents = append(documents, record)
+	}
+	return index.fill(documents)
+}
+
+// fill replaces the index by documents, IDs start over
+func (index *textIndex) fill(documents []*Record) error {
+	stats := textStats{next: 1}
+	records := []*Record{}
+	postings := map[string][]posting{}
+
+	for _, record := range documents {
+		document := index.document(record.Value)
+		if document.words == 0 {
+			continue
+		}
+		id := stats.next
+		stats.next++
+		stats.documents++
+		stats.words += uint64(document.words)
+
+		for term, positions := range document.terms {
+			postings[term] = append(postings[term], newPosting(id, positions))
+		}
+		records = append(records,
+			&Record{Key: textDocumentKey(id), Value: encodeTextDocument(document.words, document.hash, record.Key)},
+			&Record{Key: append(textDocumentIDPrefix(record.Key), NewKeyEncoder().Uint64(id).Key()...), Value: []byte{}},
+		)
+	}
+
+	// Postings were added in ID order
+	for term, termPostings := range postings {
+		first, block := termPostings[0].id, []byte{}
+		previous := first
+		for _, p := range termPostings {
+			next := appendPosting(block, previous, p)
+			if len(block) > 0 && len(next) > TEXT_BLOCK_SIZE {
+				records = append(records, &Record{Key: textPostingsKey(term, first), Value: block})
+				first, previous = p.id, p.id
+				next = appendPosting(nil, previous, p)
+			}
+			block, previous = next, p.id
+		}
+		records = append(records, &Record{Key: textPostingsKey(term, first), Value: block})
+
+		if index.phonetic != nil {
+			for _, form := range index.fuzzyForms(term) {
+				records = append(records, &Record{Key: textFuzzyKey(form, term), Value: []byte{}})
+			}
+		}
+	}
+	records = append(records, &Record{Key: []byte{TEXT_KEY_STATS}, Value: stats.bytes()})
+
+	slices.SortFunc(records, func(a, b *Record) int { return bytes.Compare(a.Key, b.Key) })
+	return index.tree.SortedRecordSet(records)
+}
+
+//------------------------------------------------------------------
+// Text tree
+//------------------------------------------------------------------
+
+// newTextIndex creates the empty tree of a text index
+func (tree *BTree) newTextIndex(options TextIndexOptions, phonetic func(string) string) (*textIndex, error) {
+	keySize := int(tree.MaxKeySize)
+	if keySize == 0 {
+		keySize = KEY_SIZE
+	}
+	// The longest of a k entry, a postings key and an f entry, every byte escaped
+	maxKeySize := 1 + max(1+2*keySize+2+9, 1+2*TEXT_TERM_SIZE+2+9, 2*(1+2*TEXT_TERM_SIZE+2))
+	if maxKeySize > MAX_KEY_SIZE {
+		return nil, ErrorIndexKeySize
+	}
+
+	textTree, err := openBTree(
+		tree.CollectionName+"/"+TEXT_INDEX_DIR,
+		tree.Order,
+		TEXT_NUM_LEVEL,
+		TEXT_BASE_SIZE,
+		TEXT_INCREMENT,
+		tree.CompactionBatchSize,
+		uint16(maxKeySize),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := textTree.reset(); err != nil {
+		textTree.closeFiles()
+		return nil, err
+	}
+
+	return &textIndex{options: options, phonetic: phonetic, tree: textTree}, nil
+}
+
+// openTextIndex reads the text index declared in text.json, an undeclared one is removed
+func (tree *BTree) openTextIndex() error {
+	options, err := loadTextIndex(tree.CollectionName)
+	if err != nil {
+		return err
+	}
+	if options == nil {
+		return os.RemoveAll(textIndexDir(tree.CollectionName))
+	}
+
+	textTree, err := readBTree(tree.CollectionName+"/"+TEXT_INDEX_DIR, nil, openBTree)
+	if err != nil {
+		return fmt.Errorf("Text index: %v", err)
+	}
+	phonetic, _ := checkTextIndexOptions(*options)
+	tree.textIndex = &textIndex{options: *options, phonetic: phonetic, tree: textTree}
+
+	return nil
+}
+
+// remove closes the tree of the index and deletes its files
+func (index *textIndex) remove() error {
+	if MODE_WASM {
+		return nil
+	}
+	return errors.Join(index.tree.closeFiles(), os.RemoveAll(fmt.Sprintf("%s/%s", SECRETARY, index.tree.CollectionName)))
+}
+
+// saveTextIndex replaces text.json with options, nil removes it
+func saveTextIndex(collectionName string, options *TextIndexOptions) error {
+	if MODE_WASM {
+		return nil
+	}
+
+	path := textIndexFile(collectionName)
+	if options == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	return file.ReplaceFile(path, data)
+}
+
+// loadTextIndex reads the options saved in text.json, nil without a text index
+func loadTextIndex(collectionName string) (*TextIndexOptions, error) {
+	data, err := os.ReadFile(textIndexFile(collectionName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var options TextIndexOptions
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, err
+	}
+	if _, err := checkTextIndexOptions(options); err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+// removeTextIndex deletes the text index of a collection
+func removeTextIndex(collectionName string) error {
+	return errors.Join(os.RemoveAll(textIndexDir(collectionName)), saveTextIndex(collectionName, nil))
+}
@@ -0,0 +1,355 @@
+package secretary
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// searchKeys returns the keys Search finds for query, best first
+func searchKeys(t *testing.T, tree *BTree, query string, limit int) []string {
+	t.Helper()
+	results, err := tree.Search(query, limit)
+	if err != nil {
+		t.Fatal(query, err)
+	}
+	keys := []string{}
+	for _, result := range results {
+		keys = append(keys, string(result.Record.Key))
+	}
+	return keys
+}
+
+func TestTextIndex(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	for key, value := range map[string]string{
+		"fox":   `{"title": "The quick brown fox", "body": "jumps over the lazy dog"}`,
+		"dog":   `{"title": "Lazy dogs", "body": "A dog sleeps, the dog dreams of a dog"}`,
+		"cat":   `{"title": "Cats", "body": "The brown cat ignores the quick dog"}`,
+		"other": `{"title": 5, "name": "dog"}`,
+	} {
+		if _, err := tree.SetKV([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, options := range []TextIndexOptions{
+		{},
+		{Fields: []string{"title", "title"}},
+		{Fields: []string{"key"}},
+		{Fields: []string{"title"}, Fuzzy: "sec8"},
+	} {
+		if err := tree.CreateTextIndex(options); err == nil {
+			t.Fatal("Expected", options, "rejected")
+		}
+	}
+	if _, err := tree.Search("dog", 0); err != ErrorTextIndexNotFound {
+		t.Fatal("Expected no text index", err)
+	}
+	if err := tree.CreateTextIndex(TextIndexOptions{Fields: []string{"title", "body"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.CreateTextIndex(TextIndexOptions{Fields: []string{"body"}}); err != ErrorTextIndexExists {
+		t.Fatal("Expected a second text index rejected", err)
+	}
+
+	check := func(query string, expected ...string) {
+		t.Helper()
+		if keys := searchKeys(t, tree, query, 0); !reflect.DeepEqual(keys, append([]string{}, expected...)) {
+			t.Fatal("Search", query, "mismatch", keys, "expected", expected)
+		}
+	}
+	// More occurrences and shorter documents rank first
+	check("dog", "dog", "cat", "fox")
+	check("DOG quick", "cat", "fox")
+	check(`"quick brown"`, "fox")
+	check(`"brown quick"`)
+	check(`"fox jumps"`)
+	check("dog*", "dog", "cat", "fox")
+	check("ca*", "cat")
+	check("missing")
+	if keys := searchKeys(t, tree, "the", 2); len(keys) != 2 {
+		t.Fatal("Expected the limit applied", keys)
+	}
+	for _, query := range []string{"", " ,. ", `"unterminated`} {
+		if _, err := tree.Search(query, 0); err == nil || !strings.HasPrefix(err.Error(), "Invalid search query") {
+			t.Fatal("Expected", query, "rejected", err)
+		}
+	}
+
+	// Every write path replaces the terms of the documents it changes
+	if err := tree.Update([]byte("fox"), []byte(`{"title": "A red fox"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.SetKV([]byte("cat"), []byte(`{"title": "duplicate fox"}`)); err != ErrorDuplicateKey {
+		t.Fatal("Expected a duplicate key", err)
+	}
+	check("fox", "fox")
+	check("quick", "cat")
+
+	_, err := tree.ApplyBatch([]Op{
+		{Type: OP_PUT, Key: []byte("owl"), Value: []byte(`{"title": "Night owl", "body": "the owl hunts"}`)},
+		{Type: OP_DELETE, Key: []byte("cat")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("owl", "owl")
+	check("quick")
+
+	txn := s.Begin()
+	if err := txn.Set(tree.CollectionName, []byte("bat"), []byte(`{"body": "bats hunt at night"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete(tree.CollectionName, []byte("dog")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	check("night", "bat", "owl")
+	check("dog")
+
+	ndjson := `{"key": "ant", "value": "{\"title\": \"Ants at night\"}"}`
+	if _, err := tree.BulkLoad(NDJSONRecords(strings.NewReader(ndjson)), BulkLoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	check("night", "ant", "bat", "owl")
+	if err := tree.Delete([]byte("ant")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Postings of a common term span several blocks, removing the first of a block rekeys it
+	for i := range 300 {
+		document := fmt.Sprintf(`{"title": "common %s", "body": "%d"}`, strings.Repeat("word ", i%5), i)
+		if _, err := tree.SetKV([]byte(fmt.Sprintf("doc%03d", i)), []byte(document)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	textIndex := tree.textIndex
+	blocks := 0
+	for range textIndex.tree.Iterate(ScanOptions{Prefix: textTermPrefix("common")}) {
+		blocks++
+	}
+	if blocks < 2 {
+		t.Fatal("Expected several postings blocks", blocks)
+	}
+	for i := range 300 {
+		if i%7 == 0 {
+			if err := tree.Delete([]byte(fmt.Sprintf("doc%03d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if keys := searchKeys(t, tree, "common", 0); len(keys) != 300-43 {
+		t.Fatal("Expected the remaining documents found", len(keys))
+	}
+	check("100", "doc100")
+	check("98")
+	stats, err := textIndex.stats()
+	if err != nil || stats.documents != 300-43+3 {
+		t.Fatal("Stats mismatch", err, stats)
+	}
+
+	// Versions left by failed writes are skipped and dropped by a rebuild
+	if _, err := textIndex.begin([]indexChange{{key: []byte("bat"), new: []byte(`{"title": "ghost"}`)}}, false); err != nil {
+		t.Fatal(err)
+	}
+	check("ghost")
+	check("bats", "bat")
+	if err := tree.BuildTextIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := tree.textIndex.documentIDs([]byte("bat")); err != nil || len(ids) != 1 {
+		t.Fatal("Expected one version after the rebuild", err, ids)
+	}
+	check("night", "bat", "owl")
+
+	// The text index is reopened with the collection
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.SetKV([]byte("elk"), []byte(`{"title": "Elk at night"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = s.NewBTreeReadHeader(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options := tree.TextIndex(); options == nil || !reflect.DeepEqual(options.Fields, []string{"title", "body"}) {
+		t.Fatal("Expected the text index reopened", options)
+	}
+	check("night", "elk", "bat", "owl")
+
+	// SortedRecordSet refills the index, Erase empties it
+	err = tree.SortedRecordSet([]*Record{
+		{Key: []byte("a"), Value: []byte(`{"title": "first night"}`)},
+		{Key: []byte("b"), Value: []byte(`{"title": "second"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("night", "a")
+	check("sec*", "b")
+	if err := tree.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	check("night")
+
+	if err := tree.DropTextIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.DropTextIndex(); err != ErrorTextIndexNotFound {
+		t.Fatal("Expected a dropped text index gone", err)
+	}
+	if _, err := os.Stat(textIndexDir(tree.CollectionName)); !os.IsNotExist(err) {
+		t.Fatal("Expected the text index files removed", err)
+	}
+
+	// A new tree of the same name has no text index
+	if err := tree.CreateTextIndex(TextIndexOptions{Fields: []string{"title"}}); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = s.NewBTree(tree.CollectionName, 4, 32, 1024, 125, 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(textIndexFile(tree.CollectionName)); tree.TextIndex() != nil || !os.IsNotExist(err) {
+		t.Fatal("Expected the text index dropped", err)
+	}
+
+	s.PagerShutdown()
+}
+
+func TestFuzzyTextIndex(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	for key, value := range map[string]string{
+		"jon":    `{"name": "Jon Smith", "title": "Keyboard"}`,
+		"john":   `{"name": "John Smyth", "title": "Mechanical keyboard"}`,
+		"joan":   `{"name": "Joan Smith", "title": "Wireless mouse"}`,
+		"philip": `{"name": "Philip Carter", "title": "Monitor stand"}`,
+	} {
+		if _, err := tree.SetKV([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tree.CreateTextIndex(TextIndexOptions{Fields: []string{"name", "title"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Suggest("jon", 0); err != ErrorTextIndexNotFuzzy {
+		t.Fatal("Expected no fuzzy mode", err)
+	}
+	if err := tree.DropTextIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.CreateTextIndex(TextIndexOptions{Fields: []string{"name", "title"}, Fuzzy: TEXT_FUZZY_SEC16}); err != nil {
+		t.Fatal(err)
+	}
+
+	terms := func(text string) [][]string {
+		t.Helper()
+		words, err := tree.Suggest(text, 0)
+		if err != nil {
+			t.Fatal(text, err)
+		}
+		terms := [][]string{}
+		for _, word := range words {
+			wordTerms := []string{}
+			for _, suggestion := range word.Suggestions {
+				wordTerms = append(wordTerms, suggestion.Term)
+			}
+			terms = append(terms, wordTerms)
+		}
+		return terms
+	}
+	check := func(text string, expected ...[]string) {
+		t.Helper()
+		if got := terms(text); !reflect.DeepEqual(got, expected) {
+			t.Fatal("Suggest", text, "mismatch", got, "expected", expected)
+		}
+	}
+	// Closest first, then the terms of the most documents
+	check("jon", []string{"jon", "joan", "john"})
+	// smyth is a substitution and a transposition from smiht, two edits of their forms
+	check("Smiht keybord", []string{"smith"}, []string{"keyboard"})
+	check("filip", []string{})
+	check("phillip", []string{"philip"})
+	check("xyz", []string{})
+
+	words, err := tree.Suggest("smiht", 1)
+	if err != nil || len(words) != 1 || !reflect.DeepEqual(words[0].Suggestions, []Suggestion{{Term: "smith", Distance: 1, Documents: 2}}) {
+		t.Fatal("Expected the best suggestion", err, words)
+	}
+	if _, err := tree.Suggest(" ,. ", 0); err == nil {
+		t.Fatal("Expected a text without words rejected")
+	}
+
+	if keys := searchKeys(t, tree, "smiht~", 0); !reflect.DeepEqual(keys, []string{"jon", "joan"}) {
+		t.Fatal("Expected the fuzzy word searched", keys)
+	}
+	if keys := searchKeys(t, tree, "jonh~ keybord~", 0); !reflect.DeepEqual(keys, []string{"jon", "john"}) {
+		t.Fatal("Expected every fuzzy word matched", keys)
+	}
+	check("smyth", []string{"smyth", "smith"})
+
+	// The f entries follow the terms through writes and rebuilds
+	if err := tree.Update([]byte("john"), []byte(`{"name": "John Smith"}`)); err != nil {
+		t.Fatal(err)
+	}
+	check("smyth", []string{"smith"})
+	if _, err := tree.SetKV([]byte("smythe"), []byte(`{"name": "Ann Smythe"}`)); err != nil {
+		t.Fatal(err)
+	}
+	check("smyth", []string{"smith", "smythe"})
+	if err := tree.BuildTextIndex(); err != nil {
+		t.Fatal(err)
+	}
+	check("smyth", []string{"smith", "smythe"})
+	if err := tree.close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = s.NewBTreeReadHeader(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options := tree.TextIndex(); options == nil || options.Fuzzy != TEXT_FUZZY_SEC16 {
+		t.Fatal("Expected the fuzzy mode reopened", options)
+	}
+	check("mous", []string{"mouse"})
+
+	s.PagerShutdown()
+}
+
+func TestTextPostings(t *testing.T) {
+	postings := []posting{
+		{id: 7, frequency: 2, positions: []uint32{0, 5}},
+		{id: 9, frequency: 1, positions: []uint32{300}},
+		{id: 1000, frequency: 200, positions: make([]uint32, TEXT_POSITIONS)},
+	}
+	decoded, err := decodePostings(7, encodePostings(postings))
+	if err != nil || !reflect.DeepEqual(decoded, postings) {
+		t.Fatal("Postings mismatch", err, decoded)
+	}
+	if _, err := decodePostings(7, []byte{0x80}); err != ErrorTextIndexCorrupt {
+		t.Fatal("Expected a truncated block rejected", err)
+	}
+
+	if p := newPosting(1, make([]uint32, TEXT_POSITIONS+5)); p.frequency != TEXT_POSITIONS+5 || len(p.positions) != TEXT_POSITIONS {
+		t.Fatal("Expected the positions capped", p.frequency, len(p.positions))
+	}
+
+	term, first, err := decodePostingsKey(textPostingsKey("zero\x00byte", 42))
+	if err != nil || term != "zero\x00byte" || first != 42 {
+		t.Fatal("Postings key mismatch", err, term, first)
+	}
+}
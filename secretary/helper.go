@@ -32,6 +32,7 @@ func DummyInitTrees(s *Secretary) {
 		1024,
 		125,
 		1000,
+		0,
 	)
 
 	images, imagesErr := s.NewBTree(
@@ -41,6 +42,7 @@ func DummyInitTrees(s *Secretary) {
 		1024*1024,
 		125,
 		1000,
+		0,
 	)
 	if userErr != nil || imagesErr != nil {
 		utils.Log(userErr, imagesErr)
@@ -0,0 +1,802 @@
+package secretary
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"slices"
+
+	"github.com/codeharik/secretary/utils"
+	"github.com/codeharik/secretary/utils/file"
+)
+
+/*
+Indexes
+
+A collection can index the top level fields of its JSON documents. Every
+index is a tree of its own in SECRETARY/<collection>/indexes/<field>, its
+keys are the KeyEncoder encoding of the field value followed by the
+primary key, its values are empty :
+
+	String("Ada") + "u1"
+	Float64(36) + "u1"
+
+Strings, numbers and booleans are indexed, strings by their first
+INDEX_VALUE_SIZE bytes. Documents without the field, with another type or
+values that are not JSON objects have no entry. The fields of a document
+are read as SQL reads its row, an index on value covers raw values.
+
+SetKV, Update, Delete, ApplyBatch, Txn.Commit, BulkLoad, SortedRecordSet
+and Erase keep the indexes in step. A write first adds the entries of the
+new documents, then writes the collection and removes the entries of the
+replaced documents once the write applied. A write that failed leaves both
+: an index may hold stale entries but never misses a document, readers
+check every document they find through an index. Writes to a collection
+with indexes take indexMu exclusively, the document they replace can not
+change until its entries are updated. Batches and transactions sync the
+index WALs before their own.
+
+CreateIndex builds the index from the documents already stored, BuildIndex
+rebuilds it, dropping stale entries. The fields are declared in
+indexes.json, an index directory that is not declared is the remain of an
+interrupted CreateIndex and is removed when the collection is read.
+
+ExecSQL uses an index for =, IN, comparisons and BETWEEN on an indexed
+field, see planSQLScan. GET /range?index=<field> scans an index.
+*/
+
+const (
+	INDEX_DIR              = "indexes"
+	INDEXES_FILE           = "indexes.json"
+	INDEX_VALUE_SIZE       = 64                         // Bytes of a string value that are indexed
+	INDEX_ENTRY_VALUE_SIZE = 1 + 2*INDEX_VALUE_SIZE + 2 // Longest encoded value, every byte escaped
+	MAX_INDEX_FIELD_LENGTH = 64
+)
+
+// fieldIndex is the index of a document field
+type fieldIndex struct {
+	field string
+	tree  *BTree
+}
+
+// indexChange is a document a write replaces, nil old or new if the key is created or deleted
+type indexChange struct {
+	key []byte
+	old []byte
+	new []byte
+}
+
+func indexDir(collectionName string) string {
+	return fmt.Sprintf("%s/%s/%s", SECRETARY, collectionName, INDEX_DIR)
+}
+
+func indexTreeName(collectionName string, field string) string {
+	return collectionName + "/" + INDEX_DIR + "/" + field
+}
+
+// checkIndexField validates a field name, it names the directory of the index
+func checkIndexField(field string) error {
+	if field == "" || len(field) > MAX_INDEX_FIELD_LENGTH || field != utils.SafeCollectionString(field) ||
+		field == "." || field == ".." || field == SQL_KEY_COLUMN {
+		return ErrorInvalidIndexField
+	}
+	return nil
+}
+
+// Indexes returns the indexed fields
+func (tree *BTree) Indexes() []string {
+	tree.indexMu.RLock()
+	defer tree.indexMu.RUnlock()
+
+	fields := make([]string, len(tree.indexes))
+	for i, index := range tree.indexes {
+		fields[i] = index.field
+	}
+	return fields
+}
+
+// CreateIndex indexes field and builds the index from the stored documents
+func (tree *BTree) CreateIndex(field string) error {
+	if err := checkIndexField(field); err != nil {
+		return err
+	}
+
+	tree.indexMu.Lock()
+	defer tree.indexMu.Unlock()
+
+	if tree.index(field) != nil {
+		return ErrorIndexExists
+	}
+
+	index, err := tree.newIndex(field)
+	if err != nil {
+		return err
+	}
+	if err := index.build(tree); err != nil {
+		return errors.Join(err, index.remove())
+	}
+
+	indexes := append(slices.Clone(tree.indexes), index)
+	if err := saveIndexes(tree.CollectionName, indexes); err != nil {
+		return errors.Join(err, index.remove())
+	}
+	tree.indexes = indexes
+
+	return nil
+}
+
+// DropIndex removes the index of field
+func (tree *BTree) DropIndex(field string) error {
+	tree.indexMu.Lock()
+	defer tree.indexMu.Unlock()
+
+	index := tree.index(field)
+	if index == nil {
+		return ErrorIndexNotFound
+	}
+
+	indexes := slices.DeleteFunc(slices.Clone(tree.indexes), func(i *fieldIndex) bool { return i == index })
+	if err := saveIndexes(tree.CollectionName, indexes); err != nil {
+		return err
+	}
+	tree.indexes = indexes
+
+	return index.remove()
+}
+
+// BuildIndex rebuilds the index of field from the stored documents
+func (tree *BTree) BuildIndex(field string) error {
+	indexes, unlock := tree.lockIndexes()
+	defer unlock()
+
+	for _, index := range indexes.fields {
+		if index.field == field {
+			return index.build(tree)
+		}
+	}
+	return ErrorIndexNotFound
+}
+
+// BuildIndexes rebuilds every index of the collection, the text index and the vector graph included
+func (tree *BTree) BuildIndexes() error {
+	indexes, unlock := tree.lockIndexes()
+	defer unlock()
+
+	for _, index := range indexes.fields {
+		if err := index.build(tree); err != nil {
+			return err
+		}
+	}
+	if indexes.text != nil {
+		if err := indexes.text.build(tree); err != nil {
+			return err
+		}
+	}
+	if indexes.vectors != nil {
+		return indexes.vectors.build(tree)
+	}
+	return nil
+}
+
+// IterateIndex returns the records whose field is between low and high, in the order of the field.
+// Bounds are strings, numbers or booleans, nil for an open bound.
+func (tree *BTree) IterateIndex(field string, low any, high any) iter.Seq2[*Record, error] {
+	return func(yield func(*Record, error) bool) {
+		keys, err := tree.indexKeys(field, low, high)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		// Bounds are normalized by indexKeys
+		low, _ = sqlParamValue(low)
+		high, _ = sqlParamValue(high)
+
+		seen := map[string]bool{}
+		for _, key := range keys {
+			if seen[string(key)] {
+				continue
+			}
+			seen[string(key)] = true
+
+			record, err := tree.Get(key)
+			if errors.Is(err, ErrorKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			// Stale entries point to documents that changed
+			value := documentField(record.Value, field)
+			if indexValue(value) == nil {
+				continue
+			}
+			if order, ok := compareSQLValues(value, low); low != nil && (!ok || order < 0) {
+				continue
+			}
+			if order, ok := compareSQLValues(value, high); high != nil && (!ok || order > 0) {
+				continue
+			}
+
+			if !yield(record, nil) {
+				return
+			}
+		}
+	}
+}
+
+// indexKeys returns the primary keys of the entries of field between low and high, in index order.
+// Stale entries are included, callers check the documents.
+func (tree *BTree) indexKeys(field string, low any, high any) ([][]byte, error) {
+	var lowValue, highValue []byte
+	for i, bound := range []any{low, high} {
+		if bound == nil {
+			continue
+		}
+		value, err := sqlParamValue(bound)
+		if err != nil {
+			return nil, ErrorIndexValueType(bound)
+		}
+		encoded := indexValue(value)
+		if encoded == nil {
+			return nil, ErrorIndexValueType(bound)
+		}
+		if i == 0 {
+			lowValue = encoded
+		} else {
+			highValue = encoded
+		}
+	}
+
+	tree.indexMu.RLock()
+	defer tree.indexMu.RUnlock()
+
+	index := tree.index(field)
+	if index == nil {
+		return nil, ErrorIndexNotFound
+	}
+
+	// Values of different types never match
+	if lowValue != nil && highValue != nil && lowValue[0] != highValue[0] {
+		return nil, nil
+	}
+
+	options := ScanOptions{StartKey: lowValue}
+	if lowValue != nil {
+		options.Prefix = lowValue[:1]
+	} else if highValue != nil {
+		options.Prefix = highValue[:1]
+	}
+
+	keys := [][]byte{}
+	for record, err := range index.tree.Iterate(options) {
+		if err != nil {
+			return nil, err
+		}
+		value, key, err := splitIndexEntry(record.Key)
+		if err != nil {
+			return nil, err
+		}
+		if highValue != nil && bytes.Compare(value, highValue) > 0 {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// index returns the index of field, indexMu is held
+func (tree *BTree) index(field string) *fieldIndex {
+	for _, index := range tree.indexes {
+		if index.field == field {
+			return index
+		}
+	}
+	return nil
+}
+
+// treeIndexes are the indexes a write maintains
+type treeIndexes struct {
+	fields  []*fieldIndex
+	text    *textIndex
+	vectors *vectorIndex
+}
+
+func (indexes treeIndexes) empty() bool {
+	return len(indexes.fields) == 0 && indexes.text == nil && indexes.vectors == nil
+}
+
+// lockIndexes locks indexMu for a write and returns the indexes to maintain and the unlock function.
+// Without indexes writes share the lock, with indexes they hold it exclusively.
+func (tree *BTree) lockIndexes() (treeIndexes, func()) {
+	tree.indexMu.RLock()
+	if len(tree.indexes) == 0 && tree.textIndex == nil && tree.vectors.Load() == nil {
+		return treeIndexes{}, tree.indexMu.RUnlock
+	}
+	tree.indexMu.RUnlock()
+
+	tree.indexMu.Lock()
+	return treeIndexes{fields: tree.indexes, text: tree.textIndex, vectors: tree.vectors.Load()}, tree.indexMu.Unlock
+}
+
+//------------------------------------------------------------------
+// Entries
+//------------------------------------------------------------------
+
+// documentField returns the field of a document as SQL reads it, nil if it is missing
+func documentField(document []byte, field string) any {
+	return decodeSQLRow(&Record{Value: document})[field]
+}
+
+// indexValue encodes an indexed value, nil for the types that are not indexed
+func indexValue(value any) []byte {
+	encoder := NewKeyEncoder()
+	switch value := value.(type) {
+	case string:
+		encoder.String(value[:min(len(value), INDEX_VALUE_SIZE)])
+	case float64:
+		encoder.Float64(value)
+	case bool:
+		encoder.Bool(value)
+	default:
+		return nil
+	}
+	return encoder.Key()
+}
+
+// entry returns the index entry of the document of key, nil if it has none
+func (index *fieldIndex) entry(key []byte, document []byte) []byte {
+	if document == nil {
+		return nil
+	}
+	value := indexValue(documentField(document, index.field))
+	if value == nil {
+		return nil
+	}
+	return append(value, key...)
+}
+
+// splitIndexEntry returns the encoded value and the primary key of an entry
+func splitIndexEntry(entry []byte) ([]byte, []byte, error) {
+	if len(entry) == 0 {
+		return nil, nil, ErrorInvalidKeyEncoding
+	}
+
+	size := 0
+	switch entry[0] {
+	case KEY_PART_STRING:
+		_, rest, err := readEscaped(entry[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		size = len(entry) - len(rest)
+	case KEY_PART_FLOAT64:
+		size = 9
+	case KEY_PART_BOOL:
+		size = 2
+	default:
+		return nil, nil, ErrorInvalidKeyEncoding
+	}
+	if size >= len(entry) {
+		return nil, nil, ErrorInvalidKeyEncoding
+	}
+	return entry[:size], entry[size:], nil
+}
+
+//------------------------------------------------------------------
+// Maintenance
+//------------------------------------------------------------------
+
+// indexWrite runs write, a single put or delete of op.Key, and keeps the indexes in step with it
+func (tree *BTree) indexWrite(op TxnOp, write func() error) error {
+	indexes, unlock := tree.lockIndexes()
+	defer unlock()
+
+	if indexes.empty() {
+		return write()
+	}
+
+	changes, err := tree.indexChanges([]TxnOp{op}, func(key []byte) ([]byte, error) {
+		record, err := tree.Get(key)
+		if errors.Is(err, ErrorKeyNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return record.Value, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	finish, err := beginIndexWrite(indexes, changes, false)
+	if err != nil {
+		return err
+	}
+
+	err = write()
+	switch {
+	case err == nil:
+		finish(true)
+	case errors.Is(err, ErrorDuplicateKey), errors.Is(err, ErrorKeyNotFound):
+		finish(false)
+	}
+	// Other errors keep the entries of both documents
+	return err
+}
+
+// indexChanges returns the documents ops replace, current reads the document of a key before the ops
+func (tree *BTree) indexChanges(ops []TxnOp, current func([]byte) ([]byte, error)) ([]indexChange, error) {
+	changes := []indexChange{}
+	positions := map[string]int{}
+
+	for _, op := range ops {
+		var document []byte
+		if op.Op != WAL_DELETE {
+			var err error
+			if document, err = tree.decodeValue(op.Value); err != nil {
+				return nil, err
+			}
+		}
+
+		if i, ok := positions[string(op.Key)]; ok {
+			changes[i].new = document
+			continue
+		}
+
+		old, err := current(op.Key)
+		if err != nil {
+			return nil, err
+		}
+		positions[string(op.Key)] = len(changes)
+		changes = append(changes, indexChange{key: op.Key, old: old, new: document})
+	}
+
+	return changes, nil
+}
+
+// documentLocked returns the document of key, nil if it does not exist, tree.mu is held
+func (tree *BTree) documentLocked(key []byte) ([]byte, error) {
+	if tree.root == nil {
+		return nil, nil
+	}
+
+	leaf, keyIndex, found, err := tree.getLeafNode(key)
+	if err != nil || !found {
+		return nil, err
+	}
+	record, err := tree.readValue(leaf.records[keyIndex])
+	if err != nil {
+		return nil, err
+	}
+	return record.Value, nil
+}
+
+// beginIndexWrite adds the entries of the new documents and returns the function that ends the write :
+// once it applied the entries of the replaced documents are removed, otherwise the added ones
+func beginIndexWrite(indexes treeIndexes, changes []indexChange, sync bool) (func(applied bool), error) {
+	finishFields, err := beginFieldIndexWrite(indexes.fields, changes, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	finishes := []func(bool){finishFields}
+	finish := func(applied bool) {
+		for _, finish := range finishes {
+			finish(applied)
+		}
+	}
+
+	if indexes.text != nil {
+		finishText, err := indexes.text.begin(changes, sync)
+		if err != nil {
+			finish(false)
+			return nil, err
+		}
+		finishes = append(finishes, finishText)
+	}
+	if indexes.vectors != nil {
+		finishVectors, err := indexes.vectors.begin(changes)
+		if err != nil {
+			finish(false)
+			return nil, err
+		}
+		finishes = append(finishes, finishVectors)
+	}
+	return finish, nil
+}
+
+// beginFieldIndexWrite is beginIndexWrite on the field indexes
+func beginFieldIndexWrite(indexes []*fieldIndex, changes []indexChange, sync bool) (func(applied bool), error) {
+	type indexEntries struct {
+		index *fieldIndex
+		added [][]byte
+		stale [][]byte
+	}
+	entries := make([]indexEntries, len(indexes))
+
+	finish := func(applied bool) {
+		for _, e := range entries {
+			remove := e.added
+			if applied {
+				remove = e.stale
+			}
+			for _, entry := range remove {
+				if err := e.index.tree.Delete(entry); err != nil && !errors.Is(err, ErrorKeyNotFound) {
+					// The entry stays stale, readers skip it
+					utils.Log("Index", e.index.tree.CollectionName, err)
+				}
+			}
+		}
+	}
+
+	for i, index := range indexes {
+		entries[i].index = index
+		for _, change := range changes {
+			old, new := index.entry(change.key, change.old), index.entry(change.key, change.new)
+			if bytes.Equal(old, new) {
+				continue
+			}
+			if old != nil {
+				entries[i].stale = append(entries[i].stale, old)
+			}
+			if new == nil {
+				continue
+			}
+
+			_, err := index.tree.SetKV(new, []byte{})
+			if errors.Is(err, ErrorDuplicateKey) {
+				continue
+			}
+			if err != nil {
+				finish(false)
+				return nil, err
+			}
+			entries[i].added = append(entries[i].added, new)
+		}
+
+		if sync && len(entries[i].added) > 0 && index.tree.wal != nil {
+			if err := index.tree.wal.Sync(); err != nil {
+				finish(false)
+				return nil, err
+			}
+		}
+	}
+
+	return finish, nil
+}
+
+// build replaces the entries of the index by those of the documents stored in tree
+func (index *fieldIndex) build(tree *BTree) error {
+	entries := [][]byte{}
+	for record, err := range tree.Iterate(ScanOptions{}) {
+		if err != nil {
+			return err
+		}
+		if entry := index.entry(record.Key, record.Value); entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return index.fill(entries)
+}
+
+// fill replaces the entries of the index
+func (index *fieldIndex) fill(entries [][]byte) error {
+	if len(entries) == 0 {
+		return index.tree.Erase()
+	}
+
+	slices.SortFunc(entries, bytes.Compare)
+	entries = slices.CompactFunc(entries, bytes.Equal)
+
+	records := make([]*Record, len(entries))
+	for i, entry := range entries {
+		records[i] = &Record{Key: entry, Value: []byte{}}
+	}
+	return index.tree.SortedRecordSet(records)
+}
+
+// fillIndexes replaces the entries of the indexes by those of sorted records, their values are stored values
+func (tree *BTree) fillIndexes(indexes treeIndexes, records []*Record) error {
+	if indexes.empty() {
+		return nil
+	}
+
+	documents := make([]*Record, len(records))
+	for i, record := range records {
+		document, err := tree.decodeValue(record.Value)
+		if err != nil {
+			return err
+		}
+		documents[i] = &Record{Key: record.Key, Value: document}
+	}
+
+	for _, index := range indexes.fields {
+		entries := [][]byte{}
+		for _, document := range documents {
+			if entry := index.entry(document.Key, document.Value); entry != nil {
+				entries = append(entries, entry)
+			}
+		}
+		if err := index.fill(entries); err != nil {
+			return err
+		}
+	}
+	if indexes.text != nil {
+		if err := indexes.text.fill(documents); err != nil {
+			return err
+		}
+	}
+	if indexes.vectors != nil {
+		return indexes.vectors.fill(documents)
+	}
+	return nil
+}
+
+//------------------------------------------------------------------
+// Index trees
+//------------------------------------------------------------------
+
+// indexKeySize returns the longest entry of an index of tree
+func (tree *BTree) indexKeySize() (uint16, error) {
+	keySize := int(tree.MaxKeySize)
+	if keySize == 0 {
+		keySize = KEY_SIZE
+	}
+	if INDEX_ENTRY_VALUE_SIZE+keySize > MAX_KEY_SIZE {
+		return 0, ErrorIndexKeySize
+	}
+	return uint16(INDEX_ENTRY_VALUE_SIZE + keySize), nil
+}
+
+// newIndex creates the empty tree of an index on field
+func (tree *BTree) newIndex(field string) (*fieldIndex, error) {
+	maxKeySize, err := tree.indexKeySize()
+	if err != nil {
+		return nil, err
+	}
+
+	indexTree, err := openBTree(
+		indexTreeName(tree.CollectionName, field),
+		tree.Order,
+		tree.NumLevel,
+		tree.BaseSize,
+		tree.Increment,
+		tree.CompactionBatchSize,
+		maxKeySize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := indexTree.reset(); err != nil {
+		indexTree.closeFiles()
+		return nil, err
+	}
+
+	return &fieldIndex{field: field, tree: indexTree}, nil
+}
+
+// openIndexes reads the indexes declared in indexes.json and text.json and removes the undeclared ones,
+// then the vector graph
+func (tree *BTree) openIndexes() error {
+	fields, err := loadIndexes(tree.CollectionName)
+	if err != nil {
+		return err
+	}
+
+	dirs, err := os.ReadDir(indexDir(tree.CollectionName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, dir := range dirs {
+		if dir.IsDir() && !slices.Contains(fields, dir.Name()) {
+			if err := os.RemoveAll(fmt.Sprintf("%s/%s", indexDir(tree.CollectionName), dir.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	indexes := make([]*fieldIndex, 0, len(fields))
+	for _, field := range fields {
+		indexTree, err := readBTree(indexTreeName(tree.CollectionName, field), nil, openBTree)
+		if err != nil {
+			for _, index := range indexes {
+				index.tree.closeFiles()
+			}
+			return fmt.Errorf("Index %s: %v", field, err)
+		}
+		indexes = append(indexes, &fieldIndex{field: field, tree: indexTree})
+	}
+
+	if err := tree.openTextIndex(); err != nil {
+		for _, index := range indexes {
+			index.tree.closeFiles()
+		}
+		return err
+	}
+	tree.indexes = indexes
+
+	if err := tree.openVectorIndex(); err != nil {
+		return errors.Join(err, tree.closeIndexes())
+	}
+	return nil
+}
+
+// closeIndexes checkpoints and closes the index trees
+func (tree *BTree) closeIndexes() error {
+	tree.indexMu.Lock()
+	defer tree.indexMu.Unlock()
+
+	errs := []error{}
+	for _, index := range tree.indexes {
+		errs = append(errs, index.tree.close())
+	}
+	if tree.textIndex != nil {
+		errs = append(errs, tree.textIndex.tree.close())
+	}
+	if vectors := tree.vectors.Load(); vectors != nil {
+		errs = append(errs, vectors.close())
+	}
+	return errors.Join(errs...)
+}
+
+// remove closes the tree of the index and deletes its files
+func (index *fieldIndex) remove() error {
+	if MODE_WASM {
+		return nil
+	}
+	return errors.Join(index.tree.closeFiles(), os.RemoveAll(fmt.Sprintf("%s/%s", SECRETARY, index.tree.CollectionName)))
+}
+
+// saveIndexes replaces indexes.json with the fields of indexes
+func saveIndexes(collectionName string, indexes []*fieldIndex) error {
+	if MODE_WASM {
+		return nil
+	}
+
+	fields := make([]string, len(indexes))
+	for i, index := range indexes {
+		fields[i] = index.field
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	if err := file.EnsureDir(indexDir(collectionName)); err != nil {
+		return err
+	}
+	return file.ReplaceFile(fmt.Sprintf("%s/%s", indexDir(collectionName), INDEXES_FILE), data)
+}
+
+// loadIndexes reads the fields declared in indexes.json
+func loadIndexes(collectionName string) ([]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("%s/%s", indexDir(collectionName), INDEXES_FILE))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if err := checkIndexField(field); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// removeIndexes deletes the indexes of a collection, its text index included
+func removeIndexes(collectionName string) error {
+	return errors.Join(os.RemoveAll(indexDir(collectionName)), removeTextIndex(collectionName))
+}
@@ -0,0 +1,278 @@
+package secretary
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// indexContent returns the entries of the index of field as value/key
+func indexContent(t *testing.T, tree *BTree, field string) []string {
+	t.Helper()
+
+	tree.indexMu.RLock()
+	index := tree.index(field)
+	tree.indexMu.RUnlock()
+	if index == nil {
+		t.Fatal("Expected an index on", field)
+	}
+
+	entries := []string{}
+	for record, err := range index.tree.Iterate(ScanOptions{}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, key, err := splitIndexEntry(record.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts, err := DecodeKey(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, fmt.Sprintf("%v/%s", parts[0], key))
+	}
+	return entries
+}
+
+func TestIndex(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	for key, value := range map[string]string{
+		"ada": `{"name": "Ada", "age": 36}`,
+		"bob": `{"name": "Bob", "age": 25}`,
+		"cy":  `{"name": "Cy"}`,
+		"raw": `not json`,
+	} {
+		if _, err := tree.SetKV([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, field := range []string{"", "key", "a/b", "..", strings.Repeat("f", MAX_INDEX_FIELD_LENGTH+1)} {
+		if err := tree.CreateIndex(field); err != ErrorInvalidIndexField {
+			t.Fatal("Expected", field, "rejected", err)
+		}
+	}
+	for _, field := range []string{"age", "name", "value"} {
+		if err := tree.CreateIndex(field); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.CreateIndex("age"); err != ErrorIndexExists {
+		t.Fatal("Expected a second index on age rejected", err)
+	}
+
+	check := func(field string, expected ...string) {
+		t.Helper()
+		if got := indexContent(t, tree, field); !slices.Equal(got, expected) {
+			t.Fatal("Index", field, "mismatch", got, "expected", expected)
+		}
+	}
+	// Built from the stored documents, raw values are read as {"value": ...}
+	check("age", "25/bob", "36/ada")
+	check("name", "Ada/ada", "Bob/bob", "Cy/cy")
+	check("value", "not json/raw")
+
+	// Every write path replaces the entries of the documents it changes
+	if _, err := tree.SetKV([]byte("dan"), []byte(`{"name": "Dan", "age": 25}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.SetKV([]byte("dan"), []byte(`{"name": "Dup", "age": 1}`)); err != ErrorDuplicateKey {
+		t.Fatal("Expected a duplicate key", err)
+	}
+	if err := tree.Update([]byte("bob"), []byte(`{"name": "Bob", "age": true}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Update([]byte("eve"), []byte(`{"name": "Eve"}`)); err != ErrorKeyNotFound {
+		t.Fatal("Expected a missing key", err)
+	}
+	if err := tree.Delete([]byte("raw")); err != nil {
+		t.Fatal(err)
+	}
+	check("age", "25/dan", "36/ada", "true/bob")
+	check("name", "Ada/ada", "Bob/bob", "Cy/cy", "Dan/dan")
+	check("value")
+
+	_, err := tree.ApplyBatch([]Op{
+		{Type: OP_PUT, Key: []byte("cy"), Value: []byte(`{"name": "Cy", "age": 40}`)},
+		{Type: OP_PUT, Key: []byte("eve"), Value: []byte(`{"name": "Eve", "age": 30}`)},
+		{Type: OP_PUT, Key: []byte("eve"), Value: []byte(`{"name": "Eve", "age": 31}`)},
+		{Type: OP_DELETE, Key: []byte("dan")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("age", "31/eve", "36/ada", "40/cy", "true/bob")
+	check("name", "Ada/ada", "Bob/bob", "Cy/cy", "Eve/eve")
+
+	txn := s.Begin()
+	if err := txn.Set(tree.CollectionName, []byte("ada"), []byte(`{"name": "Ada", "age": 37}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete(tree.CollectionName, []byte("cy")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	check("age", "31/eve", "37/ada", "true/bob")
+	check("name", "Ada/ada", "Bob/bob", "Eve/eve")
+
+	ndjson := `{"key": "ada", "value": "{\"name\": \"Ada\", \"age\": 38}"}
+{"key": "fay", "value": "{\"name\": \"Fay\", \"age\": -2.5}"}`
+	if _, err := tree.BulkLoad(NDJSONRecords(strings.NewReader(ndjson)), BulkLoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	check("age", "-2.5/fay", "31/eve", "38/ada", "true/bob")
+
+	// Records found through an index, in the order of the field
+	iterated := func(field string, low any, high any) []string {
+		t.Helper()
+		keys := []string{}
+		for record, err := range tree.IterateIndex(field, low, high) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, string(record.Key))
+		}
+		return keys
+	}
+	if keys := iterated("age", 0, 38); !reflect.DeepEqual(keys, []string{"eve", "ada"}) {
+		t.Fatal("IterateIndex mismatch", keys)
+	}
+	if keys := iterated("age", nil, 31); !reflect.DeepEqual(keys, []string{"fay", "eve"}) {
+		t.Fatal("IterateIndex mismatch", keys)
+	}
+	if keys := iterated("age", true, nil); !reflect.DeepEqual(keys, []string{"bob"}) {
+		t.Fatal("IterateIndex mismatch", keys)
+	}
+	if keys := iterated("name", "B", "Ez"); !reflect.DeepEqual(keys, []string{"bob", "eve"}) {
+		t.Fatal("IterateIndex mismatch", keys)
+	}
+	for _, err := range tree.IterateIndex("missing", nil, nil) {
+		if err != ErrorIndexNotFound {
+			t.Fatal("Expected a missing index", err)
+		}
+	}
+
+	// Stale entries are skipped by readers and dropped by a rebuild
+	tree.indexMu.RLock()
+	ageIndex := tree.index("age")
+	tree.indexMu.RUnlock()
+	if _, err := ageIndex.tree.SetKV(append(indexValue(float64(31)), "ada"...), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := iterated("age", 31, 31); !reflect.DeepEqual(keys, []string{"eve"}) {
+		t.Fatal("Expected the stale entry skipped", keys)
+	}
+	if err := tree.BuildIndex("age"); err != nil {
+		t.Fatal(err)
+	}
+	check("age", "-2.5/fay", "31/eve", "38/ada", "true/bob")
+
+	// SQL reads the keys from the index
+	plan, err := planSQLScan(tree, mustParseWhere(t, "WHERE age IN (31, 38) AND name != 'x'"), sqlEnv{})
+	if err != nil || !reflect.DeepEqual(plan.keys, [][]byte{[]byte("ada"), []byte("eve")}) {
+		t.Fatal("Expected the index used for IN", err, plan.keys)
+	}
+	plan, err = planSQLScan(tree, mustParseWhere(t, "WHERE 30 < age AND age <= 40"), sqlEnv{})
+	if err != nil || !reflect.DeepEqual(plan.keys, [][]byte{[]byte("ada"), []byte("eve")}) {
+		t.Fatal("Expected the index used for the range", err, plan.keys)
+	}
+	plan, err = planSQLScan(tree, mustParseWhere(t, "WHERE key >= 'b' AND age > 30"), sqlEnv{})
+	if err != nil || plan.keys != nil || string(plan.options.StartKey) != "b" {
+		t.Fatal("Expected the key range preferred over an index range", err, plan)
+	}
+
+	result, err := s.ExecSQL(fmt.Sprintf("SELECT key FROM %s WHERE age > 30 ORDER BY key DESC", tree.CollectionName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []map[string]any{{"key": "eve"}, {"key": "ada"}}; !reflect.DeepEqual(result.Rows, expected) {
+		t.Fatal("SQL mismatch", result.Rows)
+	}
+	result, err = s.ExecSQL(fmt.Sprintf("UPDATE %s SET age = 32 WHERE age = ?", tree.CollectionName), 31)
+	if err != nil || result.Affected != 1 {
+		t.Fatal("Expected one row updated", err, result)
+	}
+	check("age", "-2.5/fay", "32/eve", "38/ada", "true/bob")
+
+	// Indexes are reopened with the collection, an undeclared index directory is removed
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.SetKV([]byte("gus"), []byte(`{"name": "Gus", "age": 50}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.close(); err != nil {
+		t.Fatal(err)
+	}
+	leftover := fmt.Sprintf("%s/%s", indexDir(tree.CollectionName), "leftover")
+	if err := os.MkdirAll(leftover, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = s.NewBTreeReadHeader(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexes := tree.Indexes(); !reflect.DeepEqual(indexes, []string{"age", "name", "value"}) {
+		t.Fatal("Expected the indexes reopened", indexes)
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Fatal("Expected the undeclared index removed", err)
+	}
+	check("age", "-2.5/fay", "32/eve", "38/ada", "50/gus", "true/bob")
+
+	if err := tree.DropIndex("value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.DropIndex("value"); err != ErrorIndexNotFound {
+		t.Fatal("Expected a dropped index gone", err)
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s/%s", indexDir(tree.CollectionName), "value")); !os.IsNotExist(err) {
+		t.Fatal("Expected the index files removed", err)
+	}
+
+	// SortedRecordSet and Erase replace the whole collection
+	err = tree.SortedRecordSet([]*Record{
+		{Key: []byte("a"), Value: []byte(`{"name": "A", "age": 1}`)},
+		{Key: []byte("b"), Value: []byte(`{"name": "B"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("age", "1/a")
+	check("name", "A/a", "B/b")
+
+	if err := tree.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	check("age")
+	check("name")
+
+	// A new tree of the same name has no indexes
+	tree, err = s.NewBTree(tree.CollectionName, 4, 32, 1024, 125, 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(indexDir(tree.CollectionName)); len(tree.Indexes()) != 0 || !os.IsNotExist(err) {
+		t.Fatal("Expected the indexes dropped", err)
+	}
+
+	s.PagerShutdown()
+}
+
+func mustParseWhere(t *testing.T, where string) SQLExpr {
+	t.Helper()
+	stmt, err := ParseSQL("SELECT * FROM t " + where)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stmt.Where
+}
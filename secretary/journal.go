@@ -0,0 +1,163 @@
+package secretary
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/codeharik/secretary/utils/binstruct"
+)
+
+/*
+Checkpoint Journal
+
+Checkpoint overwrites node pages of index.bin in place and only then moves
+the header forward. Before the first page is touched, every page image and
+the new header are written to SECRETARY/<collection>/journal.bin as a single
+checksummed frame and synced.
+
+A crash while the pages or the header are written leaves a complete journal,
+NewBTreeReadHeader writes its pages again before reading the header.
+A torn journal means index.bin was not touched yet, it is dropped and the
+WAL replays on top of the previous checkpoint.
+*/
+
+func journalPath(collectionName string) string {
+	return fmt.Sprintf("%s/%s/journal.bin", SECRETARY, collectionName)
+}
+
+func (tree *BTree) NewJournal() (*Journal, error) {
+	if MODE_WASM {
+		return nil, ErrorModeWASM
+	}
+
+	file, err := os.OpenFile(journalPath(tree.CollectionName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Journal{file: file}, nil
+}
+
+// Write replaces the journal with pages and syncs it
+func (journal *Journal) Write(pages []JournalPage) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	payload, err := binstruct.Serialize(pages)
+	if err != nil {
+		return err
+	}
+	frame := encodeFrame(payload)
+
+	if err := journal.file.Truncate(0); err != nil {
+		return err
+	}
+	n, err := journal.file.WriteAt(frame, 0)
+	if err != nil || n != len(frame) {
+		return ErrorWritingDataAtOffset(0, err)
+	}
+
+	return journal.file.Sync()
+}
+
+// Reset empties the journal once its pages are in index.bin
+func (journal *Journal) Reset() error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	if err := journal.file.Truncate(0); err != nil {
+		return err
+	}
+	return journal.file.Sync()
+}
+
+func (journal *Journal) Close() error {
+	return journal.file.Close()
+}
+
+// readJournalPages returns the pages of a complete journal, nil for an empty or torn one
+func readJournalPages(data []byte) []JournalPage {
+	payload := decodeFrame(data)
+	if payload == nil {
+		return nil
+	}
+
+	var pages []JournalPage
+	if err := binstruct.Deserialize(payload, &pages); err != nil {
+		return nil
+	}
+	return pages
+}
+
+// writeJournalPages copies page images to their offsets in index.bin
+func writeJournalPages(file *os.File, pages []JournalPage) error {
+	for _, page := range pages {
+		n, err := file.WriteAt(page.Data, int64(page.Offset))
+		if err != nil || n != len(page.Data) {
+			return ErrorWritingDataAtOffset(int64(page.Offset), err)
+		}
+	}
+	return file.Sync()
+}
+
+// Recover writes the pages of a complete journal to index.bin, empties it and returns the written pages
+func (journal *Journal) Recover(indexFile *os.File) ([]JournalPage, error) {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	return recoverJournalFile(journal.file, indexFile)
+}
+
+func recoverJournalFile(journalFile *os.File, indexFile *os.File) ([]JournalPage, error) {
+	stat, err := journalFile.Stat()
+	if err != nil {
+		return nil, ErrorFileStat(err)
+	}
+	if stat.Size() == 0 {
+		return nil, nil
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(journalFile, 0, stat.Size()))
+	if err != nil {
+		return nil, ErrorReadingDataAtOffset(0, err)
+	}
+
+	// A torn journal is dropped, index.bin was not touched yet
+	pages := readJournalPages(data)
+	if len(pages) > 0 {
+		if err := writeJournalPages(indexFile, pages); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := journalFile.Truncate(0); err != nil {
+		return nil, err
+	}
+	return pages, journalFile.Sync()
+}
+
+// recoverJournal finishes the checkpoint of a collection that crashed while writing index.bin
+func recoverJournal(collectionName string) error {
+	if MODE_WASM {
+		return ErrorModeWASM
+	}
+
+	journalFile, err := os.OpenFile(journalPath(collectionName), os.O_RDWR, 0o644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer journalFile.Close()
+
+	indexFile, err := os.OpenFile(fmt.Sprintf("%s/%s/index.bin", SECRETARY, collectionName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer indexFile.Close()
+
+	_, err = recoverJournalFile(journalFile, indexFile)
+	return err
+}
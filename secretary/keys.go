@@ -0,0 +1,364 @@
+package secretary
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+/*
+Keys
+
+Trees created before variable length keys have a MaxKeySize of 0 in their
+header, every key is KEY_SIZE bytes and nodes are serialized by binstruct
+with 16 byte key slots. They keep that layout.
+
+Every other tree takes keys of 1 to MaxKeySize bytes. Their node pages
+start with NODE_PAGE_PREFIX_KEYS, binstruct pages start with the high byte
+of Index which is always 0, and store the prefix all keys of the node share
+once :
+
+	Format				(uint8) NODE_PAGE_PREFIX_KEYS
+	Index				(uint64)
+	ParentIndex			(uint64)
+	NextIndex			(uint64)
+	PrevIndex			(uint64)
+	NodeID				(uint64)
+	Version				(uint64)
+	IsLeaf				(uint8)
+	NumKeyLocations		(uint16)
+	NumKeys				(uint16)
+	PrefixLength		(uint16)
+	Prefix
+	KeyLocations		(uint64 each)
+	Keys				(uint16 suffix length and suffix each)
+
+Nodes keep full keys in memory, the prefix is only split off in the page.
+
+Composite keys are built with KeyEncoder, the byte order of the encoded
+keys is the order of their parts.
+*/
+
+const (
+	NODE_PAGE_PREFIX_KEYS = 0xFF
+	NODE_PAGE_HEADER      = 1 + 6*8 + 1 + 3*2 // Up to the prefix
+	NODE_PAGE_KEY_LENGTH  = 2                 // Length of each key suffix
+)
+
+// checkKey validates the size of key for the tree
+func (tree *BTree) checkKey(key []byte) error {
+	if tree.MaxKeySize == 0 {
+		if len(key) != KEY_SIZE {
+			return ErrorInvalidKey
+		}
+		return nil
+	}
+
+	if len(key) == 0 || len(key) > int(tree.MaxKeySize) {
+		return ErrorInvalidKey
+	}
+	return nil
+}
+
+// maxKey returns the largest key the tree can hold
+func (tree *BTree) maxKey() []byte {
+	if tree.MaxKeySize == 0 {
+		return bytes.Repeat([]byte{0xFF}, KEY_SIZE)
+	}
+	return bytes.Repeat([]byte{0xFF}, int(tree.MaxKeySize))
+}
+
+// nodeBytes serializes node in the page layout of the tree
+func (tree *BTree) nodeBytes(node *Node) ([]byte, error) {
+	if tree.MaxKeySize == 0 {
+		return node.ToBytes()
+	}
+	return node.prefixKeysBytes(), nil
+}
+
+// commonPrefix returns the prefix all keys share
+func commonPrefix(keys [][]byte) []byte {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	prefix := keys[0]
+	for _, key := range keys[1:] {
+		n := 0
+		for n < len(prefix) && n < len(key) && prefix[n] == key[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return prefix
+}
+
+// prefixKeysBytes serializes node with the common prefix of its keys stored once
+func (node *Node) prefixKeysBytes() []byte {
+	prefix := commonPrefix(node.Keys)
+
+	data := make([]byte, NODE_PAGE_HEADER, NODE_PAGE_HEADER+len(prefix)+len(node.KeyLocation)*KEY_OFFSET_SIZE)
+	data[0] = NODE_PAGE_PREFIX_KEYS
+	binary.BigEndian.PutUint64(data[1:9], node.Index)
+	binary.BigEndian.PutUint64(data[9:17], node.ParentIndex)
+	binary.BigEndian.PutUint64(data[17:25], node.NextIndex)
+	binary.BigEndian.PutUint64(data[25:33], node.PrevIndex)
+	binary.BigEndian.PutUint64(data[33:41], node.NodeID)
+	binary.BigEndian.PutUint64(data[41:49], node.Version)
+	data[49] = node.IsLeaf
+	binary.BigEndian.PutUint16(data[50:52], uint16(len(node.KeyLocation)))
+	binary.BigEndian.PutUint16(data[52:54], uint16(len(node.Keys)))
+	binary.BigEndian.PutUint16(data[54:56], uint16(len(prefix)))
+
+	data = append(data, prefix...)
+	for _, location := range node.KeyLocation {
+		data = binary.BigEndian.AppendUint64(data, location)
+	}
+	for _, key := range node.Keys {
+		data = binary.BigEndian.AppendUint16(data, uint16(len(key)-len(prefix)))
+		data = append(data, key[len(prefix):]...)
+	}
+
+	return data
+}
+
+// fromPrefixKeysBytes reads a page written by prefixKeysBytes
+func (node *Node) fromPrefixKeysBytes(data []byte) error {
+	if len(data) < NODE_PAGE_HEADER {
+		return ErrorInvalidNodePage
+	}
+
+	node.Index = binary.BigEndian.Uint64(data[1:9])
+	node.ParentIndex = binary.BigEndian.Uint64(data[9:17])
+	node.NextIndex = binary.BigEndian.Uint64(data[17:25])
+	node.PrevIndex = binary.BigEndian.Uint64(data[25:33])
+	node.NodeID = binary.BigEndian.Uint64(data[33:41])
+	node.Version = binary.BigEndian.Uint64(data[41:49])
+	node.IsLeaf = data[49]
+	numKeyLocations := int(binary.BigEndian.Uint16(data[50:52]))
+	numKeys := int(binary.BigEndian.Uint16(data[52:54]))
+	prefixLength := int(binary.BigEndian.Uint16(data[54:56]))
+
+	offset := NODE_PAGE_HEADER
+	if offset+prefixLength+numKeyLocations*KEY_OFFSET_SIZE > len(data) {
+		return ErrorInvalidNodePage
+	}
+	prefix := data[offset : offset+prefixLength]
+	offset += prefixLength
+
+	node.KeyLocation = make([]uint64, numKeyLocations)
+	for i := range node.KeyLocation {
+		node.KeyLocation[i] = binary.BigEndian.Uint64(data[offset : offset+KEY_OFFSET_SIZE])
+		offset += KEY_OFFSET_SIZE
+	}
+
+	node.Keys = make([][]byte, numKeys)
+	for i := range node.Keys {
+		if offset+NODE_PAGE_KEY_LENGTH > len(data) {
+			return ErrorInvalidNodePage
+		}
+		length := int(binary.BigEndian.Uint16(data[offset : offset+NODE_PAGE_KEY_LENGTH]))
+		offset += NODE_PAGE_KEY_LENGTH
+		if offset+length > len(data) {
+			return ErrorInvalidNodePage
+		}
+
+		key := make([]byte, 0, prefixLength+length)
+		key = append(key, prefix...)
+		node.Keys[i] = append(key, data[offset:offset+length]...)
+		offset += length
+	}
+
+	return nil
+}
+
+//------------------------------------------------------------------
+// Composite Keys
+//------------------------------------------------------------------
+
+// Type tags of the key parts, parts of different types order by their tag
+const (
+	KEY_PART_INT64 byte = iota + 1
+	KEY_PART_UINT64
+	KEY_PART_STRING
+	KEY_PART_BYTES
+	KEY_PART_TIME
+	KEY_PART_FLOAT64
+	KEY_PART_BOOL
+)
+
+// Strings and bytes escape 0x00 as 0x00 0xFF and end with 0x00 0x01, a prefix orders before longer values
+const (
+	KEY_ESCAPE     = 0x00
+	KEY_ESCAPED_00 = 0xFF
+	KEY_TERMINATOR = 0x01
+)
+
+// KeyEncoder builds an order-preserving composite key from typed parts
+type KeyEncoder struct {
+	key []byte
+}
+
+func NewKeyEncoder() *KeyEncoder {
+	return &KeyEncoder{key: []byte{}}
+}
+
+// Int64 appends v, negative values order before positive ones
+func (encoder *KeyEncoder) Int64(v int64) *KeyEncoder {
+	encoder.key = append(encoder.key, KEY_PART_INT64)
+	encoder.key = binary.BigEndian.AppendUint64(encoder.key, uint64(v)^(1<<63))
+	return encoder
+}
+
+func (encoder *KeyEncoder) Uint64(v uint64) *KeyEncoder {
+	encoder.key = append(encoder.key, KEY_PART_UINT64)
+	encoder.key = binary.BigEndian.AppendUint64(encoder.key, v)
+	return encoder
+}
+
+func (encoder *KeyEncoder) String(v string) *KeyEncoder {
+	encoder.key = append(encoder.key, KEY_PART_STRING)
+	encoder.key = appendEscaped(encoder.key, []byte(v))
+	return encoder
+}
+
+func (encoder *KeyEncoder) Bytes(v []byte) *KeyEncoder {
+	encoder.key = append(encoder.key, KEY_PART_BYTES)
+	encoder.key = appendEscaped(encoder.key, v)
+	return encoder
+}
+
+// Time appends v with nanosecond precision, as Int64 of its UnixNano which covers the years 1678 to 2262
+func (encoder *KeyEncoder) Time(v time.Time) *KeyEncoder {
+	encoder.key = append(encoder.key, KEY_PART_TIME)
+	encoder.key = binary.BigEndian.AppendUint64(encoder.key, uint64(v.UnixNano())^(1<<63))
+	return encoder
+}
+
+// Float64 appends v, -0 is stored as 0 so equal values encode the same
+func (encoder *KeyEncoder) Float64(v float64) *KeyEncoder {
+	if v == 0 {
+		v = 0
+	}
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits // Negative values order in reverse of their bits
+	} else {
+		bits |= 1 << 63
+	}
+	encoder.key = append(encoder.key, KEY_PART_FLOAT64)
+	encoder.key = binary.BigEndian.AppendUint64(encoder.key, bits)
+	return encoder
+}
+
+// Bool appends v, false orders before true
+func (encoder *KeyEncoder) Bool(v bool) *KeyEncoder {
+	b := byte(0)
+	if v {
+		b = 1
+	}
+	encoder.key = append(encoder.key, KEY_PART_BOOL, b)
+	return encoder
+}
+
+// Key returns the encoded key
+func (encoder *KeyEncoder) Key() []byte {
+	return bytes.Clone(encoder.key)
+}
+
+func appendEscaped(key []byte, v []byte) []byte {
+	for _, b := range v {
+		if b == KEY_ESCAPE {
+			key = append(key, KEY_ESCAPE, KEY_ESCAPED_00)
+		} else {
+			key = append(key, b)
+		}
+	}
+	return append(key, KEY_ESCAPE, KEY_TERMINATOR)
+}
+
+// DecodeKey returns the parts of a key built by KeyEncoder as int64, uint64, string, []byte, time.Time, float64 and bool
+func DecodeKey(key []byte) ([]any, error) {
+	parts := []any{}
+
+	for len(key) > 0 {
+		tag := key[0]
+		key = key[1:]
+
+		switch tag {
+		case KEY_PART_INT64, KEY_PART_UINT64, KEY_PART_TIME, KEY_PART_FLOAT64:
+			if len(key) < 8 {
+				return nil, ErrorInvalidKeyEncoding
+			}
+			v := binary.BigEndian.Uint64(key[:8])
+			key = key[8:]
+
+			switch tag {
+			case KEY_PART_INT64:
+				parts = append(parts, int64(v^(1<<63)))
+			case KEY_PART_UINT64:
+				parts = append(parts, v)
+			case KEY_PART_FLOAT64:
+				if v&(1<<63) != 0 {
+					v &^= 1 << 63
+				} else {
+					v = ^v
+				}
+				parts = append(parts, math.Float64frombits(v))
+			default:
+				parts = append(parts, time.Unix(0, int64(v^(1<<63))))
+			}
+
+		case KEY_PART_BOOL:
+			if len(key) < 1 || key[0] > 1 {
+				return nil, ErrorInvalidKeyEncoding
+			}
+			parts = append(parts, key[0] == 1)
+			key = key[1:]
+
+		case KEY_PART_STRING, KEY_PART_BYTES:
+			value, rest, err := readEscaped(key)
+			if err != nil {
+				return nil, err
+			}
+			key = rest
+
+			if tag == KEY_PART_STRING {
+				parts = append(parts, string(value))
+			} else {
+				parts = append(parts, value)
+			}
+
+		default:
+			return nil, ErrorInvalidKeyEncoding
+		}
+	}
+
+	return parts, nil
+}
+
+// readEscaped reads an escaped part up to its terminator and returns the rest of the key
+func readEscaped(key []byte) ([]byte, []byte, error) {
+	value := []byte{}
+	for i := 0; i < len(key); i++ {
+		if key[i] != KEY_ESCAPE {
+			value = append(value, key[i])
+			continue
+		}
+		if i+1 == len(key) {
+			break
+		}
+
+		switch key[i+1] {
+		case KEY_TERMINATOR:
+			return value, key[i+2:], nil
+		case KEY_ESCAPED_00:
+			value = append(value, KEY_ESCAPE)
+			i++
+		default:
+			return nil, nil, ErrorInvalidKeyEncoding
+		}
+	}
+	return nil, nil, ErrorInvalidKeyEncoding
+}
@@ -0,0 +1,232 @@
+package secretary
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/codeharik/secretary/utils"
+)
+
+func TestKeyEncoderOrder(t *testing.T) {
+	now := time.Unix(1700000000, 123)
+
+	ordered := [][]byte{
+		NewKeyEncoder().Int64(-1 << 40).Key(),
+		NewKeyEncoder().Int64(-1).Key(),
+		NewKeyEncoder().Int64(0).Key(),
+		NewKeyEncoder().Int64(7).String("").Key(),
+		NewKeyEncoder().Int64(7).String("a").Key(),
+		NewKeyEncoder().Int64(7).String("a\x00").Key(),
+		NewKeyEncoder().Int64(7).String("a\x00b").Key(),
+		NewKeyEncoder().Int64(7).String("a\x01").Key(),
+		NewKeyEncoder().Int64(7).String("ab").Key(),
+		NewKeyEncoder().Int64(1 << 40).Key(),
+		NewKeyEncoder().Uint64(0).Key(),
+		NewKeyEncoder().Uint64(1 << 63).Key(),
+		NewKeyEncoder().String("user").Time(now.Add(-time.Hour)).Key(),
+		NewKeyEncoder().String("user").Time(now).Key(),
+		NewKeyEncoder().String("users").Key(),
+		NewKeyEncoder().Bytes([]byte{0, 0}).Key(),
+		NewKeyEncoder().Bytes([]byte{0, 0xFF}).Key(),
+		NewKeyEncoder().Float64(math.Inf(-1)).Key(),
+		NewKeyEncoder().Float64(-2.5).Key(),
+		NewKeyEncoder().Float64(-1e-300).Key(),
+		NewKeyEncoder().Float64(0).Key(),
+		NewKeyEncoder().Float64(1e-300).Key(),
+		NewKeyEncoder().Float64(3).Key(),
+		NewKeyEncoder().Float64(math.Inf(1)).Key(),
+		NewKeyEncoder().Bool(false).Key(),
+		NewKeyEncoder().Bool(true).Key(),
+	}
+
+	for i := 1; i < len(ordered); i++ {
+		if bytes.Compare(ordered[i-1], ordered[i]) >= 0 {
+			t.Fatalf("Expected key %d to order before key %d: %v %v", i-1, i, ordered[i-1], ordered[i])
+		}
+	}
+
+	parts, err := DecodeKey(NewKeyEncoder().Int64(-5).Uint64(5).String("a\x00b").Bytes([]byte{0, 1, 0xFF}).Time(now).Float64(-0.25).Bool(true).Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 7 || parts[0] != int64(-5) || parts[1] != uint64(5) || parts[2] != "a\x00b" ||
+		!bytes.Equal(parts[3].([]byte), []byte{0, 1, 0xFF}) || !parts[4].(time.Time).Equal(now) || parts[5] != -0.25 || parts[6] != true {
+		t.Fatal("Decoded parts mismatch", parts)
+	}
+	if !bytes.Equal(NewKeyEncoder().Float64(math.Copysign(0, -1)).Key(), NewKeyEncoder().Float64(0).Key()) {
+		t.Fatal("Expected -0 and 0 to encode the same")
+	}
+
+	for _, invalid := range [][]byte{{KEY_PART_INT64, 1, 2}, {KEY_PART_STRING, 'a'}, {KEY_PART_STRING, 0, 7}, {KEY_PART_BOOL, 2}, {0x42}} {
+		if _, err := DecodeKey(invalid); err != ErrorInvalidKeyEncoding {
+			t.Fatal("Expected an invalid encoding", invalid, err)
+		}
+	}
+}
+
+func TestKeysNodePage(t *testing.T) {
+	node := &Node{
+		Index:       3,
+		ParentIndex: 1,
+		NextIndex:   4,
+		PrevIndex:   2,
+		NodeID:      9,
+		Version:     5,
+		IsLeaf:      1,
+		Keys:        [][]byte{[]byte("user:alice"), []byte("user:bob"), []byte("user:carol")},
+		KeyLocation: []uint64{10, 11, 12},
+	}
+
+	data := node.prefixKeysBytes()
+	if !bytes.Contains(data, []byte("user:")) || bytes.Count(data, []byte("user:")) != 1 {
+		t.Fatal("Expected the common prefix to be stored once")
+	}
+
+	var read Node
+	if err := read.FromBytes(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read.Keys, node.Keys) || !reflect.DeepEqual(read.KeyLocation, node.KeyLocation) ||
+		read.Index != node.Index || read.NextIndex != node.NextIndex || read.Version != node.Version || read.IsLeaf != 1 {
+		t.Fatal("Node page mismatch", read.Keys, read.KeyLocation)
+	}
+
+	if err := read.FromBytes(data[:len(data)-1]); err != ErrorInvalidNodePage {
+		t.Fatal("Expected a truncated page to fail", err)
+	}
+}
+
+func TestKeysVariableLengthReload(t *testing.T) {
+	s := dummySecretary(t)
+	tree, err := s.NewBTree(utils.GenerateRandomString(16), 4, 32, 1024, 125, 20, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{}
+	var keys [][]byte
+	for i := 0; i < 150; i++ {
+		key := NewKeyEncoder().String(fmt.Sprint("tenant", i%3)).Int64(int64(i - 75)).Key()
+		if i%5 == 0 {
+			key = NewKeyEncoder().String(fmt.Sprint("tenant", i%3)).Key()[:2+i%7]
+		}
+		if _, ok := expected[string(key)]; ok {
+			continue
+		}
+		if _, err := tree.SetKV(key, []byte(fmt.Sprint("value", i))); err != nil {
+			t.Fatal(err)
+		}
+		expected[string(key)] = fmt.Sprint("value", i)
+		keys = append(keys, key)
+	}
+
+	for _, invalid := range [][]byte{{}, bytes.Repeat([]byte{1}, 41)} {
+		if _, err := tree.SetKV(invalid, []byte("value")); err != ErrorInvalidKey {
+			t.Fatal("Expected an invalid key", len(invalid), err)
+		}
+	}
+
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := dummySecretary(t)
+	reloaded, err := reopened.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.MaxKeySize != 40 {
+		t.Fatal("Expected the max key size in the header", reloaded.MaxKeySize)
+	}
+	verifyTreeRecords(t, reloaded, expected)
+
+	records, err := reloaded.RangeScan([]byte{0}, bytes.Repeat([]byte{0xFF}, 40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(keys) {
+		t.Fatalf("Expected %d records, got %d", len(keys), len(records))
+	}
+	for i := 1; i < len(records); i++ {
+		if bytes.Compare(records[i-1].Key, records[i].Key) >= 0 {
+			t.Fatal("Range scan out of order at", records[i].Key)
+		}
+	}
+
+	reopened.PagerShutdown()
+	s.PagerShutdown()
+}
+
+func TestKeysLegacyTree(t *testing.T) {
+	s := dummySecretary(t)
+
+	// Trees written by version 2 have no max key size
+	tree, err := newBTree(utils.GenerateRandomString(16), 4, 32, 1024, 125, 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddTree(tree)
+
+	expected := map[string]string{}
+	var keySeq uint64
+	for i := 0; i < 60; i++ {
+		key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+		if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = "value" + key
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.nodePager.WriteAt([]byte{2}, SECRETARY_HEADER_EXTENSION); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := dummySecretary(t)
+	reloaded, err := reopened.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.MaxKeySize != 0 || reloaded.nodeSize != tree.nodeSize {
+		t.Fatal("Expected the fixed key layout", reloaded.MaxKeySize, reloaded.nodeSize)
+	}
+	verifyTreeRecords(t, reloaded, expected)
+
+	if _, err := reloaded.SetKV([]byte("short"), []byte("value")); err != ErrorInvalidKey {
+		t.Fatal("Expected fixed size keys", err)
+	}
+	key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+	if _, err := reloaded.SetKV([]byte(key), []byte("value"+key)); err != nil {
+		t.Fatal(err)
+	}
+	expected[key] = "value" + key
+	if err := reloaded.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pages keep the binstruct layout
+	page, err := reloaded.nodePager.ReadAt(int64(reloaded.RootIndex)*reloaded.nodePager.itemSize+reloaded.nodePager.headerSize, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page[0] == NODE_PAGE_PREFIX_KEYS {
+		t.Fatal("Expected a binstruct page")
+	}
+
+	reopened.PagerShutdown()
+
+	again := dummySecretary(t)
+	reloaded, err = again.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTreeRecords(t, reloaded, expected)
+
+	again.PagerShutdown()
+	s.PagerShutdown()
+}
@@ -0,0 +1,404 @@
+package secretary
+
+/*
+Concurrency
+
+Every operation holds tree.mu, the tree latch. Get, RangeScan, SetKV, Update
+and Delete hold it shared and latch the nodes they visit with Node.mu,
+Checkpoint, eviction, compaction, Erase, SortedRecordSet, transactions and
+the WAL replay hold it exclusively and need no node latches.
+
+Readers couple read latches from the root to the leaf, a child is latched
+before its parent is released. tree.rootMu is the latch above the root, it
+is held until the root itself is latched.
+
+RangeScan reads one leaf at a time and releases every latch in between, so
+that a long scan does not hold off checkpoints. It remembers the leaf and
+its Version, every change of a node bumps its Version (see markDirty). The
+next step follows leaf.next only if the version is unchanged, otherwise it
+descends again from the last key it returned.
+
+A Cursor steps the same way in both directions. Going backwards couples a
+leaf to its previous leaf, right to left. Readers never wait for a latch
+while holding a write latch on a leaf, and writers only take the neighbours
+of a split with TryLock, so the two directions do not deadlock.
+
+Writers couple the same read latches and write latch the leaf. An insert
+that does not fill the leaf, an update, and a delete that keeps the minimum
+key count and the first key of its leaf only change the leaf.
+
+An insert that splits the leaf crabs down again with write latches, the
+latches above a node that is not full are released since the split stops
+there. rootMu stays held if the root splits. A split also relinks the right
+neighbour of every node it splits and moves half of the children of split
+internal nodes, these are taken with TryLock as they are not below the
+held path. If one is busy the insert falls back to the exclusive tree latch.
+
+A delete that underflows or removes the first key of its leaf borrows from
+or merges siblings and fixes the separators up to the root, it takes the
+tree latch exclusively.
+
+The latched paths load every node they change before the WAL entry is
+written, a load error returns before anything changed and applyLogged is
+not needed. Nodes shared by all operations (tree.nodes, dirtyNodes,
+numLoaded) are guarded by nodesMu, KeySeq and the WAL order by seqMu.
+*/
+
+// childFor returns the child of an internal node that covers key
+func (node *Node) childFor(key []byte) *Node {
+	index, found := node.getKey(key)
+	if found {
+		index++
+	}
+	return node.children[index]
+}
+
+// willSplit reports if one more key splits node
+func (tree *BTree) willSplit(node *Node) bool {
+	return len(node.Keys)+1 >= int(tree.Order)
+}
+
+// latchRoot read latches the root, nil for an empty tree
+func (tree *BTree) latchRoot() (*Node, error) {
+	tree.rootMu.RLock()
+	defer tree.rootMu.RUnlock()
+
+	root := tree.root
+	if root == nil {
+		return nil, nil
+	}
+	if err := tree.load(root); err != nil {
+		return nil, err
+	}
+	root.mu.RLock()
+
+	return root, nil
+}
+
+// readLeaf couples read latches down to the leaf of key and returns it read latched, nil for an empty tree
+func (tree *BTree) readLeaf(key []byte) (*Node, error) {
+	node, err := tree.latchRoot()
+	if node == nil || err != nil {
+		return nil, err
+	}
+
+	for len(node.children) > 0 {
+		child := node.childFor(key)
+		if err := tree.load(child); err != nil {
+			node.mu.RUnlock()
+			return nil, err
+		}
+		child.mu.RLock()
+		node.mu.RUnlock()
+		node = child
+	}
+
+	return node, nil
+}
+
+// readEdgeLeaf couples read latches down to the first or the last leaf and returns it read latched, nil for an empty tree
+func (tree *BTree) readEdgeLeaf(last bool) (*Node, error) {
+	node, err := tree.latchRoot()
+	if node == nil || err != nil {
+		return nil, err
+	}
+
+	for len(node.children) > 0 {
+		child := node.children[0]
+		if last {
+			child = node.children[len(node.children)-1]
+		}
+		if err := tree.load(child); err != nil {
+			node.mu.RUnlock()
+			return nil, err
+		}
+		child.mu.RLock()
+		node.mu.RUnlock()
+		node = child
+	}
+
+	return node, nil
+}
+
+// readNeighbourLeaf couples the read latch of the read latched leaf to its next or previous leaf, nil at the end
+func (tree *BTree) readNeighbourLeaf(leaf *Node, forward bool) (*Node, error) {
+	neighbour := leaf.next
+	if !forward {
+		neighbour = leaf.prev
+	}
+	if neighbour == nil {
+		leaf.mu.RUnlock()
+		return nil, nil
+	}
+	if err := tree.load(neighbour); err != nil {
+		leaf.mu.RUnlock()
+		return nil, err
+	}
+	neighbour.mu.RLock()
+	leaf.mu.RUnlock()
+
+	return neighbour, nil
+}
+
+// writeLeaf couples read latches down to the leaf of key and returns it write latched, nil for an empty tree.
+// The parent stays read latched while the leaf latch is upgraded, a split of the leaf would need it.
+func (tree *BTree) writeLeaf(key []byte) (*Node, error) {
+	tree.rootMu.RLock()
+	root := tree.root
+	if root == nil {
+		tree.rootMu.RUnlock()
+		return nil, nil
+	}
+	if err := tree.load(root); err != nil {
+		tree.rootMu.RUnlock()
+		return nil, err
+	}
+	root.mu.RLock()
+	if len(root.children) == 0 {
+		root.mu.RUnlock()
+		root.mu.Lock()
+		tree.rootMu.RUnlock()
+		return root, nil
+	}
+	tree.rootMu.RUnlock()
+
+	node := root
+	for {
+		child := node.childFor(key)
+		if err := tree.load(child); err != nil {
+			node.mu.RUnlock()
+			return nil, err
+		}
+
+		child.mu.RLock()
+		if len(child.children) == 0 {
+			child.mu.RUnlock()
+			child.mu.Lock()
+			node.mu.RUnlock()
+			return child, nil
+		}
+		node.mu.RUnlock()
+		node = child
+	}
+}
+
+// writeLatches are the nodes an insert holds write latched while it splits
+type writeLatches struct {
+	tree   *BTree
+	root   bool    // rootMu is held, the root splits
+	path   []*Node // From the deepest node that does not split down to the leaf
+	others []*Node // Neighbours and moved children
+}
+
+func (latches *writeLatches) releasePath() {
+	if latches.root {
+		latches.tree.rootMu.Unlock()
+		latches.root = false
+	}
+	for _, node := range latches.path {
+		node.mu.Unlock()
+	}
+	latches.path = nil
+}
+
+func (latches *writeLatches) release() {
+	latches.releasePath()
+	for _, node := range latches.others {
+		node.mu.Unlock()
+	}
+	latches.others = nil
+}
+
+// splitLatches try latches the nodes a split of the leaf changes beyond the path, false if one is busy
+func (latches *writeLatches) splitLatches() (bool, error) {
+	tree := latches.tree
+	path := latches.path
+
+	held := map[*Node]bool{}
+	for _, node := range path {
+		held[node] = true
+	}
+
+	needed := []*Node{path[len(path)-1].next}
+	for i, node := range path[:len(path)-1] {
+		// The top of the path only takes the promoted key, unless it is the root that splits
+		if i > 0 || latches.root {
+			needed = append(needed, node.next)
+			needed = append(needed, node.children...)
+		}
+	}
+
+	for _, node := range needed {
+		if node == nil || held[node] {
+			continue
+		}
+		if err := tree.load(node); err != nil {
+			return false, err
+		}
+		if !node.mu.TryLock() {
+			return false, nil
+		}
+		held[node] = true
+		latches.others = append(latches.others, node)
+	}
+
+	return true, nil
+}
+
+// insertLatched inserts key with latch crabbing, false if the tree latch has to be taken exclusively.
+// tree.mu is held shared.
+func (tree *BTree) insertLatched(key []byte, value []byte) (bool, error) {
+	latches := &writeLatches{tree: tree, root: true}
+	defer latches.release()
+
+	tree.rootMu.Lock()
+	node := tree.root
+	if node == nil {
+		return false, nil
+	}
+	if err := tree.load(node); err != nil {
+		return true, err
+	}
+	node.mu.Lock()
+	if !tree.willSplit(node) {
+		latches.releasePath()
+	}
+	latches.path = append(latches.path, node)
+
+	for len(node.children) > 0 {
+		child := node.childFor(key)
+		if err := tree.load(child); err != nil {
+			return true, err
+		}
+		child.mu.Lock()
+		if !tree.willSplit(child) {
+			latches.releasePath()
+		}
+		latches.path = append(latches.path, child)
+		node = child
+	}
+
+	leaf := node
+	if _, found := leaf.getKey(key); found {
+		return true, ErrorDuplicateKey
+	}
+
+	if tree.willSplit(leaf) {
+		ok, err := latches.splitLatches()
+		if !ok || err != nil {
+			return ok, err
+		}
+	}
+
+	if err := tree.logMutationSeq(WAL_SET, key, value, KEY_INCREMENT); err != nil {
+		return true, err
+	}
+	tree.insertIntoLeaf(leaf, key, value)
+
+	return true, nil
+}
+
+// setKVLatched inserts key into its leaf under node latches, false if the tree latch has to be taken exclusively
+func (tree *BTree) setKVLatched(key []byte, value []byte) (bool, error) {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	leaf, err := tree.writeLeaf(key)
+	if leaf == nil || err != nil {
+		return err != nil, err
+	}
+
+	if _, found := leaf.getKey(key); found {
+		leaf.mu.Unlock()
+		return true, ErrorDuplicateKey
+	}
+
+	if tree.willSplit(leaf) {
+		leaf.mu.Unlock()
+		return tree.insertLatched(key, value)
+	}
+	defer leaf.mu.Unlock()
+
+	if err := tree.logMutationSeq(WAL_SET, key, value, KEY_INCREMENT); err != nil {
+		return true, err
+	}
+	tree.insertIntoLeaf(leaf, key, value)
+
+	return true, nil
+}
+
+// updateLatched replaces the record of key under the latch of its leaf
+func (tree *BTree) updateLatched(key []byte, value []byte) error {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	leaf, err := tree.writeLeaf(key)
+	if err != nil {
+		return err
+	}
+	if leaf == nil {
+		return ErrorKeyNotFound
+	}
+	defer leaf.mu.Unlock()
+
+	keyIndex, found := leaf.getKey(key)
+	if !found {
+		return ErrorKeyNotFound
+	}
+
+	if err := tree.logMutation(WAL_UPDATE, key, value); err != nil {
+		return err
+	}
+	tree.updateInLeaf(leaf, keyIndex, value)
+
+	return nil
+}
+
+// deleteLatched removes key under the latch of its leaf, false if the leaf underflows or
+// loses its first key and the tree latch has to be taken exclusively
+func (tree *BTree) deleteLatched(key []byte) (bool, error) {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	leaf, err := tree.writeLeaf(key)
+	if leaf == nil || err != nil {
+		return err != nil, err
+	}
+	defer leaf.mu.Unlock()
+
+	keyIndex, found := leaf.getKey(key)
+	if !found {
+		return true, ErrorKeyNotFound
+	}
+	if leaf.parent != nil && (keyIndex == 0 || len(leaf.Keys)-1 < int(tree.minNumKeys)) {
+		return false, nil
+	}
+
+	if err := tree.logMutation(WAL_DELETE, key, nil); err != nil {
+		return true, err
+	}
+	tree.deleteFromLeaf(leaf, keyIndex)
+
+	return true, nil
+}
+
+// settle runs the checkpoint or eviction a shared operation left due, with the tree latch held exclusively
+func (tree *BTree) settle() {
+	// Exclusive holders change numLoaded without nodesMu
+	tree.mu.RLock()
+	tree.nodesMu.Lock()
+	evict := tree.nodePager != nil && tree.numLoaded > tree.maxLoadedNodes
+	tree.nodesMu.Unlock()
+	tree.mu.RUnlock()
+
+	checkpoint := tree.wal != nil && tree.wal.Size() >= WAL_CHECKPOINT_SIZE
+	if !evict && !checkpoint {
+		return
+	}
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	tree.maybeCheckpoint()
+	tree.evictNodes()
+}
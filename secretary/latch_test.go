@@ -0,0 +1,210 @@
+package secretary
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLatchConcurrentWritersAndScanners(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+	tree.maxLoadedNodes = 64
+
+	const numWriters = 8
+	const numKeys = 300
+
+	var wg sync.WaitGroup
+	expected := make([]map[string]string, numWriters)
+	errs := make(chan error, numWriters+4)
+
+	for w := 0; w < numWriters; w++ {
+		expected[w] = map[string]string{}
+
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			// Writers interleave in the key space and share leaves
+			keys := make([]string, numKeys)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("%0*d", KEY_SIZE, i*numWriters+w)
+			}
+
+			for i, key := range keys {
+				if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+					errs <- fmt.Errorf("set %s: %v", key, err)
+					return
+				}
+				expected[w][key] = "value" + key
+
+				if i%3 == 1 {
+					if err := tree.Update([]byte(keys[i-1]), []byte("updated"+keys[i-1])); err != nil {
+						errs <- fmt.Errorf("update %s: %v", keys[i-1], err)
+						return
+					}
+					expected[w][keys[i-1]] = "updated" + keys[i-1]
+				}
+				if i%4 == 3 {
+					if err := tree.Delete([]byte(keys[i-2])); err != nil {
+						errs <- fmt.Errorf("delete %s: %v", keys[i-2], err)
+						return
+					}
+					delete(expected[w], keys[i-2])
+				}
+				if record, err := tree.Get([]byte(key)); err != nil || string(record.Value) != expected[w][key] {
+					errs <- fmt.Errorf("get %s: %v", key, err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	stop := make(chan struct{})
+	var scanners sync.WaitGroup
+	for r := 0; r < 3; r++ {
+		scanners.Add(1)
+		go func() {
+			defer scanners.Done()
+
+			start := []byte(strings.Repeat("0", KEY_SIZE))
+			end := []byte(strings.Repeat("9", KEY_SIZE))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				records, err := tree.RangeScan(start, end)
+				if err != nil {
+					errs <- fmt.Errorf("range scan: %v", err)
+					return
+				}
+				for i, record := range records {
+					if i > 0 && bytes.Compare(records[i-1].Key, record.Key) >= 0 {
+						errs <- fmt.Errorf("range scan out of order at %s", record.Key)
+						return
+					}
+					if value := string(record.Value); value != "value"+string(record.Key) && value != "updated"+string(record.Key) {
+						errs <- fmt.Errorf("range scan read %s for %s", value, record.Key)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	// A cursor walks backwards against the latch order of the scans
+	scanners.Add(1)
+	go func() {
+		defer scanners.Done()
+
+		cursor := tree.Cursor()
+		defer cursor.Close()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			var last []byte
+			for ok := cursor.Last(); ok; ok = cursor.Prev() {
+				if last != nil && bytes.Compare(cursor.Key(), last) >= 0 {
+					errs <- fmt.Errorf("cursor out of order at %s", cursor.Key())
+					return
+				}
+				last = cursor.Key()
+			}
+			if err := cursor.Err(); err != nil {
+				errs <- fmt.Errorf("cursor: %v", err)
+				return
+			}
+		}
+	}()
+
+	// A snapshot reads the same records while the writers go on
+	scanners.Add(1)
+	go func() {
+		defer scanners.Done()
+
+		start := []byte(strings.Repeat("0", KEY_SIZE))
+		end := []byte(strings.Repeat("9", KEY_SIZE))
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			snapshot := tree.Snapshot()
+			first, err := snapshot.RangeScan(start, end)
+			if err != nil {
+				errs <- fmt.Errorf("snapshot scan: %v", err)
+				return
+			}
+			second, err := snapshot.RangeScan(start, end)
+			if err != nil {
+				errs <- fmt.Errorf("snapshot scan: %v", err)
+				return
+			}
+			snapshot.Release()
+
+			if len(first) != len(second) {
+				errs <- fmt.Errorf("snapshot scans read %d and %d records", len(first), len(second))
+				return
+			}
+			for i := range first {
+				if !bytes.Equal(first[i].Key, second[i].Key) || !bytes.Equal(first[i].Value, second[i].Value) {
+					errs <- fmt.Errorf("snapshot scans differ at %s", first[i].Key)
+					return
+				}
+			}
+		}
+	}()
+
+	scanners.Add(1)
+	go func() {
+		defer scanners.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := tree.Checkpoint(); err != nil {
+				errs <- fmt.Errorf("checkpoint: %v", err)
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	scanners.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	if errs := tree.TreeVerify(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	all := map[string]string{}
+	for _, writerExpected := range expected {
+		for key, value := range writerExpected {
+			all[key] = value
+		}
+	}
+	verifyTreeRecords(t, tree, all)
+
+	s.PagerShutdown()
+}
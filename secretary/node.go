@@ -3,11 +3,14 @@ package secretary
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"slices"
 	"sort"
 	"sync/atomic"
 
 	"github.com/codeharik/secretary/utils"
+	"github.com/codeharik/secretary/utils/binstruct"
 )
 
 //------------------------------------------------------------------
@@ -54,6 +57,9 @@ func (tree *BTree) NodeVerify(node *Node) error {
 	}
 
 	// Check Next and Prev Pointers
+	if err := errors.Join(tree.load(node.next), tree.load(node.prev)); err != nil {
+		return err
+	}
 	if node.next != nil && node.next.prev != node {
 		return ErrorNextNodeLink(node)
 	}
@@ -68,11 +74,17 @@ func (tree *BTree) NodeVerify(node *Node) error {
 		}
 
 		for i, child := range node.children {
+			if err := tree.load(child); err != nil {
+				return err
+			}
 			if child.parent != node {
 				return ErrorChildNotKnowParent(node, child)
 			}
 
-			minLeafKey, err := child.getMinLeafKey()
+			minLeafKey, err := tree.getMinLeafKey(child)
+			if err != nil && err != ErrorKeyNotFound {
+				return err
+			}
 
 			if i > 0 && err == nil && bytes.Compare(minLeafKey, node.Keys[i-1]) != 0 {
 				return ErrorNodeMinKeyMismatch
@@ -97,8 +109,8 @@ func (tree *BTree) NodeVerify(node *Node) error {
 
 	// Validate each key size and key offset
 	for i, el := range node.Keys {
-		if len(el) != KEY_SIZE {
-			return ErrorInvalidKey
+		if err := tree.checkKey(el); err != nil {
+			return err
 		}
 
 		// Are keys sorted
@@ -138,6 +150,9 @@ func (tree *BTree) recursiveNodeVerify(node *Node) []error {
 }
 
 func (tree *BTree) TreeVerify() []error {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
 	return tree.recursiveNodeVerify(tree.root)
 }
 
@@ -154,12 +169,15 @@ func (tree *BTree) createInternalNode(children []*Node) *Node {
 		children = make([]*Node, 0)
 	}
 
-	return &Node{
+	node := &Node{
 		Keys:     make([][]byte, 0),
 		children: children,
 
 		NodeID: tree.NodeSeq,
 	}
+	tree.markDirty(node)
+
+	return node
 }
 
 // Create a new leaf node
@@ -167,12 +185,15 @@ func (tree *BTree) createLeafNode() *Node {
 	atomic.AddUint64(&tree.NodeSeq, 1)
 	atomic.AddUint64(&tree.NumNodeSeq, 1)
 
-	return &Node{
+	node := &Node{
 		Keys:    make([][]byte, 0),
 		records: make([]*Record, 0),
 
 		NodeID: tree.NodeSeq,
 	}
+	tree.markDirty(node)
+
+	return node
 }
 
 //------------------------------------------------------------------
@@ -209,6 +230,7 @@ func (tree *BTree) promoteKey(left *Node, promotedKey []byte, right *Node) {
 		left.parent = newRoot
 		right.parent = newRoot
 		tree.root = newRoot
+		tree.markDirty(left, right)
 
 		return
 	}
@@ -229,12 +251,13 @@ func (tree *BTree) promoteKey(left *Node, promotedKey []byte, right *Node) {
 		)...)
 
 	right.parent = parent
+	tree.markDirty(parent, right)
 
 	if len(parent.Keys) >= int(tree.Order) {
 		tree.splitInternal(parent)
 	}
 
-	ServerLog("PromoteKey", string(promotedKey), "SetIdx", setIdx, "Parent", parent.ToString())
+	ServerLog("PromoteKey", string(promotedKey), "SetIdx", setIdx, "Parent", parent.NodeID)
 }
 
 // Split a leaf node and promote key
@@ -257,6 +280,8 @@ func (tree *BTree) splitLeaf(leaf *Node) {
 	 * +++++++++++  +++++++++++  +++++++++++
 	 */
 
+	tree.markDirty(leaf, leaf.next)
+
 	newLeaf.next = leaf.next
 	if leaf.next != nil {
 		leaf.next.prev = newLeaf
@@ -273,6 +298,9 @@ func (tree *BTree) splitLeaf(leaf *Node) {
 func (tree *BTree) splitInternal(node *Node) {
 	mid := len(node.Keys) / 2
 
+	tree.markDirty(node, node.next)
+	tree.markDirty(node.children[mid+1:]...)
+
 	newRightInternal := tree.createInternalNode(nil)
 	newRightInternal.Keys = append(newRightInternal.Keys, node.Keys[mid+1:]...)
 	newRightInternal.children = append(newRightInternal.children, node.children[mid+1:]...)
@@ -299,56 +327,147 @@ func (tree *BTree) splitInternal(node *Node) {
 
 func (tree *BTree) Set(value []byte) ([]byte, error) {
 	buf := make([]byte, 16)
-	binary.BigEndian.PutUint64(buf[8:], tree.KeySeq)
+	binary.BigEndian.PutUint64(buf[8:], atomic.LoadUint64(&tree.KeySeq))
 	return tree.SetKV(buf, value)
 }
 
 // SetKV a Record key-value pair into the B+ Tree
 func (tree *BTree) SetKV(key []byte, value []byte) ([]byte, error) {
-	if len(key) != KEY_SIZE {
-		return nil, ErrorInvalidKey
+	if err := tree.checkKey(key); err != nil {
+		return nil, err
+	}
+	value, err := tree.encodeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := tree.checkRecordSize(key, value); err != nil {
+		return nil, err
+	}
+	defer tree.settle()
+
+	err = tree.indexWrite(TxnOp{Op: WAL_SET, Key: key, Value: value}, func() error {
+		return tree.insertKV(key, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// insertKV inserts a checked record, under node latches unless the tree latch is needed
+func (tree *BTree) insertKV(key []byte, value []byte) error {
+	if done, err := tree.setKVLatched(key, value); done {
+		return err
 	}
 
+	// Empty tree or a busy neighbour of a split
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	if tree.root != nil {
+		_, _, found, err := tree.getLeafNode(key)
+		if err != nil {
+			return err
+		}
+		if found {
+			return ErrorDuplicateKey
+		}
+	}
+
+	if err := tree.logMutation(WAL_SET, key, value); err != nil {
+		return err
+	}
+
+	err := tree.applyLogged(func() error {
+		_, err := tree.setKV(key, value)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	tree.maybeCheckpoint()
+
+	return nil
+}
+
+func (tree *BTree) setKV(key []byte, value []byte) ([]byte, error) {
 	if tree.root == nil {
 
 		atomic.AddUint64(&tree.KeySeq, KEY_INCREMENT)
 
 		tree.root = tree.createLeafNode()
-		tree.root.setLeafKV(key, value)
+		tree.insertIntoLeaf(tree.root, key, value)
 
 		return key, nil
 	}
 
-	leaf, index, found := tree.getLeafNode(key)
+	leaf, index, found, err := tree.getLeafNode(key)
+	if err != nil {
+		return nil, err
+	}
 	if found && bytes.Compare(leaf.Keys[index], key) == 0 {
 		return nil, ErrorDuplicateKey
 	}
 
 	atomic.AddUint64(&tree.KeySeq, KEY_INCREMENT)
 
+	tree.insertIntoLeaf(leaf, key, value)
+
+	return key, nil
+}
+
+// insertIntoLeaf sets key in the leaf it belongs to and splits the leaf once it is full
+func (tree *BTree) insertIntoLeaf(leaf *Node, key []byte, value []byte) {
+	tree.markDirty(leaf)
 	leaf.setLeafKV(key, value)
+	tree.keepVersion(key, nil)
 
 	if len(leaf.Keys) >= int(tree.Order) {
 		tree.splitLeaf(leaf)
 	}
-
-	return key, nil
 }
 
 // Update a key-value pair in the B+ Tree
 func (tree *BTree) Update(key []byte, value []byte) error {
-	if len(key) != KEY_SIZE {
-		return ErrorInvalidKey
+	if err := tree.checkKey(key); err != nil {
+		return err
 	}
+	value, err := tree.encodeValue(value)
+	if err != nil {
+		return err
+	}
+	if err := tree.checkRecordSize(key, value); err != nil {
+		return err
+	}
+	defer tree.settle()
 
-	leaf, keyIndex, found := tree.getLeafNode(key)
+	// An update never changes the structure, the leaf latch is enough
+	return tree.indexWrite(TxnOp{Op: WAL_UPDATE, Key: key, Value: value}, func() error {
+		return tree.updateLatched(key, value)
+	})
+}
+
+func (tree *BTree) update(key []byte, value []byte) error {
+	leaf, keyIndex, found, err := tree.getLeafNode(key)
+	if err != nil {
+		return err
+	}
 	if found {
-		leaf.records[keyIndex].Value = value
+		tree.updateInLeaf(leaf, keyIndex, value)
 		return nil
 	}
 	return ErrorKeyNotFound
 }
 
+// updateInLeaf replaces the record at keyIndex, its stored slot is reused after the next checkpoint
+// unless a snapshot keeps it
+func (tree *BTree) updateInLeaf(leaf *Node, keyIndex int, value []byte) {
+	tree.keepVersion(leaf.Keys[keyIndex], leaf.records[keyIndex])
+	leaf.records[keyIndex] = &Record{Key: leaf.records[keyIndex].Key, Value: value}
+	tree.markDirty(leaf)
+}
+
 //------------------------------------------------------------------
 // Sorted Records Set
 //------------------------------------------------------------------
@@ -382,10 +501,92 @@ func equiDivision(len int, max int) []int {
 
 // SortedRecordSet: set sorted records into the B+ Tree efficiently
 func (tree *BTree) SortedRecordSet(sortedRecords []*Record) error {
+	indexes, unlock := tree.lockIndexes()
+	defer unlock()
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
 	if !areRecordsSorted(sortedRecords) || len(sortedRecords) == 0 {
 		return ErrorRecordsNotSorted
 	}
 
+	records := make([]Record, len(sortedRecords))
+	for i, r := range sortedRecords {
+		if err := tree.checkKey(r.Key); err != nil {
+			return err
+		}
+		value, err := tree.encodeValue(r.Value)
+		if err != nil {
+			return err
+		}
+		if err := tree.checkRecordSize(r.Key, value); err != nil {
+			return err
+		}
+		records[i] = Record{Key: r.Key, Value: value}
+	}
+	// The tree keeps the records it is given, encoded values go in new ones
+	if tree.encodesValues() {
+		sortedRecords = make([]*Record, len(records))
+		for i := range records {
+			sortedRecords[i] = &Record{Key: records[i].Key, Value: records[i].Value}
+		}
+	}
+	recordBytes, err := binstruct.Serialize(records)
+	if err != nil {
+		return err
+	}
+	if err := tree.keepAllVersions(); err != nil {
+		return err
+	}
+
+	// The entries of the new records are added first, the indexes are refilled once they replaced the tree
+	if !indexes.empty() {
+		changes := make([]indexChange, len(sortedRecords))
+		for i, record := range sortedRecords {
+			document, err := tree.decodeValue(record.Value)
+			if err != nil {
+				return err
+			}
+			changes[i] = indexChange{key: record.Key, new: document}
+		}
+		if _, err := beginIndexWrite(indexes, changes, true); err != nil {
+			return err
+		}
+	}
+
+	if err := tree.logMutation(WAL_SORTED_SET, nil, recordBytes); err != nil {
+		return err
+	}
+
+	if err := tree.sortedRecordSet(sortedRecords); err != nil {
+		return err
+	}
+	// Keys that were not in the previous tree are new to open snapshots
+	for _, record := range sortedRecords {
+		tree.keepVersion(record.Key, nil)
+	}
+
+	if err := tree.fillIndexes(indexes, sortedRecords); err != nil {
+		return err
+	}
+
+	tree.maybeCheckpoint()
+
+	return nil
+}
+
+func (tree *BTree) sortedRecordSet(sortedRecords []*Record) error {
+	if !areRecordsSorted(sortedRecords) || len(sortedRecords) == 0 {
+		return ErrorRecordsNotSorted
+	}
+
+	// The previous nodes are replaced as a whole
+	if err := tree.freeAllPages(); err != nil {
+		return err
+	}
+	tree.resetNodes()
+
 	leafNodes := tree.buildSortedLeafNodes(sortedRecords)
 	tree.root = tree.buildInternalNodes(leafNodes)
 
@@ -408,7 +609,7 @@ func (tree *BTree) buildSortedLeafNodes(sortedRecords []*Record) []*Node {
 		leaf := tree.createLeafNode()
 		for j := start; j < end; j++ {
 			leaf.Keys = append(leaf.Keys, sortedRecords[j].Key)
-			leaf.records = append(leaf.records, sortedRecords[j])
+			leaf.records = append(leaf.records, &Record{Key: sortedRecords[j].Key, Value: sortedRecords[j].Value})
 		}
 
 		if len(leafNodes) > 0 {
@@ -445,7 +646,7 @@ func (tree *BTree) buildInternalNodes(children []*Node) *Node {
 		for i, child := range children[start:end] {
 			child.parent = node
 
-			minLeafKey, err := child.getMinLeafKey()
+			minLeafKey, err := tree.getMinLeafKey(child)
 			if err == nil && i != 0 {
 				node.Keys = append(node.Keys, minLeafKey)
 			}
@@ -477,22 +678,32 @@ func (n *Node) getKey(key []byte) (keyIndex int, keyFound bool) {
 }
 
 // Get left-most leaf node key
-func (node *Node) getMinLeafKey() ([]byte, error) {
+func (tree *BTree) getMinLeafKey(node *Node) ([]byte, error) {
+	if err := tree.load(node); err != nil {
+		return nil, err
+	}
 	if len(node.children) == 0 {
 		if len(node.Keys) > 0 {
 			return node.Keys[0], nil
 		}
 		return nil, ErrorKeyNotFound
 	}
-	return node.children[0].getMinLeafKey()
+	return tree.getMinLeafKey(node.children[0])
 }
 
 // Find the appropriate leaf node
-func (tree *BTree) getLeafNode(key []byte) (node *Node, keyIndex int, keyFound bool) {
+func (tree *BTree) getLeafNode(key []byte) (node *Node, keyIndex int, keyFound bool, err error) {
 	node = tree.root
 
 	// Traverse internal nodes
-	for len(node.children) > 0 {
+	for {
+		if err := tree.load(node); err != nil {
+			return nil, 0, false, err
+		}
+		if len(node.children) == 0 {
+			break
+		}
+
 		index, found := node.getKey(key)
 		if found {
 			node = node.children[index+1]
@@ -504,55 +715,125 @@ func (tree *BTree) getLeafNode(key []byte) (node *Node, keyIndex int, keyFound b
 	// Search within the leaf node
 	keyIndex, keyFound = node.getKey(key)
 
-	return node, keyIndex, keyFound
+	return node, keyIndex, keyFound, nil
 }
 
 // Get record using key
 func (tree *BTree) Get(key []byte) (*Record, error) {
-	node, keyIndex, found := tree.getLeafNode(key)
+	defer tree.settle()
+
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	leaf, err := tree.readLeaf(key)
+	if err != nil {
+		return nil, err
+	}
+	if leaf == nil {
+		return nil, ErrorKeyNotFound
+	}
+	defer leaf.mu.RUnlock()
+
+	keyIndex, found := leaf.getKey(key)
 	if found {
-		return node.records[keyIndex], nil
+		return tree.readValue(leaf.records[keyIndex])
 	}
 	return nil, ErrorKeyNotFound
 }
 
 // RangeScan retrieves all records in the range [startKey, endKey].
-func (tree *BTree) RangeScan(startKey, endKey []byte) []*Record {
-	if tree == nil || tree.root == nil {
-		return nil
+func (tree *BTree) RangeScan(startKey, endKey []byte) ([]*Record, error) {
+	if tree == nil {
+		return nil, nil
 	}
+	defer tree.settle()
 
 	var results []*Record
 
-	startNode, startIndex, _ := tree.getLeafNode(startKey)
-	endNode, endIndex, endFound := tree.getLeafNode(endKey)
-
-	// Iterate over nodes
-	for node := startNode; node != nil; node = node.next {
-		// Determine the range of indices to iterate over
-		start := startIndex
-		end := len(node.records)
-		if node == endNode {
-			end = endIndex // Include records up to endIndex
-			if endFound {
-				end = endIndex + 1
-			}
+	scan := &rangeScan{startKey: startKey, endKey: endKey}
+	for !scan.done {
+		records, err := tree.scanLeaf(scan)
+		if err != nil {
+			return nil, err
 		}
+		results = append(results, records...)
+	}
 
-		// Iterate over records within the node
-		for i := start; i < end; i++ {
-			record := node.records[i]
-			results = append(results, record)
-		}
+	return results, nil
+}
+
+// rangeScan is the position of a RangeScan between two leaves
+type rangeScan struct {
+	startKey []byte
+	endKey   []byte
 
-		// Reset startIndex for the next node
-		startIndex = 0
-		if node == endNode {
+	leaf    *Node  // Last leaf read
+	version uint64 // Version of leaf when it was read
+	lastKey []byte // Last key returned
+
+	done bool
+}
+
+// scanLeaf returns the records of the next leaf in the range, see latch.go
+func (tree *BTree) scanLeaf(scan *rangeScan) ([]*Record, error) {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	leaf, err := tree.nextScanLeaf(scan)
+	if err != nil || leaf == nil {
+		scan.done = true
+		return nil, err
+	}
+	defer leaf.mu.RUnlock()
+
+	var records []*Record
+	for i, key := range leaf.Keys {
+		if bytes.Compare(key, scan.startKey) < 0 || (scan.lastKey != nil && bytes.Compare(key, scan.lastKey) <= 0) {
+			continue
+		}
+		if bytes.Compare(key, scan.endKey) > 0 {
+			scan.done = true
 			break
 		}
+
+		record, err := tree.readValue(leaf.records[i])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+		scan.lastKey = key
 	}
 
-	return results
+	scan.leaf = leaf
+	scan.version = leaf.Version
+	if leaf.next == nil {
+		scan.done = true
+	}
+
+	return records, nil
+}
+
+// nextScanLeaf read latches the leaf after scan.leaf, or the leaf to continue from if it changed
+func (tree *BTree) nextScanLeaf(scan *rangeScan) (*Node, error) {
+	if scan.leaf == nil {
+		return tree.readLeaf(scan.startKey)
+	}
+
+	leaf := scan.leaf
+	if err := tree.load(leaf); err != nil {
+		return nil, err
+	}
+	leaf.mu.RLock()
+
+	if leaf.Version != scan.version {
+		leaf.mu.RUnlock()
+		if scan.lastKey == nil {
+			return tree.readLeaf(scan.startKey)
+		}
+		return tree.readLeaf(scan.lastKey)
+	}
+
+	return tree.readNeighbourLeaf(leaf, true)
 }
 
 //------------------------------------------------------------------
@@ -561,11 +842,60 @@ func (tree *BTree) RangeScan(startKey, endKey []byte) []*Record {
 
 // Delete deletes a key from the B+ Tree.
 func (tree *BTree) Delete(key []byte) error {
-	if tree == nil || tree.root == nil {
+	if tree == nil {
+		return ErrorTreeNotFound
+	}
+	defer tree.settle()
+
+	return tree.indexWrite(TxnOp{Op: WAL_DELETE, Key: key}, func() error {
+		return tree.deleteKV(key)
+	})
+}
+
+// deleteKV removes key, under node latches unless the tree latch is needed
+func (tree *BTree) deleteKV(key []byte) error {
+	if done, err := tree.deleteLatched(key); done {
+		return err
+	}
+
+	// The leaf underflows or loses its first key
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	if tree.root == nil {
+		return ErrorTreeNotFound
+	}
+
+	_, _, found, err := tree.getLeafNode(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrorKeyNotFound
+	}
+
+	if err := tree.logMutation(WAL_DELETE, key, nil); err != nil {
+		return err
+	}
+
+	if err := tree.applyLogged(func() error { return tree.delete(key) }); err != nil {
+		return err
+	}
+
+	tree.maybeCheckpoint()
+
+	return nil
+}
+
+func (tree *BTree) delete(key []byte) error {
+	if tree.root == nil {
 		return ErrorTreeNotFound
 	}
 
-	leaf, index, found := tree.getLeafNode(key)
+	leaf, index, found, err := tree.getLeafNode(key)
+	if err != nil {
+		return err
+	}
 
 	if !found {
 		return ErrorKeyNotFound
@@ -581,9 +911,7 @@ func (tree *BTree) Delete(key []byte) error {
 	// 	leaf.parent.Keys[pi] = leaf.Keys[index+1]
 	// }
 
-	// Remove the key and corresponding record
-	leaf.Keys = append(leaf.Keys[:index], leaf.Keys[index+1:]...)
-	leaf.records = append(leaf.records[:index], leaf.records[index+1:]...)
+	tree.deleteFromLeaf(leaf, index)
 
 	ServerLog("key", string(key), "leaf", leaf.NodeID, "index", index, "found", found)
 
@@ -594,6 +922,14 @@ func (tree *BTree) Delete(key []byte) error {
 	return nil
 }
 
+// deleteFromLeaf removes the key and corresponding record
+func (tree *BTree) deleteFromLeaf(leaf *Node, index int) {
+	tree.markDirty(leaf)
+	tree.keepVersion(leaf.Keys[index], leaf.records[index])
+	leaf.Keys = append(leaf.Keys[:index], leaf.Keys[index+1:]...)
+	leaf.records = append(leaf.records[:index], leaf.records[index+1:]...)
+}
+
 // handleUnderflow handles cases when a node has fewer than the required keys.
 func (tree *BTree) handleUnderflow(node *Node) {
 	minKeys := (int(tree.Order) - 1) / 2 // Minimum required keys
@@ -606,8 +942,10 @@ func (tree *BTree) handleUnderflow(node *Node) {
 	// Check if the node is the root
 	if node == tree.root {
 		if len(node.children) == 1 { // If root has only one child, make it the new root
+			tree.markDirty(node.children[0])
 			tree.root = node.children[0]
 			tree.root.parent = nil
+			tree.releaseNode(node)
 		}
 		return
 	}
@@ -621,7 +959,7 @@ func (tree *BTree) handleUnderflow(node *Node) {
 
 	// Try to borrow from left sibling
 	if pos > 0 {
-		leftSibling := parent.children[pos-1]
+		leftSibling := tree.mustLoad(parent.children[pos-1])
 
 		ServerLog(
 			"Try to borrow from leftSibling", leftSibling.ToString(),
@@ -630,6 +968,8 @@ func (tree *BTree) handleUnderflow(node *Node) {
 		)
 
 		if len(leftSibling.Keys) > minKeys {
+			tree.markDirty(leftSibling, node, parent)
+
 			blen := len(leftSibling.Keys) - 1
 			borrowedKey := leftSibling.Keys[blen]
 			leftSibling.Keys = leftSibling.Keys[:blen]
@@ -646,6 +986,7 @@ func (tree *BTree) handleUnderflow(node *Node) {
 			} else {
 				clen := len(leftSibling.children) - 1
 				borrowedChild := leftSibling.children[clen]
+				tree.markDirty(borrowedChild)
 				leftSibling.children = leftSibling.children[:clen]
 				node.children = append([]*Node{borrowedChild}, node.children...)
 				borrowedChild.parent = node
@@ -663,7 +1004,7 @@ func (tree *BTree) handleUnderflow(node *Node) {
 
 	// Try to borrow from right sibling
 	if pos < len(parent.children)-1 {
-		rightSibling := parent.children[pos+1]
+		rightSibling := tree.mustLoad(parent.children[pos+1])
 
 		ServerLog(
 			"Try to borrow from rightSibling", rightSibling.ToString(),
@@ -672,6 +1013,8 @@ func (tree *BTree) handleUnderflow(node *Node) {
 		)
 
 		if len(rightSibling.Keys) > minKeys {
+			tree.markDirty(rightSibling, node, parent)
+
 			borrowedKey := rightSibling.Keys[0]
 			rightSibling.Keys = rightSibling.Keys[1:]
 
@@ -683,6 +1026,7 @@ func (tree *BTree) handleUnderflow(node *Node) {
 				node.records = append(node.records, borrowedRecord)
 			} else {
 				borrowedChild := rightSibling.children[0]
+				tree.markDirty(borrowedChild)
 				rightSibling.children = rightSibling.children[1:]
 				node.children = append(node.children, borrowedChild)
 				borrowedChild.parent = node
@@ -702,6 +1046,9 @@ func (tree *BTree) handleUnderflow(node *Node) {
 	if pos > 0 {
 		leftSibling := parent.children[pos-1]
 
+		tree.markDirty(leftSibling, parent, node.next)
+		tree.markDirty(node.children...)
+
 		leftSibling.Keys = append(leftSibling.Keys, node.Keys...)
 
 		if leftSibling.children == nil {
@@ -720,6 +1067,7 @@ func (tree *BTree) handleUnderflow(node *Node) {
 		if node.next != nil {
 			node.next.prev = leftSibling
 		}
+		tree.releaseNode(node)
 
 		tree.recursiveFixInternalNodeChildLinksAndMinKeys(leftSibling)
 		tree.handleUnderflow(parent)
@@ -734,6 +1082,9 @@ func (tree *BTree) handleUnderflow(node *Node) {
 	{
 		rightSibling := parent.children[pos+1]
 
+		tree.markDirty(node, parent, rightSibling, rightSibling.prev, rightSibling.next)
+		tree.markDirty(rightSibling.children...)
+
 		node.Keys = append(node.Keys, rightSibling.Keys...)
 
 		if rightSibling.children == nil {
@@ -751,6 +1102,7 @@ func (tree *BTree) handleUnderflow(node *Node) {
 		if rightSibling.next != nil {
 			rightSibling.next.prev = rightSibling.prev
 		}
+		tree.releaseNode(rightSibling)
 
 		tree.recursiveFixInternalNodeChildLinksAndMinKeys(node)
 		tree.handleUnderflow(parent)
@@ -769,14 +1121,24 @@ func (tree *BTree) handleUnderflow(node *Node) {
 
 func (tree *BTree) fixInternalNodeChildLinksAndMinKeys(node *Node) {
 	if node != nil && node.children != nil {
-		node.Keys = [][]byte{}
+		keys := [][]byte{}
 		for i, child := range node.children {
-			minLeafKey, err := child.getMinLeafKey()
+			minLeafKey, err := tree.getMinLeafKey(child)
+			if err != nil && err != ErrorKeyNotFound {
+				panic(loadPanic{err})
+			}
 			if i > 0 && err == nil {
-				node.Keys = append(node.Keys, minLeafKey)
+				keys = append(keys, minLeafKey)
+			}
+			if child.parent != node {
+				child.parent = node
+				tree.markDirty(child)
 			}
-			child.parent = node
 		}
+		if !slices.EqualFunc(keys, node.Keys, bytes.Equal) {
+			tree.markDirty(node)
+		}
+		node.Keys = keys
 	}
 }
 
@@ -806,9 +1168,12 @@ type NodeJSON struct {
 }
 
 // NodeToJSON recursively converts a Node into a JSON-friendly structure
-func (tree *BTree) NodeToJSON(node *Node, height int) NodeJSON {
+func (tree *BTree) NodeToJSON(node *Node, height int) (NodeJSON, error) {
 	if node == nil {
-		return NodeJSON{}
+		return NodeJSON{}, nil
+	}
+	if err := tree.load(node); err != nil {
+		return NodeJSON{}, err
 	}
 
 	keys := make([]string, len(node.Keys))
@@ -818,17 +1183,29 @@ func (tree *BTree) NodeToJSON(node *Node, height int) NodeJSON {
 		keys[i] = string(key)
 	}
 	for i, record := range node.records {
-		values[i] = string(record.Value)
+		stored, err := tree.readValue(record)
+		if err != nil {
+			return NodeJSON{}, err
+		}
+		values[i] = string(stored.Value)
 	}
 
 	var children []NodeJSON
 	if height != 1 {
 		children = make([]NodeJSON, len(node.children))
 		for i, child := range node.children {
-			children[i] = tree.NodeToJSON(child, height-1)
+			childJSON, err := tree.NodeToJSON(child, height-1)
+			if err != nil {
+				return NodeJSON{}, err
+			}
+			children[i] = childJSON
 		}
 	}
 
+	if err := errors.Join(tree.load(node.next), tree.load(node.prev)); err != nil {
+		return NodeJSON{}, err
+	}
+
 	nextId := uint64(0)
 	prevId := uint64(0)
 	parentId := uint64(0)
@@ -852,11 +1229,18 @@ func (tree *BTree) NodeToJSON(node *Node, height int) NodeJSON {
 		Children: children,
 
 		Errors: utils.ArrayToStrings(tree.recursiveNodeVerify(node)),
-	}
+	}, nil
 }
 
-func (tree *BTree) ToJSON() NodeJSON {
-	return tree.NodeToJSON(tree.root, tree.Height())
+func (tree *BTree) ToJSON() (NodeJSON, error) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	height, err := tree.Height()
+	if err != nil {
+		return NodeJSON{}, err
+	}
+	return tree.NodeToJSON(tree.root, height)
 }
 
 func (node *Node) ToString() string {
@@ -26,6 +26,7 @@ func dummyTree(t *testing.T, s *Secretary, order uint8) *BTree {
 		1024,
 		125,
 		20,
+		0,
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -216,7 +217,10 @@ func TestNodeGetLeafNode(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result, index, found := tree.getLeafNode(test.key)
+		result, index, found, err := tree.getLeafNode(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		if result.NodeID != test.expectedNode.NodeID || index != test.expectedIndex || found != test.expectedFound {
 			t.Fatalf("GetLeafNode(%q) returned wrong leaf node\n ExpNode:%d - Got:%d\n ExpID:%d - Got:%d\n ExpFound:%v - Got:%v\n %v",
@@ -231,14 +235,14 @@ func TestNodeGetLeafNode(t *testing.T) {
 
 	// Test empty tree
 	emptyTree := &BTree{root: &Node{}}
-	emptyNode, _, _ := emptyTree.getLeafNode([]byte("x"))
+	emptyNode, _, _, _ := emptyTree.getLeafNode([]byte("x"))
 	if emptyNode.NodeID != emptyTree.root.NodeID {
 		t.Fatalf("GetLeafNode on empty tree should return root")
 	}
 
 	// Test single-node tree
 	singleNodeTree := &BTree{root: &Node{Keys: [][]byte{[]byte("a"), []byte("b"), []byte("c")}}}
-	singleNode, _, _ := singleNodeTree.getLeafNode([]byte("b"))
+	singleNode, _, _, _ := singleNodeTree.getLeafNode([]byte("b"))
 	if singleNode.NodeID != singleNodeTree.root.NodeID {
 		t.Fatalf("GetLeafNode on single-node tree should return root")
 	}
@@ -379,7 +383,10 @@ func TestNodeRangeScan(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		results := tree.RangeScan(tt.startKey, tt.endKey)
+		results, err := tree.RangeScan(tt.startKey, tt.endKey)
+		if err != nil {
+			t.Fatal(err)
+		}
 		var resultKeys []string
 		for _, record := range results {
 			resultKeys = append(resultKeys, string(record.Value))
@@ -418,7 +425,10 @@ func TestNodeSortedRecordSet(t *testing.T) {
 			startKey := sortedRecords[0].Key
 			endKey := sortedRecords[len(sortedRecords)-1].Key
 
-			rangeScan := tree.RangeScan([]byte(startKey), []byte(endKey))
+			rangeScan, err := tree.RangeScan([]byte(startKey), []byte(endKey))
+			if err != nil {
+				t.Fatal(err)
+			}
 			if len(sortedRecords) != len(rangeScan) {
 				t.Fatal("Range should be equal", len(sortedRecords), len(rangeScan))
 			}
@@ -551,7 +561,10 @@ func TestNodeSplitInternal(t *testing.T) {
 	}
 
 	{
-		nodes := tree.GetFirstNodePerHeight()
+		nodes, err := tree.GetFirstNodePerHeight()
+		if err != nil {
+			t.Fatal(err)
+		}
 		expected := []uint64{21, 7, 2, 0}
 		if len(nodes) != len(expected) {
 			t.Fatalf("Expected %d nodes, got %d", len(expected), len(nodes))
@@ -564,7 +577,10 @@ func TestNodeSplitInternal(t *testing.T) {
 	}
 
 	{ // Perform batch traversal
-		compactBatch := tree.BFSCompactBatchTraversal()
+		compactBatch, err := tree.BFSCompactBatchTraversal()
+		if err != nil {
+			t.Fatal(err)
+		}
 		expected := []uint64{21, 7, 20, 34, 47, 2, 6, 11, 15, 19, 25, 29, 50, 33, 38, 42, 46, 0, 1, 3}
 		if len(compactBatch) != len(expected) {
 			t.Fatalf("Expected %d nodes, got %d", len(expected), len(compactBatch))
@@ -575,7 +591,10 @@ func TestNodeSplitInternal(t *testing.T) {
 			}
 		}
 
-		compactBatch = tree.BFSCompactBatchTraversal()
+		compactBatch, err = tree.BFSCompactBatchTraversal()
+		if err != nil {
+			t.Fatal(err)
+		}
 		expected = []uint64{4, 5, 8, 9, 10, 12, 13, 14, 16, 17, 18, 22, 23, 24, 26, 27, 28, 48, 49, 30}
 
 		if len(compactBatch) != len(expected) {
@@ -587,7 +606,10 @@ func TestNodeSplitInternal(t *testing.T) {
 			}
 		}
 
-		compactBatch = tree.BFSCompactBatchTraversal()
+		compactBatch, err = tree.BFSCompactBatchTraversal()
+		if err != nil {
+			t.Fatal(err)
+		}
 		expected = []uint64{31, 32, 35, 36, 37, 39, 40, 41, 43, 44, 45}
 
 		// utils.Log(utils.Map(compactBatch, func(s *Node) uint64 { return s.NodeID }))
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"slices"
 
 	"github.com/codeharik/secretary/utils"
 	"github.com/dgraph-io/ristretto/v2"
@@ -85,6 +86,15 @@ func NewPager[T PageItem[T]](tree *BTree, fileType string, level uint8) (*Pager[
 		path = fmt.Sprintf("SECRETARY/%s/%s.bin", tree.CollectionName, fileType)
 	}
 
+	return openPager[T](path, level, headerSize, itemSize)
+}
+
+// openPager opens or creates the file at path, pages of itemSize follow a header of headerSize
+func openPager[T PageItem[T]](path string, level uint8, headerSize int64, itemSize int64) (*Pager[T], error) {
+	if MODE_WASM {
+		return nil, ErrorModeWASM
+	}
+
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		file, err := os.Create(path)
 		if err != nil {
@@ -128,7 +138,10 @@ func NewPager[T PageItem[T]](tree *BTree, fileType string, level uint8) (*Pager[
 			MaxCost:     1 << 24, // 16MB total cache size
 			BufferItems: 64,      // Batch writes for performance
 			OnEvict: func(item *ristretto.Item[*Page[T]]) {
-				delete(pager.dirtyPages, item.Value.Index) // Mark page as clean
+				// Clear and Close evict items without value
+				if item.Value != nil {
+					delete(pager.dirtyPages, item.Value.Index) // Mark page as clean
+				}
 			},
 		})
 	if err != nil {
@@ -189,6 +202,69 @@ func (store *Pager[T]) NumPages() (int64, error) {
 	return (fileSize - store.headerSize) / store.itemSize, nil
 }
 
+// FreePage queues a page for reuse, the checkpoint on disk may still point to it
+func (store *Pager[T]) FreePage(index int64) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.pendingPages = append(store.pendingPages, index)
+}
+
+// ReusePage returns a page freed before the last checkpoint
+func (store *Pager[T]) ReusePage() (int64, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if len(store.freePages) == 0 {
+		return -1, false
+	}
+	index := store.freePages[len(store.freePages)-1]
+	store.freePages = store.freePages[:len(store.freePages)-1]
+	return index, true
+}
+
+// NextPage returns a reusable page, the file only grows once none is left
+func (store *Pager[T]) NextPage() (int64, error) {
+	if index, ok := store.ReusePage(); ok {
+		return index, nil
+	}
+	return store.NumPages()
+}
+
+// CommitFreePages makes the pages freed before a durable checkpoint reusable
+func (store *Pager[T]) CommitFreePages() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.freePages = append(store.freePages, store.pendingPages...)
+	store.pendingPages = nil
+}
+
+// FreePages returns the reusable and the pending pages
+func (store *Pager[T]) FreePages() []int64 {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return append(slices.Clone(store.freePages), store.pendingPages...)
+}
+
+// SetFreePages replaces the free pages with the ones saved by a checkpoint
+func (store *Pager[T]) SetFreePages(pages []int64) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.freePages = pages
+	store.pendingPages = nil
+}
+
+// FreeBytes is the size of every reusable and pending page
+func (store *Pager[T]) FreeBytes() uint64 {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return uint64(len(store.freePages)+len(store.pendingPages)) * uint64(store.itemSize)
+}
+
 /**
 * Header
 * |
@@ -215,9 +291,13 @@ func (store *Pager[T]) WriteAt(data []byte, offset int64) error {
 		return ErrorModeWASM
 	}
 
-	// Ensure data size does not exceed pageSize
-	if ((int64(len(data)) + offset - store.headerSize) / store.itemSize) !=
-		((offset - store.headerSize) / store.itemSize) {
+	// Ensure data size does not exceed pageSize, the last byte has to be in the page of the first
+	if offset < store.headerSize {
+		if offset+int64(len(data)) > store.headerSize {
+			return ErrorDataExceedPageSize(len(data), store.itemSize, offset)
+		}
+	} else if len(data) > 0 && ((int64(len(data))+offset-1-store.headerSize)/store.itemSize) !=
+		((offset-store.headerSize)/store.itemSize) {
 		return ErrorDataExceedPageSize(len(data), store.itemSize, offset)
 	}
 
@@ -228,7 +308,7 @@ func (store *Pager[T]) WriteAt(data []byte, offset int64) error {
 		}
 		fileSize := fileInfo.Size()
 
-		n := 1 + (offset+int64(len(data))-fileSize)/store.itemSize
+		n := (offset + int64(len(data)) - fileSize + store.itemSize - 1) / store.itemSize
 
 		// If the requested offset is beyond the current file size, allocate a new batch
 		if offset+int64(len(data)) > fileSize {
@@ -284,9 +364,9 @@ func (store *Pager[T]) ReadPage(index int64) (*Page[T], error) {
 	}
 
 	store.mu.Lock()
+	defer store.mu.Unlock()
 
 	// Check if page exists in Ristretto cache
-
 	if cachedPage, found := store.cache.Get(index); found {
 		return cachedPage, nil
 	}
@@ -294,15 +374,6 @@ func (store *Pager[T]) ReadPage(index int64) (*Page[T], error) {
 	var item T
 	page := item.NewPage(index) // Calls the NewPage method of PageItem[T]
 
-	// Store in Ristretto cache
-	store.cache.Set(index, page, store.itemSize)
-	store.cache.Wait()
-
-	store.mu.Unlock()
-
-	page.mu.Lock()
-	defer page.mu.Unlock()
-
 	data, err := store.ReadAt(index*store.itemSize+store.headerSize, int32(store.itemSize))
 	if err != nil {
 		return nil, err
@@ -313,6 +384,10 @@ func (store *Pager[T]) ReadPage(index int64) (*Page[T], error) {
 		return nil, err
 	}
 
+	// Store in Ristretto cache, only fully read pages are visible
+	store.cache.Set(index, page, store.itemSize)
+	store.cache.Wait()
+
 	return page, nil
 }
 
@@ -325,7 +400,37 @@ func (store *Pager[T]) WritePage(data T, index int64) error {
 	if err != nil {
 		return err
 	}
-	return store.WriteAt(rootHeader, index*store.itemSize+store.headerSize)
+
+	return store.WritePageBytes(rootHeader, index)
+}
+
+// WritePageBytes writes a serialized page, for items with more than one layout
+func (store *Pager[T]) WritePageBytes(data []byte, index int64) error {
+	if MODE_WASM {
+		return ErrorModeWASM
+	}
+
+	// Drop the stale cached copy
+	store.cache.Del(index)
+
+	return store.WriteAt(data, index*store.itemSize+store.headerSize)
+}
+
+// Truncate removes every page, keeping the header
+func (store *Pager[T]) Truncate() error {
+	if MODE_WASM {
+		return ErrorModeWASM
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.cache.Clear()
+	store.dirtyPages = map[int64]bool{}
+	store.freePages = nil
+	store.pendingPages = nil
+
+	return store.file.Truncate(store.headerSize)
 }
 
 // SyncPage writes a page to disk if it's dirty.
@@ -0,0 +1,569 @@
+package secretary
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+
+	"github.com/codeharik/secretary/utils"
+)
+
+/*
+Tree Persistence
+
+index.bin holds one fixed-size page per node, page 0 is reserved so that
+index 0 can mean "no node" in ParentIndex, NextIndex, PrevIndex and RootIndex.
+Internal nodes store their child page indexes in KeyLocation,
+leaves store the packed RecordLocation of every record.
+
+Values live in the record_<level>_<size>.bin files, each record goes to the
+smallest level whose item size (BaseSize * Increment^level) fits it. Once a
+record is written its leaf only keeps the key and the location, Get and
+RangeScan read the value back through the RecordPager of that level.
+
+Nodes are loaded lazily : reading a node creates stubs (Index only) for its
+children and siblings, a stub is read through NodePager.ReadPage the first
+time its fields are needed. A stub is never modified before it is loaded.
+Once more than maxLoadedNodes are loaded, evictNodes turns clean nodes
+without loaded children back into stubs between operations. The stubs
+themselves stay in tree.nodes, other nodes may still point to them.
+
+Mutations only change nodes in memory and mark them dirty, the WAL keeps
+them durable. Checkpoint writes the dirty records, journals the dirty node
+pages, the free list (see freelist.go) and the header with the last LSN it
+contains (see journal.go), writes them to index.bin and then empties the WAL.
+
+Reads return load errors. Mutations are logged before they are applied and
+split or merge nodes without an error path, a node that can not be loaded
+halfway is caught by applyLogged, which rebuilds the tree from index.bin
+and the WAL instead of keeping half changed nodes.
+*/
+
+const (
+	WAL_CHECKPOINT_SIZE = 1 << 20 // Checkpoint once the WAL grows beyond 1MB
+	MAX_LOADED_NODES    = 4096    // Loaded nodes kept in memory before clean ones are evicted
+	RECORD_HEADER_SIZE  = 8       // Bytes Record.ToBytes adds to the key and value
+)
+
+//------------------------------------------------------------------
+// Loading
+//------------------------------------------------------------------
+
+// nodeAt returns the node stored at index, creating a stub if it was not loaded yet
+func (tree *BTree) nodeAt(index uint64) *Node {
+	if index == 0 {
+		return nil
+	}
+	if node, ok := tree.nodes[index]; ok {
+		return node
+	}
+
+	node := &Node{Index: index, stub: true}
+	tree.nodes[index] = node
+	return node
+}
+
+// load reads a stub node from index.bin and links its children, siblings and records
+func (tree *BTree) load(node *Node) error {
+	if node == nil {
+		return nil
+	}
+
+	// Readers holding the tree latch shared load the same stubs
+	tree.nodesMu.Lock()
+	defer tree.nodesMu.Unlock()
+
+	if !node.stub {
+		return nil
+	}
+
+	page, err := tree.nodePager.ReadPage(int64(node.Index))
+	if err != nil {
+		return ErrorLoadNode(node.Index, err)
+	}
+	data := page.Data
+
+	node.Version = data.Version
+	node.NodeID = data.NodeID
+	node.ParentIndex = data.ParentIndex
+	node.NextIndex = data.NextIndex
+	node.PrevIndex = data.PrevIndex
+	node.IsLeaf = data.IsLeaf
+	node.Keys = append([][]byte{}, data.Keys...)
+	node.KeyLocation = append([]uint64{}, data.KeyLocation...)
+
+	if node.parent == nil {
+		node.parent = tree.nodeAt(node.ParentIndex)
+	}
+	node.next = tree.nodeAt(node.NextIndex)
+	node.prev = tree.nodeAt(node.PrevIndex)
+
+	if node.IsLeaf == 1 {
+		if len(node.KeyLocation) > len(node.Keys) {
+			return ErrorInvalidDataLocation
+		}
+		node.records = make([]*Record, len(node.KeyLocation))
+		for i, dataLocation := range node.KeyLocation {
+			location := ToRecordLocation(dataLocation)
+			node.records[i] = &Record{Key: node.Keys[i], location: &location}
+		}
+	} else {
+		node.children = make([]*Node, len(node.KeyLocation))
+		for i, childIndex := range node.KeyLocation {
+			child := tree.nodeAt(childIndex)
+			if child == nil {
+				return ErrorInvalidDataLocation
+			}
+			child.parent = node
+			node.children[i] = child
+		}
+	}
+
+	node.stub = false
+	tree.numLoaded++
+
+	return nil
+}
+
+// unload turns a clean node back into a stub, its parent link is kept
+func (tree *BTree) unload(node *Node) {
+	node.stub = true
+	node.Keys = nil
+	node.KeyLocation = nil
+	node.records = nil
+	node.children = nil
+	node.next = nil
+	node.prev = nil
+	tree.numLoaded--
+}
+
+// evictNodes unloads clean nodes once too many are loaded, it only runs between operations
+func (tree *BTree) evictNodes() {
+	if tree.nodePager == nil || tree.numLoaded <= tree.maxLoadedNodes {
+		return
+	}
+
+	// Loaded nodes keep a loaded parent, each pass frees the parents of the previous one
+	for unloaded := true; unloaded && tree.numLoaded > tree.maxLoadedNodes/2; {
+		unloaded = false
+
+		for _, node := range tree.nodes {
+			if tree.numLoaded <= tree.maxLoadedNodes/2 {
+				break
+			}
+			if node.stub || node == tree.root || node == tree.nextCompactionNode {
+				continue
+			}
+			if _, dirty := tree.dirtyNodes[node]; dirty {
+				continue
+			}
+			if slices.ContainsFunc(node.children, func(child *Node) bool { return !child.stub }) {
+				continue
+			}
+
+			tree.unload(node)
+			unloaded = true
+		}
+	}
+}
+
+// loadPanic carries a load error out of a mutation that has no error path
+type loadPanic struct {
+	err error
+}
+
+// mustLoad is load for mutations, the error is recovered by applyLogged
+func (tree *BTree) mustLoad(node *Node) *Node {
+	if err := tree.load(node); err != nil {
+		panic(loadPanic{err})
+	}
+	return node
+}
+
+// catchLoad runs apply and returns the load error raised by mustLoad, if any
+func catchLoad(apply func() error) (err error, loadFailed bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			p, ok := r.(loadPanic)
+			if !ok {
+				panic(r)
+			}
+			err, loadFailed = p.err, true
+		}
+	}()
+
+	return apply(), false
+}
+
+// applyLogged applies a mutation that is already in the WAL.
+// If a node can not be loaded halfway, the nodes in memory are dropped and rebuilt
+// from index.bin and the WAL, which applies the logged mutation once more.
+func (tree *BTree) applyLogged(apply func() error) error {
+	err, loadFailed := catchLoad(apply)
+	if !loadFailed {
+		return err
+	}
+
+	ServerLog("Reload", tree.CollectionName, err)
+
+	if reloadErr := tree.reload(); reloadErr != nil {
+		return errors.Join(err, reloadErr)
+	}
+	return nil
+}
+
+// reload drops every node in memory and rebuilds the tree from the last checkpoint and the WAL
+func (tree *BTree) reload() error {
+	if tree.nodePager == nil {
+		return ErrorModeWASM
+	}
+
+	if err := tree.readHeader(); err != nil {
+		return err
+	}
+	tree.nextCompactionNode = nil
+
+	if err := tree.readRoot(); err != nil {
+		return err
+	}
+	return tree.replayWAL()
+}
+
+// loadRoot sets the root from RootIndex, an empty tree gets an empty leaf
+func (tree *BTree) loadRoot() error {
+	if tree.RootIndex == 0 {
+		tree.root = &Node{}
+		return nil
+	}
+
+	root := tree.nodeAt(tree.RootIndex)
+	if err := tree.load(root); err != nil {
+		return err
+	}
+	root.parent = nil
+	tree.root = root
+
+	return nil
+}
+
+//------------------------------------------------------------------
+// Dirty Tracking
+//------------------------------------------------------------------
+
+// markDirty loads and queues nodes for the next checkpoint, their Version tells transactions they changed
+func (tree *BTree) markDirty(nodes ...*Node) {
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		if tree.nodePager != nil {
+			tree.mustLoad(node)
+			tree.nodesMu.Lock()
+			tree.dirtyNodes[node] = struct{}{}
+			tree.compaction.invalidate(node)
+			tree.nodesMu.Unlock()
+		}
+		node.Version++
+	}
+}
+
+// releaseNode forgets a node that was removed from the tree
+func (tree *BTree) releaseNode(node *Node) {
+	node.Version++
+	if tree.nodePager == nil {
+		return
+	}
+
+	delete(tree.dirtyNodes, node)
+	tree.compaction.release(node)
+	if node == tree.nextCompactionNode {
+		// The batch cursor starts over at the root, pages written before stay valid
+		tree.nextCompactionNode = nil
+	}
+	if node.Index != 0 {
+		if !node.stub {
+			tree.numLoaded--
+		}
+		delete(tree.nodes, node.Index)
+		tree.freeNodePage(node.Index)
+	}
+}
+
+func (tree *BTree) resetNodes() {
+	tree.abortCompaction()
+	tree.nodes = map[uint64]*Node{}
+	tree.dirtyNodes = map[*Node]struct{}{}
+	tree.numLoaded = 0
+}
+
+// addNode gives a node created in memory its page index
+func (tree *BTree) addNode(node *Node) {
+	node.Index = tree.allocateNodeIndex()
+	tree.nodes[node.Index] = node
+	tree.numLoaded++
+}
+
+// allocateNodeIndex reuses a free page of index.bin before appending one
+func (tree *BTree) allocateNodeIndex() uint64 {
+	if tree.nodePager != nil {
+		if index, ok := tree.nodePager.ReusePage(); ok {
+			tree.ReclaimableBytes -= uint64(tree.nodePager.itemSize)
+			return uint64(index)
+		}
+	}
+	return tree.appendNodeIndex()
+}
+
+func (tree *BTree) appendNodeIndex() uint64 {
+	if tree.nextNodeIndex == 0 {
+		tree.nextNodeIndex = 1
+	}
+	index := tree.nextNodeIndex
+	tree.nextNodeIndex++
+	return index
+}
+
+//------------------------------------------------------------------
+// Records
+//------------------------------------------------------------------
+
+// recordLevel returns the smallest record level whose item size fits size bytes
+func (tree *BTree) recordLevel(size int) (uint8, error) {
+	for level, pager := range tree.recordPagers {
+		if int64(size) <= pager.itemSize {
+			return uint8(level), nil
+		}
+	}
+	return 0, ErrorRecordTooLarge(size)
+}
+
+// checkRecordSize rejects records that no record level can hold, before they reach the WAL
+func (tree *BTree) checkRecordSize(key []byte, value []byte) error {
+	if tree.recordPagers == nil {
+		return nil
+	}
+
+	// Record.ToBytes writes a 4 byte length before Key and Value
+	_, err := tree.recordLevel(RECORD_HEADER_SIZE + len(key) + len(value))
+	return err
+}
+
+func (tree *BTree) writeRecord(record *Record) error {
+	data, err := record.ToBytes()
+	if err != nil {
+		return err
+	}
+
+	level, err := tree.recordLevel(len(data))
+	if err != nil {
+		return err
+	}
+	pager := tree.recordPagers[level]
+
+	index, err := pager.NextPage()
+	if err != nil {
+		return err
+	}
+
+	if err := pager.WritePage(record, index); err != nil {
+		return err
+	}
+
+	record.location = &RecordLocation{
+		batchLevel: level,
+		offset:     uint64(index * pager.itemSize),
+	}
+	tree.countReclaimableBytes()
+
+	return nil
+}
+
+func (tree *BTree) readRecord(dataLocation uint64) (*Record, error) {
+	location := ToRecordLocation(dataLocation)
+	if int(location.batchLevel) >= len(tree.recordPagers) {
+		return nil, ErrorInvalidDataLocation
+	}
+	pager := tree.recordPagers[location.batchLevel]
+
+	page, err := pager.ReadPage(int64(location.offset) / pager.itemSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Record{
+		Key:      page.Data.Key,
+		Value:    page.Data.Value,
+		location: &location,
+	}, nil
+}
+
+// readValue returns a copy of record with its value, stored records are read from their pager.
+// Values of a collection with a schema are decoded to their JSON document.
+func (tree *BTree) readValue(record *Record) (*Record, error) {
+	stored := &Record{Key: record.Key, Value: record.Value}
+	if record.location != nil {
+		var err error
+		if stored, err = tree.readRecord(record.location.ToDataLocation()); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(stored.Key, record.Key) {
+			return nil, ErrorInvalidDataLocation
+		}
+	}
+
+	value, err := tree.decodeValue(stored.Value)
+	if err != nil {
+		return nil, err
+	}
+	stored.Value = value
+	return stored, nil
+}
+
+//------------------------------------------------------------------
+// Checkpoint
+//------------------------------------------------------------------
+
+// Checkpoint writes dirty records and nodes, saves the header and empties the WAL
+func (tree *BTree) Checkpoint() error {
+	if MODE_WASM {
+		return ErrorModeWASM
+	}
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	return tree.checkpoint()
+}
+
+func (tree *BTree) checkpoint() error {
+
+	// A previous checkpoint that failed halfway is finished before its journal is replaced
+	recovered, err := tree.journal.Recover(tree.nodePager.file)
+	if err != nil {
+		return err
+	}
+	tree.dropCachedPages(recovered)
+
+	pages, err := tree.checkpointPages()
+	if err != nil {
+		return err
+	}
+
+	// index.bin is only overwritten once every page image is durable in the journal
+	if err := tree.journal.Write(pages); err != nil {
+		return err
+	}
+	if err := tree.writeCheckpointPages(pages); err != nil {
+		return err
+	}
+	if err := tree.journal.Reset(); err != nil {
+		return err
+	}
+
+	tree.dirtyNodes = map[*Node]struct{}{}
+	tree.commitFreePages()
+
+	if tree.wal != nil {
+		return tree.wal.Reset()
+	}
+	return nil
+}
+
+// checkpointPages writes dirty records and returns the page images of the dirty nodes, the header last
+func (tree *BTree) checkpointPages() ([]JournalPage, error) {
+	// Parents store the indexes of their children, allocate every new page first
+	for node := range tree.dirtyNodes {
+		if node.Index == 0 {
+			tree.addNode(node)
+		}
+	}
+
+	pages := make([]JournalPage, 0, len(tree.dirtyNodes)+1)
+
+	for node := range tree.dirtyNodes {
+		for _, record := range node.records {
+			if record.location == nil {
+				if err := tree.writeRecord(record); err != nil {
+					return nil, err
+				}
+				// The leaf only keeps the location, the value is read back on demand
+				record.Value = nil
+			}
+		}
+		if err := tree.linkIndexes(node, node.Index); err != nil {
+			return nil, err
+		}
+		data, err := tree.nodeBytes(node)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, JournalPage{
+			Offset: uint64(int64(node.Index)*tree.nodePager.itemSize + tree.nodePager.headerSize),
+			Data:   data,
+		})
+	}
+
+	tree.RootIndex = 0
+	if tree.root != nil {
+		tree.RootIndex = tree.root.Index
+	}
+	if tree.wal != nil {
+		tree.CheckpointLSN = tree.wal.lsn
+	}
+
+	// Records are written to unused slots, they only have to be durable before the journal
+	errs := []error{}
+	for _, pager := range tree.recordPagers {
+		errs = append(errs, pager.file.Sync())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	freeListPages, err := tree.freeListJournalPages()
+	if err != nil {
+		return nil, err
+	}
+	pages = append(pages, freeListPages...)
+
+	header, err := tree.headerBytes()
+	if err != nil {
+		return nil, err
+	}
+	pages = append(pages, JournalPage{Offset: 0, Data: header})
+
+	return pages, nil
+}
+
+// writeCheckpointPages overwrites the journaled pages in index.bin
+func (tree *BTree) writeCheckpointPages(pages []JournalPage) error {
+	for _, page := range pages {
+		if err := tree.nodePager.WriteAt(page.Data, int64(page.Offset)); err != nil {
+			return err
+		}
+	}
+	tree.dropCachedPages(pages)
+
+	return tree.nodePager.file.Sync()
+}
+
+// dropCachedPages removes the overwritten node pages from the pager cache
+func (tree *BTree) dropCachedPages(pages []JournalPage) {
+	for _, page := range pages {
+		if int64(page.Offset) >= tree.nodePager.headerSize {
+			tree.nodePager.cache.Del((int64(page.Offset) - tree.nodePager.headerSize) / tree.nodePager.itemSize)
+		}
+	}
+}
+
+// maybeCheckpoint runs a checkpoint once the WAL is large enough.
+// The write that triggered it is already durable in the WAL, so a failed checkpoint
+// is only logged, the nodes stay dirty and the next write or close retries it.
+func (tree *BTree) maybeCheckpoint() {
+	if tree.wal == nil || tree.wal.size < WAL_CHECKPOINT_SIZE {
+		return
+	}
+	if err := tree.checkpoint(); err != nil {
+		utils.Log("Checkpoint", tree.CollectionName, err)
+	}
+}
@@ -0,0 +1,428 @@
+package secretary
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/codeharik/secretary/utils"
+)
+
+func verifyTreeRecords(t *testing.T, tree *BTree, expected map[string]string) {
+	if errs := tree.TreeVerify(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	for key, value := range expected {
+		record, err := tree.Get([]byte(key))
+		if err != nil || string(record.Value) != value {
+			t.Fatal("Record not found", key, err)
+		}
+	}
+
+	records, err := tree.RangeScan(make([]byte, KEY_SIZE), bytes.Repeat([]byte{0xFF}, KEY_SIZE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(records))
+	}
+}
+
+func TestPersistReloadTree(t *testing.T) {
+	s := dummySecretary(t)
+
+	for _, order := range []uint8{3, 4, 7} {
+		tree := dummyTree(t, s, order)
+
+		expected := map[string]string{}
+
+		var keySeq uint64 = 0
+		var keys []string
+		for i := 0; i < 200; i++ {
+			key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+			keys = append(keys, key)
+		}
+		for _, key := range utils.Shuffle(keys) {
+			if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+				t.Fatal(err)
+			}
+			expected[key] = "value" + key
+		}
+
+		if err := tree.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, key := range utils.Shuffle(keys)[:120] {
+			if i%3 == 0 {
+				if err := tree.Update([]byte(key), []byte(fmt.Sprint("updated", i))); err != nil {
+					t.Fatal(err)
+				}
+				expected[key] = fmt.Sprint("updated", i)
+			} else {
+				if err := tree.Delete([]byte(key)); err != nil {
+					t.Fatal(err)
+				}
+				delete(expected, key)
+			}
+		}
+
+		if err := tree.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+
+		newSecretary := dummySecretary(t)
+		reloaded, err := newSecretary.Tree(tree.CollectionName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Only the root is read on startup
+		if len(reloaded.nodes) > 1+int(order)*3 {
+			t.Fatalf("Expected lazy loading, %d nodes loaded", len(reloaded.nodes))
+		}
+
+		verifyTreeRecords(t, reloaded, expected)
+
+		if reloaded.KeySeq != tree.KeySeq || reloaded.NumNodeSeq != tree.NumNodeSeq {
+			t.Fatalf("Header mismatch %+v %+v", reloaded, tree)
+		}
+
+		// Writes continue on the reloaded tree
+		key := []byte(utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT))
+		if _, err := reloaded.SetKV(key, key); err != nil {
+			t.Fatal(err)
+		}
+		expected[string(key)] = string(key)
+
+		newSecretary.PagerShutdown()
+
+		reopened := dummySecretary(t)
+		reloaded, err = reopened.Tree(tree.CollectionName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyTreeRecords(t, reloaded, expected)
+
+		reopened.PagerShutdown()
+	}
+
+	s.PagerShutdown()
+}
+
+func TestPersistCheckpointWithWAL(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 5)
+
+	expected := map[string]string{}
+	for _, r := range SampleSortedKeyRecords(100) {
+		expected[string(r.Key)] = string(r.Value)
+	}
+	if err := tree.SortedRecordSet(SampleSortedKeyRecords(100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.wal.size != 0 {
+		t.Fatalf("Expected empty WAL after checkpoint, got %d", tree.wal.size)
+	}
+
+	// Not checkpointed, only in the WAL
+	var keySeq uint64 = 10000
+	for i := 0; i < 30; i++ {
+		key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+		if _, err := tree.SetKV([]byte(key), []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = key
+	}
+
+	newSecretary := dummySecretary(t)
+	recovered, err := newSecretary.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTreeRecords(t, recovered, expected)
+
+	if recovered.wal.lsn != tree.wal.lsn {
+		t.Fatalf("Expected LSN %d, got %d", tree.wal.lsn, recovered.wal.lsn)
+	}
+
+	s.PagerShutdown()
+	newSecretary.PagerShutdown()
+}
+
+func TestPersistRecordLevels(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	expected := map[string]string{}
+	var keySeq uint64 = 0
+	for i := 0; i < 50; i++ {
+		key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+		value := key
+		if i%5 == 0 {
+			// Larger than the first level item size
+			value = string(bytes.Repeat([]byte(key), 100))
+		}
+		if _, err := tree.SetKV([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = value
+	}
+
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	for node := tree.root; node != nil; node = node.children[0] {
+		if len(node.children) != 0 {
+			continue
+		}
+		for leaf := node; leaf != nil; leaf = leaf.next {
+			if len(leaf.KeyLocation) != len(leaf.records) {
+				t.Fatalf("Expected %d locations, got %d", len(leaf.records), len(leaf.KeyLocation))
+			}
+			for i, record := range leaf.records {
+				if record.Value != nil {
+					t.Fatal("Value kept in memory after checkpoint", string(record.Key))
+				}
+
+				// Smallest level fitting the record, BaseSize * Increment^level
+				data, _ := (&Record{Key: record.Key, Value: []byte(expected[string(record.Key)])}).ToBytes()
+				level := 0
+				for int64(len(data)) > tree.recordPagers[level].itemSize {
+					level++
+				}
+
+				location := ToRecordLocation(leaf.KeyLocation[i])
+				if int(location.batchLevel) != level {
+					t.Fatalf("Expected level %d, got %d", level, location.batchLevel)
+				}
+			}
+		}
+		break
+	}
+
+	verifyTreeRecords(t, tree, expected)
+
+	records, err := tree.RangeScan(make([]byte, KEY_SIZE), bytes.Repeat([]byte{0xFF}, KEY_SIZE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if string(record.Value) != expected[string(record.Key)] {
+			t.Fatal("Range scan value mismatch", string(record.Key))
+		}
+	}
+
+	s.PagerShutdown()
+}
+
+func TestPersistCheckpointCrash(t *testing.T) {
+	for _, journaled := range []bool{true, false} {
+		s := dummySecretary(t)
+		tree := dummyTree(t, s, 4)
+
+		expected := map[string]string{}
+		var keySeq uint64 = 0
+		setKeys := func(n int) {
+			for i := 0; i < n; i++ {
+				key := utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+				if _, err := tree.SetKV([]byte(key), []byte("value"+key)); err != nil {
+					t.Fatal(err)
+				}
+				expected[key] = "value" + key
+			}
+		}
+
+		setKeys(20)
+		if err := tree.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		setKeys(20)
+
+		// Run the checkpoint up to the header, then stop as after a crash
+		pages, err := tree.checkpointPages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if journaled {
+			if err := tree.journal.Write(pages); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.writeCheckpointPages(pages[:len(pages)-1]); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			// Torn journal, index.bin was not touched yet
+			if err := tree.journal.Write(pages); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.journal.file.Truncate(64); err != nil {
+				t.Fatal(err)
+			}
+		}
+		delete(s.trees, tree.CollectionName)
+		tree.closeFiles()
+
+		newSecretary := dummySecretary(t)
+		recovered, err := newSecretary.Tree(tree.CollectionName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyTreeRecords(t, recovered, expected)
+
+		stat, err := recovered.journal.file.Stat()
+		if err != nil || stat.Size() != 0 {
+			t.Fatal("Expected empty journal after recovery", err)
+		}
+
+		s.PagerShutdown()
+		newSecretary.PagerShutdown()
+	}
+}
+
+func TestPersistRecordFillsSlot(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	key := []byte(utils.GenerateSeqString(new(uint64), KEY_SIZE, KEY_INCREMENT))
+
+	// 4 + 16 + 4 + 1000, exactly the item size of the first level
+	value := bytes.Repeat([]byte("v"), 1000)
+	data, _ := (&Record{Key: key, Value: value}).ToBytes()
+	if int64(len(data)) != tree.recordPagers[0].itemSize {
+		t.Fatalf("Expected record of %d bytes, got %d", tree.recordPagers[0].itemSize, len(data))
+	}
+
+	if _, err := tree.SetKV(key, value); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if location := tree.root.records[0].location; location == nil || location.batchLevel != 0 {
+		t.Fatal("Expected record in the first level", location)
+	}
+
+	newSecretary := dummySecretary(t)
+	reloaded, err := newSecretary.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTreeRecords(t, reloaded, map[string]string{string(key): string(value)})
+
+	s.PagerShutdown()
+	newSecretary.PagerShutdown()
+}
+
+func TestPersistLoadError(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	sortedRecords := SampleSortedKeyRecords(100)
+	if err := tree.SortedRecordSet(sortedRecords); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	delete(s.trees, tree.CollectionName)
+	tree.closeFiles()
+
+	newSecretary := dummySecretary(t)
+	reloaded, err := newSecretary.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop every page but the root and its children
+	keep := reloaded.nodePager.headerSize + reloaded.nodePager.itemSize*int64(1+len(reloaded.nodes))
+	if err := reloaded.nodePager.file.Truncate(keep); err != nil {
+		t.Fatal(err)
+	}
+	reloaded.nodePager.cache.Clear()
+
+	lastKey := sortedRecords[len(sortedRecords)-1].Key
+	if _, err := reloaded.Get(lastKey); err == nil {
+		t.Fatal("Expected load error on Get")
+	}
+	if _, err := reloaded.RangeScan(sortedRecords[0].Key, lastKey); err == nil {
+		t.Fatal("Expected load error on RangeScan")
+	}
+	if _, err := reloaded.SetKV(lastKey, lastKey); err == nil {
+		t.Fatal("Expected load error on SetKV")
+	}
+	if err := reloaded.Delete(lastKey); err == nil {
+		t.Fatal("Expected load error on Delete")
+	}
+	if _, err := newSecretary.HandleGetRecord(reloaded.CollectionName, string(lastKey)); err == nil {
+		t.Fatal("Expected load error on HandleGetRecord")
+	}
+
+	s.PagerShutdown()
+	newSecretary.PagerShutdown()
+}
+
+func TestPersistEvictCleanNodes(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	expected := map[string]string{}
+	sortedRecords := SampleSortedKeyRecords(300)
+	for _, r := range sortedRecords {
+		expected[string(r.Key)] = string(r.Value)
+	}
+	if err := tree.SortedRecordSet(sortedRecords); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree.maxLoadedNodes = 16
+	firstKey, lastKey := sortedRecords[0].Key, sortedRecords[len(sortedRecords)-1].Key
+
+	records, err := tree.RangeScan(firstKey, lastKey)
+	if err != nil || len(records) != len(sortedRecords) {
+		t.Fatal("Range scan failed", len(records), err)
+	}
+	if tree.numLoaded > tree.maxLoadedNodes {
+		t.Fatalf("Expected at most %d loaded nodes, got %d", tree.maxLoadedNodes, tree.numLoaded)
+	}
+
+	// Evicted nodes are read again and keep working for writes
+	for i, r := range sortedRecords {
+		switch i % 3 {
+		case 0:
+			if err := tree.Delete(r.Key); err != nil {
+				t.Fatal(err)
+			}
+			delete(expected, string(r.Key))
+		case 1:
+			if err := tree.Update(r.Key, []byte("updated")); err != nil {
+				t.Fatal(err)
+			}
+			expected[string(r.Key)] = "updated"
+		}
+		if i%50 == 0 {
+			if err := tree.Checkpoint(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	verifyTreeRecords(t, tree, expected)
+	s.PagerShutdown()
+
+	newSecretary := dummySecretary(t)
+	reloaded, err := newSecretary.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTreeRecords(t, reloaded, expected)
+
+	newSecretary.PagerShutdown()
+}
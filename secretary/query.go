@@ -1,9 +1,42 @@
 package secretary
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 )
 
+/*
+SQL Grammar
+
+ParseSQL reads one statement into an SQLStatement. Keywords match in any
+case, names and literals keep theirs.
+
+	statement  = select | insert | update | delete [";"]
+	select     = SELECT ("*" | name {"," name}) FROM name [WHERE expr]
+	             [ORDER BY order {"," order}] [LIMIT operand] [OFFSET operand]
+	insert     = INSERT INTO name "(" name {"," name} ")" VALUES row {"," row}
+	row        = "(" expr {"," expr} ")"
+	update     = UPDATE name SET name "=" expr {"," name "=" expr} [WHERE expr]
+	delete     = DELETE FROM name [WHERE expr]
+	order      = expr [ASC | DESC]
+
+	expr       = and {OR and}
+	and        = not {AND not}
+	not        = NOT not | predicate
+	predicate  = operand [compare operand | [NOT] IN "(" expr {"," expr} ")"
+	             | [NOT] BETWEEN operand AND operand | [NOT] LIKE operand | IS [NOT] NULL]
+	compare    = "=" | "!=" | "<>" | "<" | "<=" | ">" | ">="
+	operand    = "-" operand | literal | param | name | "(" expr ")"
+	literal    = 'string' | number | TRUE | FALSE | NULL
+	param      = "?" | "$" digits
+
+Names are identifiers, which may start with digits, or "quoted", a quoted
+name can be a keyword. A "?" takes the next parameter, "$n" the nth from 1.
+"--" starts a comment. Errors are SQLSyntaxError and give the line and
+column of the token.
+*/
+
 type SQLStatementType int
 
 const (
@@ -11,160 +44,767 @@ const (
 	Insert
 	Update
 	Delete
-	Unknown
 )
 
 type SQLStatement struct {
-	Type   SQLStatementType
-	Table  string
-	Fields []string
-	Values []string
-	Where  string
-	Set    map[string]string
+	Type    SQLStatementType
+	Table   string
+	Fields  []string        // SELECT columns, nil for *, or INSERT columns
+	Values  [][]SQLExpr     // INSERT rows
+	Set     []SQLAssignment // UPDATE assignments in order
+	Where   SQLExpr         // nil without WHERE
+	OrderBy []SQLOrder
+	Limit   SQLExpr // nil without LIMIT
+	Offset  SQLExpr // nil without OFFSET
+	Params  int     // Parameters the placeholders need
+}
+
+type SQLAssignment struct {
+	Column string
+	Value  SQLExpr
+}
+
+type SQLOrder struct {
+	Expr SQLExpr
+	Desc bool
 }
 
-func ParseSQL(query string) SQLStatement {
-	query = strings.TrimSpace(query)
-	query = strings.ToUpper(query)
+// SQLExpr is a node of an expression tree
+type SQLExpr interface {
+	sqlExpr()
+}
 
-	stmt := SQLStatement{}
+// SQLLiteral holds a string, a float64, a bool or nil for NULL
+type SQLLiteral struct {
+	Value any
+}
 
-	switch {
-	case strings.HasPrefix(query, "SELECT"):
-		stmt.Type = Select
-		parseSelect(&stmt, query)
-	case strings.HasPrefix(query, "INSERT"):
-		stmt.Type = Insert
-		parseInsert(&stmt, query)
-	case strings.HasPrefix(query, "UPDATE"):
-		stmt.Type = Update
-		parseUpdate(&stmt, query)
-	case strings.HasPrefix(query, "DELETE"):
-		stmt.Type = Delete
-		parseDelete(&stmt, query)
-	default:
-		stmt.Type = Unknown
+type SQLColumn struct {
+	Name string
+}
+
+// SQLParam is a placeholder, Index counts from 0
+type SQLParam struct {
+	Index int
+}
+
+// SQLUnary is NOT or a negation "-"
+type SQLUnary struct {
+	Op   string
+	Expr SQLExpr
+}
+
+// SQLBinary is AND, OR or a comparison, <> is read as !=
+type SQLBinary struct {
+	Op    string
+	Left  SQLExpr
+	Right SQLExpr
+}
+
+type SQLIn struct {
+	Expr SQLExpr
+	List []SQLExpr
+	Not  bool
+}
+
+type SQLBetween struct {
+	Expr SQLExpr
+	Low  SQLExpr
+	High SQLExpr
+	Not  bool
+}
+
+// SQLLike matches a pattern where % is any run of characters and _ one character
+type SQLLike struct {
+	Expr    SQLExpr
+	Pattern SQLExpr
+	Not     bool
+}
+
+type SQLIsNull struct {
+	Expr SQLExpr
+	Not  bool
+}
+
+func (SQLLiteral) sqlExpr() {}
+func (SQLColumn) sqlExpr()  {}
+func (SQLParam) sqlExpr()   {}
+func (SQLUnary) sqlExpr()   {}
+func (SQLBinary) sqlExpr()  {}
+func (SQLIn) sqlExpr()      {}
+func (SQLBetween) sqlExpr() {}
+func (SQLLike) sqlExpr()    {}
+func (SQLIsNull) sqlExpr()  {}
+
+// SQLSyntaxError locates a lexing or parsing error, Pos is the byte offset in the query
+type SQLSyntaxError struct {
+	Pos     int
+	Line    int
+	Column  int
+	Message string
+}
+
+func (err *SQLSyntaxError) Error() string {
+	return fmt.Sprintf("SQL syntax error at line %d, column %d: %s", err.Line, err.Column, err.Message)
+}
+
+func newSQLSyntaxError(query string, pos int, format string, args ...any) *SQLSyntaxError {
+	before := query[:pos]
+	line := strings.Count(before, "\n") + 1
+	column := len([]rune(before[strings.LastIndexByte(before, '\n')+1:])) + 1
+	return &SQLSyntaxError{Pos: pos, Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+}
+
+//------------------------------------------------------------------
+// Lexer
+//------------------------------------------------------------------
+
+type sqlTokenKind int
+
+const (
+	sqlEOF sqlTokenKind = iota
+	sqlName
+	sqlKeyword
+	sqlString
+	sqlNumber
+	sqlParam
+	sqlSymbol
+)
+
+// sqlToken text is upper-cased for keywords, unquoted for strings and quoted names,
+// query[pos:end] is the token as written
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+	pos  int
+	end  int
+}
+
+var sqlKeywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "INSERT": true, "INTO": true, "VALUES": true,
+	"UPDATE": true, "SET": true, "DELETE": true, "AND": true, "OR": true, "NOT": true,
+	"IN": true, "BETWEEN": true, "LIKE": true, "IS": true, "NULL": true, "TRUE": true,
+	"FALSE": true, "ORDER": true, "BY": true, "ASC": true, "DESC": true, "LIMIT": true,
+	"OFFSET": true,
+}
+
+func lexSQL(query string) ([]sqlToken, error) {
+	tokens := []sqlToken{}
+	emit := func(kind sqlTokenKind, text string, start int, end int) {
+		tokens = append(tokens, sqlToken{kind: kind, text: text, pos: start, end: end})
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case strings.HasPrefix(query[i:], "--"):
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+
+		case isNameStart(c):
+			for i < len(query) && (isNameStart(query[i]) || isDigit(query[i])) {
+				i++
+			}
+			word := query[start:i]
+			if upper := strings.ToUpper(word); sqlKeywords[upper] {
+				emit(sqlKeyword, upper, start, i)
+			} else {
+				emit(sqlName, word, start, i)
+			}
+
+		case c == '\'' || c == '"':
+			text, end, ok := lexQuoted(query, i)
+			if !ok {
+				if c == '"' {
+					return nil, newSQLSyntaxError(query, start, "unterminated quoted name")
+				}
+				return nil, newSQLSyntaxError(query, start, "unterminated string")
+			}
+			kind := sqlString
+			if c == '"' {
+				kind = sqlName
+			}
+			emit(kind, text, start, end)
+			i = end
+
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			// Collection names can start with a digit, 12abc is a name
+			nameEnd := i
+			for nameEnd < len(query) && (isNameStart(query[nameEnd]) || isDigit(query[nameEnd])) {
+				nameEnd++
+			}
+			i = lexNumber(query, i)
+			if nameEnd > i && !strings.ContainsRune(query[start:i], '.') {
+				i = nameEnd
+				emit(sqlName, query[start:i], start, i)
+				continue
+			}
+			if i < len(query) && isNameStart(query[i]) {
+				return nil, newSQLSyntaxError(query, start, "invalid number %q", query[start:i+1])
+			}
+			emit(sqlNumber, query[start:i], start, i)
+
+		case c == '?':
+			i++
+			emit(sqlParam, "", start, i)
+
+		case c == '$':
+			i++
+			for i < len(query) && isDigit(query[i]) {
+				i++
+			}
+			if i == start+1 {
+				return nil, newSQLSyntaxError(query, start, "expected a parameter number after $")
+			}
+			emit(sqlParam, query[start+1:i], start, i)
+
+		default:
+			symbol := ""
+			for _, s := range []string{"<=", ">=", "!=", "<>", "=", "<", ">", "(", ")", ",", "*", ";", "-"} {
+				if strings.HasPrefix(query[i:], s) {
+					symbol = s
+					break
+				}
+			}
+			if symbol == "" {
+				return nil, newSQLSyntaxError(query, start, "unexpected character %q", rune(query[i]))
+			}
+			i += len(symbol)
+			emit(sqlSymbol, symbol, start, i)
+		}
+	}
+
+	emit(sqlEOF, "", len(query), len(query))
+	return tokens, nil
+}
+
+// lexQuoted reads a string or name quoted by query[start], a doubled quote escapes it
+func lexQuoted(query string, start int) (text string, end int, ok bool) {
+	quote := query[start]
+	var builder strings.Builder
+	for i := start + 1; i < len(query); i++ {
+		if query[i] != quote {
+			builder.WriteByte(query[i])
+			continue
+		}
+		if i+1 < len(query) && query[i+1] == quote {
+			builder.WriteByte(quote)
+			i++
+			continue
+		}
+		return builder.String(), i + 1, true
+	}
+	return "", 0, false
+}
+
+func lexNumber(query string, i int) int {
+	digits := func() {
+		for i < len(query) && isDigit(query[i]) {
+			i++
+		}
+	}
+
+	digits()
+	if i < len(query) && query[i] == '.' {
+		i++
+		digits()
+	}
+	if i+1 < len(query) && (query[i] == 'e' || query[i] == 'E') {
+		exponent := i + 1
+		if query[exponent] == '+' || query[exponent] == '-' {
+			exponent++
+		}
+		if exponent < len(query) && isDigit(query[exponent]) {
+			i = exponent
+			digits()
+		}
+	}
+	return i
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+//------------------------------------------------------------------
+// Parser
+//------------------------------------------------------------------
+
+type sqlParser struct {
+	query     string
+	tokens    []sqlToken
+	next      int
+	nextParam int // Index of the next ?
+	params    int
+}
+
+// ParseSQL parses one statement
+func ParseSQL(query string) (SQLStatement, error) {
+	tokens, err := lexSQL(query)
+	if err != nil {
+		return SQLStatement{}, err
+	}
+
+	p := &sqlParser{query: query, tokens: tokens}
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return SQLStatement{}, err
+	}
+
+	p.acceptSymbol(";")
+	if p.peek().kind != sqlEOF {
+		return SQLStatement{}, p.unexpected("end of query")
+	}
+
+	stmt.Params = p.params
+	return stmt, nil
+}
+
+func (p *sqlParser) peek() sqlToken {
+	return p.tokens[p.next]
+}
+
+func (p *sqlParser) advance() sqlToken {
+	token := p.tokens[p.next]
+	if token.kind != sqlEOF {
+		p.next++
+	}
+	return token
+}
+
+func (p *sqlParser) acceptKeyword(keyword string) bool {
+	if token := p.peek(); token.kind == sqlKeyword && token.text == keyword {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) acceptSymbol(symbol string) bool {
+	if token := p.peek(); token.kind == sqlSymbol && token.text == symbol {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.unexpected(keyword)
 	}
+	return nil
+}
 
-	return stmt
+func (p *sqlParser) expectSymbol(symbol string) error {
+	if !p.acceptSymbol(symbol) {
+		return p.unexpected(strconv.Quote(symbol))
+	}
+	return nil
 }
 
-func parseSelect(stmt *SQLStatement, query string) {
-	// Extract fields
-	fromIndex := strings.Index(query, "FROM")
-	fields := query[len("SELECT"):fromIndex]
-	stmt.Fields = parseFieldList(fields)
+func (p *sqlParser) expectName(what string) (string, error) {
+	if token := p.peek(); token.kind == sqlName {
+		p.next++
+		return token.text, nil
+	}
+	return "", p.unexpected(what)
+}
 
-	// Extract table
-	whereIndex := strings.Index(query, "WHERE")
-	if whereIndex == -1 {
-		stmt.Table = strings.TrimSpace(query[fromIndex+len("FROM"):])
-	} else {
-		stmt.Table = strings.TrimSpace(query[fromIndex+len("FROM") : whereIndex])
+// unexpected reports the next token where expected was needed
+func (p *sqlParser) unexpected(expected string) error {
+	token := p.peek()
+	found := "end of query"
+	if token.kind != sqlEOF {
+		found = strconv.Quote(p.query[token.pos:token.end])
 	}
+	return newSQLSyntaxError(p.query, token.pos, "expected %s, found %s", expected, found)
+}
 
-	// Extract WHERE clause
-	if whereIndex != -1 {
-		stmt.Where = strings.TrimSpace(query[whereIndex+len("WHERE"):])
+func (p *sqlParser) parseStatement() (SQLStatement, error) {
+	switch {
+	case p.acceptKeyword("SELECT"):
+		return p.parseSelect()
+	case p.acceptKeyword("INSERT"):
+		return p.parseInsert()
+	case p.acceptKeyword("UPDATE"):
+		return p.parseUpdate()
+	case p.acceptKeyword("DELETE"):
+		return p.parseDelete()
 	}
+	return SQLStatement{}, p.unexpected("SELECT, INSERT, UPDATE or DELETE")
 }
 
-func parseInsert(stmt *SQLStatement, query string) {
-	// Extract table name
-	intoIndex := strings.Index(query, "INTO")
-	parenIndex := strings.Index(query, "(")
-	stmt.Table = strings.TrimSpace(query[intoIndex+len("INTO") : parenIndex])
+func (p *sqlParser) parseSelect() (stmt SQLStatement, err error) {
+	stmt.Type = Select
+
+	if !p.acceptSymbol("*") {
+		if stmt.Fields, err = p.parseNames(); err != nil {
+			return stmt, err
+		}
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return stmt, err
+	}
+	if stmt.Table, err = p.expectName("table name"); err != nil {
+		return stmt, err
+	}
+	if stmt.Where, err = p.parseWhere(); err != nil {
+		return stmt, err
+	}
 
-	// Extract fields
-	fieldsEnd := strings.Index(query, ")")
-	fields := query[parenIndex+1 : fieldsEnd]
-	stmt.Fields = parseFieldList(fields)
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return stmt, err
+		}
+		for {
+			expr, err := p.parseExpr()
+			if err != nil {
+				return stmt, err
+			}
+			order := SQLOrder{Expr: expr}
+			if !p.acceptKeyword("ASC") {
+				order.Desc = p.acceptKeyword("DESC")
+			}
+			stmt.OrderBy = append(stmt.OrderBy, order)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
 
-	// Extract values
-	valuesIndex := strings.Index(query, "VALUES")
-	values := query[valuesIndex+len("VALUES"):]
-	values = strings.Trim(values, "()")
-	stmt.Values = parseValueList(values)
+	if p.acceptKeyword("LIMIT") {
+		if stmt.Limit, err = p.parseOperand(); err != nil {
+			return stmt, err
+		}
+	}
+	if p.acceptKeyword("OFFSET") {
+		if stmt.Offset, err = p.parseOperand(); err != nil {
+			return stmt, err
+		}
+	}
+	return stmt, nil
 }
 
-func parseUpdate(stmt *SQLStatement, query string) {
-	// Extract table name
-	setIndex := strings.Index(query, "SET")
-	stmt.Table = strings.TrimSpace(query[len("UPDATE"):setIndex])
+func (p *sqlParser) parseInsert() (stmt SQLStatement, err error) {
+	stmt.Type = Insert
 
-	// Extract SET clause
-	whereIndex := strings.Index(query, "WHERE")
-	setClause := query[setIndex+len("SET"):]
-	if whereIndex != -1 {
-		setClause = query[setIndex+len("SET") : whereIndex]
+	if err := p.expectKeyword("INTO"); err != nil {
+		return stmt, err
 	}
+	if stmt.Table, err = p.expectName("table name"); err != nil {
+		return stmt, err
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return stmt, err
+	}
+	if stmt.Fields, err = p.parseNames(); err != nil {
+		return stmt, err
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return stmt, err
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return stmt, err
+	}
+
+	for {
+		rowStart := p.peek()
+		if err := p.expectSymbol("("); err != nil {
+			return stmt, err
+		}
+		row, err := p.parseExprList()
+		if err != nil {
+			return stmt, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return stmt, err
+		}
+		if len(row) != len(stmt.Fields) {
+			return stmt, newSQLSyntaxError(p.query, rowStart.pos, "%d columns but %d values", len(stmt.Fields), len(row))
+		}
+		stmt.Values = append(stmt.Values, row)
+		if !p.acceptSymbol(",") {
+			return stmt, nil
+		}
+	}
+}
+
+func (p *sqlParser) parseUpdate() (stmt SQLStatement, err error) {
+	stmt.Type = Update
 
-	stmt.Set = make(map[string]string)
-	pairs := strings.Split(setClause, ",")
-	for _, pair := range pairs {
-		parts := strings.Split(pair, "=")
-		if len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-			stmt.Set[key] = value
+	if stmt.Table, err = p.expectName("table name"); err != nil {
+		return stmt, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return stmt, err
+	}
+	for {
+		column, err := p.expectName("column name")
+		if err != nil {
+			return stmt, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return stmt, err
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return stmt, err
 		}
+		stmt.Set = append(stmt.Set, SQLAssignment{Column: column, Value: value})
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+
+	stmt.Where, err = p.parseWhere()
+	return stmt, err
+}
+
+func (p *sqlParser) parseDelete() (stmt SQLStatement, err error) {
+	stmt.Type = Delete
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return stmt, err
 	}
+	if stmt.Table, err = p.expectName("table name"); err != nil {
+		return stmt, err
+	}
+	stmt.Where, err = p.parseWhere()
+	return stmt, err
+}
+
+func (p *sqlParser) parseWhere() (SQLExpr, error) {
+	if !p.acceptKeyword("WHERE") {
+		return nil, nil
+	}
+	return p.parseExpr()
+}
 
-	// Extract WHERE clause
-	if whereIndex != -1 {
-		stmt.Where = strings.TrimSpace(query[whereIndex+len("WHERE"):])
+func (p *sqlParser) parseNames() ([]string, error) {
+	names := []string{}
+	for {
+		name, err := p.expectName("column name")
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.acceptSymbol(",") {
+			return names, nil
+		}
 	}
 }
 
-func parseDelete(stmt *SQLStatement, query string) {
-	// Extract table name
-	fromIndex := strings.Index(query, "FROM")
-	whereIndex := strings.Index(query, "WHERE")
-	if whereIndex == -1 {
-		stmt.Table = strings.TrimSpace(query[fromIndex+len("FROM"):])
-	} else {
-		stmt.Table = strings.TrimSpace(query[fromIndex+len("FROM") : whereIndex])
+func (p *sqlParser) parseExprList() ([]SQLExpr, error) {
+	list := []SQLExpr{}
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, expr)
+		if !p.acceptSymbol(",") {
+			return list, nil
+		}
 	}
+}
 
-	// Extract WHERE clause
-	if whereIndex != -1 {
-		stmt.Where = strings.TrimSpace(query[whereIndex+len("WHERE"):])
+func (p *sqlParser) parseExpr() (SQLExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = SQLBinary{Op: "OR", Left: left, Right: right}
 	}
+	return left, nil
 }
 
-func parseFieldList(fields string) []string {
-	fields = strings.TrimSpace(fields)
-	if fields == "*" {
-		return []string{"*"}
+func (p *sqlParser) parseAnd() (SQLExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
 	}
-	return splitByComma(fields)
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = SQLBinary{Op: "AND", Left: left, Right: right}
+	}
+	return left, nil
 }
 
-func parseValueList(values string) []string {
-	return splitByComma(values)
+func (p *sqlParser) parseNot() (SQLExpr, error) {
+	if p.acceptKeyword("NOT") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return SQLUnary{Op: "NOT", Expr: expr}, nil
+	}
+	return p.parsePredicate()
 }
 
-func splitByComma(s string) []string {
-	var result []string
-	var buffer strings.Builder
-	inQuotes := false
+func (p *sqlParser) parsePredicate() (SQLExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
 
-	for _, r := range s {
-		switch {
-		case r == '\'':
-			inQuotes = !inQuotes
-			buffer.WriteRune(r)
-		case r == ',' && !inQuotes:
-			result = append(result, strings.TrimSpace(buffer.String()))
-			buffer.Reset()
-		default:
-			buffer.WriteRune(r)
+	if token := p.peek(); token.kind == sqlSymbol {
+		switch token.text {
+		case "=", "!=", "<>", "<", "<=", ">", ">=":
+			p.next++
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			op := token.text
+			if op == "<>" {
+				op = "!="
+			}
+			return SQLBinary{Op: op, Left: left, Right: right}, nil
+		}
+	}
+
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return SQLIsNull{Expr: left, Not: not}, nil
+	}
+
+	not := p.acceptKeyword("NOT")
+	switch {
+	case p.acceptKeyword("IN"):
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		list, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return SQLIn{Expr: left, List: list, Not: not}, nil
+
+	case p.acceptKeyword("BETWEEN"):
+		low, err := p.parseOperand()
+		if err != nil {
+			return nil, err
 		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return SQLBetween{Expr: left, Low: low, High: high, Not: not}, nil
+
+	case p.acceptKeyword("LIKE"):
+		pattern, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return SQLLike{Expr: left, Pattern: pattern, Not: not}, nil
+	}
+
+	if not {
+		return nil, p.unexpected("IN, BETWEEN or LIKE")
 	}
+	return left, nil
+}
+
+func (p *sqlParser) parseOperand() (SQLExpr, error) {
+	token := p.peek()
+
+	switch token.kind {
+	case sqlString:
+		p.next++
+		return SQLLiteral{Value: token.text}, nil
+
+	case sqlNumber:
+		p.next++
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, newSQLSyntaxError(p.query, token.pos, "invalid number %q", token.text)
+		}
+		return SQLLiteral{Value: number}, nil
+
+	case sqlName:
+		p.next++
+		return SQLColumn{Name: token.text}, nil
 
-	if buffer.Len() > 0 {
-		result = append(result, strings.TrimSpace(buffer.String()))
+	case sqlParam:
+		p.next++
+		index := p.nextParam
+		if token.text == "" {
+			p.nextParam++
+		} else {
+			n, err := strconv.Atoi(token.text)
+			if err != nil || n < 1 {
+				return nil, newSQLSyntaxError(p.query, token.pos, "parameters are numbered from $1")
+			}
+			index = n - 1
+		}
+		p.params = max(p.params, index+1)
+		return SQLParam{Index: index}, nil
+
+	case sqlKeyword:
+		switch token.text {
+		case "TRUE", "FALSE", "NULL":
+			p.next++
+			literal := SQLLiteral{}
+			if token.text != "NULL" {
+				literal.Value = token.text == "TRUE"
+			}
+			return literal, nil
+		}
+
+	case sqlSymbol:
+		switch token.text {
+		case "-":
+			p.next++
+			expr, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			if literal, ok := expr.(SQLLiteral); ok {
+				if number, ok := literal.Value.(float64); ok {
+					return SQLLiteral{Value: -number}, nil
+				}
+			}
+			return SQLUnary{Op: "-", Expr: expr}, nil
+
+		case "(":
+			p.next++
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			return expr, nil
+		}
 	}
 
-	return result
+	return nil, p.unexpected("a value, a column or a parameter")
 }
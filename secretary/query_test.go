@@ -1,6 +1,8 @@
 package secretary
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -13,7 +15,107 @@ func TestQuery(t *testing.T) {
 	}
 
 	for _, query := range queries {
-		stmt := ParseSQL(query)
+		stmt, err := ParseSQL(query)
+		if err != nil {
+			t.Fatal(query, err)
+		}
 		t.Logf("Parsed Statement:\n%+v\n\n", stmt)
 	}
 }
+
+func TestQueryParse(t *testing.T) {
+	column := func(name string) SQLExpr { return SQLColumn{Name: name} }
+	literal := func(value any) SQLExpr { return SQLLiteral{Value: value} }
+	binary := func(op string, left, right SQLExpr) SQLExpr { return SQLBinary{Op: op, Left: left, Right: right} }
+
+	parsed := map[string]SQLStatement{
+		// Keywords in any case, names and strings keep theirs, keywords inside strings are values
+		"select Id, fromDate from Users where Name = 'From WHERE' -- comment": {
+			Type: Select, Table: "Users", Fields: []string{"Id", "fromDate"},
+			Where: binary("=", column("Name"), literal("From WHERE")),
+		},
+		`SELECT * FROM "order" WHERE NOT a = 1 OR b <> -2.5e1 AND (c < 3 OR d >= 'x')`: {
+			Type: Select, Table: "order",
+			Where: binary("OR",
+				SQLUnary{Op: "NOT", Expr: binary("=", column("a"), literal(1.0))},
+				binary("AND",
+					binary("!=", column("b"), literal(-25.0)),
+					binary("OR", binary("<", column("c"), literal(3.0)), binary(">=", column("d"), literal("x"))),
+				),
+			),
+		},
+		"SELECT * FROM t WHERE key IN ('a', ?) AND n NOT BETWEEN $3 AND 10 AND s LIKE 'it''s%' AND z IS NOT NULL ORDER BY n DESC, key LIMIT ? OFFSET 5;": {
+			Type: Select, Table: "t",
+			Where: binary("AND",
+				binary("AND",
+					binary("AND",
+						SQLIn{Expr: column("key"), List: []SQLExpr{literal("a"), SQLParam{Index: 0}}},
+						SQLBetween{Expr: column("n"), Low: SQLParam{Index: 2}, High: literal(10.0), Not: true},
+					),
+					SQLLike{Expr: column("s"), Pattern: literal("it's%")},
+				),
+				SQLIsNull{Expr: column("z"), Not: true},
+			),
+			OrderBy: []SQLOrder{{Expr: column("n"), Desc: true}, {Expr: column("key")}},
+			Limit:   SQLParam{Index: 1},
+			Offset:  literal(5.0),
+			Params:  3,
+		},
+		"insert into Products (key, Name) values ('p1', 'Big, Laptop'), ('p2', NULL)": {
+			Type: Insert, Table: "Products", Fields: []string{"key", "Name"},
+			Values: [][]SQLExpr{{literal("p1"), literal("Big, Laptop")}, {literal("p2"), literal(nil)}},
+		},
+		"Update Staff set Team = 'a=b', Level = -Level, On = TRUE WHERE key = 'x'": {
+			Type: Update, Table: "Staff",
+			Set: []SQLAssignment{
+				{Column: "Team", Value: literal("a=b")},
+				{Column: "Level", Value: SQLUnary{Op: "-", Expr: column("Level")}},
+				{Column: "On", Value: literal(true)},
+			},
+			Where: binary("=", column("key"), literal("x")),
+		},
+		"delete from 0rders where 1e-1 < 12e": {
+			Type: Delete, Table: "0rders", Where: binary("<", literal(0.1), column("12e")),
+		},
+	}
+	for query, expected := range parsed {
+		stmt, err := ParseSQL(query)
+		if err != nil {
+			t.Fatal(query, err)
+		}
+		if !reflect.DeepEqual(stmt, expected) {
+			t.Fatalf("Parse mismatch for %s\n%+v", query, stmt)
+		}
+	}
+
+	failures := map[string]SQLSyntaxError{
+		"":                                     {Pos: 0, Line: 1, Column: 1, Message: "expected SELECT, INSERT, UPDATE or DELETE, found end of query"},
+		"SELECT * FORM t":                      {Pos: 9, Line: 1, Column: 10, Message: `expected FROM, found "FORM"`},
+		"SELECT *\nFROM t\nWHERE a = 'open":    {Pos: 26, Line: 3, Column: 11, Message: "unterminated string"},
+		"SELECT * FROM t WHERE a = 1 b":        {Pos: 28, Line: 1, Column: 29, Message: `expected end of query, found "b"`},
+		"SELECT * FROM t WHERE a NOT = 1":      {Pos: 28, Line: 1, Column: 29, Message: `expected IN, BETWEEN or LIKE, found "="`},
+		"SELECT * FROM t WHERE a BETWEEN 1 2":  {Pos: 34, Line: 1, Column: 35, Message: `expected AND, found "2"`},
+		"SELECT * FROM t WHERE a = $0":         {Pos: 26, Line: 1, Column: 27, Message: "parameters are numbered from $1"},
+		"SELECT * FROM t WHERE a = 1.5abc":     {Pos: 26, Line: 1, Column: 27, Message: `invalid number "1.5a"`},
+		"SELECT * FROM t WHERE a = #":          {Pos: 26, Line: 1, Column: 27, Message: `unexpected character '#'`},
+		"SELECT * FROM t WHERE é = ":           {Pos: 27, Line: 1, Column: 27, Message: "expected a value, a column or a parameter, found end of query"},
+		"INSERT INTO t (key, a) VALUES ('x')":  {Pos: 30, Line: 1, Column: 31, Message: "2 columns but 1 values"},
+		"UPDATE t SET a = 1,":                  {Pos: 19, Line: 1, Column: 20, Message: "expected column name, found end of query"},
+		"DELETE FROM select":                   {Pos: 12, Line: 1, Column: 13, Message: `expected table name, found "select"`},
+		"SELECT * FROM t ORDER key":            {Pos: 22, Line: 1, Column: 23, Message: `expected BY, found "key"`},
+		"SELECT * FROM t WHERE a IN ()":        {Pos: 28, Line: 1, Column: 29, Message: `expected a value, a column or a parameter, found ")"`},
+		`SELECT * FROM "unterminated`:          {Pos: 14, Line: 1, Column: 15, Message: "unterminated quoted name"},
+		"SELECT * FROM t WHERE (a = 1":         {Pos: 28, Line: 1, Column: 29, Message: `expected ")", found end of query`},
+		"SELECT * FROM t WHERE a IS 1":         {Pos: 27, Line: 1, Column: 28, Message: `expected NULL, found "1"`},
+		"SELECT * FROM t WHERE a LIKE":         {Pos: 28, Line: 1, Column: 29, Message: "expected a value, a column or a parameter, found end of query"},
+		"SELECT * FROM t WHERE a = $":          {Pos: 26, Line: 1, Column: 27, Message: "expected a parameter number after $"},
+		"SELECT * FROM t LIMIT 1 ORDER BY key": {Pos: 24, Line: 1, Column: 25, Message: `expected end of query, found "ORDER"`},
+	}
+	for query, expected := range failures {
+		_, err := ParseSQL(query)
+		var syntaxErr *SQLSyntaxError
+		if !errors.As(err, &syntaxErr) || *syntaxErr != expected {
+			t.Fatalf("Expected %+v for %q, got %v", expected, query, err)
+		}
+	}
+}
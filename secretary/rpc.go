@@ -0,0 +1,463 @@
+//go:build !js
+
+package secretary
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	"connectrpc.com/connect"
+	"github.com/codeharik/secretary/api"
+	"github.com/codeharik/secretary/api/apiconnect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+/*
+RPC
+
+The Connect service in proto/secretary.proto, mounted by Serve next to the
+HTTP routes. It speaks Connect, gRPC and gRPC-Web, the client package wraps
+it for Go callers.
+
+Requests are checked against the buf.validate rules of their fields before
+they reach a method. The rules secretary.proto uses are enforced here :
+string and bytes length, uint32 bounds, repeated item counts and required.
+
+Scan streams a range in pages of page_size records. Every page carries a
+resume_token, the key of its last record. A client that lost the stream
+sends the same request with that token and the scan continues after it.
+*/
+
+const SCAN_PAGE_SIZE = 100 // Records per Scan response unless the request sets page_size
+
+// rpcHandler returns the path and handler of the Connect service
+func (s *Secretary) rpcHandler() (string, http.Handler) {
+	return apiconnect.NewSecretaryHandler(s, connect.WithInterceptors(validateInterceptor{}))
+}
+
+// rpcError maps an error to its Connect code
+func rpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrorTreeNotFound), errors.Is(err, ErrorKeyNotFound), errors.Is(err, ErrorSnapshotNotFound):
+		return connect.NewError(connect.CodeNotFound, err)
+	case errors.Is(err, ErrorSnapshotReleased):
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	case errors.Is(err, ErrorTxnConflict):
+		return connect.NewError(connect.CodeAborted, err)
+	case errors.Is(err, ErrorSnapshotReverse), errors.Is(err, ErrorInvalidKey), errors.Is(err, ErrorInvalidMaxKeySize),
+		errors.Is(err, ErrorInvalidOrder), errors.Is(err, ErrorInvalidIncrement), errors.Is(err, ErrorInvalidCollectionName):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return connect.NewError(connect.CodeInternal, err)
+}
+
+// optionalKey returns nil for an empty key, the end of the range is open
+func optionalKey(key []byte) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+	return key
+}
+
+func collectionMessage(tree *BTree) *api.Collection {
+	return &api.Collection{
+		CollectionName:      tree.CollectionName,
+		Order:               uint32(tree.Order),
+		NumLevel:            uint32(tree.NumLevel),
+		BaseSize:            tree.BaseSize,
+		Increment:           uint32(tree.Increment),
+		CompactionBatchSize: tree.CompactionBatchSize,
+		MaxKeySize:          uint32(tree.MaxKeySize),
+	}
+}
+
+func (s *Secretary) CreateCollection(ctx context.Context, req *connect.Request[api.CreateCollectionRequest]) (*connect.Response[api.CreateCollectionResponse], error) {
+	msg := req.Msg
+
+	// NewBTree truncates the files of an existing collection
+	if _, err := s.Tree(msg.CollectionName); err == nil {
+		return nil, connect.NewError(connect.CodeAlreadyExists, ErrorTreeExists)
+	}
+
+	tree, err := s.NewBTree(
+		msg.CollectionName,
+		uint8(msg.Order),
+		uint8(msg.NumLevel),
+		msg.BaseSize,
+		uint8(msg.Increment),
+		msg.CompactionBatchSize,
+		uint16(msg.MaxKeySize),
+	)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	if err := tree.SaveHeader(); err != nil {
+		return nil, rpcError(err)
+	}
+
+	return connect.NewResponse(&api.CreateCollectionResponse{Collection: collectionMessage(tree)}), nil
+}
+
+func (s *Secretary) ListCollections(ctx context.Context, req *connect.Request[api.ListCollectionsRequest]) (*connect.Response[api.ListCollectionsResponse], error) {
+	response := &api.ListCollectionsResponse{}
+	for _, tree := range s.trees {
+		response.Collections = append(response.Collections, collectionMessage(tree))
+	}
+	slices.SortFunc(response.Collections, func(a, b *api.Collection) int {
+		return strings.Compare(a.CollectionName, b.CollectionName)
+	})
+	return connect.NewResponse(response), nil
+}
+
+func (s *Secretary) Get(ctx context.Context, req *connect.Request[api.GetRequest]) (*connect.Response[api.GetResponse], error) {
+	msg := req.Msg
+
+	tree, err := s.Tree(msg.CollectionName)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	var record *Record
+	if msg.Snapshot != 0 {
+		snapshot, err := tree.OpenSnapshot(msg.Snapshot)
+		if err != nil {
+			return nil, rpcError(err)
+		}
+		record, err = snapshot.Get(msg.Key)
+	} else {
+		record, err = tree.Get(msg.Key)
+	}
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	return connect.NewResponse(&api.GetResponse{Record: &api.Record{Key: record.Key, Value: record.Value}}), nil
+}
+
+func (s *Secretary) Set(ctx context.Context, req *connect.Request[api.SetRequest]) (*connect.Response[api.SetResponse], error) {
+	msg := req.Msg
+
+	tree, err := s.Tree(msg.CollectionName)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	created := true
+	_, err = tree.SetKV(msg.Key, msg.Value)
+	if err == ErrorDuplicateKey {
+		created = false
+		err = tree.Update(msg.Key, msg.Value)
+	}
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	return connect.NewResponse(&api.SetResponse{Created: created}), nil
+}
+
+func (s *Secretary) BatchSet(ctx context.Context, req *connect.Request[api.BatchSetRequest]) (*connect.Response[api.BatchSetResponse], error) {
+	msg := req.Msg
+
+	response := &api.BatchSetResponse{}
+	txn := s.Begin()
+	for _, record := range msg.Records {
+		_, err := txn.Get(msg.CollectionName, record.Key)
+		switch err {
+		case nil:
+			response.Updated++
+		case ErrorKeyNotFound:
+			response.Created++
+		default:
+			txn.Rollback()
+			return nil, rpcError(err)
+		}
+
+		if err := txn.Set(msg.CollectionName, record.Key, record.Value); err != nil {
+			txn.Rollback()
+			return nil, rpcError(err)
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return nil, rpcError(err)
+	}
+
+	return connect.NewResponse(response), nil
+}
+
+func (s *Secretary) ApplyBatch(ctx context.Context, req *connect.Request[api.ApplyBatchRequest]) (*connect.Response[api.ApplyBatchResponse], error) {
+	msg := req.Msg
+
+	tree, err := s.Tree(msg.CollectionName)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	ops := make([]Op, len(msg.Ops))
+	for i, op := range msg.Ops {
+		ops[i] = Op{Type: OP_PUT, Key: op.Key, Value: op.Value}
+		if op.Delete {
+			ops[i].Type = OP_DELETE
+		}
+	}
+
+	results, err := tree.ApplyBatch(ops)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	response := &api.ApplyBatchResponse{Results: make([]*api.BatchOpResult, len(results))}
+	for i, result := range results {
+		response.Results[i] = &api.BatchOpResult{Created: result.Created}
+		if result.Err != nil {
+			response.Results[i].Error = result.Err.Error()
+		} else {
+			response.Applied++
+		}
+	}
+
+	return connect.NewResponse(response), nil
+}
+
+func (s *Secretary) Delete(ctx context.Context, req *connect.Request[api.DeleteRequest]) (*connect.Response[api.DeleteResponse], error) {
+	msg := req.Msg
+
+	tree, err := s.Tree(msg.CollectionName)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	if err := tree.Delete(msg.Key); err != nil {
+		return nil, rpcError(err)
+	}
+
+	return connect.NewResponse(&api.DeleteResponse{}), nil
+}
+
+// Scan streams the records of a range in pages, see RPC
+func (s *Secretary) Scan(ctx context.Context, req *connect.Request[api.ScanRequest], stream *connect.ServerStream[api.ScanResponse]) error {
+	msg := req.Msg
+
+	options := ScanOptions{
+		StartKey: optionalKey(msg.StartKey),
+		EndKey:   optionalKey(msg.EndKey),
+		Reverse:  msg.Reverse,
+	}
+	// The scan restarts at the last key sent, which is skipped
+	resume := optionalKey(msg.ResumeToken)
+	if resume != nil {
+		if msg.Reverse {
+			options.EndKey = resume
+		} else {
+			options.StartKey = resume
+		}
+	}
+
+	token := ""
+	if msg.Snapshot != 0 {
+		token = strconv.FormatUint(msg.Snapshot, 10)
+	}
+
+	records, err := s.HandleRange(msg.CollectionName, options, token)
+	if err != nil {
+		return rpcError(err)
+	}
+
+	pageSize := int(msg.PageSize)
+	if pageSize == 0 {
+		pageSize = SCAN_PAGE_SIZE
+	}
+
+	sent := 0
+	page := &api.ScanResponse{}
+	for record, err := range records {
+		if err != nil {
+			return rpcError(err)
+		}
+		if err := ctx.Err(); err != nil {
+			return connect.NewError(connect.CodeCanceled, err)
+		}
+		if resume != nil && bytes.Equal(record.Key, resume) {
+			continue
+		}
+
+		page.Records = append(page.Records, &api.Record{Key: record.Key, Value: record.Value})
+		page.ResumeToken = record.Key
+		sent++
+
+		if len(page.Records) == pageSize {
+			if err := stream.Send(page); err != nil {
+				return err
+			}
+			page = &api.ScanResponse{}
+		}
+		if msg.Limit > 0 && sent >= int(msg.Limit) {
+			break
+		}
+	}
+
+	if len(page.Records) > 0 {
+		return stream.Send(page)
+	}
+	return nil
+}
+
+func (s *Secretary) Stats(ctx context.Context, req *connect.Request[api.StatsRequest]) (*connect.Response[api.StatsResponse], error) {
+	tree, err := s.Tree(req.Msg.CollectionName)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	stats, err := tree.Stats()
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	return connect.NewResponse(&api.StatsResponse{
+		Collection:        collectionMessage(tree),
+		Height:            uint32(stats.Height),
+		NumNodes:          stats.NumNodes,
+		LoadedNodes:       uint64(stats.LoadedNodes),
+		KeySeq:            stats.KeySeq,
+		ReclaimableBytes:  stats.ReclaimableBytes,
+		WalBytes:          uint64(stats.WALBytes),
+		CheckpointLsn:     stats.CheckpointLSN,
+		OpenSnapshots:     uint32(stats.OpenSnapshots),
+		CompactionRunning: stats.Compaction.Running,
+	}), nil
+}
+
+//------------------------------------------------------------------
+// Validation
+//------------------------------------------------------------------
+
+// validateInterceptor rejects requests that break the buf.validate rules of their fields
+type validateInterceptor struct{}
+
+func (validateInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if msg, ok := req.Any().(proto.Message); ok {
+			if err := validateMessage(msg.ProtoReflect()); err != nil {
+				return nil, connect.NewError(connect.CodeInvalidArgument, err)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+func (validateInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (validateInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		return next(ctx, validatingConn{conn})
+	}
+}
+
+// validatingConn validates every message a streaming handler receives
+type validatingConn struct {
+	connect.StreamingHandlerConn
+}
+
+func (conn validatingConn) Receive(msg any) error {
+	if err := conn.StreamingHandlerConn.Receive(msg); err != nil {
+		return err
+	}
+	if msg, ok := msg.(proto.Message); ok {
+		if err := validateMessage(msg.ProtoReflect()); err != nil {
+			return connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+	return nil
+}
+
+// validateMessage checks the fields of m and of the messages it holds
+func validateMessage(m protoreflect.Message) error {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+
+		rules, _ := proto.GetExtension(field.Options(), validate.E_Field).(*validate.FieldConstraints)
+		if rules != nil {
+			if err := validateField(m, field, rules); err != nil {
+				return err
+			}
+		}
+
+		if field.Kind() != protoreflect.MessageKind || !m.Has(field) || field.IsMap() {
+			continue
+		}
+		if field.IsList() {
+			list := m.Get(field).List()
+			for j := 0; j < list.Len(); j++ {
+				if err := validateMessage(list.Get(j).Message()); err != nil {
+					return err
+				}
+			}
+		} else if err := validateMessage(m.Get(field).Message()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateField checks one field against the rules secretary.proto uses
+func validateField(m protoreflect.Message, field protoreflect.FieldDescriptor, rules *validate.FieldConstraints) error {
+	name := string(field.FullName())
+	value := m.Get(field)
+
+	if rules.GetRequired() && !m.Has(field) {
+		return ErrorInvalidField(name, "is required")
+	}
+
+	if stringRules := rules.GetString_(); stringRules != nil {
+		length := uint64(utf8.RuneCountInString(value.String()))
+		if stringRules.HasMinLen() && length < stringRules.GetMinLen() {
+			return ErrorInvalidField(name, fmt.Sprintf("must be at least %d characters", stringRules.GetMinLen()))
+		}
+		if stringRules.HasMaxLen() && length > stringRules.GetMaxLen() {
+			return ErrorInvalidField(name, fmt.Sprintf("must be at most %d characters", stringRules.GetMaxLen()))
+		}
+	}
+
+	if bytesRules := rules.GetBytes(); bytesRules != nil {
+		length := uint64(len(value.Bytes()))
+		if bytesRules.HasMinLen() && length < bytesRules.GetMinLen() {
+			return ErrorInvalidField(name, fmt.Sprintf("must be at least %d bytes", bytesRules.GetMinLen()))
+		}
+		if bytesRules.HasMaxLen() && length > bytesRules.GetMaxLen() {
+			return ErrorInvalidField(name, fmt.Sprintf("must be at most %d bytes", bytesRules.GetMaxLen()))
+		}
+	}
+
+	if uint32Rules := rules.GetUint32(); uint32Rules != nil {
+		v := uint32(value.Uint())
+		if uint32Rules.HasGte() && v < uint32Rules.GetGte() {
+			return ErrorInvalidField(name, fmt.Sprintf("must be at least %d", uint32Rules.GetGte()))
+		}
+		if uint32Rules.HasLte() && v > uint32Rules.GetLte() {
+			return ErrorInvalidField(name, fmt.Sprintf("must be at most %d", uint32Rules.GetLte()))
+		}
+	}
+
+	if repeatedRules := rules.GetRepeated(); repeatedRules != nil {
+		items := uint64(value.List().Len())
+		if repeatedRules.HasMinItems() && items < repeatedRules.GetMinItems() {
+			return ErrorInvalidField(name, fmt.Sprintf("must have at least %d items", repeatedRules.GetMinItems()))
+		}
+		if repeatedRules.HasMaxItems() && items > repeatedRules.GetMaxItems() {
+			return ErrorInvalidField(name, fmt.Sprintf("must have at most %d items", repeatedRules.GetMaxItems()))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+//go:build !js
+
+package secretary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/codeharik/secretary/api"
+	"github.com/codeharik/secretary/api/apiconnect"
+	"github.com/codeharik/secretary/client"
+	"github.com/codeharik/secretary/utils"
+)
+
+func scanPages(t *testing.T, client apiconnect.SecretaryClient, msg *api.ScanRequest) ([]*api.ScanResponse, error) {
+	stream, err := client.Scan(context.Background(), connect.NewRequest(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	var pages []*api.ScanResponse
+	for stream.Receive() {
+		pages = append(pages, stream.Msg())
+	}
+	return pages, stream.Err()
+}
+
+func rpcServer(s *Secretary) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle(s.rpcHandler())
+	return httptest.NewServer(mux)
+}
+
+func TestRPCScan(t *testing.T) {
+	s := dummySecretary(t)
+	server := rpcServer(s)
+	defer server.Close()
+	client := apiconnect.NewSecretaryClient(server.Client(), server.URL)
+
+	u := dummyTree(t, s, 4)
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		if _, err := u.SetKV([]byte(key), []byte("value"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pages, err := scanPages(t, client, &api.ScanRequest{CollectionName: u.CollectionName, PageSize: 7, Limit: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 || len(pages[0].Records) != 7 || len(pages[2].Records) != 6 {
+		t.Fatal("Expected pages of 7 records up to the limit", len(pages))
+	}
+	if string(pages[1].ResumeToken) != "key13" || string(pages[2].Records[5].Key) != "key19" {
+		t.Fatal("Page mismatch", string(pages[1].ResumeToken))
+	}
+
+	// A lost stream continues after the last page received
+	pages, err = scanPages(t, client, &api.ScanRequest{CollectionName: u.CollectionName, PageSize: 100, ResumeToken: pages[1].ResumeToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || len(pages[0].Records) != 26 || string(pages[0].Records[0].Key) != "key14" {
+		t.Fatal("Resume mismatch", len(pages))
+	}
+
+	pages, err = scanPages(t, client, &api.ScanRequest{CollectionName: u.CollectionName, StartKey: []byte("key05"), EndKey: []byte("key30"), Reverse: true, PageSize: 5, ResumeToken: []byte("key10")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || len(pages[0].Records) != 5 || string(pages[0].Records[0].Key) != "key09" || string(pages[0].ResumeToken) != "key05" {
+		t.Fatal("Reverse resume mismatch", len(pages))
+	}
+
+	_, err = scanPages(t, client, &api.ScanRequest{CollectionName: "missingtree"})
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) || connectErr.Code() != connect.CodeNotFound {
+		t.Fatal("Expected a missing tree", err)
+	}
+
+	s.PagerShutdown()
+}
+
+func TestRPCClient(t *testing.T) {
+	s := dummySecretary(t)
+	server := rpcServer(s)
+	defer server.Close()
+	c := client.New(server.URL, client.WithHTTPClient(server.Client()))
+	ctx := context.Background()
+
+	name := utils.GenerateRandomString(16)
+	collection, err := c.CreateCollection(ctx, name, client.CollectionOptions{
+		Order: 4, NumLevel: 32, BaseSize: 1024, Increment: 125, CompactionBatchSize: 20, MaxKeySize: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collection.CollectionName != name || collection.Order != 4 || collection.MaxKeySize != 64 {
+		t.Fatal("Collection mismatch", collection)
+	}
+	if _, err := c.CreateCollection(ctx, name, client.CollectionOptions{
+		Order: 4, NumLevel: 32, BaseSize: 1024, Increment: 125, CompactionBatchSize: 20,
+	}); connect.CodeOf(err) != connect.CodeAlreadyExists {
+		t.Fatal("Expected an existing collection", err)
+	}
+
+	collections, err := c.ListCollections(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for i, collection := range collections {
+		found = found || collection.CollectionName == name
+		if i > 0 && collections[i-1].CollectionName >= collection.CollectionName {
+			t.Fatal("Collections not sorted")
+		}
+	}
+	if !found {
+		t.Fatal("Expected the new collection in the list")
+	}
+
+	if created, err := c.Set(ctx, name, []byte("alice"), []byte("1")); err != nil || !created {
+		t.Fatal("Expected a created key", err)
+	}
+	if created, err := c.Set(ctx, name, []byte("alice"), []byte("2")); err != nil || created {
+		t.Fatal("Expected an updated key", err)
+	}
+	if value, err := c.Get(ctx, name, []byte("alice")); err != nil || string(value) != "2" {
+		t.Fatal("Get mismatch", string(value), err)
+	}
+
+	records := []*api.Record{{Key: []byte("alice"), Value: []byte("3")}}
+	for i := 0; i < 30; i++ {
+		records = append(records, &api.Record{Key: []byte(fmt.Sprintf("user%02d", i)), Value: []byte(fmt.Sprint(i))})
+	}
+	if created, updated, err := c.BatchSet(ctx, name, records); err != nil || created != 30 || updated != 1 {
+		t.Fatal("BatchSet mismatch", created, updated, err)
+	}
+
+	if err := c.Delete(ctx, name, []byte("user05")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, name, []byte("user05")); !client.IsNotFound(err) {
+		t.Fatal("Expected a deleted key", err)
+	}
+
+	keys := []string{}
+	for record, err := range c.Scan(ctx, &api.ScanRequest{CollectionName: name, StartKey: []byte("user"), PageSize: 4, Limit: 10}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, string(record.Key))
+	}
+	if len(keys) != 10 || keys[0] != "user00" || keys[9] != "user10" {
+		t.Fatal("Scan mismatch", keys)
+	}
+
+	stats, err := c.Stats(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Collection.CollectionName != name || stats.Height < 2 || stats.NumNodes == 0 {
+		t.Fatal("Stats mismatch", stats)
+	}
+
+	if _, err := c.Stats(ctx, "missingtree"); !client.IsNotFound(err) {
+		t.Fatal("Expected a missing tree", err)
+	}
+
+	s.PagerShutdown()
+}
+
+func TestRPCValidation(t *testing.T) {
+	s := dummySecretary(t)
+	server := rpcServer(s)
+	defer server.Close()
+	c := client.New(server.URL, client.WithHTTPClient(server.Client()))
+	ctx := context.Background()
+
+	tree := dummyTree(t, s, 4)
+	options := client.CollectionOptions{Order: 4, NumLevel: 32, BaseSize: 1024, Increment: 125, CompactionBatchSize: 20}
+
+	invalid := map[string]error{}
+	_, invalid["short name"] = c.CreateCollection(ctx, "abc", options)
+	options.Order = 2
+	_, invalid["order"] = c.CreateCollection(ctx, utils.GenerateRandomString(16), options)
+	options.Order, options.MaxKeySize = 4, 4096
+	_, invalid["max key size"] = c.CreateCollection(ctx, utils.GenerateRandomString(16), options)
+	_, invalid["empty key"] = c.Set(ctx, tree.CollectionName, nil, []byte("value"))
+	_, _, invalid["no records"] = c.BatchSet(ctx, tree.CollectionName, nil)
+	_, _, invalid["empty record key"] = c.BatchSet(ctx, tree.CollectionName, []*api.Record{{Value: []byte("value")}})
+	for _, err := range c.Scan(ctx, &api.ScanRequest{}) {
+		invalid["scan collection"] = err
+	}
+
+	for rule, err := range invalid {
+		if connect.CodeOf(err) != connect.CodeInvalidArgument {
+			t.Fatal("Expected an invalid argument for", rule, err)
+		}
+	}
+
+	s.PagerShutdown()
+}
@@ -0,0 +1,206 @@
+package secretary
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/codeharik/secretary/utils/binstruct"
+	"github.com/codeharik/secretary/utils/dynamicstruct"
+	"github.com/codeharik/secretary/utils/file"
+)
+
+/*
+Schemas
+
+A collection can have a schema, the fields of its documents in the format of
+dynamicstruct :
+
+	{
+		"Name": {"type": "string", "tags": {"required": "true"}},
+		"Code": {"type": "string", "tags": {"regex": "^([A-Z]{3})?$", "error": "Code_must_be_3_letters"}},
+		"Age":  {"type": "int"}
+	}
+
+Types are int, float, string and bool, field names are exported Go
+identifiers. Values written to a collection with a schema are JSON documents,
+every write entry point decodes the document into the struct of the schema,
+rejects unknown fields, validates the required and regex tags and stores the
+binstruct encoding of the struct. A missing field holds its zero value, the
+regex of an optional field has to match it as well. Reads decode the stored
+value back to JSON, so callers only ever see JSON documents.
+
+The schema is saved to schema.json next to index.bin. Stored values are never
+re-encoded, a schema is only set or removed while the collection is empty.
+Erase keeps the schema, NewBTree drops it.
+*/
+
+const SCHEMA_FILE = "schema.json"
+
+// Schema is the compiled schema of a collection
+type Schema struct {
+	JSON string // Field definitions, every field has a bin tag with its name
+
+	ds *dynamicstruct.DynamicStruct
+}
+
+// ParseSchema compiles the fields of schemaJSON, bin tags are set to the field names
+func ParseSchema(schemaJSON string) (*Schema, error) {
+	var fields map[string]dynamicstruct.FieldSchema
+	if err := json.Unmarshal([]byte(schemaJSON), &fields); err != nil {
+		return nil, ErrorInvalidSchema(err)
+	}
+	if len(fields) == 0 {
+		return nil, ErrorSchemaEmpty
+	}
+
+	// binstruct only encodes tagged fields, in the order of their tags
+	for name, field := range fields {
+		if field.Tags == nil {
+			field.Tags = map[string]string{}
+		}
+		field.Tags["bin"] = name
+		fields[name] = field
+	}
+	tagged, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	ds, err := dynamicstruct.SchemaToStruct(string(tagged))
+	if err != nil {
+		return nil, ErrorInvalidSchema(err)
+	}
+	canonical, err := ds.ToSchema()
+	if err != nil {
+		return nil, ErrorInvalidSchema(err)
+	}
+
+	return &Schema{JSON: canonical, ds: ds}, nil
+}
+
+// encode validates the JSON document value and returns its binstruct encoding
+func (schema *Schema) encode(value []byte) ([]byte, error) {
+	document := schema.ds.NewInstance()
+
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(document.Instance.Addr().Interface()); err != nil {
+		return nil, ErrorSchemaDocument(err)
+	}
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return nil, ErrorSchemaDocument(errors.New("data after the document"))
+	}
+
+	if err := document.Validate(); err != nil {
+		return nil, ErrorSchemaDocument(err)
+	}
+
+	return binstruct.Serialize(document.Instance.Interface())
+}
+
+// decode returns the JSON document of a value stored by encode
+func (schema *Schema) decode(value []byte) ([]byte, error) {
+	document := schema.ds.NewInstance()
+	if err := binstruct.Deserialize(value, document.Instance.Addr().Interface()); err != nil {
+		return nil, err
+	}
+	return document.JsonMarshal()
+}
+
+// Schema returns the schema of the collection, nil without one
+func (tree *BTree) Schema() *Schema {
+	return tree.schema.Load()
+}
+
+// SetSchema sets the schema of an empty collection, an empty schemaJSON removes it
+func (tree *BTree) SetSchema(schemaJSON string) error {
+	var schema *Schema
+	if schemaJSON != "" {
+		var err error
+		if schema, err = ParseSchema(schemaJSON); err != nil {
+			return err
+		}
+	}
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	if tree.root != nil && len(tree.root.Keys) > 0 {
+		return ErrorSchemaNotEmpty
+	}
+	if schema != nil && tree.vectors.Load() != nil {
+		return ErrorVectorsSchema
+	}
+
+	if !MODE_WASM {
+		if err := saveSchema(tree.CollectionName, schema); err != nil {
+			return err
+		}
+	}
+	tree.schema.Store(schema)
+
+	return nil
+}
+
+// encodesValues reports whether values are stored encoded, by a schema or as vectors, and read as JSON documents
+func (tree *BTree) encodesValues() bool {
+	return tree.schema.Load() != nil || tree.vectors.Load() != nil
+}
+
+// encodeValue returns the stored form of value, value itself without a schema or vectors
+func (tree *BTree) encodeValue(value []byte) ([]byte, error) {
+	if vectors := tree.vectors.Load(); vectors != nil {
+		return vectors.options.encode(value)
+	}
+	schema := tree.schema.Load()
+	if schema == nil {
+		return value, nil
+	}
+	return schema.encode(value)
+}
+
+// decodeValue returns the JSON document of a stored value, value itself without a schema or vectors
+func (tree *BTree) decodeValue(value []byte) ([]byte, error) {
+	if vectors := tree.vectors.Load(); vectors != nil {
+		return vectors.options.decode(value)
+	}
+	schema := tree.schema.Load()
+	if schema == nil {
+		return value, nil
+	}
+	return schema.decode(value)
+}
+
+func schemaPath(collectionName string) string {
+	return fmt.Sprintf("%s/%s/%s", SECRETARY, collectionName, SCHEMA_FILE)
+}
+
+// saveSchema replaces schema.json, a nil schema removes it
+func saveSchema(collectionName string, schema *Schema) error {
+	path := schemaPath(collectionName)
+	if schema == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	// Written aside and renamed, a crash leaves the previous schema or the new one
+	return file.ReplaceFile(path, []byte(schema.JSON))
+}
+
+// loadSchema reads schema.json, nil if the collection has no schema
+func loadSchema(collectionName string) (*Schema, error) {
+	data, err := os.ReadFile(schemaPath(collectionName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchema(string(data))
+}
@@ -0,0 +1,164 @@
+package secretary
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"Name":  {"type": "string", "tags": {"required": "true"}},
+	"Code":  {"type": "string", "tags": {"regex": "^([A-Z]{3})?$", "error": "Code_must_be_3_letters"}},
+	"Age":   {"type": "int"},
+	"Score": {"type": "float"},
+	"Admin": {"type": "bool"}
+}`
+
+func TestSchema(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	for schema, expected := range map[string]string{
+		`{}`:                            ErrorSchemaEmpty.Error(),
+		`[`:                             "Invalid schema",
+		`{"name": {"type": "string"}}`:  "Invalid schema",
+		`{"Name": {"type": "complex"}}`: "Invalid schema",
+	} {
+		if err := tree.SetSchema(schema); err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Fatal("Expected", expected, "for", schema, "got", err)
+		}
+	}
+	if err := tree.SetSchema(testSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	document := `{"Name": "Ada", "Code": "ADA", "Age": 36, "Score": 9.5, "Admin": true}`
+	if _, err := tree.SetKV([]byte("ada"), []byte(document)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored in the binstruct encoding, read back as JSON
+	tree.mu.Lock()
+	leaf, index, _, err := tree.getLeafNode([]byte("ada"))
+	tree.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := leaf.records[index].Value; json.Valid(stored) || len(stored) >= len(document) {
+		t.Fatal("Expected a compact encoding", stored)
+	}
+	checkDocument := func(key string, expected string) {
+		t.Helper()
+		record, err := tree.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, want map[string]any
+		if err := json.Unmarshal(record.Value, &got); err != nil {
+			t.Fatal(err, string(record.Value))
+		}
+		json.Unmarshal([]byte(expected), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatal("Document mismatch", string(record.Value))
+		}
+	}
+	checkDocument("ada", document)
+
+	for _, invalid := range []string{
+		`{"Code": "ABC"}`,
+		`{"Name": "Bob", "Code": "abc"}`,
+		`{"Name": "Bob", "Extra": 1}`,
+		`{"Name": "Bob", "Age": 1.5}`,
+		`{"Name": "Bob"} {}`,
+		`not json`,
+	} {
+		if _, err := tree.SetKV([]byte("bob"), []byte(invalid)); err == nil || !strings.HasPrefix(err.Error(), "Document does not match the schema") {
+			t.Fatal("Expected", invalid, "rejected, got", err)
+		}
+	}
+	if _, err := tree.SetKV([]byte("bob"), []byte(`{"Name": "Bob", "Code": "abc"}`)); !strings.HasSuffix(fmt.Sprint(err), "Code must be 3 letters") {
+		t.Fatal("Expected the error tag message", err)
+	}
+
+	if err := tree.Update([]byte("ada"), []byte(`{"Name": "Ada", "Age": 37}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Update([]byte("ada"), []byte(`{"Age": 38}`)); err == nil {
+		t.Fatal("Expected an invalid update rejected")
+	}
+	checkDocument("ada", `{"Name": "Ada", "Code": "", "Age": 37, "Score": 0, "Admin": false}`)
+
+	results, err := tree.ApplyBatch([]Op{
+		{Type: OP_PUT, Key: []byte("bob"), Value: []byte(`{"Name": "Bob"}`)},
+		{Type: OP_PUT, Key: []byte("eve"), Value: []byte(`{"Name": 5}`)},
+	})
+	if err != nil || results[0].Err != nil || results[1].Err == nil {
+		t.Fatal("Expected only the valid put applied", err, results)
+	}
+
+	txn := s.Begin()
+	if err := txn.Set(tree.CollectionName, []byte("cy"), []byte(`{"Name": "Cy", "Score": 1.25}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set(tree.CollectionName, []byte("dan"), []byte(`{"Score": 1}`)); err == nil {
+		t.Fatal("Expected an invalid transaction write rejected")
+	}
+	if value, err := txn.Get(tree.CollectionName, []byte("cy")); err != nil || !json.Valid(value) {
+		t.Fatal("Expected the buffered write as JSON", err, string(value))
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.ExecSQL(fmt.Sprintf("SELECT key, Score FROM %s WHERE Score > 1 OR Age > 30", tree.CollectionName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]any{{"key": "ada", "Score": float64(0)}, {"key": "cy", "Score": 1.25}}
+	if !reflect.DeepEqual(result.Rows, expected) {
+		t.Fatal("SQL mismatch", result.Rows)
+	}
+
+	if err := tree.SetSchema(testSchema); err != ErrorSchemaNotEmpty {
+		t.Fatal("Expected the schema of a non empty collection kept", err)
+	}
+
+	// Schema and values survive a restart, from the pages and from the WAL
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.SetKV([]byte("dan"), []byte(`{"Name": "Dan", "Admin": true}`)); err != nil {
+		t.Fatal(err)
+	}
+	schemaJSON := tree.Schema().JSON
+
+	tree, err = s.NewBTreeReadHeader(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Schema() == nil || tree.Schema().JSON != schemaJSON {
+		t.Fatal("Expected the schema reloaded", tree.Schema())
+	}
+	checkDocument("ada", `{"Name": "Ada", "Code": "", "Age": 37, "Score": 0, "Admin": false}`)
+	checkDocument("dan", `{"Name": "Dan", "Code": "", "Age": 0, "Score": 0, "Admin": true}`)
+
+	// Erase keeps the schema, a new tree of the same name drops it
+	if err := tree.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Schema() == nil {
+		t.Fatal("Expected Erase to keep the schema")
+	}
+	tree, err = s.NewBTree(tree.CollectionName, 4, 32, 1024, 125, 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(schemaPath(tree.CollectionName)); tree.Schema() != nil || !os.IsNotExist(err) {
+		t.Fatal("Expected the schema dropped", err)
+	}
+
+	s.PagerShutdown()
+}
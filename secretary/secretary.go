@@ -1,6 +1,7 @@
 package secretary
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
@@ -40,6 +41,13 @@ func New(MODEWASM *bool) (*Secretary, error) {
 		return nil, err
 	}
 
+	txnLog, err := NewTxnLog()
+	if err != nil {
+		return nil, err
+	}
+	secretary.txnLog = txnLog
+
+	failed := []string{}
 	for _, file := range files {
 		if file.IsDir() {
 
@@ -49,10 +57,15 @@ func New(MODEWASM *bool) (*Secretary, error) {
 				startMessage += "\n*" + file.Name()
 			} else if err != nil {
 				startMessage += "\n" + file.Name() + " " + err.Error()
+				failed = append(failed, file.Name())
 			}
 		}
 	}
 
+	if err := secretary.resetTxnLog(failed); err != nil {
+		startMessage += "\nTransaction log " + err.Error()
+	}
+
 	utils.Log(startMessage)
 
 	return secretary, nil
@@ -67,9 +80,50 @@ func (s *Secretary) Tree(name string) (*BTree, error) {
 }
 
 func (s *Secretary) AddTree(tree *BTree) {
+	tree.txnLog = s.txnLog
 	s.trees[tree.CollectionName] = tree
 }
 
+// resetTxnLog checkpoints the replayed transactions, only the ids the WALs of failed trees still hold are kept
+func (s *Secretary) resetTxnLog(failed []string) error {
+	if s.txnLog == nil || s.txnLog.size == 0 {
+		return nil
+	}
+
+	for _, tree := range s.trees {
+		if tree.wal == nil || tree.wal.size == 0 {
+			continue
+		}
+		if err := tree.Checkpoint(); err != nil {
+			return err
+		}
+	}
+
+	keep := []uint64{}
+	for _, collectionName := range failed {
+		temptree := BTree{CollectionName: collectionName}
+		wal, err := temptree.NewWAL()
+		if err != nil {
+			return err
+		}
+		entries, err := wal.ReadEntries()
+		wal.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if entry.Op == WAL_TXN && len(entry.Key) == 8 {
+				if id := binary.BigEndian.Uint64(entry.Key); s.txnLog.Committed(id) {
+					keep = append(keep, id)
+				}
+			}
+		}
+	}
+
+	return s.txnLog.Reset(keep)
+}
+
 func (s *Secretary) Shutdown() {
 	s.PagerShutdown()
 	s.ServerShutdown()
@@ -84,5 +138,8 @@ func (s *Secretary) PagerShutdown() error {
 		}
 		i++
 	}
+	if s.txnLog != nil {
+		closingErrors = append(closingErrors, s.txnLog.Close())
+	}
 	return errors.Join(closingErrors...)
 }
@@ -15,6 +15,7 @@ func TestSecretary(t *testing.T) {
 		1024,
 		125,
 		1000,
+		0,
 	)
 
 	imagesTree, imagesErr := s.NewBTree(
@@ -24,6 +25,7 @@ func TestSecretary(t *testing.T) {
 		1024*1024,
 		125,
 		1000,
+		0,
 	)
 	if userErr != nil || imagesErr != nil {
 		t.Fatal(userErr, imagesErr)
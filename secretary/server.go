@@ -3,9 +3,13 @@
 package secretary
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"iter"
 	"log"
 	"net"
 	"net/http"
@@ -16,7 +20,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/codeharik/secretary/api/apiconnect"
 	"github.com/rs/cors"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
@@ -31,7 +34,9 @@ func writeJson(w http.ResponseWriter, data []byte, err error) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(data)
 
+	commandLogsMu.Lock()
 	COMMAND_LOGS = ""
+	commandLogsMu.Unlock()
 }
 
 func (s *Secretary) getAllTreeHandler(w http.ResponseWriter, r *http.Request) {
@@ -52,6 +57,7 @@ type NewTreeRequest struct {
 	BaseSize            uint32 `json:"BaseSize"`
 	Increment           uint8  `json:"Increment"`
 	CompactionBatchSize uint32 `json:"compactionBatchSize"`
+	MaxKeySize          uint16 `json:"maxKeySize"`
 }
 
 func (s *Secretary) newTreeHandler(w http.ResponseWriter, r *http.Request) {
@@ -67,7 +73,8 @@ func (s *Secretary) newTreeHandler(w http.ResponseWriter, r *http.Request) {
 		int(req.NumLevel),
 		int(req.BaseSize),
 		int(req.Increment),
-		int(req.CompactionBatchSize))
+		int(req.CompactionBatchSize),
+		int(req.MaxKeySize))
 	writeJson(w, data, err)
 }
 
@@ -106,10 +113,431 @@ func (s *Secretary) getRecordHandler(w http.ResponseWriter, r *http.Request) {
 	collectionName := r.PathValue("collectionName")
 	id := r.PathValue("id")
 
+	// Reads as of an open snapshot with ?snapshot=<token>
+	if token := r.URL.Query().Get("snapshot"); token != "" {
+		data, err := s.HandleGetSnapshotRecord(collectionName, id, token)
+		writeJson(w, data, err)
+		return
+	}
+
 	data, err := s.HandleGetRecord(collectionName, id)
 	writeJson(w, data, err)
 }
 
+func (s *Secretary) newSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	data, err := s.HandleNewSnapshot(collectionName)
+	writeJson(w, data, err)
+}
+
+func (s *Secretary) releaseSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+	token := r.PathValue("snapshot")
+
+	data, err := s.HandleReleaseSnapshot(collectionName, token)
+	writeJson(w, data, err)
+}
+
+// BatchRequest is the body of POST /batch, ops apply in order
+type BatchRequest struct {
+	Ops []struct {
+		Op    string `json:"op"` // "put" or "delete"
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"ops"`
+}
+
+func (s *Secretary) batchHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Ops) == 0 {
+		http.Error(w, ErrorInvalidJson.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ops := make([]Op, len(req.Ops))
+	for i, op := range req.Ops {
+		ops[i] = Op{Key: []byte(op.Key), Value: []byte(op.Value)}
+		switch op.Op {
+		case "put":
+			ops[i].Type = OP_PUT
+		case "delete":
+			ops[i].Type = OP_DELETE
+		default:
+			http.Error(w, fmt.Sprintf("Invalid op %q at %d", op.Op, i), http.StatusBadRequest)
+			return
+		}
+	}
+
+	data, err := s.HandleBatch(collectionName, ops)
+	writeJson(w, data, err)
+}
+
+// loadHandler bulk loads the sorted records of the body, ?format=csv|ndjson|dump and an optional ?fill factor
+func (s *Secretary) loadHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+	query := r.URL.Query()
+
+	options := BulkLoadOptions{}
+	if fill := query.Get("fill"); fill != "" {
+		value, err := strconv.ParseFloat(fill, 64)
+		if err != nil {
+			http.Error(w, ErrorInvalidFillFactor.Error(), http.StatusBadRequest)
+			return
+		}
+		options.FillFactor = value
+	}
+
+	records, err := LoadRecords(query.Get("format"), r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := s.HandleBulkLoad(collectionName, records, options)
+	writeJson(w, data, err)
+}
+
+// dumpHandler streams every record of a collection in the format of DumpRecords
+func (s *Secretary) dumpHandler(w http.ResponseWriter, r *http.Request) {
+	tree, err := s.Tree(r.PathValue("collectionName"))
+	if err != nil {
+		writeJson(w, nil, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := tree.Dump(w); err != nil {
+		ServerLog("Dump", tree.CollectionName, err)
+	}
+}
+
+func (s *Secretary) getSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	data, err := s.HandleGetSchema(collectionName)
+	writeJson(w, data, err)
+}
+
+// setSchemaHandler sets the schema of the body, a JSON object of fields, on an empty collection
+func (s *Secretary) setSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schemaJSON := strings.TrimSpace(string(body))
+	if schemaJSON != "" {
+		if _, err := ParseSchema(schemaJSON); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	data, err := s.HandleSetSchema(collectionName, schemaJSON)
+	writeJson(w, data, err)
+}
+
+func (s *Secretary) getVectorsHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	data, err := s.HandleGetVectors(collectionName)
+	writeJson(w, data, err)
+}
+
+// setVectorsHandler makes an empty collection a vector collection with the VectorOptions of the body,
+// an empty body makes it a collection of raw values
+func (s *Secretary) setVectorsHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var options *VectorOptions
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &options); err != nil || options == nil {
+			http.Error(w, ErrorInvalidJson.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkVectorOptions(options.withDefaults()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	data, err := s.HandleSetVectors(collectionName, options)
+	writeJson(w, data, err)
+}
+
+// buildVectorsHandler rebuilds the vector graph from the stored documents
+func (s *Secretary) buildVectorsHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	data, err := s.HandleBuildVectors(collectionName)
+	writeJson(w, data, err)
+}
+
+// knnHandler returns the documents nearest to the KNNQuery of the body
+func (s *Secretary) knnHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	var query KNNQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		http.Error(w, ErrorInvalidJson.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := query.check(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := s.HandleKNN(collectionName, query)
+	writeJson(w, data, err)
+}
+
+// SQLRequest is the body of POST /sql
+type SQLRequest struct {
+	Query  string `json:"query"`
+	Params []any  `json:"params,omitempty"` // Values of the ? and $n placeholders
+}
+
+// sqlHandler runs one statement, see ExecSQL
+func (s *Secretary) sqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req SQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Query == "" {
+		http.Error(w, ErrorInvalidJson.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := s.HandleSQL(req.Query, req.Params)
+	if errors.As(err, new(*SQLSyntaxError)) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, data, err)
+}
+
+// RangeRecord is one line of a /range response
+type RangeRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+const RANGE_FLUSH_RECORDS = 100 // Records written between two flushes of a /range response
+
+// rangeHandler streams the records in [start, end] as NDJSON, an error after the first line is sent as {"error": ...}
+func (s *Secretary) rangeHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+	query := r.URL.Query()
+
+	options := ScanOptions{}
+	if start := query.Get("start"); start != "" {
+		options.StartKey = []byte(start)
+	}
+	if end := query.Get("end"); end != "" {
+		options.EndKey = []byte(end)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		options.Limit = value
+	}
+	if reverse := query.Get("reverse"); reverse != "" {
+		value, err := strconv.ParseBool(reverse)
+		if err != nil {
+			http.Error(w, "Invalid reverse", http.StatusBadRequest)
+			return
+		}
+		options.Reverse = value
+	}
+
+	var records iter.Seq2[*Record, error]
+	var err error
+	if field := query.Get("index"); field != "" {
+		if options.Reverse || query.Get("snapshot") != "" {
+			http.Error(w, ErrorIndexScanOptions.Error(), http.StatusBadRequest)
+			return
+		}
+		records, err = s.HandleRangeIndex(collectionName, field, indexBound(query.Get("start")), indexBound(query.Get("end")), options.Limit)
+	} else {
+		records, err = s.HandleRange(collectionName, options, query.Get("snapshot"))
+	}
+	if err != nil {
+		writeJson(w, nil, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	written := 0
+	for record, err := range records {
+		if err != nil {
+			encoder.Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+
+		if err := encoder.Encode(RangeRecord{Key: string(record.Key), Value: string(record.Value)}); err != nil {
+			return
+		}
+		written++
+		if flusher != nil && written%RANGE_FLUSH_RECORDS == 0 {
+			flusher.Flush()
+		}
+	}
+}
+
+// indexBound reads a bound of an index scan as a JSON string, number or boolean, other text is a string
+func indexBound(bound string) any {
+	if bound == "" {
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal([]byte(bound), &value); err == nil {
+		switch value.(type) {
+		case string, float64, bool:
+			return value
+		}
+	}
+	return bound
+}
+
+func (s *Secretary) getIndexesHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	data, err := s.HandleGetIndexes(collectionName)
+	writeJson(w, data, err)
+}
+
+// createIndexHandler indexes a field and builds the index from the stored documents
+func (s *Secretary) createIndexHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+	field := r.PathValue("field")
+
+	if err := checkIndexField(field); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := s.HandleCreateIndex(collectionName, field)
+	writeJson(w, data, err)
+}
+
+func (s *Secretary) dropIndexHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+	field := r.PathValue("field")
+
+	data, err := s.HandleDropIndex(collectionName, field)
+	writeJson(w, data, err)
+}
+
+// buildIndexHandler rebuilds an index from the stored documents
+func (s *Secretary) buildIndexHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+	field := r.PathValue("field")
+
+	data, err := s.HandleBuildIndex(collectionName, field)
+	writeJson(w, data, err)
+}
+
+// searchHandler returns the documents matching q, best first, at most limit or TEXT_SEARCH_LIMIT
+func (s *Secretary) searchHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+	query := r.URL.Query()
+
+	if _, err := parseTextQuery(query.Get("q")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := TEXT_SEARCH_LIMIT
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	data, err := s.HandleSearch(collectionName, query.Get("q"), limit)
+	writeJson(w, data, err)
+}
+
+func (s *Secretary) getTextIndexHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	data, err := s.HandleGetTextIndex(collectionName)
+	writeJson(w, data, err)
+}
+
+// suggestHandler returns the indexed terms close to every word of q, at most limit or TEXT_SEARCH_LIMIT per word
+func (s *Secretary) suggestHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+	query := r.URL.Query()
+
+	if len(textTerms(query.Get("q"))) == 0 {
+		http.Error(w, ErrorSearchQuery("no words").Error(), http.StatusBadRequest)
+		return
+	}
+	limit := TEXT_SEARCH_LIMIT
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	data, err := s.HandleSuggest(collectionName, query.Get("q"), limit)
+	writeJson(w, data, err)
+}
+
+// createTextIndexHandler indexes the words of the comma separated fields, in the fuzzy mode of the
+// optional fuzzy alphabet, and builds the index
+func (s *Secretary) createTextIndexHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+	query := r.URL.Query()
+
+	options := TextIndexOptions{Fields: strings.Split(query.Get("fields"), ","), Fuzzy: query.Get("fuzzy")}
+	if _, err := checkTextIndexOptions(options); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := s.HandleCreateTextIndex(collectionName, options)
+	writeJson(w, data, err)
+}
+
+func (s *Secretary) dropTextIndexHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	data, err := s.HandleDropTextIndex(collectionName)
+	writeJson(w, data, err)
+}
+
+// buildTextIndexHandler rebuilds the text index from the stored documents
+func (s *Secretary) buildTextIndexHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	data, err := s.HandleBuildTextIndex(collectionName)
+	writeJson(w, data, err)
+}
+
 func (s *Secretary) deleteRecordHandler(w http.ResponseWriter, r *http.Request) {
 	collectionName := r.PathValue("collectionName")
 	id := r.PathValue("id")
@@ -126,15 +554,62 @@ func (s *Secretary) clearTreeHandler(w http.ResponseWriter, r *http.Request) {
 	writeJson(w, data, err)
 }
 
+func (s *Secretary) getCompactionHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	data, err := s.HandleGetCompaction(collectionName)
+	writeJson(w, data, err)
+}
+
+func (s *Secretary) startCompactionHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	data, err := s.HandleStartCompaction(collectionName)
+	writeJson(w, data, err)
+}
+
+func (s *Secretary) stopCompactionHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := r.PathValue("collectionName")
+
+	data, err := s.HandleStopCompaction(collectionName)
+	writeJson(w, data, err)
+}
+
 func (s *Secretary) setupRouter(mux *http.ServeMux) http.Handler {
 	mux.HandleFunc("GET /getalltree", s.getAllTreeHandler)
 	mux.HandleFunc("GET /gettree/{collectionName}", s.getTreeHandler)
 	mux.HandleFunc("POST /newtree", s.newTreeHandler)
 	mux.HandleFunc("POST /set/{collectionName}", s.setRecordHandler)
 	mux.HandleFunc("POST /sortedset/{collectionName}/{value}", s.sortedSetRecordHandler)
+	mux.HandleFunc("POST /batch/{collectionName}", s.batchHandler)
+	mux.HandleFunc("POST /load/{collectionName}", s.loadHandler)
+	mux.HandleFunc("GET /dump/{collectionName}", s.dumpHandler)
+	mux.HandleFunc("POST /sql", s.sqlHandler)
+	mux.HandleFunc("GET /schema/{collectionName}", s.getSchemaHandler)
+	mux.HandleFunc("POST /schema/{collectionName}", s.setSchemaHandler)
+	mux.HandleFunc("GET /vectors/{collectionName}", s.getVectorsHandler)
+	mux.HandleFunc("POST /vectors/{collectionName}", s.setVectorsHandler)
+	mux.HandleFunc("POST /vectors/{collectionName}/build", s.buildVectorsHandler)
+	mux.HandleFunc("POST /knn/{collectionName}", s.knnHandler)
+	mux.HandleFunc("GET /index/{collectionName}", s.getIndexesHandler)
+	mux.HandleFunc("POST /index/{collectionName}/{field}", s.createIndexHandler)
+	mux.HandleFunc("DELETE /index/{collectionName}/{field}", s.dropIndexHandler)
+	mux.HandleFunc("POST /index/{collectionName}/{field}/build", s.buildIndexHandler)
+	mux.HandleFunc("GET /search/{collectionName}", s.searchHandler)
+	mux.HandleFunc("GET /suggest/{collectionName}", s.suggestHandler)
+	mux.HandleFunc("GET /textindex/{collectionName}", s.getTextIndexHandler)
+	mux.HandleFunc("POST /textindex/{collectionName}", s.createTextIndexHandler)
+	mux.HandleFunc("DELETE /textindex/{collectionName}", s.dropTextIndexHandler)
+	mux.HandleFunc("POST /textindex/{collectionName}/build", s.buildTextIndexHandler)
 	mux.HandleFunc("GET /get/{collectionName}/{id}", s.getRecordHandler)
 	mux.HandleFunc("DELETE /delete/{collectionName}/{id}", s.deleteRecordHandler)
+	mux.HandleFunc("GET /range/{collectionName}", s.rangeHandler)
 	mux.HandleFunc("DELETE /clear/{collectionName}", s.clearTreeHandler)
+	mux.HandleFunc("GET /compaction/{collectionName}", s.getCompactionHandler)
+	mux.HandleFunc("POST /compaction/{collectionName}/start", s.startCompactionHandler)
+	mux.HandleFunc("POST /compaction/{collectionName}/stop", s.stopCompactionHandler)
+	mux.HandleFunc("POST /snapshot/{collectionName}", s.newSnapshotHandler)
+	mux.HandleFunc("DELETE /snapshot/{collectionName}/{snapshot}", s.releaseSnapshotHandler)
 
 	// Enable CORS with custom settings
 	handler := cors.New(cors.Options{
@@ -159,7 +634,7 @@ func (s *Secretary) Serve() {
 	}
 
 	mux := http.NewServeMux()
-	mux.Handle(apiconnect.NewSecretaryHandler(&s))
+	mux.Handle(s.rpcHandler())
 
 	handler := s.setupRouter(mux)
 
@@ -4,16 +4,23 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"iter"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/codeharik/secretary/utils"
 )
 
 var COMMAND_LOGS = ""
+var commandLogsMu sync.Mutex // Concurrent requests log to the same COMMAND_LOGS
 
 func ServerLog(msgs ...any) {
 	if !MODE_TEST {
 		msg, _ := utils.LogMessage(msgs...)
+		commandLogsMu.Lock()
+		defer commandLogsMu.Unlock()
 		COMMAND_LOGS += fmt.Sprintf("<div style='color:%s;background:#000'>%s</div><br>", utils.LightColor().Hex, strings.ReplaceAll(msg, "\n", "<br>"))
 	}
 }
@@ -24,6 +31,9 @@ type JsonResponse struct {
 }
 
 func makeJson(data any) ([]byte, error) {
+	commandLogsMu.Lock()
+	defer commandLogsMu.Unlock()
+
 	response := JsonResponse{
 		Data: data,
 		Logs: COMMAND_LOGS,
@@ -47,7 +57,12 @@ func (s *Secretary) HandleGetTree(collectionName string) ([]byte, error) {
 		return nil, ErrorTreeNotFound
 	}
 
-	jsonData, err := makeJson(tree.ToJSON())
+	treeJSON, err := tree.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, err := makeJson(treeJSON)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +71,7 @@ func (s *Secretary) HandleGetTree(collectionName string) ([]byte, error) {
 	return jsonData, errors.Join(errs...)
 }
 
-func (s *Secretary) HandleNewTree(collectionName string, order int, numLevel int, baseSize int, increment int, compactionBatchSize int) ([]byte, error) {
+func (s *Secretary) HandleNewTree(collectionName string, order int, numLevel int, baseSize int, increment int, compactionBatchSize int, maxKeySize int) ([]byte, error) {
 	tree, err := s.NewBTree(
 		collectionName,
 		uint8(order),
@@ -64,6 +79,7 @@ func (s *Secretary) HandleNewTree(collectionName string, order int, numLevel int
 		uint32(baseSize),
 		uint8(increment),
 		uint32(compactionBatchSize),
+		uint16(maxKeySize),
 	)
 	if err != nil {
 		return nil, err
@@ -83,8 +99,12 @@ func (s *Secretary) HandleSetRecord(collectionName string, reqKey string, reqVal
 
 	key := []byte(reqKey)
 
-	if len(reqKey) == 0 || len(reqKey) != KEY_SIZE {
-		key = []byte(utils.GenerateSeqString(&tree.KeySeq, KEY_SIZE, KEY_INCREMENT))
+	if tree.checkKey(key) != nil {
+		keySize := KEY_SIZE
+		if tree.MaxKeySize != 0 {
+			keySize = min(KEY_SIZE, int(tree.MaxKeySize))
+		}
+		key = []byte(utils.GenerateSeqString(&tree.KeySeq, keySize, KEY_INCREMENT))
 		_, err = tree.SetKV(key, []byte(reqValue))
 	} else {
 		_, err = tree.SetKV(key, []byte(reqValue))
@@ -113,6 +133,195 @@ func (s *Secretary) HandleSetRecord(collectionName string, reqKey string, reqVal
 	return data, err
 }
 
+// BatchResult is the outcome of one op of a /batch request
+type BatchResult struct {
+	Key     string `json:"key"`
+	Created bool   `json:"created"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (s *Secretary) HandleBatch(collectionName string, ops []Op) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	results, err := tree.ApplyBatch(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	batchResults := make([]BatchResult, len(results))
+	applied := 0
+	for i, result := range results {
+		batchResults[i] = BatchResult{Key: string(ops[i].Key), Created: result.Created}
+		if result.Err != nil {
+			batchResults[i].Error = result.Err.Error()
+		} else {
+			applied++
+		}
+	}
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"applied":        applied,
+		"results":        batchResults,
+	}
+
+	return makeJson(response)
+}
+
+func (s *Secretary) HandleBulkLoad(collectionName string, records iter.Seq2[*Record, error], options BulkLoadOptions) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	result, err := tree.BulkLoad(records, options)
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("%d records loaded before the error", result.Loaded))
+	}
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"result":         result,
+	}
+
+	return makeJson(response)
+}
+
+func (s *Secretary) HandleSQL(query string, params []any) ([]byte, error) {
+	result, err := s.ExecSQL(query, params...)
+	if err != nil {
+		if result != nil {
+			err = errors.Join(err, fmt.Errorf("%d rows affected", result.Affected))
+		}
+		return nil, err
+	}
+
+	return makeJson(result)
+}
+
+// recordJSON returns the document of a collection with a schema or vectors as JSON, other values as bytes
+func recordJSON(tree *BTree, value []byte) any {
+	if tree.encodesValues() {
+		return json.RawMessage(value)
+	}
+	return value
+}
+
+func (s *Secretary) HandleGetSchema(collectionName string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	var schema json.RawMessage
+	if current := tree.Schema(); current != nil {
+		schema = json.RawMessage(current.JSON)
+	}
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"schema":         schema,
+	}
+	return makeJson(response)
+}
+
+// HandleSetSchema sets the schema of an empty collection, an empty schemaJSON removes it
+func (s *Secretary) HandleSetSchema(collectionName string, schemaJSON string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	if err := tree.SetSchema(schemaJSON); err != nil {
+		return nil, err
+	}
+
+	return s.HandleGetSchema(collectionName)
+}
+
+// HandleGetVectors returns the vector options and graph of a collection, null without vectors
+func (s *Secretary) HandleGetVectors(collectionName string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	var vectors *VectorIndexStats
+	stats, err := tree.VectorIndex()
+	if err == nil {
+		vectors = &stats
+	} else if !errors.Is(err, ErrorVectorsNotFound) {
+		return nil, err
+	}
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"vectors":        vectors,
+	}
+	return makeJson(response)
+}
+
+// HandleSetVectors sets the vector options of an empty collection, nil options remove them
+func (s *Secretary) HandleSetVectors(collectionName string, options *VectorOptions) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	if err := tree.SetVectorOptions(options); err != nil {
+		return nil, err
+	}
+
+	return s.HandleGetVectors(collectionName)
+}
+
+func (s *Secretary) HandleBuildVectors(collectionName string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	if err := tree.BuildVectorIndex(); err != nil {
+		return nil, err
+	}
+
+	return s.HandleGetVectors(collectionName)
+}
+
+// KNNHit is a document of a /knn response
+type KNNHit struct {
+	Key      string          `json:"key"`
+	Value    json.RawMessage `json:"value"`
+	Distance float32         `json:"distance"`
+}
+
+// HandleKNN returns the documents nearest to the query vector, nearest first
+func (s *Secretary) HandleKNN(collectionName string, query KNNQuery) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	results, err := tree.KNN(query)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]KNNHit, len(results))
+	for i, result := range results {
+		hits[i] = KNNHit{Key: string(result.Record.Key), Value: result.Record.Value, Distance: result.Distance}
+	}
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"results":        hits,
+	}
+	return makeJson(response)
+}
+
 func (s *Secretary) HandleSortedSetRecord(collectionName string, value int) ([]byte, error) {
 	tree, exists := s.trees[collectionName]
 	if !exists {
@@ -143,13 +352,29 @@ func (s *Secretary) HandleGetRecord(collectionName string, key string) ([]byte,
 		return nil, ErrorTreeNotFound
 	}
 
-	node, index, found := tree.getLeafNode([]byte(key))
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	node, err := tree.readLeaf([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, ErrorKeyNotFound
+	}
+	defer node.mu.RUnlock()
+
+	index, found := node.getKey([]byte(key))
 	if found {
+		record, err := tree.readValue(node.records[index])
+		if err != nil {
+			return nil, err
+		}
 		response := map[string]any{
 			"collectionName": collectionName,
 			"nodeID":         node.NodeID,
 			"found":          found,
-			"record":         node.records[index].Value,
+			"record":         recordJSON(tree, record.Value),
 		}
 		return makeJson(response)
 	}
@@ -157,6 +382,291 @@ func (s *Secretary) HandleGetRecord(collectionName string, key string) ([]byte,
 	return nil, ErrorKeyNotFound
 }
 
+func (s *Secretary) HandleNewSnapshot(collectionName string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	snapshot := tree.Snapshot()
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"snapshot":       strconv.FormatUint(snapshot.ID, 10),
+	}
+	return makeJson(response)
+}
+
+func (s *Secretary) HandleReleaseSnapshot(collectionName string, token string) ([]byte, error) {
+	snapshot, err := s.snapshot(collectionName, token)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot.Release()
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"result":         "Release success " + token,
+	}
+	return makeJson(response)
+}
+
+func (s *Secretary) HandleGetSnapshotRecord(collectionName string, key string, token string) ([]byte, error) {
+	snapshot, err := s.snapshot(collectionName, token)
+	if err != nil {
+		return nil, err
+	}
+	tree := s.trees[collectionName]
+
+	record, err := snapshot.Get([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"snapshot":       token,
+		"found":          true,
+		"record":         recordJSON(tree, record.Value),
+	}
+	return makeJson(response)
+}
+
+// HandleRange returns the records of a collection selected by options, as of the snapshot token if not empty
+func (s *Secretary) HandleRange(collectionName string, options ScanOptions, token string) (iter.Seq2[*Record, error], error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	if token == "" {
+		return tree.Iterate(options), nil
+	}
+
+	snapshot, err := s.snapshot(collectionName, token)
+	if err != nil {
+		return nil, err
+	}
+	if options.Reverse {
+		return nil, ErrorSnapshotReverse
+	}
+	return snapshot.Iterate(options), nil
+}
+
+// HandleRangeIndex returns the records whose indexed field is between low and high, at most limit unless it is 0
+func (s *Secretary) HandleRangeIndex(collectionName string, field string, low any, high any, limit int) (iter.Seq2[*Record, error], error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+	if !slices.Contains(tree.Indexes(), field) {
+		return nil, ErrorIndexNotFound
+	}
+
+	records := tree.IterateIndex(field, low, high)
+	if limit == 0 {
+		return records, nil
+	}
+	return func(yield func(*Record, error) bool) {
+		returned := 0
+		for record, err := range records {
+			if !yield(record, err) || err != nil {
+				return
+			}
+			returned++
+			if returned == limit {
+				return
+			}
+		}
+	}, nil
+}
+
+func (s *Secretary) HandleGetIndexes(collectionName string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"indexes":        tree.Indexes(),
+		"textIndex":      tree.TextIndex(),
+	}
+	return makeJson(response)
+}
+
+func (s *Secretary) HandleCreateIndex(collectionName string, field string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	if err := tree.CreateIndex(field); err != nil {
+		return nil, err
+	}
+
+	return s.HandleGetIndexes(collectionName)
+}
+
+func (s *Secretary) HandleDropIndex(collectionName string, field string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	if err := tree.DropIndex(field); err != nil {
+		return nil, err
+	}
+
+	return s.HandleGetIndexes(collectionName)
+}
+
+func (s *Secretary) HandleBuildIndex(collectionName string, field string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	if err := tree.BuildIndex(field); err != nil {
+		return nil, err
+	}
+
+	return s.HandleGetIndexes(collectionName)
+}
+
+// SearchHit is a document of a /search response
+type SearchHit struct {
+	Key   string  `json:"key"`
+	Value string  `json:"value"`
+	Score float64 `json:"score"`
+}
+
+// HandleSearch returns the documents matching query, best BM25 score first
+func (s *Secretary) HandleSearch(collectionName string, query string, limit int) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	results, err := tree.Search(query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]SearchHit, len(results))
+	for i, result := range results {
+		hits[i] = SearchHit{Key: string(result.Record.Key), Value: string(result.Record.Value), Score: result.Score}
+	}
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"results":        hits,
+	}
+	return makeJson(response)
+}
+
+// HandleSuggest returns the indexed terms close to every word of text and the best one per word
+func (s *Secretary) HandleSuggest(collectionName string, text string, limit int) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	words, err := tree.Suggest(text, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// A word without a close term is kept as it is
+	didYouMean := make([]string, len(words))
+	for i, word := range words {
+		didYouMean[i] = word.Word
+		if len(word.Suggestions) > 0 {
+			didYouMean[i] = word.Suggestions[0].Term
+		}
+	}
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"words":          words,
+		"didYouMean":     strings.Join(didYouMean, " "),
+	}
+	return makeJson(response)
+}
+
+func (s *Secretary) HandleGetTextIndex(collectionName string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	options := tree.TextIndex()
+	if options == nil {
+		options = &TextIndexOptions{}
+	}
+
+	response := map[string]any{
+		"collectionName": collectionName,
+		"fields":         options.Fields,
+		"fuzzy":          options.Fuzzy,
+	}
+	return makeJson(response)
+}
+
+func (s *Secretary) HandleCreateTextIndex(collectionName string, options TextIndexOptions) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	if err := tree.CreateTextIndex(options); err != nil {
+		return nil, err
+	}
+
+	return s.HandleGetTextIndex(collectionName)
+}
+
+func (s *Secretary) HandleDropTextIndex(collectionName string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	if err := tree.DropTextIndex(); err != nil {
+		return nil, err
+	}
+
+	return s.HandleGetTextIndex(collectionName)
+}
+
+func (s *Secretary) HandleBuildTextIndex(collectionName string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	if err := tree.BuildTextIndex(); err != nil {
+		return nil, err
+	}
+
+	return s.HandleGetTextIndex(collectionName)
+}
+
+// snapshot returns the open snapshot of a collection by its token
+func (s *Secretary) snapshot(collectionName string, token string) (*Snapshot, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	id, err := strconv.ParseUint(token, 10, 64)
+	if err != nil {
+		return nil, ErrorSnapshotNotFound
+	}
+	return tree.OpenSnapshot(id)
+}
+
 func (s *Secretary) HandleDeleteRecord(collectionName string, id string) ([]byte, error) {
 	tree, exists := s.trees[collectionName]
 	if !exists {
@@ -194,3 +704,36 @@ func (s *Secretary) HandleClearTree(collectionName string) ([]byte, error) {
 
 	return makeJson(response)
 }
+
+func (s *Secretary) HandleGetCompaction(collectionName string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	return makeJson(tree.CompactionStatus())
+}
+
+func (s *Secretary) HandleStartCompaction(collectionName string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	if err := tree.StartCompaction(COMPACTION_INTERVAL); err != nil {
+		return nil, err
+	}
+
+	return makeJson(tree.CompactionStatus())
+}
+
+func (s *Secretary) HandleStopCompaction(collectionName string) ([]byte, error) {
+	tree, exists := s.trees[collectionName]
+	if !exists {
+		return nil, ErrorTreeNotFound
+	}
+
+	tree.StopCompaction()
+
+	return makeJson(tree.CompactionStatus())
+}
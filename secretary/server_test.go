@@ -4,8 +4,10 @@ package secretary
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -188,3 +190,515 @@ func TestServerGetHandler(t *testing.T) {
 
 	s.PagerShutdown()
 }
+
+func TestServerSnapshotHandler(t *testing.T) {
+	s := dummySecretary(t)
+	mux := http.NewServeMux()
+	router := s.setupRouter(mux)
+
+	u := dummyTree(t, s, 4)
+
+	var keySeq uint64 = 0
+	key := []byte(utils.GenerateSeqRandomString(&keySeq, 16, 5, 4))
+	if _, err := u.SetKV(key, []byte("before")); err != nil {
+		t.Fatalf("Insert failed: %s", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/snapshot/"+u.CollectionName, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v", resp.Status)
+	}
+	var created struct {
+		Data struct {
+			Snapshot string `json:"snapshot"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.Update(key, []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/get/"+u.CollectionName+"/"+string(key)+"?snapshot="+created.Data.Snapshot, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	defer resp.Body.Close()
+	var got struct {
+		Data struct {
+			Record []byte `json:"record"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil || string(got.Data.Record) != "before" {
+		t.Fatal("Expected the record as of the snapshot", string(got.Data.Record), err)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/snapshot/"+u.CollectionName+"/"+created.Data.Snapshot, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v", resp.Status)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/get/"+u.CollectionName+"/"+string(key)+"?snapshot="+created.Data.Snapshot, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected status StatusInternalServerError; got %v", resp.Status)
+	}
+
+	s.PagerShutdown()
+}
+
+func readRange(t *testing.T, router http.Handler, url string) (int, []RangeRecord) {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	defer resp.Body.Close()
+
+	var records []RangeRecord
+	decoder := json.NewDecoder(resp.Body)
+	for resp.StatusCode == http.StatusOK && decoder.More() {
+		var record RangeRecord
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return resp.StatusCode, records
+}
+
+func TestServerRangeHandler(t *testing.T) {
+	s := dummySecretary(t)
+	mux := http.NewServeMux()
+	router := s.setupRouter(mux)
+
+	u := dummyTree(t, s, 4)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		if _, err := u.SetKV([]byte(key), []byte("value"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, records := readRange(t, router, "/range/"+u.CollectionName+"?start=key10&end=key19&limit=4")
+	if status != http.StatusOK || len(records) != 4 || records[0].Key != "key10" || records[3].Value != "valuekey13" {
+		t.Fatal("Range mismatch", status, records)
+	}
+
+	status, records = readRange(t, router, "/range/"+u.CollectionName+"?end=key05&reverse=true")
+	if status != http.StatusOK || len(records) != 6 || records[0].Key != "key05" || records[5].Key != "key00" {
+		t.Fatal("Reverse range mismatch", status, records)
+	}
+
+	snapshot := u.Snapshot()
+	if err := u.Delete([]byte("key00")); err != nil {
+		t.Fatal(err)
+	}
+	token := fmt.Sprint(snapshot.ID)
+
+	status, records = readRange(t, router, "/range/"+u.CollectionName+"?snapshot="+token)
+	if status != http.StatusOK || len(records) != 50 || records[0].Key != "key00" {
+		t.Fatal("Snapshot range mismatch", status, len(records))
+	}
+	if status, _ := readRange(t, router, "/range/"+u.CollectionName+"?snapshot="+token+"&reverse=true"); status != http.StatusInternalServerError {
+		t.Fatal("Expected snapshot scans to be forward only", status)
+	}
+	snapshot.Release()
+
+	if status, _ := readRange(t, router, "/range/"+u.CollectionName+"?limit=x"); status != http.StatusBadRequest {
+		t.Fatal("Expected an invalid limit", status)
+	}
+	if status, _ := readRange(t, router, "/range/missingtree"); status != http.StatusInternalServerError {
+		t.Fatal("Expected a missing tree", status)
+	}
+
+	s.PagerShutdown()
+}
+
+func TestServerLoadHandler(t *testing.T) {
+	s := dummySecretary(t)
+	mux := http.NewServeMux()
+	router := s.setupRouter(mux)
+
+	u := dummyTree(t, s, 4)
+	if _, err := u.SetKV([]byte("b"), []byte("before")); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func(query string, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/load/"+u.CollectionName+query, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Result().StatusCode
+	}
+
+	if status := load("?format=csv&fill=1", "a,1\nb,2\nc,3\n"); status != http.StatusOK {
+		t.Fatal("Expected the csv to load", status)
+	}
+	verifyTreeRecords(t, u, map[string]string{"a": "1", "b": "2", "c": "3"})
+
+	if status := load("?format=csv", "b,1\na,2\n"); status != http.StatusInternalServerError {
+		t.Fatal("Expected unsorted records to fail", status)
+	}
+	if status := load("?format=xml", ""); status != http.StatusBadRequest {
+		t.Fatal("Expected an unknown format", status)
+	}
+	if status := load("?format=csv&fill=x", ""); status != http.StatusBadRequest {
+		t.Fatal("Expected an invalid fill factor", status)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/dump/"+u.CollectionName, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	copied := dummyTree(t, s, 4)
+	if _, err := copied.BulkLoad(DumpRecords(rec.Result().Body), BulkLoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	verifyTreeRecords(t, copied, map[string]string{"a": "1", "b": "2", "c": "3"})
+
+	s.PagerShutdown()
+}
+
+func TestServerSQLHandler(t *testing.T) {
+	s := dummySecretary(t)
+	mux := http.NewServeMux()
+	router := s.setupRouter(mux)
+
+	u := dummyTree(t, s, 4)
+
+	sql := func(body string) (int, string) {
+		req := httptest.NewRequest(http.MethodPost, "/sql", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Result().StatusCode, rec.Body.String()
+	}
+
+	query := fmt.Sprintf(`{"query": "INSERT INTO %s (key, Name) VALUES ('Ab', ?)", "params": ["Mixed Case"]}`, u.CollectionName)
+	if status, body := sql(query); status != http.StatusOK {
+		t.Fatal("Expected the insert to run", status, body)
+	}
+
+	query = fmt.Sprintf(`{"query": "select Name from %s where key = 'Ab'"}`, u.CollectionName)
+	status, body := sql(query)
+	var response struct {
+		Data SQLResult `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &response); err != nil || status != http.StatusOK {
+		t.Fatal("Expected the select to run", status, body)
+	}
+	if len(response.Data.Rows) != 1 || response.Data.Rows[0]["Name"] != "Mixed Case" {
+		t.Fatal("Expected the row with its case kept", response.Data.Rows)
+	}
+
+	if status, _ := sql(`{"query": ""}`); status != http.StatusBadRequest {
+		t.Fatal("Expected an empty query to fail", status)
+	}
+	if status, _ := sql(`{"query": "SELECT * FORM missing"}`); status != http.StatusBadRequest {
+		t.Fatal("Expected a syntax error to fail", status)
+	}
+	if status, _ := sql(`{"query": "SELECT * FROM missing"}`); status != http.StatusInternalServerError {
+		t.Fatal("Expected a missing collection to fail", status)
+	}
+
+	s.PagerShutdown()
+}
+
+func TestServerSchemaHandler(t *testing.T) {
+	s := dummySecretary(t)
+	mux := http.NewServeMux()
+	router := s.setupRouter(mux)
+
+	u := dummyTree(t, s, 4)
+
+	request := func(method string, path string, body string) (int, string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Result().StatusCode, rec.Body.String()
+	}
+
+	if status, body := request(http.MethodPost, "/schema/"+u.CollectionName, `{"Name": {"type": "str"}}`); status != http.StatusBadRequest {
+		t.Fatal("Expected an invalid schema to fail", status, body)
+	}
+	if status, body := request(http.MethodPost, "/schema/"+u.CollectionName, `{"Name": {"type": "string"}, "Age": {"type": "int"}}`); status != http.StatusOK {
+		t.Fatal("Expected the schema set", status, body)
+	}
+	if status, body := request(http.MethodGet, "/schema/"+u.CollectionName, ""); status != http.StatusOK || !strings.Contains(body, `"Age":{"type":"int","tags":{"bin":"Age"}}`) {
+		t.Fatal("Expected the schema", status, body)
+	}
+
+	if status, body := request(http.MethodPost, "/set/"+u.CollectionName, `{"key": "ann", "value": "{\"Name\": \"Ann\", \"Age\": 7}"}`); status != http.StatusOK {
+		t.Fatal("Expected the document set", status, body)
+	}
+	if status, _ := request(http.MethodPost, "/set/"+u.CollectionName, `{"key": "bad", "value": "{\"Age\": \"seven\"}"}`); status != http.StatusInternalServerError {
+		t.Fatal("Expected an invalid document to fail", status)
+	}
+
+	status, body := request(http.MethodGet, "/get/"+u.CollectionName+"/ann", "")
+	var response struct {
+		Data struct {
+			Record map[string]any `json:"record"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &response); err != nil || status != http.StatusOK {
+		t.Fatal("Expected the record", status, body)
+	}
+	if !reflect.DeepEqual(response.Data.Record, map[string]any{"Name": "Ann", "Age": float64(7)}) {
+		t.Fatal("Expected the decoded document", body)
+	}
+
+	if status, _ := request(http.MethodPost, "/schema/"+u.CollectionName, `{"Name": {"type": "string"}}`); status != http.StatusInternalServerError {
+		t.Fatal("Expected the schema of a non empty collection kept", status)
+	}
+
+	s.PagerShutdown()
+}
+
+func TestServerIndexHandler(t *testing.T) {
+	s := dummySecretary(t)
+	mux := http.NewServeMux()
+	router := s.setupRouter(mux)
+
+	u := dummyTree(t, s, 4)
+	for key, value := range map[string]string{"ann": `{"age": 7}`, "ben": `{"age": 30}`, "cat": `{"age": "30"}`} {
+		if _, err := u.SetKV([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request := func(method string, path string) (int, string) {
+		req := httptest.NewRequest(method, path, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Result().StatusCode, rec.Body.String()
+	}
+
+	if status, body := request(http.MethodPost, "/index/"+u.CollectionName+"/key"); status != http.StatusBadRequest {
+		t.Fatal("Expected an invalid field to fail", status, body)
+	}
+	if status, body := request(http.MethodPost, "/index/"+u.CollectionName+"/age"); status != http.StatusOK || !strings.Contains(body, `"indexes":["age"]`) {
+		t.Fatal("Expected the index created", status, body)
+	}
+	if status, body := request(http.MethodPost, "/index/"+u.CollectionName+"/age/build"); status != http.StatusOK {
+		t.Fatal("Expected the index rebuilt", status, body)
+	}
+
+	for query, expected := range map[string]string{
+		"start=10":           "ben",
+		"start=%2230%22":     "cat",
+		"end=30&limit=1":     "ann",
+		"start=30&end=30":    "ben",
+		"start=30&end=%2230": "",
+	} {
+		status, body := request(http.MethodGet, "/range/"+u.CollectionName+"?index=age&"+query)
+		keys := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+			var record RangeRecord
+			if line != "" && json.Unmarshal([]byte(line), &record) == nil {
+				keys = append(keys, record.Key)
+			}
+		}
+		if status != http.StatusOK || strings.Join(keys, ",") != expected {
+			t.Fatal("Range mismatch for", query, status, body)
+		}
+	}
+	if status, _ := request(http.MethodGet, "/range/"+u.CollectionName+"?index=age&reverse=true"); status != http.StatusBadRequest {
+		t.Fatal("Expected a reverse index scan rejected", status)
+	}
+	if status, _ := request(http.MethodGet, "/range/"+u.CollectionName+"?index=name"); status != http.StatusInternalServerError {
+		t.Fatal("Expected a missing index to fail", status)
+	}
+
+	if status, body := request(http.MethodDelete, "/index/"+u.CollectionName+"/age"); status != http.StatusOK || !strings.Contains(body, `"indexes":[]`) {
+		t.Fatal("Expected the index dropped", status, body)
+	}
+	if status, body := request(http.MethodGet, "/index/"+u.CollectionName); status != http.StatusOK || !strings.Contains(body, `"indexes":[]`) {
+		t.Fatal("Expected no index", status, body)
+	}
+
+	s.PagerShutdown()
+}
+
+func TestServerSearchHandler(t *testing.T) {
+	s := dummySecretary(t)
+	mux := http.NewServeMux()
+	router := s.setupRouter(mux)
+
+	u := dummyTree(t, s, 4)
+	for key, value := range map[string]string{
+		"ann": `{"bio": "Ann writes Go databases"}`,
+		"ben": `{"bio": "Ben writes Go", "note": "databases databases"}`,
+		"cat": `{"bio": "Cat reads"}`,
+	} {
+		if _, err := u.SetKV([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request := func(method string, path string) (int, string) {
+		req := httptest.NewRequest(method, path, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Result().StatusCode, rec.Body.String()
+	}
+
+	if status, body := request(http.MethodPost, "/textindex/"+u.CollectionName+"?fields=bio,bio"); status != http.StatusBadRequest {
+		t.Fatal("Expected duplicate fields to fail", status, body)
+	}
+	if status, _ := request(http.MethodGet, "/search/"+u.CollectionName+"?q=go"); status != http.StatusInternalServerError {
+		t.Fatal("Expected a search without text index to fail", status)
+	}
+	if status, body := request(http.MethodPost, "/textindex/"+u.CollectionName+"?fields=bio,note"); status != http.StatusOK || !strings.Contains(body, `"fields":["bio","note"]`) {
+		t.Fatal("Expected the text index created", status, body)
+	}
+	if status, body := request(http.MethodPost, "/textindex/"+u.CollectionName+"/build"); status != http.StatusOK {
+		t.Fatal("Expected the text index rebuilt", status, body)
+	}
+
+	search := func(query string) []SearchHit {
+		t.Helper()
+		status, body := request(http.MethodGet, "/search/"+u.CollectionName+"?"+query)
+		var response struct {
+			Data struct {
+				Results []SearchHit `json:"results"`
+			} `json:"data"`
+		}
+		if status != http.StatusOK || json.Unmarshal([]byte(body), &response) != nil {
+			t.Fatal("Search failed for", query, status, body)
+		}
+		return response.Data.Results
+	}
+	if hits := search("q=database*+%22writes+go%22"); len(hits) != 2 || hits[0].Key != "ben" || hits[1].Key != "ann" || hits[0].Score <= hits[1].Score {
+		t.Fatal("Search mismatch", hits)
+	}
+	if hits := search("q=reads"); len(hits) != 1 || hits[0].Value != `{"bio": "Cat reads"}` {
+		t.Fatal("Expected the document returned", hits)
+	}
+	if hits := search("q=writes&limit=1"); len(hits) != 1 {
+		t.Fatal("Expected the limit applied", hits)
+	}
+	for _, query := range []string{"q=", "q=%22open", "q=go&limit=0"} {
+		if status, _ := request(http.MethodGet, "/search/"+u.CollectionName+"?"+query); status != http.StatusBadRequest {
+			t.Fatal("Expected", query, "rejected", status)
+		}
+	}
+
+	if status, body := request(http.MethodDelete, "/textindex/"+u.CollectionName); status != http.StatusOK || !strings.Contains(body, `"fields":null`) {
+		t.Fatal("Expected the text index dropped", status, body)
+	}
+
+	// Fuzzy mode
+	if status, body := request(http.MethodPost, "/textindex/"+u.CollectionName+"?fields=bio&fuzzy=sec64"); status != http.StatusBadRequest {
+		t.Fatal("Expected an unknown alphabet to fail", status, body)
+	}
+	if status, body := request(http.MethodPost, "/textindex/"+u.CollectionName+"?fields=bio&fuzzy=sec32"); status != http.StatusOK || !strings.Contains(body, `"fuzzy":"sec32"`) {
+		t.Fatal("Expected the fuzzy text index created", status, body)
+	}
+	status, body := request(http.MethodGet, "/suggest/"+u.CollectionName+"?q=wrtes+databses+zzz&limit=1")
+	var response struct {
+		Data struct {
+			Words      []WordSuggestions `json:"words"`
+			DidYouMean string            `json:"didYouMean"`
+		} `json:"data"`
+	}
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &response) != nil {
+		t.Fatal("Suggest failed", status, body)
+	}
+	if response.Data.DidYouMean != "writes databases zzz" || len(response.Data.Words) != 3 || len(response.Data.Words[0].Suggestions) != 1 {
+		t.Fatal("Suggest mismatch", body)
+	}
+	if hits := search("q=databses~"); len(hits) != 1 || hits[0].Key != "ann" {
+		t.Fatal("Expected the fuzzy word searched", hits)
+	}
+	for _, query := range []string{"q=+,", "q=go&limit=x"} {
+		if status, _ := request(http.MethodGet, "/suggest/"+u.CollectionName+"?"+query); status != http.StatusBadRequest {
+			t.Fatal("Expected", query, "rejected", status)
+		}
+	}
+
+	s.PagerShutdown()
+}
+
+func TestServerKNNHandler(t *testing.T) {
+	s := dummySecretary(t)
+	mux := http.NewServeMux()
+	router := s.setupRouter(mux)
+
+	u := dummyTree(t, s, 4)
+
+	request := func(method string, path string, body string) (int, string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Result().StatusCode, rec.Body.String()
+	}
+
+	if status, body := request(http.MethodGet, "/vectors/"+u.CollectionName, ""); status != http.StatusOK || !strings.Contains(body, `"vectors":null`) {
+		t.Fatal("Expected no vectors", status, body)
+	}
+	for _, options := range []string{`[`, `{"dimensions": 0}`, `{"dimensions": 2, "metric": "manhattan"}`} {
+		if status, body := request(http.MethodPost, "/vectors/"+u.CollectionName, options); status != http.StatusBadRequest {
+			t.Fatal("Expected", options, "rejected", status, body)
+		}
+	}
+	if status, body := request(http.MethodPost, "/vectors/"+u.CollectionName, `{"dimensions": 2, "metric": "l2"}`); status != http.StatusOK || !strings.Contains(body, `"dimensions":2,"metric":"l2","m":16`) {
+		t.Fatal("Expected the vector options set", status, body)
+	}
+
+	for key, value := range map[string]string{
+		"origin": `{"vector": [0, 0], "metadata": {"tag": "a"}}`,
+		"near":   `{"vector": [1, 0], "metadata": {"tag": "b"}}`,
+		"far":    `{"vector": [5, 5], "metadata": {"tag": "a"}}`,
+	} {
+		if _, err := u.SetKV([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status, body := request(http.MethodPost, "/vectors/"+u.CollectionName+"/build", ""); status != http.StatusOK || !strings.Contains(body, `"vectors":3`) {
+		t.Fatal("Expected the vector graph rebuilt", status, body)
+	}
+
+	knn := func(query string) []KNNHit {
+		t.Helper()
+		status, body := request(http.MethodPost, "/knn/"+u.CollectionName, query)
+		var response struct {
+			Data struct {
+				Results []KNNHit `json:"results"`
+			} `json:"data"`
+		}
+		if status != http.StatusOK || json.Unmarshal([]byte(body), &response) != nil {
+			t.Fatal("k-NN failed for", query, status, body)
+		}
+		return response.Data.Results
+	}
+	if hits := knn(`{"vector": [0.2, 0], "k": 2}`); len(hits) != 2 || hits[0].Key != "origin" || hits[1].Key != "near" || hits[0].Distance != 0.2 {
+		t.Fatal("k-NN mismatch", hits)
+	}
+	if hits := knn(`{"vector": [0.9, 0], "k": 5, "filter": {"tag": "a"}}`); len(hits) != 2 || hits[0].Key != "origin" || hits[1].Key != "far" {
+		t.Fatal("Expected the filter applied", hits)
+	}
+	if hits := knn(`{"vector": [1, 1], "k": 1, "metric": "cosine"}`); len(hits) != 1 || hits[0].Key != "far" || !strings.Contains(string(hits[0].Value), `"tag":"a"`) {
+		t.Fatal("Expected the document nearest by cosine", hits)
+	}
+	for _, query := range []string{`{`, `{"vector": [1, 1]}`, `{"vector": [1, 1], "k": 1, "metric": "manhattan"}`} {
+		if status, body := request(http.MethodPost, "/knn/"+u.CollectionName, query); status != http.StatusBadRequest {
+			t.Fatal("Expected", query, "rejected", status, body)
+		}
+	}
+
+	if status, body := request(http.MethodPost, "/vectors/"+u.CollectionName, ""); status != http.StatusInternalServerError {
+		t.Fatal("Expected the options kept on a collection with documents", status, body)
+	}
+}
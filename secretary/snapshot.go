@@ -0,0 +1,408 @@
+package secretary
+
+import (
+	"bytes"
+	"iter"
+	"slices"
+)
+
+/*
+Snapshots
+
+A Snapshot reads the tree as it was when it was taken while writers keep
+changing it. Snapshot takes the tree latch exclusively for a moment, so that
+every change happened either before or after it, and starts a new epoch.
+
+While snapshots are open, the first change of a key in an epoch keeps the
+record it replaced in tree.versions, nil if the key did not exist. Later
+changes of the key in the same epoch free their record as usual. A snapshot
+reads the first kept version of a key from its epoch on, the live record if
+the key did not change since.
+
+A kept record keeps its slot in the record file, it is freed once no open
+snapshot can read it anymore. A compaction swap and a replacement of the
+whole tree read the kept records into memory first. Snapshots do not
+survive a restart, slots kept by a crash are reclaimed by the next
+compaction.
+*/
+
+// recordVersion is a record replaced by the first change of its key in an epoch
+type recordVersion struct {
+	epoch  uint64
+	record *Record // nil if the key did not exist before
+}
+
+// Snapshot is a read-only view of a tree as of the moment it was taken
+type Snapshot struct {
+	tree     *BTree
+	ID       uint64 // Epoch of the snapshot, changes from this epoch on are not visible
+	released bool
+}
+
+// Snapshot opens a read-only view of the tree, it has to be released
+func (tree *BTree) Snapshot() *Snapshot {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	if tree.snapshots == nil {
+		tree.snapshots = map[uint64]*Snapshot{}
+		tree.versions = map[string][]recordVersion{}
+	}
+
+	tree.snapshotEpoch++
+	snapshot := &Snapshot{tree: tree, ID: tree.snapshotEpoch}
+	tree.snapshots[snapshot.ID] = snapshot
+
+	return snapshot
+}
+
+// OpenSnapshot returns the open snapshot with id
+func (tree *BTree) OpenSnapshot(id uint64) (*Snapshot, error) {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	tree.versionsMu.Lock()
+	defer tree.versionsMu.Unlock()
+
+	snapshot, ok := tree.snapshots[id]
+	if !ok {
+		return nil, ErrorSnapshotNotFound
+	}
+	return snapshot, nil
+}
+
+// Release closes the snapshot and frees the records only it could read
+func (snapshot *Snapshot) Release() {
+	tree := snapshot.tree
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	if snapshot.released {
+		return
+	}
+	snapshot.released = true
+	delete(tree.snapshots, snapshot.ID)
+
+	tree.pruneVersions()
+}
+
+// releaseSnapshots releases every open snapshot, tree.mu is held exclusively
+func (tree *BTree) releaseSnapshots() {
+	for _, snapshot := range tree.snapshots {
+		snapshot.released = true
+	}
+	clear(tree.snapshots)
+
+	tree.pruneVersions()
+}
+
+// Get returns the record of key as of the snapshot
+func (snapshot *Snapshot) Get(key []byte) (*Record, error) {
+	tree := snapshot.tree
+	defer tree.settle()
+
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	leaf, err := tree.readLeaf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var live *Record
+	if leaf != nil {
+		defer leaf.mu.RUnlock()
+
+		if keyIndex, found := leaf.getKey(key); found {
+			live = leaf.records[keyIndex]
+		}
+	}
+
+	tree.versionsMu.Lock()
+	record, err := snapshot.visible(key, live)
+	tree.versionsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrorKeyNotFound
+	}
+
+	return tree.readValue(record)
+}
+
+// RangeScan retrieves all records in the range [startKey, endKey] as of the snapshot
+func (snapshot *Snapshot) RangeScan(startKey, endKey []byte) ([]*Record, error) {
+	var results []*Record
+	for record, err := range snapshot.Scan(startKey, endKey) {
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, record)
+	}
+	return results, nil
+}
+
+// Scan iterates over the records in the range [startKey, endKey] as of the snapshot, one leaf at a time
+func (snapshot *Snapshot) Scan(startKey, endKey []byte) iter.Seq2[*Record, error] {
+	return func(yield func(*Record, error) bool) {
+		defer snapshot.tree.settle()
+
+		scan := &rangeScan{startKey: startKey, endKey: endKey}
+		for !scan.done {
+			records, err := snapshot.scanLeaf(scan)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, record := range records {
+				if !yield(record, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Iterate returns the records selected by options as of the snapshot, in key order only
+func (snapshot *Snapshot) Iterate(options ScanOptions) iter.Seq2[*Record, error] {
+	return func(yield func(*Record, error) bool) {
+		if options.Reverse {
+			yield(nil, ErrorSnapshotReverse)
+			return
+		}
+
+		lower := options.StartKey
+		if options.Prefix != nil && bytes.Compare(options.Prefix, lower) > 0 {
+			lower = options.Prefix
+		}
+		upper := options.EndKey
+		if upper == nil {
+			upper = snapshot.tree.maxKey()
+		}
+
+		skipped, returned := 0, 0
+		for record, err := range snapshot.Scan(lower, upper) {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if options.Prefix != nil && !bytes.HasPrefix(record.Key, options.Prefix) {
+				return
+			}
+
+			if skipped < options.Offset {
+				skipped++
+				continue
+			}
+			if !yield(record, nil) {
+				return
+			}
+			returned++
+			if options.Limit > 0 && returned >= options.Limit {
+				return
+			}
+		}
+	}
+}
+
+// scanLeaf returns the records of the snapshot up to the last key of the next leaf.
+// Keys kept by tree.versions that are no longer in the tree are merged in.
+func (snapshot *Snapshot) scanLeaf(scan *rangeScan) ([]*Record, error) {
+	tree := snapshot.tree
+
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	// Keys up to scan.lastKey were covered by the previous leaf
+	from := scan.lastKey
+
+	leaf, err := tree.nextScanLeaf(scan)
+	if err != nil {
+		scan.done = true
+		return nil, err
+	}
+
+	upTo := scan.endKey
+	live := map[string]*Record{}
+	if leaf != nil {
+		defer leaf.mu.RUnlock()
+
+		if leaf.next != nil && len(leaf.Keys) > 0 && bytes.Compare(leaf.Keys[len(leaf.Keys)-1], scan.endKey) < 0 {
+			upTo = leaf.Keys[len(leaf.Keys)-1]
+		}
+		// The leaf found again after a change may end before the keys already covered
+		if from != nil && bytes.Compare(upTo, from) < 0 {
+			upTo = from
+		}
+		for i, key := range leaf.Keys {
+			live[string(key)] = leaf.records[i]
+		}
+
+		scan.leaf = leaf
+		scan.version = leaf.Version
+	}
+	if bytes.Equal(upTo, scan.endKey) {
+		scan.done = true
+	}
+	scan.lastKey = upTo
+
+	inRange := func(key string) bool {
+		return key >= string(scan.startKey) && (from == nil || key > string(from)) && key <= string(upTo)
+	}
+
+	tree.versionsMu.Lock()
+	keys := []string{}
+	for key := range live {
+		if inRange(key) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range tree.versions {
+		if _, ok := live[key]; !ok && inRange(key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	visible := make([]*Record, 0, len(keys))
+	for _, key := range keys {
+		record, err := snapshot.visible([]byte(key), live[key])
+		if err != nil {
+			tree.versionsMu.Unlock()
+			return nil, err
+		}
+		if record != nil {
+			visible = append(visible, record)
+		}
+	}
+	tree.versionsMu.Unlock()
+
+	records := make([]*Record, len(visible))
+	for i, record := range visible {
+		if records[i], err = tree.readValue(record); err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+// visible returns the record of key the snapshot reads given its live record, tree.versionsMu is held
+func (snapshot *Snapshot) visible(key []byte, live *Record) (*Record, error) {
+	if snapshot.released {
+		return nil, ErrorSnapshotReleased
+	}
+
+	for _, version := range snapshot.tree.versions[string(key)] {
+		if version.epoch >= snapshot.ID {
+			return version.record, nil
+		}
+	}
+	return live, nil
+}
+
+//------------------------------------------------------------------
+// Versions
+//------------------------------------------------------------------
+
+// keepVersion keeps the record replaced by a change of key while an open snapshot may read it,
+// record is nil for an inserted key. A record that is not kept is freed.
+func (tree *BTree) keepVersion(key []byte, record *Record) {
+	tree.versionsMu.Lock()
+	kept := false
+	if len(tree.snapshots) > 0 {
+		versions := tree.versions[string(key)]
+		// The snapshots read the version kept by the first change in this epoch
+		if len(versions) == 0 || versions[len(versions)-1].epoch != tree.snapshotEpoch {
+			tree.versions[string(key)] = append(versions, recordVersion{epoch: tree.snapshotEpoch, record: record})
+			kept = true
+		}
+	}
+	tree.versionsMu.Unlock()
+
+	if !kept && record != nil {
+		tree.freeRecord(record)
+	}
+}
+
+// pruneVersions frees the versions older than every open snapshot, tree.mu is held exclusively
+func (tree *BTree) pruneVersions() {
+	oldest := tree.snapshotEpoch + 1
+	for id := range tree.snapshots {
+		oldest = min(oldest, id)
+	}
+
+	for key, versions := range tree.versions {
+		keep := 0
+		for keep < len(versions) && versions[keep].epoch < oldest {
+			if versions[keep].record != nil {
+				tree.freeRecord(versions[keep].record)
+			}
+			keep++
+		}
+
+		if keep == len(versions) {
+			delete(tree.versions, key)
+		} else {
+			tree.versions[key] = versions[keep:]
+		}
+	}
+}
+
+// detachVersions reads the kept records into memory and frees their slots, tree.mu is held exclusively.
+// The record files are about to be replaced.
+func (tree *BTree) detachVersions() error {
+	for _, versions := range tree.versions {
+		for i, version := range versions {
+			if version.record == nil || version.record.location == nil {
+				continue
+			}
+
+			stored, err := tree.readValue(version.record)
+			if err != nil {
+				return err
+			}
+			tree.freeRecord(version.record)
+			versions[i].record = &Record{Key: stored.Key, Value: stored.Value}
+		}
+	}
+	return nil
+}
+
+// keepAllVersions keeps every record before the tree is replaced as a whole, tree.mu is held exclusively
+func (tree *BTree) keepAllVersions() error {
+	if len(tree.snapshots) == 0 || tree.root == nil {
+		return nil
+	}
+	if err := tree.detachVersions(); err != nil {
+		return err
+	}
+
+	leaf := tree.root
+	for {
+		if err := tree.load(leaf); err != nil {
+			return err
+		}
+		if len(leaf.children) == 0 {
+			break
+		}
+		leaf = leaf.children[0]
+	}
+
+	for ; leaf != nil; leaf = leaf.next {
+		if err := tree.load(leaf); err != nil {
+			return err
+		}
+		for _, record := range leaf.records {
+			stored, err := tree.readValue(record)
+			if err != nil {
+				return err
+			}
+			// The slot is freed with every other page of the tree
+			tree.keepVersion(record.Key, &Record{Key: stored.Key, Value: stored.Value})
+		}
+	}
+
+	return nil
+}
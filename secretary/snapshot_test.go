@@ -0,0 +1,177 @@
+package secretary
+
+import (
+	"bytes"
+	"testing"
+)
+
+func verifySnapshotRecords(t *testing.T, snapshot *Snapshot, keys []string, expected map[string]string) {
+	for _, key := range keys {
+		record, err := snapshot.Get([]byte(key))
+		value, ok := expected[key]
+		if !ok {
+			if err != ErrorKeyNotFound {
+				t.Fatal("Expected the key to be missing in the snapshot", key, err)
+			}
+			continue
+		}
+		if err != nil || string(record.Value) != value {
+			t.Fatal("Snapshot record mismatch", key, err)
+		}
+	}
+
+	records, err := snapshot.RangeScan(make([]byte, KEY_SIZE), bytes.Repeat([]byte{0xFF}, KEY_SIZE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d records in the snapshot, got %d", len(expected), len(records))
+	}
+	for i, record := range records {
+		if i > 0 && bytes.Compare(records[i-1].Key, record.Key) >= 0 {
+			t.Fatal("Snapshot scan out of order at", string(record.Key))
+		}
+		if expected[string(record.Key)] != string(record.Value) {
+			t.Fatal("Snapshot scan mismatch", string(record.Key), string(record.Value))
+		}
+	}
+}
+
+func TestSnapshotPointInTime(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	keys := txnKeys(60)
+	before := map[string]string{}
+	for _, key := range keys[:40] {
+		if _, err := tree.SetKV([]byte(key), []byte("first")); err != nil {
+			t.Fatal(err)
+		}
+		before[key] = "first"
+	}
+	// The kept records are read from their slots
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	first := tree.Snapshot()
+
+	after := map[string]string{}
+	for _, key := range keys[:40] {
+		after[key] = "first"
+	}
+	for i, key := range keys[:40] {
+		switch i % 3 {
+		case 0:
+			if err := tree.Update([]byte(key), []byte("second")); err != nil {
+				t.Fatal(err)
+			}
+			after[key] = "second"
+		case 1:
+			if err := tree.Delete([]byte(key)); err != nil {
+				t.Fatal(err)
+			}
+			delete(after, key)
+		}
+	}
+	for _, key := range keys[40:] {
+		if _, err := tree.SetKV([]byte(key), []byte("second")); err != nil {
+			t.Fatal(err)
+		}
+		after[key] = "second"
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := tree.Snapshot()
+	for _, key := range keys {
+		if _, ok := after[key]; ok {
+			if err := tree.Update([]byte(key), []byte("third")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Slots freed before are reused, the kept ones are not
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	verifySnapshotRecords(t, first, keys, before)
+	verifySnapshotRecords(t, second, keys, after)
+
+	first.Release()
+	if _, err := first.Get([]byte(keys[0])); err != ErrorSnapshotReleased {
+		t.Fatal("Expected the snapshot to be released", err)
+	}
+	verifySnapshotRecords(t, second, keys, after)
+
+	second.Release()
+	if len(tree.versions) != 0 {
+		t.Fatal("Expected the versions to be freed with the last snapshot", len(tree.versions))
+	}
+
+	current := map[string]string{}
+	for key := range after {
+		current[key] = "third"
+	}
+	verifyTreeRecords(t, tree, current)
+
+	s.PagerShutdown()
+}
+
+func TestSnapshotEraseAndCompaction(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	keys := txnKeys(30)
+	expected := map[string]string{}
+	for _, key := range keys[:20] {
+		if _, err := tree.SetKV([]byte(key), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = "value"
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := tree.Snapshot()
+	for _, key := range keys[:10] {
+		if err := tree.Update([]byte(key), []byte("updated")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The kept records are moved to memory before the old files go away
+	for {
+		swapped, err := tree.CompactStep()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if swapped {
+			break
+		}
+	}
+	verifySnapshotRecords(t, snapshot, keys, expected)
+
+	if err := tree.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys[20:] {
+		if _, err := tree.SetKV([]byte(key), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	verifySnapshotRecords(t, snapshot, keys, expected)
+
+	snapshot.Release()
+	if errs := tree.TreeVerify(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	s.PagerShutdown()
+}
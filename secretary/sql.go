@@ -0,0 +1,887 @@
+package secretary
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+SQL
+
+ExecSQL runs the statements ParseSQL reads against the collections, Table
+names the collection. A row is a record whose value is a JSON object, the
+record key is the "key" column. A value that is not a JSON object reads as
+{"value": "..."}.
+
+	INSERT INTO users (key, name, age) VALUES ('u1', 'Ada', 36), ('u2', ?, ?)
+	SELECT name FROM users WHERE key BETWEEN 'u1' AND 'u5' AND age > 30
+	SELECT * FROM users WHERE name LIKE 'A%' ORDER BY age DESC LIMIT 10
+	UPDATE users SET age = 37 WHERE key = 'u1'
+	DELETE FROM users WHERE name IN ('Ada', 'Bob') OR age IS NULL
+
+Expressions follow SQL logic : a missing column is NULL, a comparison with
+NULL is NULL and only rows where WHERE is TRUE match. Values of different
+types are never equal and do not order. ORDER BY puts NULL first, then
+booleans, numbers, strings and the other JSON values.
+
+The key conditions of the top level ANDs narrow the records read : key
+equality and IN read single records, comparisons and BETWEEN bound the scan,
+LIKE 'prefix%' scans the prefix. Without key conditions, = and IN on an
+indexed field, or else its comparisons and BETWEEN, read the keys from the
+index, see index.go. Without ORDER BY, or ordered by key, the scan stops
+after LIMIT rows.
+
+INSERT fails on an existing key, the rows before it stay inserted. UPDATE
+assigns SET in the documents and, like DELETE, writes the matched rows with
+one ApplyBatch.
+*/
+
+const (
+	SQL_KEY_COLUMN = "key" // Column holding the record key
+	SQL_PROMPT     = "secretary> "
+)
+
+// SQLResult holds the rows of a SELECT, or how many records an INSERT, UPDATE or DELETE changed
+type SQLResult struct {
+	Rows     []map[string]any `json:"rows"`
+	Affected int              `json:"affected"`
+}
+
+// sqlEnv evaluates expressions on a row, params fill the placeholders
+type sqlEnv struct {
+	row      map[string]any
+	params   []any
+	patterns map[string]*regexp.Regexp // Compiled LIKE patterns of the statement
+}
+
+func (env sqlEnv) withRow(row map[string]any) sqlEnv {
+	env.row = row
+	return env
+}
+
+// ExecSQL parses and runs one statement, params are strings, numbers, booleans or nil
+func (s *Secretary) ExecSQL(query string, params ...any) (*SQLResult, error) {
+	stmt, err := ParseSQL(query)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := s.Tree(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params) != stmt.Params {
+		return nil, ErrorSQLParams(stmt.Params, len(params))
+	}
+	env := sqlEnv{params: make([]any, len(params)), patterns: map[string]*regexp.Regexp{}}
+	for i, param := range params {
+		if env.params[i], err = sqlParamValue(param); err != nil {
+			return nil, err
+		}
+	}
+
+	switch stmt.Type {
+	case Select:
+		return execSelect(tree, stmt, env)
+	case Insert:
+		return execInsert(tree, stmt, env)
+	case Update:
+		return execUpdate(tree, stmt, env)
+	default:
+		return execDelete(tree, stmt, env)
+	}
+}
+
+// sqlParamValue converts a parameter to the types of decoded JSON
+func sqlParamValue(param any) (any, error) {
+	switch param := param.(type) {
+	case nil, string, bool, float64:
+		return param, nil
+	case []byte:
+		return string(param), nil
+	case int:
+		return float64(param), nil
+	case int64:
+		return float64(param), nil
+	case uint64:
+		return float64(param), nil
+	case float32:
+		return float64(param), nil
+	}
+	return nil, ErrorSQLParamType(param)
+}
+
+func execSelect(tree *BTree, stmt SQLStatement, env sqlEnv) (*SQLResult, error) {
+	limit, err := env.count(stmt.Limit, -1)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := env.count(stmt.Offset, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := planSQLScan(tree, stmt.Where, env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows come in key order, ORDER BY key only picks the direction
+	sorted := len(stmt.OrderBy) == 0
+	if len(stmt.OrderBy) == 1 {
+		if column, ok := stmt.OrderBy[0].Expr.(SQLColumn); ok && column.Name == SQL_KEY_COLUMN {
+			sorted = true
+			plan.options.Reverse = stmt.OrderBy[0].Desc
+		}
+	}
+
+	rows := []map[string]any{}
+	if sorted {
+		skipped := 0
+		err = plan.rows(tree, stmt.Where, env, func(row map[string]any) bool {
+			if skipped < offset {
+				skipped++
+				return true
+			}
+			if limit >= 0 && len(rows) == limit {
+				return false
+			}
+			rows = append(rows, row)
+			return limit < 0 || len(rows) < limit
+		})
+	} else {
+		err = plan.rows(tree, stmt.Where, env, func(row map[string]any) bool {
+			rows = append(rows, row)
+			return true
+		})
+		rows = sortSQLRows(rows, stmt.OrderBy, env)
+		rows = rows[min(offset, len(rows)):]
+		if limit >= 0 {
+			rows = rows[:min(limit, len(rows))]
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if stmt.Fields != nil {
+		for i, row := range rows {
+			projected := make(map[string]any, len(stmt.Fields))
+			for _, column := range stmt.Fields {
+				projected[column] = row[column]
+			}
+			rows[i] = projected
+		}
+	}
+
+	return &SQLResult{Rows: rows}, nil
+}
+
+func execInsert(tree *BTree, stmt SQLStatement, env sqlEnv) (*SQLResult, error) {
+	if !slices.Contains(stmt.Fields, SQL_KEY_COLUMN) {
+		return nil, ErrorSQLMissingKey
+	}
+
+	result := &SQLResult{}
+	for _, values := range stmt.Values {
+		var key []byte
+		doc := make(map[string]any, len(stmt.Fields))
+		for i, column := range stmt.Fields {
+			value := env.eval(values[i])
+			if column != SQL_KEY_COLUMN {
+				doc[column] = value
+				continue
+			}
+			switch value := value.(type) {
+			case string:
+				key = []byte(value)
+			case float64:
+				key = []byte(strconv.FormatFloat(value, 'f', -1, 64))
+			default:
+				return result, ErrorSQLKeyType
+			}
+		}
+
+		value, err := json.Marshal(doc)
+		if err != nil {
+			return result, err
+		}
+		if _, err := tree.SetKV(key, value); err != nil {
+			return result, err
+		}
+		result.Affected++
+	}
+	return result, nil
+}
+
+func execUpdate(tree *BTree, stmt SQLStatement, env sqlEnv) (*SQLResult, error) {
+	for _, assignment := range stmt.Set {
+		if assignment.Column == SQL_KEY_COLUMN {
+			return nil, ErrorSQLSetKey
+		}
+	}
+
+	ops := []Op{}
+	plan, err := planSQLScan(tree, stmt.Where, env)
+	if err != nil {
+		return nil, err
+	}
+	err = plan.rows(tree, stmt.Where, env, func(row map[string]any) bool {
+		// Every SET sees the row before the update
+		rowEnv := env.withRow(row)
+		values := make([]any, len(stmt.Set))
+		for i, assignment := range stmt.Set {
+			values[i] = rowEnv.eval(assignment.Value)
+		}
+
+		key := row[SQL_KEY_COLUMN].(string)
+		delete(row, SQL_KEY_COLUMN)
+		for i, assignment := range stmt.Set {
+			row[assignment.Column] = values[i]
+		}
+		value, _ := json.Marshal(row)
+		ops = append(ops, Op{Type: OP_PUT, Key: []byte(key), Value: value})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return applySQLOps(tree, ops)
+}
+
+func execDelete(tree *BTree, stmt SQLStatement, env sqlEnv) (*SQLResult, error) {
+	ops := []Op{}
+	plan, err := planSQLScan(tree, stmt.Where, env)
+	if err != nil {
+		return nil, err
+	}
+	err = plan.rows(tree, stmt.Where, env, func(row map[string]any) bool {
+		ops = append(ops, Op{Type: OP_DELETE, Key: []byte(row[SQL_KEY_COLUMN].(string))})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return applySQLOps(tree, ops)
+}
+
+// applySQLOps applies the writes of an UPDATE or DELETE, the ops that failed are joined in the error
+func applySQLOps(tree *BTree, ops []Op) (*SQLResult, error) {
+	result := &SQLResult{}
+	if len(ops) == 0 {
+		return result, nil
+	}
+
+	results, err := tree.ApplyBatch(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := []error{}
+	for i, opResult := range results {
+		if opResult.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ops[i].Key, opResult.Err))
+			continue
+		}
+		result.Affected++
+	}
+	return result, errors.Join(errs...)
+}
+
+//------------------------------------------------------------------
+// Scan
+//------------------------------------------------------------------
+
+// sqlScan reads the records a WHERE clause can match, keys replaces the scan by single reads
+type sqlScan struct {
+	options ScanOptions
+	keys    [][]byte
+}
+
+// planSQLScan narrows the scan with the key and indexed field conditions of the top level ANDs
+func planSQLScan(tree *BTree, where SQLExpr, env sqlEnv) (sqlScan, error) {
+	plan := sqlScan{}
+	bound := func(key string, lower bool) {
+		if lower && (plan.options.StartKey == nil || bytes.Compare([]byte(key), plan.options.StartKey) > 0) {
+			plan.options.StartKey = []byte(key)
+		}
+		if !lower && (plan.options.EndKey == nil || bytes.Compare([]byte(key), plan.options.EndKey) < 0) {
+			plan.options.EndKey = []byte(key)
+		}
+	}
+	valueOf := func(expr SQLExpr) (any, bool) {
+		switch expr.(type) {
+		case SQLLiteral, SQLParam:
+			value := env.eval(expr)
+			return value, value != nil
+		}
+		return nil, false
+	}
+	keyOf := func(expr SQLExpr) (string, bool) {
+		value, _ := valueOf(expr)
+		key, ok := value.(string)
+		return key, ok
+	}
+	isKey := func(expr SQLExpr) bool {
+		column, ok := expr.(SQLColumn)
+		return ok && column.Name == SQL_KEY_COLUMN
+	}
+
+	// Conditions on indexed fields, used when the key does not narrow the scan
+	indexed := tree.Indexes()
+	var equalField string
+	var equalValues []any
+	type fieldBounds struct{ low, high any }
+	ranges := map[string]*fieldBounds{}
+	rangeFields := []string{}
+	indexedField := func(expr SQLExpr) (string, bool) {
+		column, ok := expr.(SQLColumn)
+		return column.Name, ok && slices.Contains(indexed, column.Name)
+	}
+	indexBound := func(field string, value any, lower bool) {
+		if indexValue(value) == nil {
+			return
+		}
+		bounds, ok := ranges[field]
+		if !ok {
+			bounds = &fieldBounds{}
+			ranges[field] = bounds
+			rangeFields = append(rangeFields, field)
+		}
+		current := &bounds.high
+		if lower {
+			current = &bounds.low
+		}
+		// A bound of another type is ignored, WHERE still filters the rows
+		order, comparable := compareSQLValues(value, *current)
+		if *current == nil || (comparable && ((lower && order > 0) || (!lower && order < 0))) {
+			*current = value
+		}
+	}
+
+	for _, condition := range sqlConjuncts(where) {
+		switch condition := condition.(type) {
+		case SQLBinary:
+			op, left, right := condition.Op, condition.Left, condition.Right
+			if _, ok := right.(SQLColumn); ok {
+				op, left, right = flipSQLComparison(op), right, left
+			}
+
+			if field, ok := indexedField(left); ok {
+				value, ok := valueOf(right)
+				switch {
+				case !ok:
+				case op == "=" && equalValues == nil && indexValue(value) != nil:
+					equalField, equalValues = field, []any{value}
+				case op == ">" || op == ">=":
+					indexBound(field, value, true)
+				case op == "<" || op == "<=":
+					indexBound(field, value, false)
+				}
+				continue
+			}
+
+			key, ok := keyOf(right)
+			if !isKey(left) || !ok {
+				continue
+			}
+			switch op {
+			case "=":
+				plan.keys = [][]byte{[]byte(key)}
+				return plan, nil
+			case ">", ">=":
+				bound(key, true)
+			case "<", "<=":
+				bound(key, false)
+			}
+
+		case SQLBetween:
+			if condition.Not {
+				continue
+			}
+			if field, ok := indexedField(condition.Expr); ok {
+				low, lowOk := valueOf(condition.Low)
+				high, highOk := valueOf(condition.High)
+				if lowOk && highOk {
+					indexBound(field, low, true)
+					indexBound(field, high, false)
+				}
+				continue
+			}
+
+			low, lowOk := keyOf(condition.Low)
+			high, highOk := keyOf(condition.High)
+			if isKey(condition.Expr) && lowOk && highOk {
+				bound(low, true)
+				bound(high, false)
+			}
+
+		case SQLIn:
+			if condition.Not {
+				continue
+			}
+			if field, ok := indexedField(condition.Expr); ok && equalValues == nil {
+				values := []any{}
+				for _, expr := range condition.List {
+					value, ok := valueOf(expr)
+					if !ok || indexValue(value) == nil {
+						values = nil
+						break
+					}
+					values = append(values, value)
+				}
+				if values != nil {
+					equalField, equalValues = field, values
+				}
+				continue
+			}
+
+			if !isKey(condition.Expr) {
+				continue
+			}
+			keys := [][]byte{}
+			for _, expr := range condition.List {
+				key, ok := keyOf(expr)
+				if !ok {
+					keys = nil
+					break
+				}
+				keys = append(keys, []byte(key))
+			}
+			if keys != nil {
+				slices.SortFunc(keys, bytes.Compare)
+				plan.keys = slices.CompactFunc(keys, bytes.Equal)
+				return plan, nil
+			}
+
+		case SQLLike:
+			pattern, ok := keyOf(condition.Pattern)
+			if isKey(condition.Expr) && !condition.Not && ok {
+				if prefix := pattern[:strings.IndexAny(pattern+"%", "%_")]; len(prefix) > len(plan.options.Prefix) {
+					plan.options.Prefix = []byte(prefix)
+				}
+			}
+		}
+	}
+
+	// Index equality reads the fewest records, then the key range, then an index range
+	switch {
+	case equalValues != nil:
+		keys := [][]byte{}
+		for _, value := range equalValues {
+			valueKeys, err := tree.indexKeys(equalField, value, value)
+			if err != nil {
+				return plan, err
+			}
+			keys = append(keys, valueKeys...)
+		}
+		plan.keys = sortedSQLKeys(keys)
+	case plan.options.StartKey != nil || plan.options.EndKey != nil || plan.options.Prefix != nil:
+	case len(rangeFields) > 0:
+		bounds := ranges[rangeFields[0]]
+		keys, err := tree.indexKeys(rangeFields[0], bounds.low, bounds.high)
+		if err != nil {
+			return plan, err
+		}
+		plan.keys = sortedSQLKeys(keys)
+	}
+	return plan, nil
+}
+
+// sortedSQLKeys orders the keys found through an index, rows are read in key order
+func sortedSQLKeys(keys [][]byte) [][]byte {
+	slices.SortFunc(keys, bytes.Compare)
+	return slices.CompactFunc(keys, bytes.Equal)
+}
+
+// rows calls yield with every row where is TRUE, until yield returns false
+func (plan sqlScan) rows(tree *BTree, where SQLExpr, env sqlEnv, yield func(map[string]any) bool) error {
+	match := func(record *Record) bool {
+		row := decodeSQLRow(record)
+		if where != nil && env.withRow(row).eval(where) != true {
+			return true
+		}
+		return yield(row)
+	}
+
+	if plan.keys != nil {
+		keys := slices.Clone(plan.keys)
+		if plan.options.Reverse {
+			slices.Reverse(keys)
+		}
+		for _, key := range keys {
+			record, err := tree.Get(key)
+			if errors.Is(err, ErrorKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !match(record) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	// An empty range, Iterate would read it as unbounded
+	if plan.options.StartKey != nil && plan.options.EndKey != nil && bytes.Compare(plan.options.StartKey, plan.options.EndKey) > 0 {
+		return nil
+	}
+	for record, err := range tree.Iterate(plan.options) {
+		if err != nil {
+			return err
+		}
+		if !match(record) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func sqlConjuncts(expr SQLExpr) []SQLExpr {
+	if binary, ok := expr.(SQLBinary); ok && binary.Op == "AND" {
+		return append(sqlConjuncts(binary.Left), sqlConjuncts(binary.Right)...)
+	}
+	if expr == nil {
+		return nil
+	}
+	return []SQLExpr{expr}
+}
+
+// flipSQLComparison swaps the sides of a comparison, a < b is b > a
+func flipSQLComparison(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	}
+	return op
+}
+
+func decodeSQLRow(record *Record) map[string]any {
+	var row map[string]any
+	if err := json.Unmarshal(record.Value, &row); err != nil || row == nil {
+		row = map[string]any{"value": string(record.Value)}
+	}
+	row[SQL_KEY_COLUMN] = string(record.Key)
+	return row
+}
+
+func sortSQLRows(rows []map[string]any, orderBy []SQLOrder, env sqlEnv) []map[string]any {
+	if len(orderBy) == 0 {
+		return rows
+	}
+
+	type sortedRow struct {
+		row  map[string]any
+		keys []any
+	}
+	sorted := make([]sortedRow, len(rows))
+	for i, row := range rows {
+		rowEnv := env.withRow(row)
+		keys := make([]any, len(orderBy))
+		for j, order := range orderBy {
+			keys[j] = rowEnv.eval(order.Expr)
+		}
+		sorted[i] = sortedRow{row: row, keys: keys}
+	}
+
+	slices.SortStableFunc(sorted, func(a, b sortedRow) int {
+		for j, order := range orderBy {
+			if c := orderSQLValues(a.keys[j], b.keys[j]); c != 0 {
+				if order.Desc {
+					return -c
+				}
+				return c
+			}
+		}
+		return 0
+	})
+
+	for i := range sorted {
+		rows[i] = sorted[i].row
+	}
+	return rows
+}
+
+//------------------------------------------------------------------
+// Expressions
+//------------------------------------------------------------------
+
+// eval returns a string, a float64, a bool, nil for NULL, or a JSON array or object of the row
+func (env sqlEnv) eval(expr SQLExpr) any {
+	switch expr := expr.(type) {
+	case SQLLiteral:
+		return expr.Value
+
+	case SQLColumn:
+		return env.row[expr.Name]
+
+	case SQLParam:
+		return env.params[expr.Index]
+
+	case SQLUnary:
+		value := env.eval(expr.Expr)
+		if expr.Op == "-" {
+			if number, ok := value.(float64); ok {
+				return -number
+			}
+			return nil
+		}
+		return sqlNot(value)
+
+	case SQLBinary:
+		switch expr.Op {
+		case "AND":
+			left := env.eval(expr.Left)
+			if left == false {
+				return false
+			}
+			return sqlAnd(left, env.eval(expr.Right))
+		case "OR":
+			left := env.eval(expr.Left)
+			if left == true {
+				return true
+			}
+			return sqlNot(sqlAnd(sqlNot(left), sqlNot(env.eval(expr.Right))))
+		}
+		return compareSQL(expr.Op, env.eval(expr.Left), env.eval(expr.Right))
+
+	case SQLIn:
+		value := env.eval(expr.Expr)
+		var result any = false
+		for _, item := range expr.List {
+			switch compareSQL("=", value, env.eval(item)) {
+			case true:
+				result = true
+			case nil:
+				if result == false {
+					result = nil
+				}
+			}
+			if result == true {
+				break
+			}
+		}
+		if expr.Not {
+			return sqlNot(result)
+		}
+		return result
+
+	case SQLBetween:
+		value := env.eval(expr.Expr)
+		result := sqlAnd(compareSQL(">=", value, env.eval(expr.Low)), compareSQL("<=", value, env.eval(expr.High)))
+		if expr.Not {
+			return sqlNot(result)
+		}
+		return result
+
+	case SQLLike:
+		value, valueOk := env.eval(expr.Expr).(string)
+		pattern, patternOk := env.eval(expr.Pattern).(string)
+		if !valueOk || !patternOk {
+			return nil
+		}
+		regex, ok := env.patterns[pattern]
+		if !ok {
+			regex = sqlLikePattern(pattern)
+			env.patterns[pattern] = regex
+		}
+		return regex.MatchString(value) != expr.Not
+
+	case SQLIsNull:
+		return (env.eval(expr.Expr) == nil) != expr.Not
+	}
+	return nil
+}
+
+// count evaluates a LIMIT or OFFSET to a whole number, empty is returned without one
+func (env sqlEnv) count(expr SQLExpr, empty int) (int, error) {
+	if expr == nil {
+		return empty, nil
+	}
+	number, ok := env.eval(expr).(float64)
+	if !ok || number < 0 || number != math.Trunc(number) || number > math.MaxInt32 {
+		return 0, ErrorSQLCount
+	}
+	return int(number), nil
+}
+
+// sqlAnd is AND with NULL as unknown
+func sqlAnd(a any, b any) any {
+	if a == false || b == false {
+		return false
+	}
+	if a == true && b == true {
+		return true
+	}
+	return nil
+}
+
+func sqlNot(value any) any {
+	if b, ok := value.(bool); ok {
+		return !b
+	}
+	return nil
+}
+
+// compareSQL returns the comparison as a bool, or nil if a side is NULL or the types do not order
+func compareSQL(op string, a any, b any) any {
+	if a == nil || b == nil {
+		return nil
+	}
+
+	order, comparable := compareSQLValues(a, b)
+	if !comparable {
+		switch op {
+		case "=":
+			return false
+		case "!=":
+			return true
+		}
+		return nil
+	}
+
+	switch op {
+	case "=":
+		return order == 0
+	case "!=":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	}
+	return nil
+}
+
+// compareSQLValues orders two values of the same type, comparable is false for different types
+func compareSQLValues(a any, b any) (order int, comparable bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			return cmp.Compare(a, b), true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, true
+			case b:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// orderSQLValues orders any two values for ORDER BY, by type first
+func orderSQLValues(a any, b any) int {
+	if order, comparable := compareSQLValues(a, b); comparable {
+		return order
+	}
+	rank := func(value any) int {
+		switch value.(type) {
+		case nil:
+			return 0
+		case bool:
+			return 1
+		case float64:
+			return 2
+		case string:
+			return 3
+		}
+		return 4
+	}
+	return cmp.Compare(rank(a), rank(b))
+}
+
+// sqlLikePattern compiles a LIKE pattern, % matches any run of characters and _ one character
+func sqlLikePattern(pattern string) *regexp.Regexp {
+	var builder strings.Builder
+	builder.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			builder.WriteString(".*")
+		case '_':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	builder.WriteString("$")
+	return regexp.MustCompile(builder.String())
+}
+
+//------------------------------------------------------------------
+// REPL
+//------------------------------------------------------------------
+
+// SQLRepl runs the statements read from in, one per line, and prints their results to out.
+// It returns when in ends or on a \q line.
+func SQLRepl(in io.Reader, out io.Writer, exec func(query string) (*SQLResult, error)) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1<<20)
+
+	fmt.Fprint(out, SQL_PROMPT)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == `\q` {
+			return nil
+		}
+
+		if line != "" {
+			result, err := exec(line)
+			if err != nil {
+				fmt.Fprintln(out, "Error:", err)
+			} else {
+				printSQLResult(out, result)
+			}
+		}
+		fmt.Fprint(out, SQL_PROMPT)
+	}
+	return scanner.Err()
+}
+
+func printSQLResult(out io.Writer, result *SQLResult) {
+	if result.Rows == nil {
+		fmt.Fprintf(out, "%d rows affected\n", result.Affected)
+		return
+	}
+
+	for _, row := range result.Rows {
+		line, _ := json.Marshal(row)
+		fmt.Fprintln(out, string(line))
+	}
+	fmt.Fprintf(out, "(%d rows)\n", len(result.Rows))
+}
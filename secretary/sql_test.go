@@ -0,0 +1,175 @@
+package secretary
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSQLExec(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+	table := tree.CollectionName
+
+	exec := func(query string, params ...any) *SQLResult {
+		t.Helper()
+		result, err := s.ExecSQL(fmt.Sprintf(query, table), params...)
+		if err != nil {
+			t.Fatal(query, err)
+		}
+		return result
+	}
+	keys := func(result *SQLResult) []string {
+		keys := []string{}
+		for _, row := range result.Rows {
+			keys = append(keys, row["key"].(string))
+		}
+		return keys
+	}
+
+	for i := 0; i < 20; i += 2 {
+		result := exec("insert into %s (key, name, age, admin) VALUES (?, ?, ?, ?), ($5, $6, $7, $8)",
+			fmt.Sprintf("u%02d", i), fmt.Sprintf("Name'%d", i), 20+i, i%5 == 0,
+			fmt.Sprintf("u%02d", i+1), fmt.Sprintf("Name'%d", i+1), 21+i, (i+1)%5 == 0,
+		)
+		if result.Affected != 2 {
+			t.Fatal("Expected two inserted rows", result.Affected)
+		}
+	}
+	if _, err := tree.SetKV([]byte("raw"), []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+
+	result := exec("SELECT * FROM %s WHERE key = 'u03'")
+	expected := []map[string]any{{"key": "u03", "name": "Name'3", "age": float64(23), "admin": false}}
+	if !reflect.DeepEqual(result.Rows, expected) {
+		t.Fatal("Row mismatch", result.Rows)
+	}
+
+	// Key conditions, in either order, narrow the scan and still filter
+	checks := map[string][]string{
+		"SELECT key FROM %s WHERE key >= 'u05' AND 'u09' > key AND key != 'u06'":   {"u05", "u07", "u08"},
+		"SELECT key FROM %s WHERE key BETWEEN 'u10' AND 'u12' OR key = 'raw'":      {"raw", "u10", "u11", "u12"},
+		"SELECT key FROM %s WHERE key IN ('u19', 'u02', 'missing', 'u02')":         {"u02", "u19"},
+		"SELECT key FROM %s WHERE key LIKE 'u1%%' AND age <= 31 ORDER BY key DESC": {"u11", "u10"},
+		"SELECT key FROM %s WHERE key > 'u17' AND key < 'u12'":                     {},
+		"SELECT key FROM %s WHERE name LIKE '%%''1_' AND NOT admin":                {"u11", "u12", "u13", "u14", "u16", "u17", "u18", "u19"},
+		"SELECT key FROM %s WHERE age NOT BETWEEN 21 AND 38 AND admin IS NOT NULL": {"u00", "u19"},
+		"SELECT key FROM %s WHERE age > 30 AND admin = TRUE":                       {"u15"},
+		"SELECT key FROM %s WHERE age IS NULL":                                     {"raw"},
+		"SELECT key FROM %s WHERE age != 20 AND age NOT IN (21, 22, 'x') LIMIT 2":  {"u03", "u04"},
+		"SELECT key FROM %s WHERE value = 'not json' OR age = 'text'":              {"raw"},
+		"SELECT key FROM %s ORDER BY admin DESC, age DESC LIMIT 3 OFFSET 1":        {"u10", "u05", "u00"},
+		"SELECT key FROM %s WHERE key < 'u05' ORDER BY key DESC LIMIT 2 OFFSET 1":  {"u03", "u02"},
+		"SELECT key FROM %s ORDER BY age LIMIT 2":                                  {"raw", "u00"},
+		"SELECT key FROM %s WHERE (age < 21 OR age > 38) AND NOT (key = 'u00')":    {"u19"},
+		"SELECT key FROM %s WHERE key = 'u04' AND key = 'u05'":                     {},
+		"SELECT key FROM %s WHERE -age = -20 OR name = NULL OR NOT (name != NULL)": {"u00"},
+		"SELECT key FROM %s LIMIT 0":                                               {},
+		"SELECT key FROM %s WHERE key >= 'u18' AND key IN ('u17', 'u18', 'u19')":   {"u18", "u19"},
+		"SELECT key FROM %s WHERE key IN ('u17', 'u18', 'u19') AND key >= 'u18'":   {"u18", "u19"},
+		"SELECT key FROM %s WHERE key BETWEEN 'u18' AND 'u19' ORDER BY key DESC":   {"u19", "u18"},
+		"SELECT key FROM %s WHERE key IN ('u01', 'u02') ORDER BY key DESC LIMIT 1": {"u02"},
+		"SELECT key FROM %s WHERE \"key\" LIKE 'u0%%' ORDER BY age DESC LIMIT 1":   {"u09"},
+		"SELECT key FROM %s WHERE key LIKE '%%0' AND key NOT LIKE 'u1%%'":          {"u00"},
+	}
+	for query, expectedKeys := range checks {
+		if got := keys(exec(query)); !reflect.DeepEqual(got, expectedKeys) {
+			t.Fatal("Mismatch for", query, got)
+		}
+	}
+	if got := keys(exec("SELECT key FROM %s WHERE age IN (?, ?) ORDER BY key DESC LIMIT ? OFFSET ?", 25, 30.0, 1, 1)); !reflect.DeepEqual(got, []string{"u05"}) {
+		t.Fatal("Params mismatch", got)
+	}
+
+	result = exec("SELECT name, missing FROM %s WHERE key = ?", []byte("u15"))
+	if !reflect.DeepEqual(result.Rows, []map[string]any{{"name": "Name'15", "missing": nil}}) {
+		t.Fatal("Projection mismatch", result.Rows)
+	}
+
+	result = exec("UPDATE %s SET age = 99, team = 'Red Team', old = age WHERE key > 'u17'")
+	if result.Affected != 2 {
+		t.Fatal("Expected 2 updated rows", result.Affected)
+	}
+	result = exec("SELECT key, team, old FROM %s WHERE age = 99")
+	expected = []map[string]any{
+		{"key": "u18", "team": "Red Team", "old": float64(38)},
+		{"key": "u19", "team": "Red Team", "old": float64(39)},
+	}
+	if !reflect.DeepEqual(result.Rows, expected) {
+		t.Fatal("Update mismatch", result.Rows)
+	}
+
+	result = exec("DELETE FROM %s WHERE admin = TRUE")
+	if result.Affected != 4 {
+		t.Fatal("Expected 4 deleted rows", result.Affected)
+	}
+	result = exec("SELECT * FROM %s WHERE key = 'u00'")
+	if len(result.Rows) != 0 {
+		t.Fatal("Expected u00 deleted", result.Rows)
+	}
+
+	result, err := s.ExecSQL(fmt.Sprintf("INSERT INTO %s (key) VALUES ('new'), ('u01'), ('never')", table))
+	if err != ErrorDuplicateKey || result.Affected != 1 {
+		t.Fatal("Expected the rows before the duplicate inserted", err, result)
+	}
+
+	failures := map[string]error{
+		"SELECT * FROM missing":                ErrorTreeNotFound,
+		"INSERT INTO %s (name) VALUES ('x')":   ErrorSQLMissingKey,
+		"INSERT INTO %s (key) VALUES (TRUE)":   ErrorSQLKeyType,
+		"UPDATE %s SET key = 'x'":              ErrorSQLSetKey,
+		"SELECT * FROM %s LIMIT -1":            ErrorSQLCount,
+		"SELECT * FROM %s LIMIT 1 OFFSET 1.5":  ErrorSQLCount,
+		"SELECT * FROM %s LIMIT 'ten'":         ErrorSQLCount,
+		"SELECT * FROM %s WHERE key = ? OR $3": ErrorSQLParams(3, 0),
+	}
+	for query, expected := range failures {
+		if strings.Contains(query, "%s") {
+			query = fmt.Sprintf(query, table)
+		}
+		if _, err := s.ExecSQL(query); fmt.Sprint(err) != fmt.Sprint(expected) {
+			t.Fatal("Expected", expected, "for", query, "got", err)
+		}
+	}
+
+	var syntaxErr *SQLSyntaxError
+	if _, err := s.ExecSQL("SELECT * FORM " + table); !errors.As(err, &syntaxErr) || syntaxErr.Column != 10 {
+		t.Fatal("Expected a syntax error", err)
+	}
+	if _, err := s.ExecSQL(fmt.Sprintf("SELECT * FROM %s WHERE key = ?", table), struct{}{}); fmt.Sprint(err) != fmt.Sprint(ErrorSQLParamType(struct{}{})) {
+		t.Fatal("Expected an unsupported parameter", err)
+	}
+
+	s.PagerShutdown()
+}
+
+func TestSQLRepl(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	in := strings.NewReader(fmt.Sprintf(
+		"INSERT INTO %[1]s (key, n) VALUES ('a', 1);\n\nSELECT * FROM %[1]s\nSELECT * FROM missing\n\\q\nSELECT * FROM %[1]s\n",
+		tree.CollectionName,
+	))
+	out := &strings.Builder{}
+	exec := func(query string) (*SQLResult, error) {
+		return s.ExecSQL(query)
+	}
+	if err := SQLRepl(in, out, exec); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := SQL_PROMPT + "1 rows affected\n" +
+		SQL_PROMPT +
+		SQL_PROMPT + `{"key":"a","n":1}` + "\n(1 rows)\n" +
+		SQL_PROMPT + "Error: " + ErrorTreeNotFound.Error() + "\n" +
+		SQL_PROMPT
+	if out.String() != expected {
+		t.Fatalf("REPL output mismatch\n%s", out.String())
+	}
+
+	s.PagerShutdown()
+}
@@ -0,0 +1,492 @@
+package secretary
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/codeharik/secretary/utils/binstruct"
+)
+
+/*
+Transactions
+
+A Txn buffers its writes and remembers, for every key it reads or writes,
+the leaf the key belongs to and the Version of that leaf. markDirty bumps
+the version of every node it is about to change.
+
+Commit locks the trees in collection name order and checks that every key
+still belongs to the same leaf with the same version. If one changed, an
+other writer touched the keys around it and the commit fails with
+ErrorTxnConflict, otherwise nothing the transaction saw has changed and it
+commits as if it ran alone at that moment. Reads see the writes of the
+transaction itself.
+
+The commit is durable and all or nothing across trees :
+
+ 1. each tree logs its writes as one WAL_TXN entry and syncs its WAL
+ 2. the transaction id is appended to SECRETARY/txn.bin and synced
+ 3. the writes are applied to the trees
+
+Replay applies a WAL_TXN entry only if its id is in txn.bin, a crash
+before step 2 leaves entries that are skipped. Once the trees replayed,
+New checkpoints them and drops the ids no WAL holds anymore.
+*/
+
+// Begin starts a transaction over the trees of the Secretary
+func (s *Secretary) Begin() *Txn {
+	return &Txn{
+		secretary: s,
+		reads:     map[*BTree]map[string]txnRead{},
+		writes:    map[*BTree]map[string]txnWrite{},
+	}
+}
+
+func (txn *Txn) tree(collectionName string) (*BTree, error) {
+	if txn.done {
+		return nil, ErrorTxnDone
+	}
+	return txn.secretary.Tree(collectionName)
+}
+
+// observe remembers the leaf of key the first time the transaction reads or writes it, tree.mu is held
+func (txn *Txn) observe(tree *BTree, key []byte) (leaf *Node, keyIndex int, found bool, err error) {
+	if tree.root != nil {
+		leaf, keyIndex, found, err = tree.getLeafNode(key)
+		if err != nil {
+			return nil, 0, false, err
+		}
+	}
+
+	if txn.reads[tree] == nil {
+		txn.reads[tree] = map[string]txnRead{}
+	}
+	if _, ok := txn.reads[tree][string(key)]; !ok {
+		read := txnRead{leaf: leaf}
+		if leaf != nil {
+			read.version = leaf.Version
+		}
+		txn.reads[tree][string(key)] = read
+	}
+
+	return leaf, keyIndex, found, nil
+}
+
+// Get returns the value of key, including the writes of the transaction
+func (txn *Txn) Get(collectionName string, key []byte) ([]byte, error) {
+	tree, err := txn.tree(collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	if write, ok := txn.writes[tree][string(key)]; ok {
+		if write.delete {
+			return nil, ErrorKeyNotFound
+		}
+		return tree.decodeValue(write.value)
+	}
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	defer tree.evictNodes()
+
+	leaf, keyIndex, found, err := txn.observe(tree, key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrorKeyNotFound
+	}
+
+	record, err := tree.readValue(leaf.records[keyIndex])
+	if err != nil {
+		return nil, err
+	}
+	return record.Value, nil
+}
+
+// Set inserts or replaces key on commit
+func (txn *Txn) Set(collectionName string, key []byte, value []byte) error {
+	tree, err := txn.tree(collectionName)
+	if err != nil {
+		return err
+	}
+
+	if err := tree.checkKey(key); err != nil {
+		return err
+	}
+	value, err = tree.encodeValue(value)
+	if err != nil {
+		return err
+	}
+	if err := tree.checkRecordSize(key, value); err != nil {
+		return err
+	}
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	defer tree.evictNodes()
+
+	if _, _, _, err := txn.observe(tree, key); err != nil {
+		return err
+	}
+
+	txn.write(tree, key, txnWrite{value: value})
+	return nil
+}
+
+// Delete removes key on commit
+func (txn *Txn) Delete(collectionName string, key []byte) error {
+	tree, err := txn.tree(collectionName)
+	if err != nil {
+		return err
+	}
+
+	if write, ok := txn.writes[tree][string(key)]; ok {
+		if write.delete {
+			return ErrorKeyNotFound
+		}
+		txn.write(tree, key, txnWrite{delete: true})
+		return nil
+	}
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	defer tree.evictNodes()
+
+	_, _, found, err := txn.observe(tree, key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrorKeyNotFound
+	}
+
+	txn.write(tree, key, txnWrite{delete: true})
+	return nil
+}
+
+func (txn *Txn) write(tree *BTree, key []byte, write txnWrite) {
+	if txn.writes[tree] == nil {
+		txn.writes[tree] = map[string]txnWrite{}
+	}
+	txn.writes[tree][string(key)] = write
+}
+
+// Rollback drops the writes of the transaction
+func (txn *Txn) Rollback() {
+	txn.done = true
+	txn.reads = nil
+	txn.writes = nil
+}
+
+// Commit applies the writes on every tree or on none, ErrorTxnConflict if a key changed since it was read
+func (txn *Txn) Commit() error {
+	if txn.done {
+		return ErrorTxnDone
+	}
+	txn.done = true
+
+	trees := make([]*BTree, 0, len(txn.reads))
+	for tree := range txn.reads {
+		trees = append(trees, tree)
+	}
+	slices.SortFunc(trees, func(a, b *BTree) int { return strings.Compare(a.CollectionName, b.CollectionName) })
+
+	// A fixed lock order, two commits never wait on each other
+	indexes := map[*BTree]treeIndexes{}
+	for _, tree := range trees {
+		treeIndexes, unlock := tree.lockIndexes()
+		defer unlock()
+		indexes[tree] = treeIndexes
+	}
+	for _, tree := range trees {
+		tree.mu.Lock()
+		defer tree.mu.Unlock()
+		defer tree.evictNodes()
+	}
+
+	ops := map[*BTree][]TxnOp{}
+	for _, tree := range trees {
+		for key, read := range txn.reads[tree] {
+			var leaf *Node
+			if tree.root != nil {
+				var err error
+				if leaf, _, _, err = tree.getLeafNode([]byte(key)); err != nil {
+					return err
+				}
+			}
+			if leaf != read.leaf || (leaf != nil && leaf.Version != read.version) {
+				return ErrorTxnConflict
+			}
+		}
+
+		treeOps, err := txn.treeOps(tree)
+		if err != nil {
+			return err
+		}
+		if len(treeOps) > 0 {
+			ops[tree] = treeOps
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+
+	finishes := map[*BTree]func(bool){}
+	for tree, treeOps := range ops {
+		if indexes[tree].empty() {
+			continue
+		}
+		changes, err := tree.indexChanges(treeOps, tree.documentLocked)
+		if err != nil {
+			return err
+		}
+		if finishes[tree], err = beginIndexWrite(indexes[tree], changes, true); err != nil {
+			return err
+		}
+	}
+
+	if err := txn.log(trees, ops); err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, tree := range trees {
+		if treeOps, ok := ops[tree]; ok {
+			err := tree.applyLogged(func() error { return tree.applyTxnOps(treeOps) })
+			if finish, ok := finishes[tree]; ok && err == nil {
+				finish(true)
+			}
+			errs = append(errs, err)
+			tree.maybeCheckpoint()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// treeOps turns the writes on tree into the ops that are logged and applied, in key order
+func (txn *Txn) treeOps(tree *BTree) ([]TxnOp, error) {
+	keys := make([]string, 0, len(txn.writes[tree]))
+	for key := range txn.writes[tree] {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	ops := make([]TxnOp, 0, len(keys))
+	for _, key := range keys {
+		write := txn.writes[tree][key]
+
+		found := false
+		if tree.root != nil {
+			var err error
+			if _, _, found, err = tree.getLeafNode([]byte(key)); err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case write.delete && found:
+			ops = append(ops, TxnOp{Op: WAL_DELETE, Key: []byte(key)})
+		case write.delete:
+			// Set and deleted again by the transaction
+		case found:
+			ops = append(ops, TxnOp{Op: WAL_UPDATE, Key: []byte(key), Value: write.value})
+		default:
+			ops = append(ops, TxnOp{Op: WAL_SET, Key: []byte(key), Value: write.value})
+		}
+	}
+
+	return ops, nil
+}
+
+// log makes the transaction durable, it is committed once its id is in txn.bin
+func (txn *Txn) log(trees []*BTree, ops map[*BTree][]TxnOp) error {
+	txnLog := txn.secretary.txnLog
+	if txnLog == nil {
+		return nil
+	}
+
+	id := txnLog.NextID()
+	idBytes := binary.BigEndian.AppendUint64(nil, id)
+
+	for _, tree := range trees {
+		treeOps, ok := ops[tree]
+		if !ok || tree.wal == nil {
+			continue
+		}
+
+		value, err := binstruct.Serialize(treeOps)
+		if err != nil {
+			return err
+		}
+		if err := tree.logMutation(WAL_TXN, idBytes, value); err != nil {
+			return err
+		}
+		if err := tree.wal.Sync(); err != nil {
+			return err
+		}
+	}
+
+	return txnLog.Commit(id)
+}
+
+func (tree *BTree) applyTxnOps(ops []TxnOp) error {
+	for _, op := range ops {
+		var err error
+		switch op.Op {
+		case WAL_SET:
+			_, err = tree.setKV(op.Key, op.Value)
+		case WAL_UPDATE:
+			err = tree.update(op.Key, op.Value)
+		case WAL_DELETE:
+			err = tree.delete(op.Key)
+		default:
+			err = ErrorTxnUnknownOp(op.Op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyWALTxn replays a WAL_TXN entry if its transaction committed
+func (tree *BTree) applyWALTxn(entry *WALEntry) error {
+	if len(entry.Key) != 8 {
+		return ErrorInvalidDataLocation
+	}
+	if tree.txnLog != nil && !tree.txnLog.Committed(binary.BigEndian.Uint64(entry.Key)) {
+		return nil
+	}
+
+	var ops []TxnOp
+	if err := binstruct.Deserialize(entry.Value, &ops); err != nil {
+		return err
+	}
+	return tree.applyTxnOps(ops)
+}
+
+//------------------------------------------------------------------
+// Transaction Log
+//------------------------------------------------------------------
+
+func NewTxnLog() (*TxnLog, error) {
+	if MODE_WASM {
+		return nil, ErrorModeWASM
+	}
+
+	file, err := os.OpenFile(fmt.Sprintf("%s/txn.bin", SECRETARY), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	txnLog := &TxnLog{file: file, committed: map[uint64]bool{}}
+	if err := txnLog.read(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return txnLog, nil
+}
+
+// read loads the committed ids, a torn tail is cut off
+func (txnLog *TxnLog) read() error {
+	stat, err := txnLog.file.Stat()
+	if err != nil {
+		return ErrorFileStat(err)
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(txnLog.file, 0, stat.Size()))
+	if err != nil {
+		return ErrorReadingDataAtOffset(0, err)
+	}
+
+	offset := 0
+	for {
+		payload := decodeFrame(data[offset:])
+		if len(payload) != 8 {
+			break
+		}
+		id := binary.BigEndian.Uint64(payload)
+		txnLog.committed[id] = true
+		txnLog.lastID = max(txnLog.lastID, id)
+		offset += WAL_FRAME_HEADER_SIZE + len(payload)
+	}
+
+	txnLog.size = int64(offset)
+	if txnLog.size != stat.Size() {
+		return txnLog.file.Truncate(txnLog.size)
+	}
+	return nil
+}
+
+// NextID returns an id that no transaction of a previous run used, they are time based
+func (txnLog *TxnLog) NextID() uint64 {
+	txnLog.mu.Lock()
+	defer txnLog.mu.Unlock()
+
+	txnLog.lastID = max(txnLog.lastID+1, uint64(time.Now().UnixNano()))
+	return txnLog.lastID
+}
+
+// Commit appends id and syncs, the transaction is committed once it returns
+func (txnLog *TxnLog) Commit(id uint64) error {
+	txnLog.mu.Lock()
+	defer txnLog.mu.Unlock()
+
+	frame := encodeFrame(binary.BigEndian.AppendUint64(nil, id))
+	n, err := txnLog.file.WriteAt(frame, txnLog.size)
+	if err != nil || n != len(frame) {
+		return ErrorWritingDataAtOffset(txnLog.size, err)
+	}
+	if err := txnLog.file.Sync(); err != nil {
+		return err
+	}
+
+	txnLog.size += int64(n)
+	txnLog.committed[id] = true
+	return nil
+}
+
+func (txnLog *TxnLog) Committed(id uint64) bool {
+	txnLog.mu.Lock()
+	defer txnLog.mu.Unlock()
+
+	return txnLog.committed[id]
+}
+
+// Reset rewrites the log with the committed ids of keep, no other WAL may hold a WAL_TXN entry anymore
+func (txnLog *TxnLog) Reset(keep []uint64) error {
+	txnLog.mu.Lock()
+	defer txnLog.mu.Unlock()
+
+	data := []byte{}
+	committed := map[uint64]bool{}
+	for _, id := range keep {
+		if !committed[id] {
+			data = append(data, encodeFrame(binary.BigEndian.AppendUint64(nil, id))...)
+			committed[id] = true
+		}
+	}
+
+	if err := txnLog.file.Truncate(0); err != nil {
+		return err
+	}
+	if n, err := txnLog.file.WriteAt(data, 0); err != nil || n != len(data) {
+		return ErrorWritingDataAtOffset(0, err)
+	}
+	txnLog.size = int64(len(data))
+	txnLog.committed = committed
+
+	return txnLog.file.Sync()
+}
+
+func (txnLog *TxnLog) Close() error {
+	return txnLog.file.Close()
+}
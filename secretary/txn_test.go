@@ -0,0 +1,193 @@
+package secretary
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/codeharik/secretary/utils"
+	"github.com/codeharik/secretary/utils/binstruct"
+)
+
+func txnKeys(n int) []string {
+	var keySeq uint64 = 0
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT)
+	}
+	return keys
+}
+
+func TestTxnCommitAcrossTrees(t *testing.T) {
+	s := dummySecretary(t)
+	accounts := dummyTree(t, s, 4)
+	ledger := dummyTree(t, s, 5)
+
+	keys := txnKeys(20)
+	for _, key := range keys[:10] {
+		if _, err := accounts.SetKV([]byte(key), []byte("100")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	txn := s.Begin()
+	value, err := txn.Get(accounts.CollectionName, []byte(keys[0]))
+	if err != nil || string(value) != "100" {
+		t.Fatal("Expected the stored value", string(value), err)
+	}
+	if err := txn.Set(accounts.CollectionName, []byte(keys[0]), []byte("50")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set(accounts.CollectionName, []byte(keys[10]), []byte("50")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete(accounts.CollectionName, []byte(keys[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Set(ledger.CollectionName, []byte(keys[0]), []byte("transfer")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The transaction sees its own writes, the trees do not yet
+	if value, err := txn.Get(accounts.CollectionName, []byte(keys[0])); err != nil || string(value) != "50" {
+		t.Fatal("Expected the transaction write", string(value), err)
+	}
+	if _, err := txn.Get(accounts.CollectionName, []byte(keys[1])); err != ErrorKeyNotFound {
+		t.Fatal("Expected the deleted key to be missing", err)
+	}
+	if _, err := ledger.Get([]byte(keys[0])); err != ErrorKeyNotFound {
+		t.Fatal("Expected the write to wait for commit", err)
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != ErrorTxnDone {
+		t.Fatal("Expected a second commit to fail", err)
+	}
+
+	expected := map[string]string{}
+	for _, key := range keys[2:10] {
+		expected[key] = "100"
+	}
+	expected[keys[0]] = "50"
+	expected[keys[10]] = "50"
+	verifyTreeRecords(t, accounts, expected)
+	verifyTreeRecords(t, ledger, map[string]string{keys[0]: "transfer"})
+
+	s.PagerShutdown()
+}
+
+func TestTxnRollback(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	keys := txnKeys(5)
+	if _, err := tree.SetKV([]byte(keys[0]), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := s.Begin()
+	if err := txn.Set(tree.CollectionName, []byte(keys[1]), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete(tree.CollectionName, []byte(keys[0])); err != nil {
+		t.Fatal(err)
+	}
+	txn.Rollback()
+
+	if err := txn.Set(tree.CollectionName, []byte(keys[2]), []byte("value")); err != ErrorTxnDone {
+		t.Fatal("Expected the transaction to be done", err)
+	}
+	verifyTreeRecords(t, tree, map[string]string{keys[0]: "value"})
+
+	s.PagerShutdown()
+}
+
+func TestTxnConflict(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	keys := txnKeys(5)
+	for _, key := range keys {
+		if _, err := tree.SetKV([]byte(key), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := s.Begin()
+	second := s.Begin()
+	if _, err := first.Get(tree.CollectionName, []byte(keys[0])); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Set(tree.CollectionName, []byte(keys[1]), []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Set(tree.CollectionName, []byte(keys[0]), []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// first read keys[0] before second changed it
+	if err := first.Commit(); err != ErrorTxnConflict {
+		t.Fatal("Expected a conflict", err)
+	}
+
+	expected := map[string]string{}
+	for _, key := range keys {
+		expected[key] = "value"
+	}
+	expected[keys[0]] = "second"
+	verifyTreeRecords(t, tree, expected)
+
+	s.PagerShutdown()
+}
+
+func TestTxnRecovery(t *testing.T) {
+	s := dummySecretary(t)
+	first := dummyTree(t, s, 4)
+	second := dummyTree(t, s, 4)
+
+	keys := txnKeys(4)
+
+	committed := s.Begin()
+	for _, tree := range []*BTree{first, second} {
+		if err := committed.Set(tree.CollectionName, []byte(keys[0]), []byte("committed")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := committed.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash after the WALs are synced but before the id reaches txn.bin
+	id := binary.BigEndian.AppendUint64(nil, s.txnLog.NextID())
+	for _, tree := range []*BTree{first, second} {
+		value, err := binstruct.Serialize([]TxnOp{{Op: WAL_SET, Key: []byte(keys[1]), Value: []byte("uncommitted")}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.logMutation(WAL_TXN, id, value); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.closeFiles(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.txnLog.Close()
+
+	reopened := dummySecretary(t)
+	for _, tree := range []*BTree{first, second} {
+		reloaded, err := reopened.Tree(tree.CollectionName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyTreeRecords(t, reloaded, map[string]string{keys[0]: "committed"})
+	}
+	if reopened.txnLog.size != 0 {
+		t.Fatal("Expected the transaction log to be reset after replay")
+	}
+
+	reopened.PagerShutdown()
+}
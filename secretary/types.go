@@ -9,7 +9,8 @@ import (
 )
 
 type Secretary struct {
-	trees map[string]*BTree
+	trees  map[string]*BTree
+	txnLog *TxnLog
 
 	listener net.Listener
 	server   *http.Server
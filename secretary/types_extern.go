@@ -3,6 +3,7 @@ package secretary
 import (
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/codeharik/secretary/utils"
 	"github.com/dgraph-io/ristretto/v2"
@@ -16,15 +17,20 @@ var (
 const (
 	SECRETARY                  = "SECRETARY"
 	SECRETARY_HEADER_LENGTH    = 128
+	SECRETARY_HEADER_EXTENSION = 80 // Offset of the header fields that are not written by binstruct
+	SECRETARY_HEADER_VERSION   = 3
 	MAX_COLLECTION_NAME_LENGTH = 30
 
 	MIN_ORDER = 3   // Minimum allowed order for the B+ Tree
 	MAX_ORDER = 200 // Maximum allowed order for the B+ Tree
 
-	KEY_SIZE        = 16
+	KEY_SIZE        = 16 // Size of every key in trees without MaxKeySize, see keys.go
 	KEY_OFFSET_SIZE = 8
 	POINTER_SIZE    = 8
 
+	DEFAULT_MAX_KEY_SIZE = 64   // Longest key of new trees unless given
+	MAX_KEY_SIZE         = 1024 // Longest key a tree can take
+
 	KEY_INCREMENT = 5
 
 	BYTE_8  = uint64(1<<8 - 1)
@@ -40,19 +46,30 @@ const (
 /*
 **HEADER AND NODES**
 
+binstruct writes fields in the order of their bin tags, a new header field
+must not get a bin tag or it shifts the fields of existing headers.
+Fields added after the first release live in the extension at offset 80.
+
 ------128 bytes------
-SECRETARY				(9 bytes)  9
-order 					(uint8)    10
-NumLevel  				(uint8)    11
-baseSize  			(uint32)   15
-increment 				(uint8)    16
-keySeq    				(uint64)   24
-nodeSeq    				(uint64)   32
-numNodeSeq    			(uint64)   40
-compactionBatchSize    	(uint32)   48
-collectionName			(string)
+SECRETARY				(9 bytes)
+baseSize				(uint32)
+collectionName			(uint32 length + max 30 bytes)
+compactionBatchSize		(uint32)
+increment				(uint8)
+keySeq					(uint64)
+nodeSeq					(uint64)
+numLevel				(uint8)
+numNodeSeq				(uint64)
+order					(uint8)
+-------- padding '-' up to byte 80
+80	version				(uint8) '-' in headers written before the extension
+81	rootIndex			(uint64)
+89	checkpointLSN		(uint64)
+97	freeListIndex		(uint64) version 2, first page of the free list
+105	maxKeySize			(uint16) version 3, 0 for fixed 16 byte keys
+107	reserved
 ---------------------
-1  			Root		(5*1024 = 5120)
+0			Reserved	(index 0 means no node)
 ---------------------
 order		Internal
 order ^ 2	Internal
@@ -63,16 +80,46 @@ order ^ n	Leaf
 ---------------------
 */
 type BTree struct {
-	mu sync.Mutex // Global Lock for root changes
+	mu      sync.RWMutex // Tree latch, shared while nodes are latched, exclusive for merges, checkpoints and eviction (see latch.go)
+	rootMu  sync.RWMutex // Latch above the root, held while tree.root may change
+	nodesMu sync.Mutex   // Guards nodes, dirtyNodes, numLoaded, stub loading and the free page counters in shared mode
+	seqMu   sync.Mutex   // Logs KeySeq in the order concurrent writers append to the WAL
+
+	versionsMu    sync.Mutex                 // Guards versions in shared mode
+	snapshots     map[uint64]*Snapshot       // Open snapshots by ID, see snapshot.go
+	snapshotEpoch uint64                     // ID of the last snapshot taken
+	versions      map[string][]recordVersion // Records replaced while snapshots are open, by key
 
 	CollectionName string `json:"collectionName" bin:"collectionName" max:"30"` // Max 30Char
 
+	schema  atomic.Pointer[Schema]      // Schema of the documents, nil for raw values, see schema.go
+	vectors atomic.Pointer[vectorIndex] // Vector options and HNSW graph, nil for raw values, see vector.go
+
+	indexMu   sync.RWMutex  // Shared by writes without indexes, exclusive for writes that maintain them, see index.go
+	indexes   []*fieldIndex // Secondary indexes on document fields
+	textIndex *textIndex    // Full-text index, see fulltext.go
+
 	nodePager    *NodePager
 	recordPagers []*RecordPager
+	wal          *WAL
+	journal      *Journal
+	txnLog       *TxnLog // Committed transactions of the Secretary, decides which WAL_TXN entries replay
 
 	root               *Node // Root node of the tree
 	nextCompactionNode *Node // Compaction Node For Current Batch
 
+	compaction       *Compaction      // Running compaction pass, nil between passes
+	compactionStatus CompactionStatus // Progress reported over HTTP
+	compactionStop   chan struct{}    // Closed to stop the background compactor
+	compactionDone   chan struct{}    // Closed once the background compactor returned
+
+	nodes          map[uint64]*Node   // Loaded nodes and stubs by page index
+	dirtyNodes     map[*Node]struct{} // Nodes changed since the last checkpoint
+	nextNodeIndex  uint64             // Next unused page index in index.bin
+	freeListPages  []uint64           // Pages of index.bin holding the free list, see freelist.go
+	numLoaded      int                // Nodes in nodes that are not stubs
+	maxLoadedNodes int                // Clean nodes are unloaded beyond this
+
 	Order     uint8  `json:"order" bin:"order"`         // Max = 255, Order of the tree (maximum number of children)
 	NumLevel  uint8  `json:"numLevel" bin:"numLevel"`   // 32, Max 256 levels
 	BaseSize  uint32 `json:"baseSize" bin:"baseSize"`   // 1024Bytes
@@ -82,6 +129,13 @@ type BTree struct {
 	NodeSeq    uint64 `json:"nodeSeq" bin:"nodeSeq"` // Incrementing Node sequence
 	NumNodeSeq uint64 `json:"numNodeSeq" bin:"numNodeSeq"`
 
+	RootIndex     uint64 `json:"rootIndex"`     // Page index of the root node, header extension
+	CheckpointLSN uint64 `json:"checkpointLSN"` // Last WAL entry contained in index.bin, header extension
+	FreeListIndex uint64 `json:"freeListIndex"` // First page of the free list, header extension
+	MaxKeySize    uint16 `json:"maxKeySize"`    // Longest key, 0 for the fixed KEY_SIZE keys of older trees, header extension
+
+	ReclaimableBytes uint64 `json:"reclaimableBytes"` // Size of the free node pages and record slots
+
 	nodeSize   uint32
 	minNumKeys uint32 // Minimum required keys in node
 
@@ -91,15 +145,21 @@ type BTree struct {
 /*
 **Node Structure**
 +----------------+----------------+----------------+----------------+
-| NodeID         | parentOffset   | nextOffset     | prevOffset     |
+| Index          | ParentIndex    | NextIndex      | PrevIndex      |
 | (8 bytes)      | (8 bytes)      | (8 bytes)      | (8 bytes)      |
 +----------------+----------------+----------------+----------------+
-| keyOffsets...                                                     |
+| NodeID         | Version        | IsLeaf         |                |
+| (8 bytes)      | (8 bytes)      | (1 byte)       |                |
++----------------+----------------+----------------+----------------+
+| KeyLocations...  (child indexes or record locations)              |
 | (8 bytes each)                                                    |
 +----------------+----------------+----------------+----------------+
 | Keys...                                                           |
 | (16 bytes each)                                                   |
 +----------------+----------------+----------------+----------------+
+
+Trees with a MaxKeySize store their keys with a common prefix instead,
+see keys.go.
 */
 type Node struct {
 	mu      sync.RWMutex // 🚦 Latch for Synchronization
@@ -113,13 +173,16 @@ type Node struct {
 	children []*Node
 	records  []*Record
 
+	stub bool // Only Index is known, fields are read from index.bin on first use
+
 	Index       uint64 `bin:"Index"`
 	ParentIndex uint64 `bin:"ParentIndex"`
-	// NextIndex   uint64 `bin:"NextIndex"`
-	// PrevIndex   uint64 `bin:"PrevIndex"`
+	NextIndex   uint64 `bin:"NextIndex"`
+	PrevIndex   uint64 `bin:"PrevIndex"`
+	IsLeaf      uint8  `bin:"IsLeaf"`
 
 	KeyLocation []uint64 `bin:"KeyLocations"`             // (8 bytes) [node index | record location]
-	Keys        [][]byte `bin:"Keys" array_elem_len:"16"` // (16 bytes)
+	Keys        [][]byte `bin:"Keys" array_elem_len:"16"` // (16 bytes) Full keys in memory, see keys.go for the page layouts
 }
 
 type PageItem[T any] interface {
@@ -148,6 +211,9 @@ type Pager[T PageItem[T]] struct {
 	cache      *ristretto.Cache[int64, *Page[T]] // In-memory cache
 	dirtyPages map[int64]bool
 
+	freePages    []int64 // Freed before the last checkpoint, reused before the file grows
+	pendingPages []int64 // Freed since the last checkpoint, which may still point to them
+
 	mu sync.Mutex
 }
 
@@ -162,8 +228,174 @@ type RecordPager struct {
 type Record struct {
 	Offset uint64 // (8 bytes)
 	Size   uint32 // (4 bytes) Max size = 4GB
-	Key    []byte // (8 bytes or 16 bytes)
-	Value  []byte
+	Key    []byte `bin:"Key"` // (up to MaxKeySize bytes)
+	Value  []byte `bin:"Value"`
+
+	location *RecordLocation // nil until the record is written to a record pager
+}
+
+type WALOp uint8
+
+const (
+	WAL_SET WALOp = iota + 1
+	WAL_UPDATE
+	WAL_DELETE
+	WAL_ERASE
+	WAL_SORTED_SET
+	WAL_TXN       // Key is the transaction id, Value the TxnOps for this tree
+	WAL_BATCH     // Value is the TxnOps of an ApplyBatch
+	WAL_BULK_LOAD // Key is the keys per packed leaf, Value the dumped Records of a BulkLoad chunk
+)
+
+/*
+**WAL Entry**
++----------------+----------------+----------------+
+| Length         | CRC32C         | Payload        |
+| (4 bytes)      | (4 bytes)      | (Length bytes) |
++----------------+----------------+----------------+
+*/
+type WALEntry struct {
+	LSN    uint64 `bin:"LSN"`    // Log sequence number, strictly increasing
+	KeySeq uint64 `bin:"KeySeq"` // tree.KeySeq before the mutation was applied
+	Op     WALOp  `bin:"Op"`
+	Key    []byte `bin:"Key"`
+	Value  []byte `bin:"Value"`
+}
+
+// WAL is the per-collection write-ahead log (SECRETARY/<collection>/wal.bin)
+type WAL struct {
+	file *os.File
+
+	lsn  uint64 // Last appended LSN
+	size int64  // Current size of the log file
+
+	mu sync.Mutex
+}
+
+// TxnOp is a write of a committed transaction or a batch, logged in a WAL_TXN or WAL_BATCH entry
+type TxnOp struct {
+	Op    WALOp  `bin:"Op"` // WAL_SET, WAL_UPDATE or WAL_DELETE
+	Key   []byte `bin:"Key"`
+	Value []byte `bin:"Value"`
+}
+
+type OpType uint8
+
+const (
+	OP_PUT OpType = iota + 1
+	OP_DELETE
+)
+
+// Op is a put or a delete of ApplyBatch
+type Op struct {
+	Type  OpType
+	Key   []byte
+	Value []byte // Ignored by OP_DELETE
+}
+
+// OpResult is the outcome of the Op at the same index
+type OpResult struct {
+	Created bool  // An OP_PUT inserted a new key, false if it replaced a value
+	Err     error // Why the op was skipped, nil if it was applied
+}
+
+// TxnLog holds the ids of the committed transactions (SECRETARY/txn.bin)
+type TxnLog struct {
+	file *os.File
+
+	committed map[uint64]bool
+	lastID    uint64
+	size      int64
+
+	mu sync.Mutex
+}
+
+// Txn groups reads and writes on several trees of a Secretary, see txn.go
+type Txn struct {
+	secretary *Secretary
+
+	reads  map[*BTree]map[string]txnRead
+	writes map[*BTree]map[string]txnWrite
+	done   bool
+}
+
+// txnRead is the leaf a transaction found a key in, with the version it had
+type txnRead struct {
+	leaf    *Node
+	version uint64
+}
+
+type txnWrite struct {
+	value  []byte
+	delete bool
+}
+
+/*
+**Journal Page**
+Page image written to journal.bin before the checkpoint overwrites it in index.bin
+*/
+type JournalPage struct {
+	Offset uint64 `bin:"Offset"` // Byte offset in index.bin
+	Data   []byte `bin:"Data"`
+}
+
+// Journal holds the page images of the running checkpoint (SECRETARY/<collection>/journal.bin)
+type Journal struct {
+	file *os.File
+
+	mu sync.Mutex
+}
+
+/*
+**Free List**
+Free pages of index.bin and free slots of the record files, saved by Checkpoint
+in a chain of index.bin pages
++----------------+----------------+----------------+
+| NextIndex      | Length         | Payload        |
+| (8 bytes)      | (4 bytes)      | (Length bytes) |
++----------------+----------------+----------------+
+*/
+type FreeList struct {
+	Nodes   []uint64 `bin:"Nodes"`   // Page indexes in index.bin
+	Records []uint64 `bin:"Records"` // Packed RecordLocation of each free slot
+}
+
+/*
+**Compaction**
+A compaction pass rewrites the nodes batch by batch in BFS order into
+SECRETARY/<collection>/compact/index.bin and copies their records densely
+into the record files next to it, see compaction.go
+*/
+type Compaction struct {
+	nodePager    *NodePager
+	recordPagers []*RecordPager
+
+	nodeIndexes map[*Node]uint64  // Page in the new index.bin of every node reached so far
+	written     map[*Node]bool    // Nodes whose current content is in the new index.bin
+	records     map[uint64]uint64 // Packed RecordLocation of a copied record, old to new
+	nextIndex   uint64            // Next unused page in the new index.bin
+}
+
+type CompactionStatus struct {
+	Running        bool   `json:"running"`        // Background compactor started
+	Passes         uint64 `json:"passes"`         // Passes that swapped the files
+	NodesCopied    uint64 `json:"nodesCopied"`    // Nodes written by the current pass
+	RecordsCopied  uint64 `json:"recordsCopied"`  // Records copied by the current pass
+	ReclaimedBytes uint64 `json:"reclaimedBytes"` // Bytes freed by the last swap
+	LastError      string `json:"lastError"`
+}
+
+// TreeStats is the size and state of a tree
+type TreeStats struct {
+	Height           int              `json:"height"`
+	NumNodes         uint64           `json:"numNodes"`
+	LoadedNodes      int              `json:"loadedNodes"`
+	KeySeq           uint64           `json:"keySeq"`
+	ReclaimableBytes uint64           `json:"reclaimableBytes"`
+	WALBytes         int64            `json:"walBytes"` // Logged since the last checkpoint
+	CheckpointLSN    uint64           `json:"checkpointLSN"`
+	OpenSnapshots    int              `json:"openSnapshots"`
+	Compaction       CompactionStatus `json:"compaction"`
 }
 
 type RecordLocation struct {
@@ -7,7 +7,8 @@ import (
 )
 
 type Secretary struct {
-	trees map[string]*BTree
+	trees  map[string]*BTree
+	txnLog *TxnLog
 
 	quit chan any
 	wg   sync.WaitGroup
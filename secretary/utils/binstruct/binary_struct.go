@@ -100,6 +100,10 @@ func Serialize(s interface{}) ([]byte, error) {
 			binary.Write(buf, BYTEORDER, int64(fieldValue.Int()))
 		case reflect.Uint64:
 			binary.Write(buf, BYTEORDER, uint64(fieldValue.Uint()))
+		case reflect.Int:
+			binary.Write(buf, BYTEORDER, fieldValue.Int()) // int is written as int64
+		case reflect.Bool:
+			binary.Write(buf, BYTEORDER, fieldValue.Bool())
 		case reflect.Float64:
 			binary.Write(buf, BYTEORDER, float64(fieldValue.Float()))
 		case reflect.String:
@@ -369,6 +373,14 @@ func Deserialize(data []byte, s interface{}) error {
 			var num uint64
 			binary.Read(buf, BYTEORDER, &num)
 			fieldValue.SetUint(num)
+		case reflect.Int:
+			var num int64
+			binary.Read(buf, BYTEORDER, &num)
+			fieldValue.SetInt(num)
+		case reflect.Bool:
+			var flag bool
+			binary.Read(buf, BYTEORDER, &flag)
+			fieldValue.SetBool(flag)
 		case reflect.Float64:
 			var num float64
 			binary.Read(buf, BYTEORDER, &num)
@@ -593,8 +605,10 @@ func MarshalJSON(s interface{}) ([]byte, error) {
 		}
 
 		switch fieldValue.Kind() {
-		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 			jsonMap[tag] = fieldValue.Int()
+		case reflect.Bool:
+			jsonMap[tag] = fieldValue.Bool()
 		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 			jsonMap[tag] = fieldValue.Uint()
 		case reflect.Float64, reflect.Float32:
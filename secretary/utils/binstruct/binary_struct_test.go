@@ -21,6 +21,8 @@ type testStruct struct {
 	Fuint64  uint64  `bin:"Fuint64"`
 	Ffloat64 float64 `bin:"Ffloat64"`
 	Fstring  string  `bin:"Fstring"`
+	Fint     int     `bin:"Fint"`
+	Fbool    bool    `bin:"Fbool"`
 
 	Fstring_4_30 string `bin:"Fstring_4_30" lenbyte:"1" max:"30"`
 	Fstring_30   string `bin:"Fstring_30" max:"30"`
@@ -62,6 +64,8 @@ func TestBinaryStructSerialize(t *testing.T) {
 				Fuint64:      18000000000000000000,
 				Ffloat64:     3.1415926535,
 				Fstring:      "Hello",
+				Fint:         -7000000000,
+				Fbool:        true,
 				Fstring_4_30: "Hello",
 				Fstring_30:   "Hello",
 				Fbytes:       []byte{0x12, 0x34, 0x56, 0x78},
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go/token"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/codeharik/secretary/utils"
@@ -44,12 +47,9 @@ func StructToSchema(instance any) (string, error) {
 		}
 
 		// Extract all tags dynamically
-		tagMap := make(map[string]string)
-		for _, tag := range strings.Split(string(field.Tag), " ") {
-			parts := strings.SplitN(tag, ":", 2)
-			if len(parts) == 2 {
-				tagMap[strings.Trim(parts[0], "` ")] = strings.Trim(parts[1], "`\"")
-			}
+		tagMap, err := parseTags(field.Tag)
+		if err != nil {
+			return "", fmt.Errorf("field %s: %w", field.Name, err)
 		}
 
 		// Convert to schema format
@@ -76,9 +76,21 @@ func SchemaToStruct(schemaJSON string) (*DynamicStruct, error) {
 		return nil, err
 	}
 
+	// Fields are laid out by name, the same schema always builds the same struct
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
 	fields := []reflect.StructField{}
 
-	for name, info := range schema {
+	for _, name := range names {
+		info := schema[name]
+		if !token.IsIdentifier(name) || !token.IsExported(name) {
+			return nil, fmt.Errorf("field %q must be an exported Go identifier", name)
+		}
+
 		var fieldType reflect.Type
 		switch info.Type {
 		case "int":
@@ -90,13 +102,21 @@ func SchemaToStruct(schemaJSON string) (*DynamicStruct, error) {
 		case "bool":
 			fieldType = reflect.TypeOf(true)
 		default:
-			continue
+			return nil, fmt.Errorf("field %s has unsupported type %q", name, info.Type)
 		}
 
-		// Construct struct tag correctly
+		// Construct struct tag correctly, values are quoted so field.Tag.Get returns them unchanged
+		keys := make([]string, 0, len(info.Tags))
+		for k := range info.Tags {
+			if k == "" || strings.ContainsAny(k, " :\"`") {
+				return nil, fmt.Errorf("field %s has invalid tag name %q", name, k)
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
 		tagParts := []string{}
-		for k, v := range info.Tags {
-			tagParts = append(tagParts, fmt.Sprintf(`%s:"%s"`, k, v))
+		for _, k := range keys {
+			tagParts = append(tagParts, k+":"+strconv.Quote(info.Tags[k]))
 		}
 		tagStr := strings.Join(tagParts, " ")
 
@@ -114,6 +134,39 @@ func SchemaToStruct(schemaJSON string) (*DynamicStruct, error) {
 	}, nil
 }
 
+// parseTags splits a struct tag into its unquoted key value pairs
+func parseTags(tag reflect.StructTag) (map[string]string, error) {
+	tagMap := make(map[string]string)
+	rest := strings.TrimSpace(string(tag))
+	for rest != "" {
+		colon := strings.Index(rest, ":")
+		if colon <= 0 || colon+1 >= len(rest) || rest[colon+1] != '"' {
+			return nil, fmt.Errorf("malformed tag %q", string(tag))
+		}
+		key := rest[:colon]
+
+		// Find the closing quote, skipping escaped characters
+		end := colon + 2
+		for end < len(rest) && rest[end] != '"' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return nil, fmt.Errorf("malformed tag %q", string(tag))
+		}
+
+		value, err := strconv.Unquote(rest[colon+1 : end+1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed tag %q", string(tag))
+		}
+		tagMap[key] = value
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+	return tagMap, nil
+}
+
 func (ds *DynamicStruct) ToSchema() (string, error) {
 	instance := reflect.New(ds.Type).Elem().Interface()
 
@@ -191,6 +244,13 @@ func (ds *DynamicStruct) ValidateStruct(instance any) error {
 		regexTag := field.Tag.Get("regex")
 		errorMsg := strings.ReplaceAll(field.Tag.Get("error"), "_", " ")
 
+		if field.Tag.Get("required") == "true" && value.IsZero() {
+			if errorMsg != "" {
+				return errors.New(errorMsg)
+			}
+			return fmt.Errorf("field %s is required", field.Name)
+		}
+
 		if regexTag != "" {
 			if !utils.ValidateRegex(fmt.Sprint(value), regexTag) {
 				if errorMsg != "" {
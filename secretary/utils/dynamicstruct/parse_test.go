@@ -2,6 +2,7 @@ package dynamicstruct
 
 import (
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -137,3 +138,59 @@ func testMachine(t *testing.T, dynamicMachine *DynamicStruct, pass bool, machine
 	// 	"newMachineN", newMachineN,
 	// 	"newMachineD", newMachineD)
 }
+
+func TestSchemaToStruct(t *testing.T) {
+	schema := `{
+		"Zone": {"type": "string", "tags": {"regex": "^\\d{2}$", "error": "Zone_must_be_two_digits"}},
+		"Age":  {"type": "int", "tags": {"required": "true"}},
+		"Admin": {"type": "bool"}
+	}`
+
+	ds, err := SchemaToStruct(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"Admin", "Age", "Zone"} {
+		if ds.Type.Field(i).Name != name {
+			t.Fatal("Expected fields sorted by name", ds.Type)
+		}
+	}
+	if ds.Type.Field(2).Tag.Get("regex") != `^\d{2}$` {
+		t.Fatal("Tag value changed", ds.Type.Field(2).Tag)
+	}
+
+	validations := map[string]string{
+		`{"Age": 3, "Zone": "42"}`:   "",
+		`{"Zone": "42"}`:             "field Age is required",
+		`{"Age": 3, "Zone": "4two"}`: "Zone must be two digits",
+	}
+	for document, expected := range validations {
+		instance := ds.NewInstance()
+		if err := instance.JsonUnmarshal([]byte(document)); err != nil {
+			t.Fatal(err)
+		}
+		if err := instance.Validate(); fmt.Sprint(err) != expected && !(expected == "" && err == nil) {
+			t.Fatal("Expected", expected, "for", document, "got", err)
+		}
+	}
+
+	roundTrip, err := ds.ToSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := SchemaToStruct(roundTrip)
+	if err != nil || again.Type != ds.Type {
+		t.Fatal("Schema round trip mismatch", roundTrip, err)
+	}
+
+	for _, invalid := range []string{
+		`{"Name": {"type": "complex"}}`,
+		`{"name": {"type": "string"}}`,
+		`{"Na me": {"type": "string"}}`,
+		`{"Name": {"type": "string", "tags": {"a:b": "c"}}}`,
+	} {
+		if _, err := SchemaToStruct(invalid); err == nil {
+			t.Fatal("Expected an invalid schema", invalid)
+		}
+	}
+}
@@ -38,3 +38,26 @@ func EnsureFile(path string) error {
 
 	return nil
 }
+
+// ReplaceFile writes data to path through a synced temporary file and a rename,
+// a crash leaves either the previous content or data
+func ReplaceFile(path string, data []byte) error {
+	temp := path + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
@@ -2,24 +2,27 @@ package ngram
 
 import (
 	"strings"
-
-	"github.com/codeharik/secretary/utils"
+	"unicode"
 )
 
-// GenerateNGrams creates n-grams of the given size from a string.
+// Words splits text into lower case words, runs of letters and digits.
+func Words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// GenerateNGrams creates n-grams of the given size from the words of a string.
 func GenerateNGrams(text string, n int) []string {
 	var ngrams []string
-	words := strings.Fields(text) // Split into words
-
-	utils.Log(words)
+	words := Words(text)
 
-	if len(words) < n {
+	if n < 1 || len(words) < n {
 		return ngrams // Not enough words to form an n-gram
 	}
 
 	for i := 0; i <= len(words)-n; i++ {
 		ngrams = append(ngrams, strings.Join(words[i:i+n], " "))
-		utils.Log(ngrams)
 	}
 
 	return ngrams
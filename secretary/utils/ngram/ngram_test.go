@@ -1,14 +1,35 @@
 package ngram
 
 import (
-	"fmt"
+	"reflect"
 	"testing"
 )
 
 func TestNGram(t *testing.T) {
-	text := "hello world this is a test"
+	text := "Hello, world! This is a test"
 
-	fmt.Println("Bigrams:", GenerateNGrams(text, 2))
-	fmt.Println("Trigrams:", GenerateNGrams(text, 3))
-	fmt.Println("Four-grams:", GenerateNGrams(text, 4))
+	tests := []struct {
+		n        int
+		expected []string
+	}{
+		{1, []string{"hello", "world", "this", "is", "a", "test"}},
+		{2, []string{"hello world", "world this", "this is", "is a", "a test"}},
+		{4, []string{"hello world this is", "world this is a", "this is a test"}},
+		{7, nil},
+		{0, nil},
+	}
+	for _, test := range tests {
+		if got := GenerateNGrams(text, test.n); !reflect.DeepEqual(got, test.expected) {
+			t.Fatal(test.n, "grams mismatch", got)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	if got := Words("Über-Größe: 42x\ttabs_and\nlines"); !reflect.DeepEqual(got, []string{"über", "größe", "42x", "tabs", "and", "lines"}) {
+		t.Fatal("Words mismatch", got)
+	}
+	if got := Words(" ,.; "); len(got) != 0 {
+		t.Fatal("Expected no words", got)
+	}
 }
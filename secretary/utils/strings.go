@@ -25,14 +25,12 @@ func GenerateRandomString(length int) string {
 }
 
 func GenerateSeqString(sequence *uint64, length int, increment uint64) string {
-	atomic.AddUint64(sequence, increment)
-	key := fmt.Sprintf("%0*d", length, *sequence)
+	key := fmt.Sprintf("%0*d", length, atomic.AddUint64(sequence, increment))
 	return key
 }
 
 func GenerateSeqRandomString(sequence *uint64, length int, increment uint64, pad int, value ...string) string {
-	atomic.AddUint64(sequence, increment)
-	str := fmt.Sprintf("%0*d:%s:%s", pad, *sequence, value, GenerateRandomString(length))
+	str := fmt.Sprintf("%0*d:%s:%s", pad, atomic.AddUint64(sequence, increment), value, GenerateRandomString(length))
 	return str[:length]
 }
 
@@ -44,3 +42,33 @@ func ArrayContains[T string](arr []T, target T) bool {
 	}
 	return false
 }
+
+// EditDistance returns the Levenshtein distance between the runes of a and b,
+// with transpositions it is the Damerau (optimal string alignment) distance
+func EditDistance(a string, b string, transpositions bool) int {
+	ra, rb := []rune(a), []rune(b)
+
+	// Three rows of the distance matrix, a transposition looks two rows back
+	previous2 := make([]int, len(rb)+1)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if transpositions && i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				current[j] = min(current[j], previous2[j-2]+1)
+			}
+		}
+		previous2, previous, current = previous, current, previous2
+	}
+	return previous[len(rb)]
+}
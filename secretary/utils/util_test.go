@@ -23,3 +23,26 @@ func TestStringsToArray(t *testing.T) {
 		}
 	}
 }
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b        string
+		levenshtein int
+		damerau     int
+	}{
+		{"", "", 0, 0},
+		{"", "abc", 3, 3},
+		{"kitten", "sitting", 3, 3},
+		{"john", "jonh", 2, 1},
+		{"ca", "abc", 3, 3},
+		{"größe", "grösse", 2, 2},
+	}
+	for _, test := range tests {
+		if d := EditDistance(test.a, test.b, false); d != test.levenshtein {
+			t.Fatal("Levenshtein", test.a, test.b, d)
+		}
+		if d := EditDistance(test.b, test.a, true); d != test.damerau {
+			t.Fatal("Damerau", test.a, test.b, d)
+		}
+	}
+}
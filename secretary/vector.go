@@ -0,0 +1,1212 @@
+package secretary
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+
+	"github.com/codeharik/secretary/utils"
+	"github.com/codeharik/secretary/utils/file"
+)
+
+/*
+Vector collections
+
+A collection with VectorOptions stores embeddings. Its values are JSON
+documents of a vector of Dimensions numbers and optional metadata :
+
+	{"vector": [0.12, -0.5, 0.33], "metadata": {"lang": "en", "year": 2024}}
+
+Every write entry point stores the vector as Dimensions little endian
+float32 followed by the compact JSON of the metadata, in the record pagers
+like any value, reads decode it back to the document. The options are saved
+to vector.json and, like a schema, only set or removed while the collection
+is empty. A collection has a schema or vectors, not both.
+
+The vectors are indexed by an HNSW graph in SECRETARY/<collection>/hnsw.bin,
+read and written through a Pager. Every node is a page, its ID is the page
+index :
+
+	flags		(uint8) 1 once deleted
+	level		(uint8)
+	key			(uint16 length + bytes)
+	vector		(uint16 dimensions + float32), normalized for cosine
+	neighbors	(uint16 count + uint32 IDs) of every level up to its own
+
+A node links up to 2*M neighbors on level 0 and M above, its level is drawn
+from the hash of its ID. The header holds the entry node, the top level and
+the node counts. Writes maintain the graph with the indexes, see index.go :
+once a write applied, the node of the replaced vector is marked deleted and
+the new vector is inserted. Deleted nodes still route searches until
+BuildVectorIndex rebuilds the graph. The header is marked dirty before the
+first change and clean when the collection closes, a graph left dirty by a
+crash is rebuilt from the collection when it is read.
+
+KNN searches the graph for the K vectors nearest to a query by the metric of
+the collection, keeping max(Ef, K) candidates. Distances are 1 - cosine,
+the euclidean distance or minus the dot product, smaller is closer. A filter
+keeps the documents whose metadata fields equal its values, the nodes it
+rejects still route the search. A query with another metric compares every
+vector of the collection.
+
+POST /knn/<collection> takes a KNNQuery and returns the nearest documents.
+*/
+
+const (
+	VECTOR_FILE           = "vector.json"
+	HNSW_FILE             = "hnsw.bin"
+	HNSW_HEADER_LENGTH    = 32
+	HNSW_VERSION          = 1
+	MAX_VECTOR_DIMENSIONS = 4096
+	HNSW_M                = 16   // Neighbors of a node above level 0 unless given
+	HNSW_MAX_M            = 64   // Most neighbors of a node above level 0
+	HNSW_EF_CONSTRUCTION  = 200  // Candidates of an insertion unless given
+	HNSW_EF_SEARCH        = 64   // Candidates of a search unless given
+	HNSW_MAX_EF           = 4096 // Most candidates of an insertion or a search
+	HNSW_MAX_LEVEL        = 16
+	HNSW_NO_NODE          = math.MaxUint32 // Entry of an empty graph
+
+	HNSW_NODE_DELETED uint8 = 1
+
+	VECTOR_METRIC_COSINE = "cosine"
+	VECTOR_METRIC_L2     = "l2"
+	VECTOR_METRIC_DOT    = "dot"
+)
+
+// VectorOptions configure the vectors of a collection
+type VectorOptions struct {
+	Dimensions     int    `json:"dimensions"`
+	Metric         string `json:"metric"`         // cosine, l2 or dot
+	M              int    `json:"m"`              // Neighbors of a node above level 0, twice as many on level 0
+	EfConstruction int    `json:"efConstruction"` // Candidates of an insertion
+}
+
+// VectorDocument is a value of a vector collection
+type VectorDocument struct {
+	Vector   []float32       `json:"vector"`
+	Metadata json.RawMessage `json:"metadata,omitempty"` // JSON object
+}
+
+// KNNQuery asks for the K documents nearest to Vector
+type KNNQuery struct {
+	Vector []float32      `json:"vector"`
+	K      int            `json:"k"`
+	Ef     int            `json:"ef,omitempty"`     // Candidates of the search, HNSW_EF_SEARCH if 0
+	Metric string         `json:"metric,omitempty"` // Metric of the collection if empty
+	Filter map[string]any `json:"filter,omitempty"` // Metadata fields and the values they must equal
+}
+
+// KNNResult is a document found by KNN and its distance to the query
+type KNNResult struct {
+	Record   *Record
+	Distance float32
+}
+
+// VectorIndexStats are the vector options of a collection and the nodes of its graph
+type VectorIndexStats struct {
+	Options *VectorOptions `json:"options"`
+	Vectors int            `json:"vectors"` // Live nodes, one per document
+	Deleted int            `json:"deleted"` // Nodes of replaced vectors, dropped by a rebuild
+}
+
+// vectorIndex is the HNSW graph of a vector collection
+type vectorIndex struct {
+	collectionName string
+	options        VectorOptions
+	pager          *Pager[*hnswNode]
+	header         hnswHeader
+	keys           map[string]uint32 // Live node of every key
+}
+
+// hnswHeader is the header of hnsw.bin
+type hnswHeader struct {
+	dirty    bool
+	maxLevel uint8
+	entry    uint32
+	nodes    uint32
+	deleted  uint32
+	itemSize uint32 // Page size, the graph is rebuilt if the layout changed
+}
+
+// hnswNode is a node of the graph
+type hnswNode struct {
+	flags     uint8
+	key       []byte
+	vector    []float32
+	neighbors [][]uint32 // By level
+}
+
+// hnswCandidate is a node found by a search and its distance to the query
+type hnswCandidate struct {
+	id       uint32
+	distance float32
+	node     *hnswNode
+}
+
+// hnswHeap orders candidates, nearest first or farthest first
+type hnswHeap struct {
+	candidates []hnswCandidate
+	farthest   bool
+}
+
+func (h *hnswHeap) Len() int {
+	return len(h.candidates)
+}
+
+func (h *hnswHeap) Less(i, j int) bool {
+	if h.farthest {
+		return h.candidates[i].distance > h.candidates[j].distance
+	}
+	return h.candidates[i].distance < h.candidates[j].distance
+}
+
+func (h *hnswHeap) Swap(i, j int) {
+	h.candidates[i], h.candidates[j] = h.candidates[j], h.candidates[i]
+}
+
+func (h *hnswHeap) Push(x any) {
+	h.candidates = append(h.candidates, x.(hnswCandidate))
+}
+
+func (h *hnswHeap) Pop() any {
+	last := h.candidates[len(h.candidates)-1]
+	h.candidates = h.candidates[:len(h.candidates)-1]
+	return last
+}
+
+func vectorOptionsPath(collectionName string) string {
+	return fmt.Sprintf("%s/%s/%s", SECRETARY, collectionName, VECTOR_FILE)
+}
+
+func hnswPath(collectionName string) string {
+	return fmt.Sprintf("%s/%s/%s", SECRETARY, collectionName, HNSW_FILE)
+}
+
+// withDefaults fills the options left to 0
+func (options VectorOptions) withDefaults() VectorOptions {
+	if options.Metric == "" {
+		options.Metric = VECTOR_METRIC_COSINE
+	}
+	if options.M == 0 {
+		options.M = HNSW_M
+	}
+	if options.EfConstruction == 0 {
+		options.EfConstruction = HNSW_EF_CONSTRUCTION
+	}
+	return options
+}
+
+// checkVectorOptions validates options with their defaults
+func checkVectorOptions(options VectorOptions) error {
+	switch {
+	case options.Dimensions < 1 || options.Dimensions > MAX_VECTOR_DIMENSIONS:
+		return ErrorInvalidVectorOptions(fmt.Sprintf("dimensions must be between 1 and %d", MAX_VECTOR_DIMENSIONS))
+	case !isVectorMetric(options.Metric):
+		return ErrorInvalidVectorOptions("metric must be cosine, l2 or dot")
+	case options.M < 2 || options.M > HNSW_MAX_M:
+		return ErrorInvalidVectorOptions(fmt.Sprintf("m must be between 2 and %d", HNSW_MAX_M))
+	case options.EfConstruction < 1 || options.EfConstruction > HNSW_MAX_EF:
+		return ErrorInvalidVectorOptions(fmt.Sprintf("efConstruction must be between 1 and %d", HNSW_MAX_EF))
+	}
+	return nil
+}
+
+func isVectorMetric(metric string) bool {
+	return metric == VECTOR_METRIC_COSINE || metric == VECTOR_METRIC_L2 || metric == VECTOR_METRIC_DOT
+}
+
+// checkVector validates a vector of the collection, compared by metric
+func (options *VectorOptions) checkVector(vector []float32, metric string) error {
+	if len(vector) != options.Dimensions {
+		return fmt.Errorf("vector has %d dimensions, expected %d", len(vector), options.Dimensions)
+	}
+	norm := float32(0)
+	for _, v := range vector {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return errors.New("vector values must be finite")
+		}
+		norm += v * v
+	}
+	if metric == VECTOR_METRIC_COSINE && norm == 0 {
+		return errors.New("cosine vectors can not be zero")
+	}
+	return nil
+}
+
+//------------------------------------------------------------------
+// Values
+//------------------------------------------------------------------
+
+// parse reads and validates a JSON vector document
+func (options *VectorOptions) parse(value []byte) (VectorDocument, error) {
+	var document VectorDocument
+
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&document); err != nil {
+		return document, ErrorVectorDocument(err)
+	}
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return document, ErrorVectorDocument(errors.New("data after the document"))
+	}
+
+	if err := options.checkVector(document.Vector, options.Metric); err != nil {
+		return document, ErrorVectorDocument(err)
+	}
+
+	if metadata := bytes.TrimSpace(document.Metadata); len(metadata) > 0 && !bytes.Equal(metadata, []byte("null")) {
+		if metadata[0] != '{' {
+			return document, ErrorVectorDocument(errors.New("metadata must be an object"))
+		}
+		compact := bytes.Buffer{}
+		if err := json.Compact(&compact, metadata); err != nil {
+			return document, ErrorVectorDocument(err)
+		}
+		document.Metadata = compact.Bytes()
+	} else {
+		document.Metadata = nil
+	}
+
+	return document, nil
+}
+
+// encode validates the JSON document value and returns its stored form
+func (options *VectorOptions) encode(value []byte) ([]byte, error) {
+	document, err := options.parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make([]byte, 0, 4*len(document.Vector)+len(document.Metadata))
+	for _, v := range document.Vector {
+		stored = binary.LittleEndian.AppendUint32(stored, math.Float32bits(v))
+	}
+	return append(stored, document.Metadata...), nil
+}
+
+// decode returns the JSON document of a value stored by encode
+func (options *VectorOptions) decode(value []byte) ([]byte, error) {
+	size := 4 * options.Dimensions
+	if len(value) < size {
+		return nil, ErrorVectorDocument(fmt.Errorf("stored value of %d bytes is shorter than its vector", len(value)))
+	}
+
+	document := VectorDocument{Vector: make([]float32, options.Dimensions)}
+	for i := range document.Vector {
+		document.Vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(value[4*i:]))
+	}
+	if len(value) > size {
+		document.Metadata = value[size:]
+	}
+	return json.Marshal(document)
+}
+
+//------------------------------------------------------------------
+// Collection
+//------------------------------------------------------------------
+
+// VectorOptions returns the vector options of the collection, nil without vectors
+func (tree *BTree) VectorOptions() *VectorOptions {
+	index := tree.vectors.Load()
+	if index == nil {
+		return nil
+	}
+	options := index.options
+	return &options
+}
+
+// SetVectorOptions makes an empty collection a vector collection, nil options make it a collection of raw values again.
+// Options left to 0 get their defaults.
+func (tree *BTree) SetVectorOptions(options *VectorOptions) error {
+	if options != nil {
+		withDefaults := options.withDefaults()
+		if err := checkVectorOptions(withDefaults); err != nil {
+			return err
+		}
+		options = &withDefaults
+	}
+	if MODE_WASM {
+		return ErrorModeWASM
+	}
+
+	tree.indexMu.Lock()
+	defer tree.indexMu.Unlock()
+
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	if tree.root != nil && len(tree.root.Keys) > 0 {
+		return ErrorVectorsNotEmpty
+	}
+	if options != nil && tree.schema.Load() != nil {
+		return ErrorVectorsSchema
+	}
+
+	if index := tree.vectors.Load(); index != nil {
+		tree.vectors.Store(nil)
+		if err := index.remove(); err != nil {
+			return err
+		}
+	}
+	if err := saveVectorOptions(tree.CollectionName, options); err != nil {
+		return err
+	}
+	if options == nil {
+		return nil
+	}
+
+	index, err := newVectorIndex(tree.CollectionName, *options, tree.vectorKeySize())
+	if err == nil {
+		err = index.reset()
+	}
+	if err != nil {
+		return errors.Join(err, saveVectorOptions(tree.CollectionName, nil))
+	}
+	tree.vectors.Store(index)
+
+	return nil
+}
+
+// VectorIndex returns the options and the node counts of the vector graph
+func (tree *BTree) VectorIndex() (VectorIndexStats, error) {
+	tree.indexMu.RLock()
+	defer tree.indexMu.RUnlock()
+
+	index := tree.vectors.Load()
+	if index == nil {
+		return VectorIndexStats{}, ErrorVectorsNotFound
+	}
+	options := index.options
+	return VectorIndexStats{Options: &options, Vectors: len(index.keys), Deleted: int(index.header.deleted)}, nil
+}
+
+// BuildVectorIndex rebuilds the vector graph from the stored documents, without deleted nodes
+func (tree *BTree) BuildVectorIndex() error {
+	indexes, unlock := tree.lockIndexes()
+	defer unlock()
+
+	if indexes.vectors == nil {
+		return ErrorVectorsNotFound
+	}
+	return indexes.vectors.build(tree)
+}
+
+// vectorKeySize is the longest key of the collection
+func (tree *BTree) vectorKeySize() int {
+	if tree.MaxKeySize == 0 {
+		return KEY_SIZE
+	}
+	return int(tree.MaxKeySize)
+}
+
+//------------------------------------------------------------------
+// Search
+//------------------------------------------------------------------
+
+// check validates a query, its filter values are normalized
+func (query *KNNQuery) check() error {
+	switch {
+	case query.K < 1:
+		return ErrorKNNQuery("k must be at least 1")
+	case query.Ef < 0 || query.Ef > HNSW_MAX_EF:
+		return ErrorKNNQuery(fmt.Sprintf("ef must be between 0 and %d", HNSW_MAX_EF))
+	case query.Metric != "" && !isVectorMetric(query.Metric):
+		return ErrorKNNQuery("metric must be cosine, l2 or dot")
+	}
+	for field, value := range query.Filter {
+		value, err := sqlParamValue(value)
+		if err != nil || value == nil || indexValue(value) == nil {
+			return ErrorKNNQuery("filter values are strings, numbers or booleans")
+		}
+		query.Filter[field] = value
+	}
+	return nil
+}
+
+// KNN returns the query.K documents nearest to query.Vector, nearest first
+func (tree *BTree) KNN(query KNNQuery) ([]KNNResult, error) {
+	query.Filter = maps.Clone(query.Filter)
+	if err := query.check(); err != nil {
+		return nil, err
+	}
+
+	// Writes keep the graph and the collection in step under indexMu
+	tree.indexMu.RLock()
+	defer tree.indexMu.RUnlock()
+
+	index := tree.vectors.Load()
+	if index == nil {
+		return nil, ErrorVectorsNotFound
+	}
+	metric := query.Metric
+	if metric == "" {
+		metric = index.options.Metric
+	}
+	if err := index.options.checkVector(query.Vector, metric); err != nil {
+		return nil, ErrorKNNQuery(err.Error())
+	}
+
+	if metric != index.options.Metric {
+		return index.scan(tree, query, metric)
+	}
+
+	// Documents of the nodes the filter was checked on
+	records := map[uint32]*Record{}
+	accept := func(id uint32, node *hnswNode) (bool, error) {
+		if node.flags&HNSW_NODE_DELETED != 0 {
+			return false, nil
+		}
+		record, err := tree.Get(node.key)
+		if errors.Is(err, ErrorKeyNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !index.matches(record, query.Filter) {
+			return false, nil
+		}
+		records[id] = record
+		return true, nil
+	}
+
+	ef := query.Ef
+	if ef == 0 {
+		ef = HNSW_EF_SEARCH
+	}
+	candidates, err := index.search(query.Vector, max(ef, query.K), accept)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]KNNResult, 0, min(len(candidates), query.K))
+	for _, candidate := range candidates[:min(len(candidates), query.K)] {
+		results = append(results, KNNResult{Record: records[candidate.id], Distance: candidate.distance})
+	}
+	return results, nil
+}
+
+// search returns the ef accepted nodes nearest to vector, nearest first
+func (index *vectorIndex) search(vector []float32, ef int, accept func(uint32, *hnswNode) (bool, error)) ([]hnswCandidate, error) {
+	if index.header.entry == HNSW_NO_NODE {
+		return nil, nil
+	}
+	vector = index.graphVector(vector)
+
+	entry, err := index.node(index.header.entry)
+	if err != nil {
+		return nil, err
+	}
+	entries := []hnswCandidate{{id: index.header.entry, distance: index.distance(vector, entry.vector), node: entry}}
+	for level := int(index.header.maxLevel); level > 0; level-- {
+		if entries, err = index.searchLayer(vector, entries, 1, level, nil); err != nil {
+			return nil, err
+		}
+	}
+	return index.searchLayer(vector, entries, ef, 0, accept)
+}
+
+// scan compares the query to every vector of the collection by metric, for the metrics the graph is not built for
+func (index *vectorIndex) scan(tree *BTree, query KNNQuery, metric string) ([]KNNResult, error) {
+	vector := metricVector(metric, query.Vector)
+
+	results := []KNNResult{}
+	for record, err := range tree.Iterate(ScanOptions{}) {
+		if err != nil {
+			return nil, err
+		}
+		document, err := index.options.parse(record.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !index.matches(record, query.Filter) {
+			continue
+		}
+		distance := vectorDistance(metric, vector, metricVector(metric, document.Vector))
+		results = append(results, KNNResult{Record: record, Distance: distance})
+	}
+
+	slices.SortFunc(results, func(a, b KNNResult) int {
+		if a.Distance != b.Distance {
+			if a.Distance < b.Distance {
+				return -1
+			}
+			return 1
+		}
+		return bytes.Compare(a.Record.Key, b.Record.Key)
+	})
+	return results[:min(len(results), query.K)], nil
+}
+
+// matches reports whether the metadata of a document has the values of filter
+func (index *vectorIndex) matches(record *Record, filter map[string]any) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	var document struct {
+		Metadata map[string]any `json:"metadata"`
+	}
+	if err := json.Unmarshal(record.Value, &document); err != nil {
+		return false
+	}
+	for field, value := range filter {
+		if order, ok := compareSQLValues(document.Metadata[field], value); !ok || order != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//------------------------------------------------------------------
+// Distances
+//------------------------------------------------------------------
+
+// vectorDistance is the distance of a to b by metric, smaller is closer. Cosine vectors are normalized.
+func vectorDistance(metric string, a []float32, b []float32) float32 {
+	switch metric {
+	case VECTOR_METRIC_L2:
+		sum := float32(0)
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		return float32(math.Sqrt(float64(sum)))
+	case VECTOR_METRIC_DOT:
+		return -dotProduct(a, b)
+	default:
+		return 1 - dotProduct(a, b)
+	}
+}
+
+func dotProduct(a []float32, b []float32) float32 {
+	sum := float32(0)
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// metricVector returns the vector compared by metric, a normalized copy for cosine
+func metricVector(metric string, vector []float32) []float32 {
+	if metric != VECTOR_METRIC_COSINE {
+		return vector
+	}
+	norm := float32(math.Sqrt(float64(dotProduct(vector, vector))))
+	if norm == 0 {
+		return vector
+	}
+	normalized := make([]float32, len(vector))
+	for i, v := range vector {
+		normalized[i] = v / norm
+	}
+	return normalized
+}
+
+func (index *vectorIndex) graphVector(vector []float32) []float32 {
+	return metricVector(index.options.Metric, vector)
+}
+
+func (index *vectorIndex) distance(a []float32, b []float32) float32 {
+	return vectorDistance(index.options.Metric, a, b)
+}
+
+//------------------------------------------------------------------
+// Graph
+//------------------------------------------------------------------
+
+// level draws the level of node id from its hash, every level is M times rarer than the one below
+func (index *vectorIndex) level(id uint32) int {
+	// splitmix64
+	x := uint64(id) + 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+
+	uniform := (float64(x>>11) + 1) / (1 << 53)
+	level := int(-math.Log(uniform) / math.Log(float64(index.options.M)))
+	return min(level, HNSW_MAX_LEVEL-1)
+}
+
+// maxNeighbors is the most neighbors of a node on level
+func (index *vectorIndex) maxNeighbors(level int) int {
+	if level == 0 {
+		return 2 * index.options.M
+	}
+	return index.options.M
+}
+
+// node reads the node of id through the pager cache
+func (index *vectorIndex) node(id uint32) (*hnswNode, error) {
+	page, err := index.pager.ReadPage(int64(id))
+	if err != nil {
+		return nil, err
+	}
+	return page.Data, nil
+}
+
+// searchLayer returns the ef accepted nodes of level nearest to vector, nearest first, starting from entries.
+// Every node routes the search, accept nil accepts them all.
+func (index *vectorIndex) searchLayer(
+	vector []float32,
+	entries []hnswCandidate,
+	ef int,
+	level int,
+	accept func(uint32, *hnswNode) (bool, error),
+) ([]hnswCandidate, error) {
+	visited := map[uint32]bool{}
+	candidates := &hnswHeap{}
+	results := &hnswHeap{farthest: true}
+
+	add := func(candidate hnswCandidate) error {
+		heap.Push(candidates, candidate)
+		if accept != nil {
+			if ok, err := accept(candidate.id, candidate.node); err != nil || !ok {
+				return err
+			}
+		}
+		heap.Push(results, candidate)
+		if results.Len() > ef {
+			heap.Pop(results)
+		}
+		return nil
+	}
+
+	for _, entry := range entries {
+		visited[entry.id] = true
+		if err := add(entry); err != nil {
+			return nil, err
+		}
+	}
+
+	for candidates.Len() > 0 {
+		current := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && current.distance > results.candidates[0].distance {
+			break
+		}
+		if level >= len(current.node.neighbors) {
+			continue
+		}
+
+		for _, id := range current.node.neighbors[level] {
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+
+			node, err := index.node(id)
+			if err != nil {
+				return nil, err
+			}
+			distance := index.distance(vector, node.vector)
+			if results.Len() < ef || distance < results.candidates[0].distance {
+				if err := add(hnswCandidate{id: id, distance: distance, node: node}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	found := results.candidates
+	slices.SortFunc(found, func(a, b hnswCandidate) int {
+		if a.distance != b.distance {
+			if a.distance < b.distance {
+				return -1
+			}
+			return 1
+		}
+		return int(a.id) - int(b.id)
+	})
+	return found, nil
+}
+
+// selectNeighbors keeps up to m of candidates, nearest first. A candidate closer to a kept one than
+// to the node is skipped, skipped candidates fill the places left.
+func (index *vectorIndex) selectNeighbors(candidates []hnswCandidate, m int) []hnswCandidate {
+	selected := make([]hnswCandidate, 0, m)
+	skipped := []hnswCandidate{}
+	for _, candidate := range candidates {
+		if len(selected) == m {
+			break
+		}
+		diverse := true
+		for _, kept := range selected {
+			if index.distance(candidate.node.vector, kept.node.vector) < candidate.distance {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, candidate)
+		} else {
+			skipped = append(skipped, candidate)
+		}
+	}
+	for _, candidate := range skipped {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, candidate)
+	}
+	return selected
+}
+
+// insert adds a node for the vector of key
+func (index *vectorIndex) insert(key []byte, vector []float32) error {
+	id := index.header.nodes
+	level := index.level(id)
+	node := &hnswNode{key: bytes.Clone(key), vector: index.graphVector(vector), neighbors: make([][]uint32, level+1)}
+
+	// Neighbors by level, linked back once the node is written
+	links := make([][]hnswCandidate, level+1)
+	if index.header.entry != HNSW_NO_NODE {
+		entry, err := index.node(index.header.entry)
+		if err != nil {
+			return err
+		}
+		entries := []hnswCandidate{{id: index.header.entry, distance: index.distance(node.vector, entry.vector), node: entry}}
+		for l := int(index.header.maxLevel); l > level; l-- {
+			if entries, err = index.searchLayer(node.vector, entries, 1, l, nil); err != nil {
+				return err
+			}
+		}
+		for l := min(level, int(index.header.maxLevel)); l >= 0; l-- {
+			found, err := index.searchLayer(node.vector, entries, index.options.EfConstruction, l, nil)
+			if err != nil {
+				return err
+			}
+			links[l] = index.selectNeighbors(found, index.options.M)
+			for _, neighbor := range links[l] {
+				node.neighbors[l] = append(node.neighbors[l], neighbor.id)
+			}
+			entries = found
+		}
+	}
+
+	if err := index.pager.WritePage(node, int64(id)); err != nil {
+		return err
+	}
+	index.header.nodes++
+	index.keys[string(key)] = id
+	if index.header.entry == HNSW_NO_NODE || level > int(index.header.maxLevel) {
+		index.header.entry = id
+		index.header.maxLevel = uint8(level)
+	}
+
+	for l, neighbors := range links {
+		for _, neighbor := range neighbors {
+			if err := index.link(neighbor.id, id, l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// link adds id to the neighbors of node from on level, the farthest are pruned beyond maxNeighbors
+func (index *vectorIndex) link(from uint32, id uint32, level int) error {
+	node, err := index.node(from)
+	if err != nil {
+		return err
+	}
+	neighbors := append(node.neighbors[level], id)
+
+	if len(neighbors) > index.maxNeighbors(level) {
+		candidates := make([]hnswCandidate, len(neighbors))
+		for i, neighborID := range neighbors {
+			neighbor, err := index.node(neighborID)
+			if err != nil {
+				return err
+			}
+			candidates[i] = hnswCandidate{id: neighborID, distance: index.distance(node.vector, neighbor.vector), node: neighbor}
+		}
+		slices.SortFunc(candidates, func(a, b hnswCandidate) int {
+			if a.distance < b.distance {
+				return -1
+			}
+			if a.distance > b.distance {
+				return 1
+			}
+			return 0
+		})
+
+		neighbors = neighbors[:0]
+		for _, candidate := range index.selectNeighbors(candidates, index.maxNeighbors(level)) {
+			neighbors = append(neighbors, candidate.id)
+		}
+	}
+
+	node.neighbors[level] = neighbors
+	return index.pager.WritePage(node, int64(from))
+}
+
+// delete marks the node of key deleted
+func (index *vectorIndex) delete(key []byte) error {
+	id, ok := index.keys[string(key)]
+	if !ok {
+		return nil
+	}
+	node, err := index.node(id)
+	if err != nil {
+		return err
+	}
+	node.flags |= HNSW_NODE_DELETED
+	if err := index.pager.WritePage(node, int64(id)); err != nil {
+		return err
+	}
+	delete(index.keys, string(key))
+	index.header.deleted++
+	return nil
+}
+
+//------------------------------------------------------------------
+// Maintenance
+//------------------------------------------------------------------
+
+// begin marks the graph dirty and returns the function that ends the write, like beginIndexWrite.
+// The graph changes once the write applied.
+func (index *vectorIndex) begin(changes []indexChange) (func(applied bool), error) {
+	if err := index.markDirty(); err != nil {
+		return nil, err
+	}
+
+	return func(applied bool) {
+		if !applied {
+			return
+		}
+		for _, change := range changes {
+			if err := index.apply(change); err != nil {
+				// The graph stays dirty and is rebuilt when the collection is read
+				utils.Log("Vector index", index.collectionName, err)
+				return
+			}
+		}
+	}, nil
+}
+
+// apply replaces the node of the document of a change
+func (index *vectorIndex) apply(change indexChange) error {
+	if bytes.Equal(change.old, change.new) {
+		return nil
+	}
+	if err := index.delete(change.key); err != nil {
+		return err
+	}
+	if change.new == nil {
+		return nil
+	}
+	document, err := index.options.parse(change.new)
+	if err != nil {
+		return err
+	}
+	return index.insert(change.key, document.Vector)
+}
+
+// build replaces the graph by the documents stored in tree
+func (index *vectorIndex) build(tree *BTree) error {
+	documents := []*Record{}
+	for record, err := range tree.Iterate(ScanOptions{}) {
+		if err != nil {
+			return err
+		}
+		documents = append(documents, record)
+	}
+	return index.fill(documents)
+}
+
+// fill replaces the graph by documents, node IDs start over
+func (index *vectorIndex) fill(documents []*Record) error {
+	if err := index.reset(); err != nil {
+		return err
+	}
+	for _, record := range documents {
+		document, err := index.options.parse(record.Value)
+		if err != nil {
+			return err
+		}
+		if err := index.insert(record.Key, document.Vector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reset empties the graph, it stays dirty until the collection closes
+func (index *vectorIndex) reset() error {
+	if err := index.pager.Truncate(); err != nil {
+		return err
+	}
+	index.header = hnswHeader{dirty: true, entry: HNSW_NO_NODE, itemSize: uint32(index.pager.itemSize)}
+	index.keys = map[string]uint32{}
+	return index.saveHeader()
+}
+
+func (index *vectorIndex) markDirty() error {
+	if index.header.dirty {
+		return nil
+	}
+	index.header.dirty = true
+	return index.saveHeader()
+}
+
+func (index *vectorIndex) saveHeader() error {
+	header := index.header
+	data := make([]byte, HNSW_HEADER_LENGTH)
+	copy(data, "HNSW")
+	data[4] = HNSW_VERSION
+	if header.dirty {
+		data[5] = 1
+	}
+	data[6] = header.maxLevel
+	binary.BigEndian.PutUint32(data[8:], header.entry)
+	binary.BigEndian.PutUint32(data[12:], header.nodes)
+	binary.BigEndian.PutUint32(data[16:], header.deleted)
+	binary.BigEndian.PutUint32(data[20:], header.itemSize)
+	return index.pager.WriteAt(data, 0)
+}
+
+// readHeader reads the header of hnsw.bin, false if it is not one of this layout
+func (index *vectorIndex) readHeader() (bool, error) {
+	data, err := index.pager.ReadAt(0, HNSW_HEADER_LENGTH)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(data[:4], []byte("HNSW")) || data[4] != HNSW_VERSION {
+		return false, nil
+	}
+
+	index.header = hnswHeader{
+		dirty:    data[5] != 0,
+		maxLevel: data[6],
+		entry:    binary.BigEndian.Uint32(data[8:]),
+		nodes:    binary.BigEndian.Uint32(data[12:]),
+		deleted:  binary.BigEndian.Uint32(data[16:]),
+		itemSize: binary.BigEndian.Uint32(data[20:]),
+	}
+	return index.header.itemSize == uint32(index.pager.itemSize), nil
+}
+
+// close marks the graph clean and closes hnsw.bin
+func (index *vectorIndex) close() error {
+	index.header.dirty = false
+	return errors.Join(index.saveHeader(), index.pager.Close())
+}
+
+// remove closes hnsw.bin and deletes it
+func (index *vectorIndex) remove() error {
+	err := index.pager.Close()
+	if err := os.Remove(hnswPath(index.collectionName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return err
+}
+
+//------------------------------------------------------------------
+// Files
+//------------------------------------------------------------------
+
+// newVectorIndex opens the graph of options in hnsw.bin, a graph it can not read is emptied and left dirty
+func newVectorIndex(collectionName string, options VectorOptions, keySize int) (*vectorIndex, error) {
+	itemSize := 1 + 1 + 2 + keySize + 2 + 4*options.Dimensions +
+		(2 + 4*2*options.M) + (HNSW_MAX_LEVEL-1)*(2+4*options.M)
+
+	pager, err := openPager[*hnswNode](hnswPath(collectionName), 0, HNSW_HEADER_LENGTH, int64(itemSize))
+	if err != nil {
+		return nil, err
+	}
+	index := &vectorIndex{collectionName: collectionName, options: options, pager: pager, keys: map[string]uint32{}}
+
+	ok, err := index.readHeader()
+	if err == nil && ok && !index.header.dirty {
+		err = index.readKeys()
+	}
+	if err == nil && (!ok || index.header.dirty) {
+		err = index.reset()
+	}
+	if err != nil {
+		pager.Close()
+		return nil, err
+	}
+	return index, nil
+}
+
+// readKeys finds the live node of every key
+func (index *vectorIndex) readKeys() error {
+	for id := range index.header.nodes {
+		node, err := index.node(id)
+		if err != nil {
+			return err
+		}
+		if node.flags&HNSW_NODE_DELETED == 0 {
+			index.keys[string(node.key)] = id
+		}
+	}
+	return nil
+}
+
+// openVectorIndex reads the options of vector.json and opens their graph, a graph it empties is rebuilt
+func (tree *BTree) openVectorIndex() error {
+	options, err := loadVectorOptions(tree.CollectionName)
+	if err != nil {
+		return err
+	}
+	if options == nil {
+		if err := os.Remove(hnswPath(tree.CollectionName)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	index, err := newVectorIndex(tree.CollectionName, *options, tree.vectorKeySize())
+	if err != nil {
+		return fmt.Errorf("Vector index: %v", err)
+	}
+	// Documents are read as JSON once the options are known
+	tree.vectors.Store(index)
+
+	if index.header.nodes == 0 && index.header.dirty {
+		if err := index.build(tree); err != nil {
+			tree.vectors.Store(nil)
+			return errors.Join(fmt.Errorf("Vector index: %v", err), index.pager.Close())
+		}
+	}
+	return nil
+}
+
+// saveVectorOptions replaces vector.json, nil removes it
+func saveVectorOptions(collectionName string, options *VectorOptions) error {
+	if MODE_WASM {
+		return nil
+	}
+
+	path := vectorOptionsPath(collectionName)
+	if options == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	return file.ReplaceFile(path, data)
+}
+
+// loadVectorOptions reads vector.json, nil if the collection has no vectors
+func loadVectorOptions(collectionName string) (*VectorOptions, error) {
+	data, err := os.ReadFile(vectorOptionsPath(collectionName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var options VectorOptions
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, err
+	}
+	if err := checkVectorOptions(options); err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+// removeVectorIndex deletes the vector options and the graph of a collection
+func removeVectorIndex(collectionName string) error {
+	return errors.Join(saveVectorOptions(collectionName, nil), os.RemoveAll(hnswPath(collectionName)))
+}
+
+//------------------------------------------------------------------
+// Pages
+//------------------------------------------------------------------
+
+func (node *hnswNode) NewPage(index int64) *Page[*hnswNode] {
+	return &Page[*hnswNode]{
+		Index: index,
+		Data:  &hnswNode{},
+	}
+}
+
+func (node *hnswNode) ToBytes() ([]byte, error) {
+	data := []byte{node.flags, uint8(len(node.neighbors) - 1)}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(node.key)))
+	data = append(data, node.key...)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(node.vector)))
+	for _, v := range node.vector {
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v))
+	}
+	for _, neighbors := range node.neighbors {
+		data = binary.BigEndian.AppendUint16(data, uint16(len(neighbors)))
+		for _, id := range neighbors {
+			data = binary.BigEndian.AppendUint32(data, id)
+		}
+	}
+	return data, nil
+}
+
+func (node *hnswNode) FromBytes(data []byte) error {
+	read := func(size int) ([]byte, error) {
+		if len(data) < size {
+			return nil, ErrorVectorIndexCorrupt
+		}
+		chunk := data[:size]
+		data = data[size:]
+		return chunk, nil
+	}
+
+	header, err := read(4)
+	if err != nil {
+		return err
+	}
+	node.flags = header[0]
+	levels := int(header[1]) + 1
+	if levels > HNSW_MAX_LEVEL {
+		return ErrorVectorIndexCorrupt
+	}
+
+	key, err := read(int(binary.BigEndian.Uint16(header[2:])))
+	if err != nil {
+		return err
+	}
+	node.key = bytes.Clone(key)
+
+	size, err := read(2)
+	if err != nil {
+		return err
+	}
+	vector, err := read(4 * int(binary.BigEndian.Uint16(size)))
+	if err != nil {
+		return err
+	}
+	node.vector = make([]float32, len(vector)/4)
+	for i := range node.vector {
+		node.vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(vector[4*i:]))
+	}
+
+	node.neighbors = make([][]uint32, levels)
+	for level := range node.neighbors {
+		count, err := read(2)
+		if err != nil {
+			return err
+		}
+		ids, err := read(4 * int(binary.BigEndian.Uint16(count)))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(ids); i += 4 {
+			node.neighbors[level] = append(node.neighbors[level], binary.BigEndian.Uint32(ids[i:]))
+		}
+	}
+	return nil
+}
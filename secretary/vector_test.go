@@ -0,0 +1,317 @@
+package secretary
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// vectorValue returns the JSON document of a vector and its metadata
+func vectorValue(vector []float32, metadata string) []byte {
+	document := VectorDocument{Vector: vector}
+	if metadata != "" {
+		document.Metadata = json.RawMessage(metadata)
+	}
+	value, _ := json.Marshal(document)
+	return value
+}
+
+// knnKeys returns the keys KNN finds for query, nearest first
+func knnKeys(t *testing.T, tree *BTree, query KNNQuery) []string {
+	t.Helper()
+	results, err := tree.KNN(query)
+	if err != nil {
+		t.Fatal(query, err)
+	}
+	keys := []string{}
+	for i, result := range results {
+		if i > 0 && result.Distance < results[i-1].Distance {
+			t.Fatal("Expected results nearest first", results)
+		}
+		keys = append(keys, string(result.Record.Key))
+	}
+	return keys
+}
+
+func TestVectorOptions(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	for _, options := range []VectorOptions{
+		{},
+		{Dimensions: MAX_VECTOR_DIMENSIONS + 1},
+		{Dimensions: 3, Metric: "manhattan"},
+		{Dimensions: 3, M: 1},
+		{Dimensions: 3, M: HNSW_MAX_M + 1},
+		{Dimensions: 3, EfConstruction: HNSW_MAX_EF + 1},
+	} {
+		if err := tree.SetVectorOptions(&options); err == nil {
+			t.Fatal("Expected", options, "rejected")
+		}
+	}
+	if tree.VectorOptions() != nil {
+		t.Fatal("Expected no vector options")
+	}
+	if _, err := tree.KNN(KNNQuery{Vector: []float32{1, 0, 0}, K: 1}); err != ErrorVectorsNotFound {
+		t.Fatal("Expected no vectors", err)
+	}
+
+	if err := tree.SetVectorOptions(&VectorOptions{Dimensions: 3}); err != nil {
+		t.Fatal(err)
+	}
+	expected := VectorOptions{Dimensions: 3, Metric: VECTOR_METRIC_COSINE, M: HNSW_M, EfConstruction: HNSW_EF_CONSTRUCTION}
+	if options := tree.VectorOptions(); options == nil || *options != expected {
+		t.Fatal("Expected the defaults", options)
+	}
+	if err := tree.SetSchema(testSchema); err != ErrorVectorsSchema {
+		t.Fatal("Expected a schema rejected", err)
+	}
+
+	for _, value := range []string{
+		`[1, 2, 3]`,
+		`{"vector": [1, 2]}`,
+		`{"vector": [0, 0, 0]}`,
+		`{"vector": [1, 2, 3], "metadata": [1]}`,
+		`{"vector": [1, 2, 3], "other": 1}`,
+	} {
+		if _, err := tree.SetKV([]byte("bad"), []byte(value)); err == nil || !strings.HasPrefix(err.Error(), "Document is not a vector document") {
+			t.Fatal("Expected", value, "rejected", err)
+		}
+	}
+
+	value := `{"vector": [1, 0.5, -2], "metadata": {"lang": "en"}}`
+	if _, err := tree.SetKV([]byte("a"), []byte(value)); err != nil {
+		t.Fatal(err)
+	}
+	record, err := tree.Get([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want VectorDocument
+	json.Unmarshal(record.Value, &got)
+	json.Unmarshal([]byte(value), &want)
+	if !reflect.DeepEqual(got.Vector, want.Vector) || string(got.Metadata) != `{"lang":"en"}` {
+		t.Fatal("Expected the document read back", string(record.Value))
+	}
+
+	if err := tree.SetVectorOptions(nil); err != ErrorVectorsNotEmpty {
+		t.Fatal("Expected the options kept on a collection with documents", err)
+	}
+	if err := tree.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.SetVectorOptions(nil); err != nil {
+		t.Fatal(err)
+	}
+	if tree.VectorOptions() != nil {
+		t.Fatal("Expected the options removed")
+	}
+	if _, err := tree.SetKV([]byte("raw"), []byte("anything")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVectorKNN(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 8)
+
+	const dimensions = 16
+	if err := tree.SetVectorOptions(&VectorOptions{Dimensions: dimensions, M: 8, EfConstruction: 64}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clusters of vectors around random centers
+	random := rand.New(rand.NewSource(21))
+	randomVector := func(center []float32, spread float32) []float32 {
+		vector := make([]float32, dimensions)
+		for i := range vector {
+			vector[i] = random.Float32()*2 - 1
+			if center != nil {
+				vector[i] = center[i] + vector[i]*spread
+			}
+		}
+		return vector
+	}
+	centers := [][]float32{}
+	for range 8 {
+		centers = append(centers, randomVector(nil, 0))
+	}
+	for i := range 400 {
+		cluster := i % len(centers)
+		key := fmt.Appendf(nil, "v%03d", i)
+		value := vectorValue(randomVector(centers[cluster], 0.3), fmt.Sprintf(`{"cluster": %d, "even": %t}`, cluster, i%2 == 0))
+		if _, err := tree.SetKV(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats, err := tree.VectorIndex(); err != nil || stats.Vectors != 400 || stats.Deleted != 0 {
+		t.Fatal("Expected 400 vectors", stats, err)
+	}
+
+	// The graph finds most of what comparing every vector finds
+	recall := func() float64 {
+		t.Helper()
+		found, total := 0, 0
+		for range 20 {
+			query := KNNQuery{Vector: randomVector(centers[random.Intn(len(centers))], 0.5), K: 10}
+			exact, err := tree.vectors.Load().scan(tree, query, VECTOR_METRIC_COSINE)
+			if err != nil {
+				t.Fatal(err)
+			}
+			approximate := knnKeys(t, tree, query)
+			for _, result := range exact {
+				if slices.Contains(approximate, string(result.Record.Key)) {
+					found++
+				}
+			}
+			total += len(exact)
+		}
+		return float64(found) / float64(total)
+	}
+	if r := recall(); r < 0.9 {
+		t.Fatal("Expected a recall of at least 0.9, got", r)
+	}
+
+	// A document is its own nearest neighbor
+	record, err := tree.Get([]byte("v123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document VectorDocument
+	if err := json.Unmarshal(record.Value, &document); err != nil {
+		t.Fatal(err)
+	}
+	for _, metric := range []string{"", VECTOR_METRIC_L2} {
+		if keys := knnKeys(t, tree, KNNQuery{Vector: document.Vector, K: 3, Metric: metric}); keys[0] != "v123" {
+			t.Fatal("Expected v123 nearest by", metric, keys)
+		}
+	}
+
+	// Filters keep the matching metadata
+	results, err := tree.KNN(KNNQuery{Vector: document.Vector, K: 5, Filter: map[string]any{"cluster": 3, "even": false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Fatal("Expected 5 filtered results", results)
+	}
+	for _, result := range results {
+		var document VectorDocument
+		json.Unmarshal(result.Record.Value, &document)
+		if string(document.Metadata) != `{"cluster":3,"even":false}` {
+			t.Fatal("Expected the filter applied", string(result.Record.Value))
+		}
+	}
+	if keys := knnKeys(t, tree, KNNQuery{Vector: document.Vector, K: 5, Filter: map[string]any{"cluster": 99}}); len(keys) != 0 {
+		t.Fatal("Expected nothing for cluster 99", keys)
+	}
+
+	for _, query := range []KNNQuery{
+		{Vector: document.Vector},
+		{Vector: document.Vector, K: 1, Ef: HNSW_MAX_EF + 1},
+		{Vector: document.Vector, K: 1, Metric: "manhattan"},
+		{Vector: document.Vector[:3], K: 1},
+		{Vector: document.Vector, K: 1, Filter: map[string]any{"cluster": []int{1}}},
+	} {
+		if _, err := tree.KNN(query); err == nil || !strings.HasPrefix(err.Error(), "Invalid k-NN query") {
+			t.Fatal("Expected", query, "rejected", err)
+		}
+	}
+
+	// Updates and deletes leave deleted nodes until a rebuild
+	far := make([]float32, dimensions)
+	far[0] = -100
+	if err := tree.Update([]byte("v123"), vectorValue(far, "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete([]byte("v124")); err != nil {
+		t.Fatal(err)
+	}
+	if keys := knnKeys(t, tree, KNNQuery{Vector: document.Vector, K: 20}); slices.Contains(keys, "v123") || slices.Contains(keys, "v124") {
+		t.Fatal("Expected the replaced and deleted vectors skipped", keys)
+	}
+	if keys := knnKeys(t, tree, KNNQuery{Vector: far, K: 1, Metric: VECTOR_METRIC_L2}); keys[0] != "v123" {
+		t.Fatal("Expected the new vector of v123", keys)
+	}
+	if stats, _ := tree.VectorIndex(); stats.Vectors != 399 || stats.Deleted != 2 {
+		t.Fatal("Expected 399 vectors and 2 deleted", stats)
+	}
+	if err := tree.BuildVectorIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := tree.VectorIndex(); stats.Vectors != 399 || stats.Deleted != 0 {
+		t.Fatal("Expected the deleted nodes dropped", stats)
+	}
+	if r := recall(); r < 0.9 {
+		t.Fatal("Expected a recall of at least 0.9 after the rebuild, got", r)
+	}
+
+	// A clean graph is read back, a dirty one rebuilt
+	before := knnKeys(t, tree, KNNQuery{Vector: document.Vector, K: 10})
+	if err := tree.close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = s.NewBTreeReadHeader(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := knnKeys(t, tree, KNNQuery{Vector: document.Vector, K: 10}); !reflect.DeepEqual(before, after) {
+		t.Fatal("Expected the same results once read", before, after)
+	}
+
+	if err := tree.vectors.Load().markDirty(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.vectors.Load().pager.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree.vectors.Store(nil)
+	if err := tree.close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = s.NewBTreeReadHeader(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := tree.VectorIndex(); stats.Vectors != 399 || stats.Deleted != 0 {
+		t.Fatal("Expected the dirty graph rebuilt", stats)
+	}
+	if keys := knnKeys(t, tree, KNNQuery{Vector: document.Vector, K: 10}); len(keys) != 10 {
+		t.Fatal("Expected 10 results after the rebuild", keys)
+	}
+
+	// Erase empties the graph and keeps the options
+	if err := tree.Erase(); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := tree.VectorIndex(); err != nil || stats.Vectors != 0 || stats.Options.Dimensions != dimensions {
+		t.Fatal("Expected an empty graph", stats, err)
+	}
+	if keys := knnKeys(t, tree, KNNQuery{Vector: document.Vector, K: 10}); len(keys) != 0 {
+		t.Fatal("Expected nothing after Erase", keys)
+	}
+}
+
+func TestHNSWNodeBytes(t *testing.T) {
+	node := &hnswNode{
+		flags:     HNSW_NODE_DELETED,
+		key:       []byte("key"),
+		vector:    []float32{1, -0.5, 3.25},
+		neighbors: [][]uint32{{1, 2, 3}, {4}, nil},
+	}
+	data, err := node.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &hnswNode{}
+	if err := got.FromBytes(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(node, got) {
+		t.Fatal("Expected", node, "got", got)
+	}
+}
@@ -0,0 +1,287 @@
+package secretary
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/codeharik/secretary/utils/binstruct"
+)
+
+/*
+Write-Ahead Log
+
+Every mutation (SetKV, Update, Delete, Erase, SortedRecordSet, ApplyBatch,
+BulkLoad) is appended to SECRETARY/<collection>/wal.bin before it touches the
+in-memory nodes.
+Appends go through the OS page cache (O_APPEND), so an acknowledged write
+survives a process kill, Sync() additionally survives a power loss.
+
+On startup NewBTreeReadHeader replays the entries newer than the
+checkpoint stored in the header, in LSN order.
+A frame with a short length or a bad checksum marks a torn tail,
+the file is truncated at the last good frame and replay stops there.
+*/
+
+const WAL_FRAME_HEADER_SIZE = 8 // Length (4 bytes) + CRC32C (4 bytes)
+
+var CRC32C_TABLE = crc32.MakeTable(crc32.Castagnoli)
+
+func (tree *BTree) NewWAL() (*WAL, error) {
+	if MODE_WASM {
+		return nil, ErrorModeWASM
+	}
+
+	path := fmt.Sprintf("%s/%s/wal.bin", SECRETARY, tree.CollectionName)
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ErrorFileStat(err)
+	}
+
+	return &WAL{
+		file: file,
+		size: stat.Size(),
+	}, nil
+}
+
+func encodeWALFrame(entry *WALEntry) ([]byte, error) {
+	payload, err := binstruct.Serialize(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeFrame(payload), nil
+}
+
+// encodeFrame prefixes payload with its length and CRC32C
+func encodeFrame(payload []byte) []byte {
+	frame := make([]byte, WAL_FRAME_HEADER_SIZE+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, CRC32C_TABLE))
+	copy(frame[WAL_FRAME_HEADER_SIZE:], payload)
+
+	return frame
+}
+
+// decodeFrame returns the payload of the frame at the start of data, nil for a torn or corrupt frame
+func decodeFrame(data []byte) []byte {
+	if len(data) < WAL_FRAME_HEADER_SIZE {
+		return nil
+	}
+
+	length := int(binary.BigEndian.Uint32(data[0:4]))
+	checksum := binary.BigEndian.Uint32(data[4:8])
+
+	if length == 0 || WAL_FRAME_HEADER_SIZE+length > len(data) {
+		return nil
+	}
+
+	payload := data[WAL_FRAME_HEADER_SIZE : WAL_FRAME_HEADER_SIZE+length]
+	if crc32.Checksum(payload, CRC32C_TABLE) != checksum {
+		return nil
+	}
+
+	return payload
+}
+
+// Append assigns the next LSN to entry and writes it at the end of the log
+func (wal *WAL) Append(entry *WALEntry) error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	entry.LSN = wal.lsn + 1
+
+	frame, err := encodeWALFrame(entry)
+	if err != nil {
+		return err
+	}
+
+	n, err := wal.file.Write(frame)
+	if err != nil || n != len(frame) {
+		return ErrorWritingDataAtOffset(wal.size, err)
+	}
+
+	wal.size += int64(n)
+	wal.lsn = entry.LSN
+
+	return nil
+}
+
+// Size returns the current size of the log file
+func (wal *WAL) Size() int64 {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	return wal.size
+}
+
+// ReadEntries decodes every valid frame, truncating the file at the first torn or corrupt frame
+func (wal *WAL) ReadEntries() ([]*WALEntry, error) {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	data, err := io.ReadAll(io.NewSectionReader(wal.file, 0, wal.size))
+	if err != nil {
+		return nil, ErrorReadingDataAtOffset(0, err)
+	}
+
+	var entries []*WALEntry
+	var lastLSN uint64
+
+	offset := 0
+	for {
+		payload := decodeFrame(data[offset:])
+		if payload == nil {
+			break
+		}
+
+		entry := &WALEntry{}
+		if err := binstruct.Deserialize(payload, entry); err != nil || entry.LSN <= lastLSN {
+			break
+		}
+
+		entries = append(entries, entry)
+		lastLSN = entry.LSN
+		offset += WAL_FRAME_HEADER_SIZE + len(payload)
+	}
+
+	wal.lsn = max(wal.lsn, lastLSN)
+
+	if int64(offset) != wal.size {
+		if err := wal.file.Truncate(int64(offset)); err != nil {
+			return nil, err
+		}
+		wal.size = int64(offset)
+	}
+
+	return entries, nil
+}
+
+// Reset empties the log, LSNs keep increasing
+func (wal *WAL) Reset() error {
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if err := wal.file.Truncate(0); err != nil {
+		return err
+	}
+	wal.size = 0
+
+	return nil
+}
+
+// Sync flushes the log to stable storage
+func (wal *WAL) Sync() error {
+	return wal.file.Sync()
+}
+
+func (wal *WAL) Close() error {
+	if err := wal.Sync(); err != nil {
+		return err
+	}
+	return wal.file.Close()
+}
+
+//------------------------------------------------------------------
+// Tree Logging
+//------------------------------------------------------------------
+
+func (tree *BTree) logMutation(op WALOp, key []byte, value []byte) error {
+	return tree.logMutationSeq(op, key, value, 0)
+}
+
+// logMutationSeq logs a mutation and adds increment to KeySeq in the same step,
+// concurrent writers log KeySeq in the order replay applies their entries
+func (tree *BTree) logMutationSeq(op WALOp, key []byte, value []byte, increment uint64) error {
+	tree.seqMu.Lock()
+	defer tree.seqMu.Unlock()
+
+	if tree.wal != nil {
+		err := tree.wal.Append(&WALEntry{
+			Op:     op,
+			KeySeq: atomic.LoadUint64(&tree.KeySeq),
+			Key:    key,
+			Value:  value,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	atomic.AddUint64(&tree.KeySeq, increment)
+	return nil
+}
+
+// replayWAL re-applies every logged mutation to the tree without logging it again
+func (tree *BTree) replayWAL() error {
+	if tree.wal == nil {
+		return nil
+	}
+
+	entries, err := tree.wal.ReadEntries()
+	if err != nil {
+		return err
+	}
+
+	// LSNs continue after the checkpoint even when the log was emptied
+	tree.wal.lsn = max(tree.wal.lsn, tree.CheckpointLSN)
+
+	for _, entry := range entries {
+		if entry.LSN <= tree.CheckpointLSN {
+			continue // Already in index.bin
+		}
+		if err, _ := catchLoad(func() error { return tree.applyWALEntry(entry) }); err != nil {
+			return ErrorWALReplay(entry, err)
+		}
+	}
+
+	return nil
+}
+
+func (tree *BTree) applyWALEntry(entry *WALEntry) error {
+	tree.KeySeq = entry.KeySeq
+
+	switch entry.Op {
+	case WAL_SET:
+		_, err := tree.setKV(entry.Key, entry.Value)
+		return err
+	case WAL_UPDATE:
+		return tree.update(entry.Key, entry.Value)
+	case WAL_DELETE:
+		return tree.delete(entry.Key)
+	case WAL_ERASE:
+		return tree.erase()
+	case WAL_TXN:
+		return tree.applyWALTxn(entry)
+	case WAL_BATCH:
+		var ops []TxnOp
+		if err := binstruct.Deserialize(entry.Value, &ops); err != nil {
+			return err
+		}
+		return tree.applyTxnOps(ops)
+	case WAL_BULK_LOAD:
+		return tree.applyWALBulkLoad(entry)
+	case WAL_SORTED_SET:
+		var records []Record
+		if err := binstruct.Deserialize(entry.Value, &records); err != nil {
+			return err
+		}
+		sortedRecords := make([]*Record, len(records))
+		for i := range records {
+			sortedRecords[i] = &records[i]
+		}
+		return tree.sortedRecordSet(sortedRecords)
+	}
+
+	return ErrorWALUnknownOp(entry)
+}
@@ -0,0 +1,182 @@
+package secretary
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/codeharik/secretary/utils"
+)
+
+func TestWALAppendReadEntries(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 10)
+
+	for i := 0; i < 10; i++ {
+		err := tree.wal.Append(&WALEntry{
+			Op:    WAL_SET,
+			Key:   []byte(fmt.Sprintf("%016d", i)),
+			Value: []byte(fmt.Sprint("value", i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wal, err := tree.NewWAL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	entries, err := wal.ReadEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 10 {
+		t.Fatalf("Expected %d entries, got %d", 10, len(entries))
+	}
+	for i, entry := range entries {
+		if entry.LSN != uint64(i+1) || entry.Op != WAL_SET ||
+			string(entry.Key) != fmt.Sprintf("%016d", i) || string(entry.Value) != fmt.Sprint("value", i) {
+			t.Fatalf("Entry %d mismatch %+v", i, entry)
+		}
+	}
+
+	s.PagerShutdown()
+}
+
+func TestWALTornTail(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 10)
+
+	for i := 0; i < 3; i++ {
+		if err := tree.wal.Append(&WALEntry{Op: WAL_SET, Key: []byte(fmt.Sprintf("%016d", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	goodSize := tree.wal.size
+
+	// Half written frame
+	frame, err := encodeWALFrame(&WALEntry{LSN: 4, Op: WAL_SET, Key: []byte("0000000000000004")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.wal.file.Write(frame[:len(frame)/2]); err != nil {
+		t.Fatal(err)
+	}
+
+	wal, err := tree.NewWAL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := wal.ReadEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected %d entries, got %d", 3, len(entries))
+	}
+	if wal.size != goodSize {
+		t.Fatalf("Expected torn tail truncated to %d, got %d", goodSize, wal.size)
+	}
+	wal.Close()
+
+	// Corrupt checksum of the last frame
+	data, err := os.ReadFile(tree.wal.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xFF
+	if err := os.WriteFile(tree.wal.file.Name(), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	wal, err = tree.NewWAL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = wal.ReadEntries()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected %d entries, got %d %v", 2, len(entries), err)
+	}
+	wal.Close()
+
+	s.PagerShutdown()
+}
+
+func TestWALRecovery(t *testing.T) {
+	s := dummySecretary(t)
+	tree := dummyTree(t, s, 4)
+
+	if err := tree.SaveHeader(); err != nil {
+		t.Fatal(err)
+	}
+
+	sortedRecords := SampleSortedKeyRecords(20)
+	if err := tree.SortedRecordSet(sortedRecords); err != nil {
+		t.Fatal(err)
+	}
+
+	var keySeq uint64 = 1000
+	var keys [][]byte
+	for i := 0; i < 50; i++ {
+		key := []byte(utils.GenerateSeqString(&keySeq, KEY_SIZE, KEY_INCREMENT))
+		if _, err := tree.SetKV(key, key); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	for _, key := range keys[:10] {
+		if err := tree.Update(key, []byte("updated")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range keys[40:] {
+		if err := tree.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reopen without closing the first instance, as after a kill -9
+	newSecretary := dummySecretary(t)
+	recovered, err := newSecretary.Tree(tree.CollectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := recovered.TreeVerify(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if recovered.KeySeq != tree.KeySeq {
+		t.Fatalf("Expected KeySeq %d, got %d", tree.KeySeq, recovered.KeySeq)
+	}
+
+	for _, r := range sortedRecords {
+		record, err := recovered.Get(r.Key)
+		if err != nil || !bytes.Equal(record.Value, r.Value) {
+			t.Fatal("Sorted record not recovered", string(r.Key), err)
+		}
+	}
+	for i, key := range keys {
+		record, err := recovered.Get(key)
+		switch {
+		case i < 10:
+			if err != nil || string(record.Value) != "updated" {
+				t.Fatal("Updated record not recovered", string(key), err)
+			}
+		case i < 40:
+			if err != nil || !bytes.Equal(record.Value, key) {
+				t.Fatal("Record not recovered", string(key), err)
+			}
+		default:
+			if err == nil {
+				t.Fatal("Deleted record recovered", string(key))
+			}
+		}
+	}
+
+	s.PagerShutdown()
+	newSecretary.PagerShutdown()
+}
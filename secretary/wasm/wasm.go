@@ -35,7 +35,7 @@ func main() {
 		"getTree_info": "getTree() -> data, error",
 
 		"newTree":      js.FuncOf(newTree),
-		"newTree_info": "newTree(collectionName string, order int, numLevel int, baseSize int, increment int, compactionBatchSize int) -> data, error",
+		"newTree_info": "newTree(collectionName string, order int, numLevel int, baseSize int, increment int, compactionBatchSize int, maxKeySize int?) -> data, error",
 
 		"clearTree":      js.FuncOf(clearTree),
 		"clearTree_info": "clearTree() -> data, error",
@@ -69,13 +69,19 @@ func getTree(this js.Value, args []js.Value) any {
 }
 
 func newTree(this js.Value, args []js.Value) any {
+	maxKeySize := 0
+	if len(args) > 6 {
+		maxKeySize = args[6].Int()
+	}
+
 	data, err := SECRETARY.HandleNewTree(
 		args[0].String(),
 		args[1].Int(),
 		args[2].Int(),
 		args[3].Int(),
 		args[4].Int(),
-		args[5].Int())
+		args[5].Int(),
+		maxKeySize)
 	return jsResponse(data, err)
 }
 